@@ -0,0 +1,327 @@
+// Package breaker 基于滑动窗口错误率的熔断器
+//
+// 依赖（MySQL、Redis）出故障时，每个请求仍然会访问它并等到驱动超时，请求越堆越多。熔断器的三个状态：
+// 1. 关闭（closed）：正常放行，按滑动窗口统计错误率；窗口内请求数达到下限且错误率超过阈值时打开
+// 2. 打开（open）：直接返回 ErrOpen，不再访问依赖；经过 openTimeout 后进入半开
+// 3. 半开（half-open）：最多放行 halfOpenRequests 个探测请求，全部成功则关闭，任意一个失败则重新打开
+//
+// 与 go-zero 的自适应熔断器（按概率丢弃请求）相比，状态明确，适合配合降级逻辑使用：打开时直接走降级
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrOpen 熔断器打开（或半开状态下探测请求已满），请求没有发给依赖
+var ErrOpen = errors.New("熔断器已打开")
+
+const (
+	// DefaultWindow 默认统计窗口长度
+	DefaultWindow = 10 * time.Second
+	// DefaultBuckets 默认窗口分桶个数（窗口每次滑动一个桶的长度）
+	DefaultBuckets = 10
+	// DefaultMinRequests 窗口内请求数少于该值时不打开（请求太少时错误率没有意义）
+	DefaultMinRequests = 20
+	// DefaultErrorRate 默认错误率阈值
+	DefaultErrorRate = 0.5
+	// DefaultOpenTimeout 默认打开状态的持续时间，之后进入半开
+	DefaultOpenTimeout = 5 * time.Second
+	// DefaultHalfOpenRequests 半开状态默认放行的探测请求数
+	DefaultHalfOpenRequests = 3
+)
+
+// State 熔断器状态
+type State int32
+
+const (
+	StateClosed   State = iota // 关闭：正常放行
+	StateOpen                  // 打开：直接拒绝
+	StateHalfOpen              // 半开：放行少量探测请求
+)
+
+// String 状态名称
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Counts 熔断器的统计数据
+type Counts struct {
+	State    State
+	Requests int64 // 窗口内的请求数
+	Failures int64 // 窗口内的失败数
+	Rejected int64 // 累计被拒绝（没有发给依赖）的请求数
+}
+
+// ErrorRate 窗口内的错误率
+func (c Counts) ErrorRate() float64 {
+	if c.Requests == 0 {
+		return 0
+	}
+	return float64(c.Failures) / float64(c.Requests)
+}
+
+// String 格式化输出
+func (c Counts) String() string {
+	return fmt.Sprintf("状态=%s, 窗口请求=%d, 失败=%d, 错误率=%.1f%%, 累计拒绝=%d",
+		c.State, c.Requests, c.Failures, c.ErrorRate()*100, c.Rejected)
+}
+
+// Breaker 熔断器，创建后可以被多个 goroutine 共享
+type Breaker struct {
+	name             string
+	windowLength     time.Duration
+	buckets          int
+	minRequests      int64
+	errorRate        float64
+	openTimeout      time.Duration
+	halfOpenRequests int
+	onStateChange    func(name string, from, to State)
+
+	mu         sync.Mutex
+	state      State
+	window     *window
+	openedAt   time.Time
+	generation uint64 // 每次状态变化加1，状态变化之前发出的请求结果不再统计
+	probes     int    // 半开状态已放行的探测请求数
+	successes  int    // 半开状态成功的探测请求数
+	rejected   atomic.Int64
+}
+
+// Option 熔断器选项
+type Option func(b *Breaker)
+
+// WithWindow 滑动窗口长度和分桶个数
+func WithWindow(length time.Duration, buckets int) Option {
+	return func(b *Breaker) {
+		if length > 0 {
+			b.windowLength = length
+		}
+		if buckets > 0 {
+			b.buckets = buckets
+		}
+	}
+}
+
+// WithMinRequests 窗口内请求数达到该值后才按错误率判断是否打开
+func WithMinRequests(n int) Option {
+	return func(b *Breaker) {
+		if n > 0 {
+			b.minRequests = int64(n)
+		}
+	}
+}
+
+// WithErrorRate 错误率阈值（0-1），窗口内错误率达到该值时打开
+func WithErrorRate(rate float64) Option {
+	return func(b *Breaker) {
+		if rate > 0 && rate <= 1 {
+			b.errorRate = rate
+		}
+	}
+}
+
+// WithOpenTimeout 打开状态的持续时间，之后进入半开放行探测请求
+func WithOpenTimeout(d time.Duration) Option {
+	return func(b *Breaker) {
+		if d > 0 {
+			b.openTimeout = d
+		}
+	}
+}
+
+// WithHalfOpenRequests 半开状态放行的探测请求数，全部成功后关闭
+func WithHalfOpenRequests(n int) Option {
+	return func(b *Breaker) {
+		if n > 0 {
+			b.halfOpenRequests = n
+		}
+	}
+}
+
+// WithOnStateChange 状态变化时回调（在锁外调用），用于日志和指标
+func WithOnStateChange(fn func(name string, from, to State)) Option {
+	return func(b *Breaker) {
+		b.onStateChange = fn
+	}
+}
+
+// New 创建熔断器，name 用于错误信息和回调
+func New(name string, opts ...Option) *Breaker {
+	b := &Breaker{
+		name:             name,
+		windowLength:     DefaultWindow,
+		buckets:          DefaultBuckets,
+		minRequests:      DefaultMinRequests,
+		errorRate:        DefaultErrorRate,
+		openTimeout:      DefaultOpenTimeout,
+		halfOpenRequests: DefaultHalfOpenRequests,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.window = newWindow(b.windowLength, b.buckets)
+	return b
+}
+
+// Name 熔断器名称
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// State 当前状态（打开超过 openTimeout 时返回半开）
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	changed, from := b.advance(time.Now())
+	state := b.state
+	b.mu.Unlock()
+
+	b.notify(changed, from, state)
+	return state
+}
+
+// Counts 当前的统计数据
+func (b *Breaker) Counts() Counts {
+	now := time.Now()
+	b.mu.Lock()
+	changed, from := b.advance(now)
+	state := b.state
+	total, failures := b.window.counts(now)
+	b.mu.Unlock()
+
+	b.notify(changed, from, state)
+	return Counts{State: state, Requests: total, Failures: failures, Rejected: b.rejected.Load()}
+}
+
+// Do 通过熔断器执行 fn，只有 fn 返回 nil 才算成功
+func (b *Breaker) Do(fn func() error) error {
+	return b.DoWithAcceptable(fn, func(err error) bool {
+		return err == nil
+	})
+}
+
+// DoWithAcceptable 通过熔断器执行 fn，acceptable 返回 true 的错误不算失败（例如记录不存在）
+// 熔断器打开时不执行 fn，返回包装了 ErrOpen 的错误
+func (b *Breaker) DoWithAcceptable(fn func() error, acceptable func(err error) bool) (err error) {
+	generation, err := b.allow()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		// fn panic 也算一次失败，然后继续 panic
+		if p := recover(); p != nil {
+			b.record(generation, false)
+			panic(p)
+		}
+	}()
+
+	err = fn()
+	b.record(generation, acceptable(err))
+	return err
+}
+
+// allow 判断是否放行，返回当前状态的代数
+func (b *Breaker) allow() (uint64, error) {
+	b.mu.Lock()
+	changed, from := b.advance(time.Now())
+	state, generation := b.state, b.generation
+
+	var err error
+	switch state {
+	case StateOpen:
+		err = fmt.Errorf("%s: %w", b.name, ErrOpen)
+	case StateHalfOpen:
+		if b.probes >= b.halfOpenRequests {
+			err = fmt.Errorf("%s: %w (半开状态，探测请求已满)", b.name, ErrOpen)
+		} else {
+			b.probes++
+		}
+	}
+	b.mu.Unlock()
+
+	b.notify(changed, from, state)
+	if err != nil {
+		b.rejected.Add(1)
+	}
+	return generation, err
+}
+
+// record 记录请求结果并按结果切换状态
+func (b *Breaker) record(generation uint64, success bool) {
+	now := time.Now()
+	b.mu.Lock()
+	if generation != b.generation {
+		b.mu.Unlock()
+		return
+	}
+
+	from := b.state
+	changed := false
+	switch b.state {
+	case StateClosed:
+		b.window.add(now, success)
+		total, failures := b.window.counts(now)
+		if total >= b.minRequests && float64(failures) >= float64(total)*b.errorRate {
+			b.setState(StateOpen, now)
+			changed = true
+		}
+	case StateHalfOpen:
+		if !success {
+			b.setState(StateOpen, now)
+			changed = true
+			break
+		}
+		b.successes++
+		if b.successes >= b.halfOpenRequests {
+			b.setState(StateClosed, now)
+			changed = true
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+
+	b.notify(changed, from, to)
+}
+
+// advance 打开状态超过 openTimeout 后进入半开（调用方持有锁）
+func (b *Breaker) advance(now time.Time) (changed bool, from State) {
+	from = b.state
+	if b.state == StateOpen && now.Sub(b.openedAt) >= b.openTimeout {
+		b.setState(StateHalfOpen, now)
+		return true, from
+	}
+	return false, from
+}
+
+// setState 切换状态并重置对应的计数（调用方持有锁）
+func (b *Breaker) setState(state State, now time.Time) {
+	b.state = state
+	b.generation++
+	b.probes, b.successes = 0, 0
+	switch state {
+	case StateOpen:
+		b.openedAt = now
+	case StateClosed:
+		b.window.reset()
+	}
+}
+
+// notify 在锁外调用状态变化回调
+func (b *Breaker) notify(changed bool, from, to State) {
+	if changed && b.onStateChange != nil {
+		b.onStateChange(b.name, from, to)
+	}
+}
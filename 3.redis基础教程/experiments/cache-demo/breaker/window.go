@@ -0,0 +1,58 @@
+package breaker
+
+import "time"
+
+// bucket 滑动窗口中的一个时间桶
+type bucket struct {
+	index    int64 // 桶的序号（时间 / 桶长度），用来判断桶是否已经过期
+	total    int64
+	failures int64
+}
+
+// window 滑动窗口：把窗口分成多个时间桶，统计最近一个窗口内的请求数和失败数
+// 过期的桶在下一次写入时清零，不需要后台协程
+type window struct {
+	buckets []bucket
+	size    time.Duration // 每个桶的时间长度
+}
+
+// newWindow 创建滑动窗口，length 为窗口长度，n 为桶的个数
+func newWindow(length time.Duration, n int) *window {
+	size := length / time.Duration(n)
+	if size <= 0 {
+		size = time.Millisecond
+	}
+	return &window{buckets: make([]bucket, n), size: size}
+}
+
+// add 记录一次请求结果
+func (w *window) add(now time.Time, success bool) {
+	index := now.UnixNano() / int64(w.size)
+	b := &w.buckets[index%int64(len(w.buckets))]
+	if b.index != index {
+		*b = bucket{index: index}
+	}
+	b.total++
+	if !success {
+		b.failures++
+	}
+}
+
+// counts 统计窗口内（最近 n 个桶）的请求数和失败数
+func (w *window) counts(now time.Time) (total, failures int64) {
+	index := now.UnixNano() / int64(w.size)
+	for _, b := range w.buckets {
+		if b.index > index-int64(len(w.buckets)) && b.index <= index {
+			total += b.total
+			failures += b.failures
+		}
+	}
+	return total, failures
+}
+
+// reset 清空窗口（熔断器关闭时重新开始统计）
+func (w *window) reset() {
+	for i := range w.buckets {
+		w.buckets[i] = bucket{}
+	}
+}
@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/spaolacci/murmur3"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+// ErrBloomNotBuilt 布隆过滤器还没有构建（元数据不存在），需要先执行重建（go run main.go bloom rebuild）
+var ErrBloomNotBuilt = errors.New("布隆过滤器未构建")
+
+const (
+	// DefaultBloomFPRate 默认的目标误判率
+	DefaultBloomFPRate = 0.01
+	// maxBloomBits Redis 字符串最大512MB，即 2^32 位
+	maxBloomBits = 1 << 32
+	// maxBloomHashes 哈希函数个数上限（误判率 1e-9 时 k 约为30）
+	maxBloomHashes = 30
+)
+
+// 元数据字段
+const (
+	bloomFieldBits     = "bits"
+	bloomFieldHashes   = "hashes"
+	bloomFieldCapacity = "capacity"
+	bloomFieldFPRate   = "fp_rate"
+	bloomFieldItems    = "items"
+	bloomFieldBuiltAt  = "built_at"
+)
+
+// BloomParams 布隆过滤器参数
+type BloomParams struct {
+	Bits   uint64 // 位数组大小（m）
+	Hashes uint   // 哈希函数个数（k）
+}
+
+// OptimalBloomParams 根据预计元素个数 n 和目标误判率 p 计算最优参数
+// m = -n·ln(p) / (ln2)²，k = m/n·ln2
+func OptimalBloomParams(expectedItems uint64, fpRate float64) BloomParams {
+	if expectedItems == 0 {
+		expectedItems = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = DefaultBloomFPRate
+	}
+
+	n := float64(expectedItems)
+	m := math.Min(math.Ceil(-n*math.Log(fpRate)/(math.Ln2*math.Ln2)), maxBloomBits)
+	k := math.Max(1, math.Min(math.Round(m/n*math.Ln2), maxBloomHashes))
+	return BloomParams{Bits: uint64(m), Hashes: uint(k)}
+}
+
+// FalsePositiveRate 插入 items 个元素后的理论误判率 (1 - e^(-kn/m))^k
+func (p BloomParams) FalsePositiveRate(items uint64) float64 {
+	if p.Bits == 0 {
+		return 1
+	}
+	k := float64(p.Hashes)
+	return math.Pow(1-math.Exp(-k*float64(items)/float64(p.Bits)), k)
+}
+
+// 位置在Lua脚本中按元数据里的 bits、hashes 计算：(h1 + i*h2) % bits
+// h1、h2 都小于 2^32，i 不超过30，计算结果小于 2^53，Lua 的 double 可以精确表示
+
+// bloomExistsScript 检查元素是否可能存在，元数据不存在时返回 -1
+const bloomExistsScript = `
+local params = redis.call('HMGET', KEYS[2], 'bits', 'hashes')
+if not params[1] then
+	return -1
+end
+local bits, hashes = tonumber(params[1]), tonumber(params[2])
+local h1, h2 = tonumber(ARGV[1]), tonumber(ARGV[2])
+for i = 0, hashes - 1 do
+	if redis.call('GETBIT', KEYS[1], (h1 + i * h2) % bits) == 0 then
+		return 0
+	end
+end
+return 1
+`
+
+// bloomAddScript 添加元素，有新的位被置1时元素个数加1；元数据不存在时返回 -1
+// 正在重建时（KEYS[4] 存在）同时写入新的过滤器，避免重建期间新增的元素在切换后丢失
+const bloomAddScript = `
+local h1, h2 = tonumber(ARGV[1]), tonumber(ARGV[2])
+local function add(key, meta)
+	local params = redis.call('HMGET', meta, 'bits', 'hashes')
+	if not params[1] then
+		return false
+	end
+	local bits, hashes = tonumber(params[1]), tonumber(params[2])
+	local added = false
+	for i = 0, hashes - 1 do
+		if redis.call('SETBIT', key, (h1 + i * h2) % bits, 1) == 0 then
+			added = true
+		end
+	end
+	if added then
+		redis.call('HINCRBY', meta, 'items', 1)
+	end
+	return true
+end
+add(KEYS[3], KEYS[4])
+if not add(KEYS[1], KEYS[2]) then
+	return -1
+end
+return 1
+`
+
+// BloomFilter 参数保存在Redis中的布隆过滤器（go-zero 的 bloom.Filter 固定使用14个哈希函数，不能按误判率调整）
+// 位数组保存在 key，参数和统计保存在 key:meta（Hash），由 BloomManager 按数据量计算参数并重建
+// 读写时在Lua脚本里读取参数，重建后用 RENAME 同时替换位数组和元数据，不会出现新参数读旧位数组的情况
+type BloomFilter struct {
+	rds *redis.Redis
+	key string
+}
+
+// NewBloomFilter 创建布隆过滤器，需要先通过 BloomManager.Rebuild 构建，否则读写返回 ErrBloomNotBuilt
+func NewBloomFilter(rds *redis.Redis, key string) *BloomFilter {
+	return &BloomFilter{
+		rds: rds,
+		key: key,
+	}
+}
+
+// Add 添加元素
+func (f *BloomFilter) Add(data []byte) error {
+	rebuildKey := bloomRebuildKey(f.key)
+	keys := []string{f.key, bloomMetaKey(f.key), rebuildKey, bloomMetaKey(rebuildKey)}
+	resp, err := f.rds.Eval(bloomAddScript, keys, bloomHashArgs(data)...)
+	if err != nil {
+		return fmt.Errorf("添加到布隆过滤器失败: %w", err)
+	}
+	if code, ok := resp.(int64); ok && code == -1 {
+		return ErrBloomNotBuilt
+	}
+	return nil
+}
+
+// Exists 检查元素是否可能存在
+func (f *BloomFilter) Exists(data []byte) (bool, error) {
+	resp, err := f.rds.Eval(bloomExistsScript, []string{f.key, bloomMetaKey(f.key)}, bloomHashArgs(data)...)
+	if err != nil {
+		return false, fmt.Errorf("查询布隆过滤器失败: %w", err)
+	}
+	code, ok := resp.(int64)
+	if ok && code == -1 {
+		return false, ErrBloomNotBuilt
+	}
+	return ok && code == 1, nil
+}
+
+// bloomMetaKey 元数据的key
+func bloomMetaKey(key string) string {
+	return key + ":meta"
+}
+
+// bloomRebuildKey 重建时写入的临时key
+func bloomRebuildKey(key string) string {
+	return key + ":rebuild"
+}
+
+// bloomHashes 双重哈希的两个基础哈希值（murmur3 64位结果的低32位和高32位，h2 取奇数避免所有位置相同）
+func bloomHashes(data []byte) (h1, h2 uint64) {
+	h := murmur3.Sum64(data)
+	return h & 0xffffffff, h>>32 | 1
+}
+
+// bloomHashArgs 传给Lua脚本的 h1、h2
+func bloomHashArgs(data []byte) []any {
+	h1, h2 := bloomHashes(data)
+	return []any{strconv.FormatUint(h1, 10), strconv.FormatUint(h2, 10)}
+}
+
+// bloomLocations 在Go中计算位置，与Lua脚本的算法一致（全量重建时批量 SETBIT）
+func bloomLocations(data []byte, params BloomParams) []uint64 {
+	h1, h2 := bloomHashes(data)
+	locations := make([]uint64, params.Hashes)
+	for i := uint64(0); i < uint64(params.Hashes); i++ {
+		locations[i] = (h1 + i*h2) % params.Bits
+	}
+	return locations
+}
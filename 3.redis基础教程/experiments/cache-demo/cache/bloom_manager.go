@@ -0,0 +1,305 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+const (
+	// DefaultBloomGrowth 默认按当前数据量的2倍预留容量，数据量翻倍之前不需要重建
+	DefaultBloomGrowth = 2.0
+	// DefaultBloomPageSize 全量加载时每页查询的ID个数
+	DefaultBloomPageSize = 1000
+	// BloomDriftFactor 估算误判率超过目标误判率的倍数时需要重建
+	BloomDriftFactor = 2
+	// MinBloomCapacity 最小容量，避免数据很少时位数组过小
+	MinBloomCapacity = 1000
+	// bloomRebuildLockSeconds 重建锁的过期时间
+	bloomRebuildLockSeconds = 600
+)
+
+// bloomSwapScript 用重建好的位数组和元数据原子替换线上的过滤器
+// KEYS[1] 新位数组，KEYS[2] 新元数据，KEYS[3] 线上位数组，KEYS[4] 线上元数据
+const bloomSwapScript = `
+if redis.call('EXISTS', KEYS[2]) == 0 then
+	return 0
+end
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('RENAME', KEYS[1], KEYS[3])
+else
+	redis.call('DEL', KEYS[3])
+end
+redis.call('RENAME', KEYS[2], KEYS[4])
+return 1
+`
+
+// IDSource 全量加载布隆过滤器的数据源（model.UserRepo 实现了该接口）
+type IDSource interface {
+	Count() (int64, error)
+	// ListIDs 按ID升序返回大于 afterID 的最多 limit 个ID
+	ListIDs(afterID int64, limit int) ([]int64, error)
+}
+
+// BloomStats 布隆过滤器的参数与状态
+type BloomStats struct {
+	BloomParams
+	Capacity        uint64    // 构建时预留的容量（预计元素个数）
+	Items           uint64    // 已添加的元素个数
+	TargetFPRate    float64   // 构建时的目标误判率
+	FillRatio       float64   // 位数组中1的比例
+	EstimatedFPRate float64   // 按填充率估算的当前误判率（FillRatio^k）
+	BuiltAt         time.Time // 构建时间
+}
+
+// String 格式化输出
+func (s BloomStats) String() string {
+	return fmt.Sprintf("bits=%d(%.1fMB) hashes=%d 容量=%d 元素=%d 填充率=%.2f%% 目标误判率=%.3f%% 估算误判率=%.3f%% 构建时间=%s",
+		s.Bits, float64(s.Bits)/8/1024/1024, s.Hashes, s.Capacity, s.Items, s.FillRatio*100,
+		s.TargetFPRate*100, s.EstimatedFPRate*100, s.BuiltAt.Format("2006-01-02 15:04:05"))
+}
+
+// BloomManagerOption 布隆过滤器管理器的可选配置
+type BloomManagerOption func(m *BloomManager)
+
+// WithBloomFPRate 设置目标误判率
+func WithBloomFPRate(fpRate float64) BloomManagerOption {
+	return func(m *BloomManager) {
+		if fpRate > 0 && fpRate < 1 {
+			m.fpRate = fpRate
+		}
+	}
+}
+
+// WithBloomGrowth 设置容量相对当前数据量的倍数（不小于1）
+func WithBloomGrowth(growth float64) BloomManagerOption {
+	return func(m *BloomManager) {
+		if growth >= 1 {
+			m.growth = growth
+		}
+	}
+}
+
+// WithBloomPageSize 设置全量加载时每页的ID个数
+func WithBloomPageSize(pageSize int) BloomManagerOption {
+	return func(m *BloomManager) {
+		if pageSize > 0 {
+			m.pageSize = pageSize
+		}
+	}
+}
+
+// BloomManager 布隆过滤器管理器：按数据量和目标误判率计算参数，从数据库分页全量加载，
+// 先写入临时key，再用 RENAME 原子替换线上的过滤器
+//
+// 需要重建的情况（见 NeedsRebuild）：
+// - 过滤器还没有构建
+// - 元素个数或数据库中的数据量超过了构建时的容量
+// - 按填充率估算的误判率超过目标误判率的 BloomDriftFactor 倍
+// - 目标误判率的配置变了
+type BloomManager struct {
+	rds      *redis.Redis
+	key      string
+	source   IDSource
+	member   func(id int64) string
+	fpRate   float64
+	growth   float64
+	pageSize int
+}
+
+// NewBloomManager 创建布隆过滤器管理器
+// member 把ID转换为过滤器中的元素，必须与缓存写入过滤器时使用的Key一致（例如 user:1）
+func NewBloomManager(rds *redis.Redis, key string, source IDSource, member func(id int64) string, opts ...BloomManagerOption) *BloomManager {
+	m := &BloomManager{
+		rds:      rds,
+		key:      key,
+		source:   source,
+		member:   member,
+		fpRate:   DefaultBloomFPRate,
+		growth:   DefaultBloomGrowth,
+		pageSize: DefaultBloomPageSize,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Filter 管理器维护的布隆过滤器
+func (m *BloomManager) Filter() *BloomFilter {
+	return NewBloomFilter(m.rds, m.key)
+}
+
+// Stats 查询线上过滤器的参数与状态，没有构建时返回 ErrBloomNotBuilt
+func (m *BloomManager) Stats() (BloomStats, error) {
+	meta, err := m.rds.Hgetall(bloomMetaKey(m.key))
+	if err != nil {
+		return BloomStats{}, fmt.Errorf("查询布隆过滤器元数据失败: %w", err)
+	}
+	if meta[bloomFieldBits] == "" {
+		return BloomStats{}, ErrBloomNotBuilt
+	}
+
+	var stats BloomStats
+	stats.Bits, _ = strconv.ParseUint(meta[bloomFieldBits], 10, 64)
+	hashes, _ := strconv.ParseUint(meta[bloomFieldHashes], 10, 64)
+	stats.Hashes = uint(hashes)
+	stats.Capacity, _ = strconv.ParseUint(meta[bloomFieldCapacity], 10, 64)
+	stats.Items, _ = strconv.ParseUint(meta[bloomFieldItems], 10, 64)
+	stats.TargetFPRate, _ = strconv.ParseFloat(meta[bloomFieldFPRate], 64)
+	builtAt, _ := strconv.ParseInt(meta[bloomFieldBuiltAt], 10, 64)
+	stats.BuiltAt = time.Unix(builtAt, 0)
+
+	ones, err := m.rds.BitCount(m.key, 0, -1)
+	if err != nil {
+		return BloomStats{}, fmt.Errorf("统计布隆过滤器填充率失败: %w", err)
+	}
+	if stats.Bits > 0 {
+		stats.FillRatio = float64(ones) / float64(stats.Bits)
+	}
+	stats.EstimatedFPRate = math.Pow(stats.FillRatio, float64(stats.Hashes))
+	return stats, nil
+}
+
+// NeedsRebuild 判断是否需要重建，返回原因
+func (m *BloomManager) NeedsRebuild() (reason string, needed bool, err error) {
+	stats, err := m.Stats()
+	if errors.Is(err, ErrBloomNotBuilt) {
+		return "过滤器未构建", true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	count, err := m.source.Count()
+	if err != nil {
+		return "", false, fmt.Errorf("查询数据量失败: %w", err)
+	}
+
+	switch {
+	case stats.Items > stats.Capacity:
+		return fmt.Sprintf("元素个数 %d 超过容量 %d", stats.Items, stats.Capacity), true, nil
+	case uint64(count) > stats.Capacity:
+		return fmt.Sprintf("数据量 %d 超过容量 %d", count, stats.Capacity), true, nil
+	case stats.EstimatedFPRate > stats.TargetFPRate*BloomDriftFactor:
+		return fmt.Sprintf("估算误判率 %.3f%% 超过目标误判率 %.3f%% 的%d倍",
+			stats.EstimatedFPRate*100, stats.TargetFPRate*100, BloomDriftFactor), true, nil
+	case stats.TargetFPRate != m.fpRate:
+		return fmt.Sprintf("目标误判率从 %.3f%% 调整为 %.3f%%", stats.TargetFPRate*100, m.fpRate*100), true, nil
+	}
+	return "", false, nil
+}
+
+// RebuildIfNeeded 需要时重建，返回是否重建以及原因
+func (m *BloomManager) RebuildIfNeeded() (rebuilt bool, reason string, err error) {
+	reason, needed, err := m.NeedsRebuild()
+	if err != nil || !needed {
+		return false, reason, err
+	}
+	if _, err := m.Rebuild(); err != nil {
+		return false, reason, err
+	}
+	return true, reason, nil
+}
+
+// Rebuild 按当前数据量重新计算参数，全量加载到临时key后原子替换线上的过滤器
+// 重建期间线上过滤器照常读写，新添加的元素同时写入临时key（见 bloomAddScript）
+func (m *BloomManager) Rebuild() (BloomStats, error) {
+	lock := redis.NewRedisLock(m.rds, m.key+":rebuild:lock")
+	lock.SetExpire(bloomRebuildLockSeconds)
+	ok, err := lock.Acquire()
+	if err != nil {
+		return BloomStats{}, fmt.Errorf("获取重建锁失败: %w", err)
+	}
+	if !ok {
+		return BloomStats{}, fmt.Errorf("布隆过滤器 %s 正在被其他进程重建", m.key)
+	}
+	defer lock.Release()
+
+	start := time.Now()
+	count, err := m.source.Count()
+	if err != nil {
+		return BloomStats{}, fmt.Errorf("查询数据量失败: %w", err)
+	}
+	capacity := uint64(math.Ceil(float64(count) * m.growth))
+	if capacity < MinBloomCapacity {
+		capacity = MinBloomCapacity
+	}
+	params := OptimalBloomParams(capacity, m.fpRate)
+
+	tmpKey := bloomRebuildKey(m.key)
+	tmpMeta := bloomMetaKey(tmpKey)
+	if _, err := m.rds.Del(tmpKey, tmpMeta); err != nil {
+		return BloomStats{}, fmt.Errorf("清理临时key失败: %w", err)
+	}
+	swapped := false
+	defer func() {
+		if !swapped {
+			m.rds.Del(tmpKey, tmpMeta)
+		}
+	}()
+
+	// 先写元数据：从这一刻起线上新添加的元素也会写入临时key
+	err = m.rds.Hmset(tmpMeta, map[string]string{
+		bloomFieldBits:     strconv.FormatUint(params.Bits, 10),
+		bloomFieldHashes:   strconv.FormatUint(uint64(params.Hashes), 10),
+		bloomFieldCapacity: strconv.FormatUint(capacity, 10),
+		bloomFieldFPRate:   strconv.FormatFloat(m.fpRate, 'g', -1, 64),
+		bloomFieldItems:    "0",
+		bloomFieldBuiltAt:  strconv.FormatInt(start.Unix(), 10),
+	})
+	if err != nil {
+		return BloomStats{}, fmt.Errorf("写入布隆过滤器元数据失败: %w", err)
+	}
+
+	// 按ID分页全量加载，每页一次 Pipeline
+	loaded := 0
+	for afterID := int64(0); ; {
+		ids, err := m.source.ListIDs(afterID, m.pageSize)
+		if err != nil {
+			return BloomStats{}, fmt.Errorf("分页查询ID失败(after=%d): %w", afterID, err)
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		err = m.rds.Pipelined(func(pipe redis.Pipeliner) error {
+			ctx := context.Background()
+			for _, id := range ids {
+				for _, pos := range bloomLocations([]byte(m.member(id)), params) {
+					pipe.SetBit(ctx, tmpKey, int64(pos), 1)
+				}
+			}
+			pipe.HIncrBy(ctx, tmpMeta, bloomFieldItems, int64(len(ids)))
+			return nil
+		})
+		if err != nil {
+			return BloomStats{}, fmt.Errorf("写入布隆过滤器失败(after=%d): %w", afterID, err)
+		}
+
+		loaded += len(ids)
+		afterID = ids[len(ids)-1]
+		if len(ids) < m.pageSize {
+			break
+		}
+	}
+
+	resp, err := m.rds.Eval(bloomSwapScript, []string{tmpKey, tmpMeta, m.key, bloomMetaKey(m.key)})
+	if err != nil {
+		return BloomStats{}, fmt.Errorf("替换布隆过滤器失败: %w", err)
+	}
+	if n, ok := resp.(int64); !ok || n != 1 {
+		return BloomStats{}, fmt.Errorf("替换布隆过滤器失败: 临时元数据不存在")
+	}
+	swapped = true
+
+	log.Printf("[布隆过滤器重建] key=%s, 数据量=%d, 加载=%d, 容量=%d, bits=%d, hashes=%d, 耗时=%v",
+		m.key, count, loaded, capacity, params.Bits, params.Hashes, time.Since(start))
+	return m.Stats()
+}
@@ -0,0 +1,522 @@
+package cache
+
+import (
+	"cache-demo/breaker"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+var (
+	// ErrCacheMiss 缓存未命中（Redis中不存在该Key）
+	ErrCacheMiss = errors.New("缓存未命中")
+	// ErrNullCache 空值缓存错误（用于标识空值缓存命中）
+	ErrNullCache = errors.New("空值缓存命中")
+	// ErrStaleVersion 缓存中已经有更新的版本，没有写入（见 WithVersion）
+	ErrStaleVersion = errors.New("缓存中已有更新的版本")
+	// ErrVersionMismatch 缓存中的版本号和期望的不同，没有写入（见 CompareAndSet）
+	ErrVersionMismatch = errors.New("缓存中的版本号不一致")
+)
+
+// KeyFunc 根据业务主键生成缓存Key
+type KeyFunc[K any] func(key K) string
+
+// Codec 缓存值的序列化/反序列化接口
+type Codec[V any] interface {
+	Marshal(val V) ([]byte, error)
+	Unmarshal(data []byte) (V, error)
+}
+
+// TTLPolicy 过期时间策略：根据基础过期时间计算实际写入Redis的过期时间（秒）
+type TTLPolicy func(baseExpireSeconds int) int
+
+// Filter 成员过滤器接口（布隆过滤器等），go-zero 的 bloom.Filter 满足该接口
+type Filter interface {
+	Add(data []byte) error
+	Exists(data []byte) (bool, error)
+}
+
+// migrateScript 编码迁移：值没有被其他请求改写时才用新编码覆盖，并保留剩余过期时间
+const migrateScript = `
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl > 0 then
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ttl)
+else
+	redis.call('SET', KEYS[1], ARGV[2])
+end
+return 1
+`
+
+// Option 通用缓存的可选配置
+type Option[K any, V any] func(c *Cache[K, V])
+
+// Cache 通用的类型化缓存（Redis + 可插拔的Key生成、编解码和过期策略）
+// 布隆过滤器、空值缓存、随机过期时间都以 Option 的方式组合，而不是各写一个类型
+type Cache[K any, V any] struct {
+	rds        *redis.Redis
+	keyFunc    KeyFunc[K]
+	codec      Codec[V]
+	expire     int
+	ttlPolicy  TTLPolicy
+	filter     Filter
+	nullExpire int
+	nullTTL    TTLPolicy
+	nullIndex  string
+	maxNulls   int
+	breaker    *breaker.Breaker
+	staleTTL   int
+	version    func(val V) int64
+}
+
+// New 创建通用缓存实例
+func New[K any, V any](rds *redis.Redis, keyFunc KeyFunc[K], opts ...Option[K, V]) *Cache[K, V] {
+	c := &Cache[K, V]{
+		rds:        rds,
+		keyFunc:    keyFunc,
+		codec:      JSONCodec[V]{},
+		expire:     DefaultExpireSeconds,
+		ttlPolicy:  FixedTTL(),
+		nullExpire: NullCacheExpireSeconds,
+		nullTTL:    FixedTTL(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithCodec 指定编解码器（默认JSON）
+// 编解码器实现了 Migrator（例如 VersionedCodec）时，读到旧编码的数据会自动用新编码重写
+func WithCodec[K any, V any](codec Codec[V]) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.codec = codec
+	}
+}
+
+// WithExpire 指定默认过期时间（秒）
+func WithExpire[K any, V any](expireSeconds int) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		if expireSeconds > 0 {
+			c.expire = expireSeconds
+		}
+	}
+}
+
+// WithTTLPolicy 指定过期时间策略
+func WithTTLPolicy[K any, V any](policy TTLPolicy) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		if policy != nil {
+			c.ttlPolicy = policy
+		}
+	}
+}
+
+// WithRandomExpire 使用随机过期时间（防止缓存雪崩），rangePercent 为随机范围百分比
+func WithRandomExpire[K any, V any](rangePercent int) Option[K, V] {
+	return WithTTLPolicy[K, V](RandomTTL(rangePercent))
+}
+
+// WithFilter 查询前先经过成员过滤器（防止缓存穿透）
+func WithFilter[K any, V any](filter Filter) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.filter = filter
+	}
+}
+
+// WithNullCache 指定空值缓存的过期时间（秒）
+func WithNullCache[K any, V any](expireSeconds int) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		if expireSeconds > 0 {
+			c.nullExpire = expireSeconds
+		}
+	}
+}
+
+// WithRandomNullExpire 空值缓存使用随机过期时间，rangePercent 为随机范围百分比（为0时使用固定过期时间）
+// 攻击者集中刷一批不存在的ID时，空值缓存不会在同一秒过期，再一起打到数据库
+func WithRandomNullExpire[K any, V any](rangePercent int) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		if rangePercent > 0 {
+			c.nullTTL = RandomTTL(rangePercent)
+		} else {
+			c.nullTTL = FixedTTL()
+		}
+	}
+}
+
+// WithNullKeyLimit 限制空值缓存Key的个数（见 setNullScript），maxKeys 为0时不限制
+// indexKey 为记录空值Key的有序集合，超过 maxKeys 时淘汰最早过期的空值缓存
+func WithNullKeyLimit[K any, V any](indexKey string, maxKeys int) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		if indexKey == "" || maxKeys <= 0 {
+			c.nullIndex, c.maxNulls = "", 0
+			return
+		}
+		c.nullIndex = indexKey
+		c.maxNulls = maxKeys
+	}
+}
+
+// FixedTTL 固定过期时间策略
+func FixedTTL() TTLPolicy {
+	return func(baseExpireSeconds int) int {
+		return baseExpireSeconds
+	}
+}
+
+// RandomTTL 随机过期时间策略：基础过期时间 + 随机值（0-rangePercent%范围）
+func RandomTTL(rangePercent int) TTLPolicy {
+	return func(baseExpireSeconds int) int {
+		randomRange := baseExpireSeconds * rangePercent / 100
+		if randomRange == 0 {
+			randomRange = 1 // 至少1秒的随机范围
+		}
+		return baseExpireSeconds + rand.Intn(randomRange)
+	}
+}
+
+// Key 生成缓存Key
+func (c *Cache[K, V]) Key(key K) string {
+	return c.keyFunc(key)
+}
+
+// Get 从Redis获取数据
+// 返回 ErrCacheMiss 表示未命中，ErrNullCache 表示命中空值缓存
+func (c *Cache[K, V]) Get(key K) (V, error) {
+	return c.GetCtx(context.Background(), key)
+}
+
+// GetCtx 从Redis获取数据，ctx 的超时和取消会传给Redis客户端
+func (c *Cache[K, V]) GetCtx(ctx context.Context, key K) (V, error) {
+	redisKey := c.keyFunc(key)
+	var val string
+	err := c.exec(func() (err error) {
+		val, err = c.rds.GetCtx(ctx, redisKey)
+		return err
+	})
+	if err != nil {
+		// Redis连接错误
+		var zero V
+		return zero, err
+	}
+
+	return c.decode(ctx, redisKey, val)
+}
+
+// MGet 批量读取缓存（一次MGET），返回与 keys 一一对应的值和错误
+// 错误为 nil 表示命中，ErrCacheMiss 表示未命中，ErrNullCache 表示命中空值缓存
+func (c *Cache[K, V]) MGet(keys []K) ([]V, []error, error) {
+	return c.MGetCtx(context.Background(), keys)
+}
+
+// MGetCtx 批量读取缓存（一次MGET）
+func (c *Cache[K, V]) MGetCtx(ctx context.Context, keys []K) ([]V, []error, error) {
+	vals := make([]V, len(keys))
+	errs := make([]error, len(keys))
+	if len(keys) == 0 {
+		return vals, errs, nil
+	}
+
+	redisKeys := make([]string, len(keys))
+	for i, key := range keys {
+		redisKeys[i] = c.keyFunc(key)
+	}
+
+	var results []string
+	err := c.exec(func() (err error) {
+		results, err = c.rds.MgetCtx(ctx, redisKeys...)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, val := range results {
+		vals[i], errs[i] = c.decode(ctx, redisKeys[i], val)
+	}
+
+	return vals, errs, nil
+}
+
+// decode 解析Redis中读到的值，旧编码的数据顺便迁移到当前编码
+func (c *Cache[K, V]) decode(ctx context.Context, redisKey, val string) (V, error) {
+	var zero V
+
+	if val == "" {
+		return zero, ErrCacheMiss
+	}
+
+	// 检查是否是空值缓存
+	if val == NullCacheValue {
+		return zero, ErrNullCache
+	}
+
+	result, err := c.codec.Unmarshal([]byte(val))
+	if err != nil {
+		return zero, fmt.Errorf("反序列化缓存数据失败: %w", err)
+	}
+
+	if migrator, ok := c.codec.(Migrator); ok && migrator.NeedsMigration([]byte(val)) {
+		c.migrate(ctx, redisKey, val, result)
+	}
+
+	return result, nil
+}
+
+// migrate 用当前编码重写旧编码的数据，失败只记录日志（下次读取时会再次尝试）
+func (c *Cache[K, V]) migrate(ctx context.Context, redisKey, old string, val V) {
+	data, err := c.codec.Marshal(val)
+	if err != nil {
+		log.Printf("[缓存编码迁移失败] key=%s, error=%v", redisKey, err)
+		return
+	}
+
+	if _, err := c.rds.EvalCtx(ctx, migrateScript, []string{redisKey}, old, string(data)); err != nil {
+		log.Printf("[缓存编码迁移失败] key=%s, error=%v", redisKey, err)
+		return
+	}
+	log.Printf("[缓存编码迁移] key=%s, %d字节 -> %d字节", redisKey, len(old), len(data))
+}
+
+// Set 使用默认过期时间写入缓存
+func (c *Cache[K, V]) Set(key K, val V) error {
+	return c.SetEx(key, val, c.expire)
+}
+
+// SetCtx 使用默认过期时间写入缓存
+func (c *Cache[K, V]) SetCtx(ctx context.Context, key K, val V) error {
+	return c.SetExCtx(ctx, key, val, c.expire)
+}
+
+// SetEx 使用指定的基础过期时间写入缓存（会经过过期时间策略计算）
+func (c *Cache[K, V]) SetEx(key K, val V, expireSeconds int) error {
+	return c.SetExCtx(context.Background(), key, val, expireSeconds)
+}
+
+// SetExCtx 使用指定的基础过期时间写入缓存
+func (c *Cache[K, V]) SetExCtx(ctx context.Context, key K, val V, expireSeconds int) error {
+	data, err := c.codec.Marshal(val)
+	if err != nil {
+		return fmt.Errorf("序列化缓存数据失败: %w", err)
+	}
+
+	if expireSeconds <= 0 {
+		expireSeconds = c.expire
+	}
+
+	ttl := c.ttlPolicy(expireSeconds)
+	written := true
+	err = c.exec(func() (err error) {
+		if c.version != nil {
+			written, err = c.setIfNewer(ctx, c.keyFunc(key), string(data), c.version(val), ttl)
+			return err
+		}
+		if c.staleTTL > 0 {
+			return c.setWithStale(ctx, c.keyFunc(key), string(data), ttl)
+		}
+		return c.rds.SetexCtx(ctx, c.keyFunc(key), string(data), ttl)
+	})
+	if err != nil {
+		return fmt.Errorf("设置缓存失败: %w", err)
+	}
+	if !written {
+		// 不经过熔断器统计：版本比较的结果，不是Redis故障
+		return fmt.Errorf("%w: key=%s, version=%d", ErrStaleVersion, c.keyFunc(key), c.version(val))
+	}
+
+	return nil
+}
+
+// SetMany 批量写入缓存（pipeline 一次发送多条SETEX），keys 与 vals 一一对应
+// 过期时间策略对每个Key单独计算，随机过期时间不会让同一批数据同时过期
+// 配置了 WithVersion 时每个Key执行一次 setIfNewerScript，跳过的旧版本不记录日志
+func (c *Cache[K, V]) SetMany(keys []K, vals []V, expireSeconds int) error {
+	return c.SetManyCtx(context.Background(), keys, vals, expireSeconds)
+}
+
+// SetManyCtx 批量写入缓存（pipeline）
+func (c *Cache[K, V]) SetManyCtx(ctx context.Context, keys []K, vals []V, expireSeconds int) error {
+	if len(keys) != len(vals) {
+		return fmt.Errorf("批量写入缓存参数错误: keys=%d, vals=%d", len(keys), len(vals))
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if expireSeconds <= 0 {
+		expireSeconds = c.expire
+	}
+
+	data := make([][]byte, len(vals))
+	for i, val := range vals {
+		d, err := c.codec.Marshal(val)
+		if err != nil {
+			return fmt.Errorf("序列化缓存数据失败: %w", err)
+		}
+		data[i] = d
+	}
+
+	err := c.exec(func() error {
+		return c.rds.PipelinedCtx(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				if c.version != nil {
+					redisKeys, args := c.setIfNewerArgs(c.keyFunc(key), string(data[i]), c.version(vals[i]), c.ttlPolicy(expireSeconds))
+					pipe.Eval(ctx, setIfNewerScript, redisKeys, args...)
+					continue
+				}
+				ttl := time.Duration(c.ttlPolicy(expireSeconds)) * time.Second
+				pipe.SetEX(ctx, c.keyFunc(key), string(data[i]), ttl)
+				if c.staleTTL > 0 {
+					pipe.SetEX(ctx, c.staleKey(key), string(data[i]), ttl+time.Duration(c.staleTTL)*time.Second)
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("批量设置缓存失败: %w", err)
+	}
+
+	return nil
+}
+
+// SetNull 设置空值缓存（用于防止缓存穿透）
+func (c *Cache[K, V]) SetNull(key K) error {
+	return c.SetNullCtx(context.Background(), key)
+}
+
+// SetNullCtx 设置空值缓存
+func (c *Cache[K, V]) SetNullCtx(ctx context.Context, key K) error {
+	ttl := c.nullTTL(c.nullExpire)
+	if c.maxNulls > 0 {
+		return c.setNullLimited(ctx, c.keyFunc(key), ttl)
+	}
+
+	err := c.exec(func() error {
+		return c.rds.SetexCtx(ctx, c.keyFunc(key), NullCacheValue, ttl)
+	})
+	if err != nil {
+		return fmt.Errorf("设置空值缓存失败: %w", err)
+	}
+	return nil
+}
+
+// SetNullMany 批量设置空值缓存（pipeline）
+func (c *Cache[K, V]) SetNullMany(keys []K) error {
+	return c.SetNullManyCtx(context.Background(), keys)
+}
+
+// SetNullManyCtx 批量设置空值缓存（pipeline）
+func (c *Cache[K, V]) SetNullManyCtx(ctx context.Context, keys []K) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	err := c.exec(func() error {
+		return c.rds.PipelinedCtx(ctx, func(pipe redis.Pipeliner) error {
+			now := time.Now().UnixMilli()
+			for _, key := range keys {
+				ttl := c.nullTTL(c.nullExpire)
+				if c.maxNulls > 0 {
+					pipe.Eval(ctx, setNullScript, []string{c.keyFunc(key), c.nullIndex}, setNullArgs(ttl, now, c.maxNulls)...)
+					continue
+				}
+				pipe.SetEX(ctx, c.keyFunc(key), NullCacheValue, time.Duration(ttl)*time.Second)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("批量设置空值缓存失败: %w", err)
+	}
+
+	return nil
+}
+
+// IsNull 检查是否是空值缓存
+func (c *Cache[K, V]) IsNull(key K) (bool, error) {
+	return c.IsNullCtx(context.Background(), key)
+}
+
+// IsNullCtx 检查是否是空值缓存
+func (c *Cache[K, V]) IsNullCtx(ctx context.Context, key K) (bool, error) {
+	var val string
+	err := c.exec(func() (err error) {
+		val, err = c.rds.GetCtx(ctx, c.keyFunc(key))
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return val == NullCacheValue, nil
+}
+
+// Delete 删除缓存（包括空值缓存和旧数据副本，数据已经删除或修改，不能再降级返回）
+func (c *Cache[K, V]) Delete(key K) error {
+	return c.DeleteCtx(context.Background(), key)
+}
+
+// DeleteCtx 删除缓存（包括空值缓存和旧数据副本）
+func (c *Cache[K, V]) DeleteCtx(ctx context.Context, key K) error {
+	return c.exec(func() error {
+		if c.staleTTL > 0 {
+			_, err := c.rds.DelCtx(ctx, c.keyFunc(key), c.staleKey(key))
+			return err
+		}
+		_, err := c.rds.DelCtx(ctx, c.keyFunc(key))
+		return err
+	})
+}
+
+// AddToFilter 把Key加入成员过滤器，未配置过滤器时直接返回
+func (c *Cache[K, V]) AddToFilter(key K) error {
+	if c.filter == nil {
+		return nil
+	}
+	return c.filter.Add([]byte(c.keyFunc(key)))
+}
+
+// RemoveFromFilter 把Key从成员过滤器中删除，未配置过滤器时直接返回
+// 过滤器不支持删除时返回 ErrFilterNotDeletable
+func (c *Cache[K, V]) RemoveFromFilter(key K) error {
+	if c.filter == nil {
+		return nil
+	}
+	filter, ok := c.filter.(DeletableFilter)
+	if !ok {
+		return ErrFilterNotDeletable
+	}
+	return filter.Remove([]byte(c.keyFunc(key)))
+}
+
+// MayExist 检查Key是否可能存在，未配置过滤器时总是返回 true
+func (c *Cache[K, V]) MayExist(key K) (bool, error) {
+	if c.filter == nil {
+		return true, nil
+	}
+	return c.filter.Exists([]byte(c.keyFunc(key)))
+}
+
+// JSONCodec 基于 encoding/json 的编解码器
+type JSONCodec[V any] struct{}
+
+// Marshal 序列化为JSON
+func (JSONCodec[V]) Marshal(val V) ([]byte, error) {
+	return json.Marshal(val)
+}
+
+// Unmarshal 从JSON反序列化
+func (JSONCodec[V]) Unmarshal(data []byte) (V, error) {
+	var val V
+	err := json.Unmarshal(data, &val)
+	return val, err
+}
@@ -0,0 +1,299 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Format 序列化格式
+type Format byte
+
+const (
+	// FormatJSON encoding/json
+	FormatJSON Format = 1
+	// FormatMsgpack MessagePack
+	FormatMsgpack Format = 2
+	// FormatProtobuf Protocol Buffers（需要为具体类型提供编解码器，见 NewUserCodec）
+	FormatProtobuf Format = 3
+)
+
+// Compression 压缩算法
+type Compression byte
+
+const (
+	// CompressNone 不压缩
+	CompressNone Compression = 0
+	// CompressGzip gzip 压缩（压缩率高，CPU开销大）
+	CompressGzip Compression = 1
+	// CompressSnappy snappy 压缩（压缩率一般，速度快）
+	CompressSnappy Compression = 2
+)
+
+// versionFlag 版本字节的最高位固定为1
+// 合法的JSON文本不会以 >= 0x80 的字节开头，所以没有版本字节的旧数据（JSON）可以被直接识别出来
+const versionFlag byte = 0x80
+
+// 版本字节布局: 1 | 0 | 压缩算法(2位) | 序列化格式(4位)
+func versionByte(format Format, compression Compression) byte {
+	return versionFlag | byte(compression)<<4 | byte(format)
+}
+
+// parseVersion 解析版本字节，不是版本字节时返回 false
+func parseVersion(b byte) (Format, Compression, bool) {
+	if b&versionFlag == 0 {
+		return 0, 0, false
+	}
+	return Format(b & 0x0f), Compression(b >> 4 & 0x03), true
+}
+
+// String 格式名称
+func (f Format) String() string {
+	switch f {
+	case FormatJSON:
+		return "json"
+	case FormatMsgpack:
+		return "msgpack"
+	case FormatProtobuf:
+		return "protobuf"
+	default:
+		return fmt.Sprintf("format(%d)", byte(f))
+	}
+}
+
+// String 压缩算法名称
+func (c Compression) String() string {
+	switch c {
+	case CompressNone:
+		return "none"
+	case CompressGzip:
+		return "gzip"
+	case CompressSnappy:
+		return "snappy"
+	default:
+		return fmt.Sprintf("compression(%d)", byte(c))
+	}
+}
+
+// ParseCodecName 解析编解码器名称，格式为 <序列化格式>[+<压缩算法>]，例如 json、msgpack+snappy、protobuf+gzip
+func ParseCodecName(name string) (Format, Compression, error) {
+	formatName, compressionName, _ := strings.Cut(strings.ToLower(strings.TrimSpace(name)), "+")
+
+	var format Format
+	switch formatName {
+	case "", "json":
+		format = FormatJSON
+	case "msgpack":
+		format = FormatMsgpack
+	case "protobuf", "proto":
+		format = FormatProtobuf
+	default:
+		return 0, 0, fmt.Errorf("不支持的序列化格式: %s", formatName)
+	}
+
+	var compression Compression
+	switch compressionName {
+	case "", "none":
+		compression = CompressNone
+	case "gzip":
+		compression = CompressGzip
+	case "snappy":
+		compression = CompressSnappy
+	default:
+		return 0, 0, fmt.Errorf("不支持的压缩算法: %s", compressionName)
+	}
+
+	return format, compression, nil
+}
+
+// Migrator 能识别旧编码的编解码器
+// 缓存读到旧编码的数据时，会用当前编码原地重写（保留剩余过期时间），实现灰度切换编码时的平滑迁移
+type Migrator interface {
+	NeedsMigration(data []byte) bool
+}
+
+// VersionedCodec 带版本字节前缀的编解码器
+// 写入：[版本字节][（压缩后的）序列化数据]，使用构造时指定的格式和压缩算法；
+// 不压缩的JSON不加版本字节，和旧版本写入的数据完全一样，旧实例也能读取
+// 读取：根据版本字节选择解码方式，没有版本字节的数据按JSON解析，所以切换编码期间新旧数据可以共存
+//
+// 灰度切换编码的步骤：
+// 1. 所有实例先升级为 VersionedCodec，编码仍然配置为 json（能读所有编码，写入的仍是旧格式）
+// 2. 再把编码改成新格式（例如 msgpack+snappy），旧数据在被读到时自动迁移（见 Migrator）
+type VersionedCodec[V any] struct {
+	format      Format
+	compression Compression
+	codecs      map[Format]Codec[V]
+}
+
+// NewVersionedCodec 创建带版本字节的编解码器
+// JSON 和 MessagePack 对任意类型可用；其他格式（protobuf）需要通过 extra 提供具体类型的编解码器
+func NewVersionedCodec[V any](format Format, compression Compression, extra map[Format]Codec[V]) (*VersionedCodec[V], error) {
+	codecs := map[Format]Codec[V]{
+		FormatJSON:    JSONCodec[V]{},
+		FormatMsgpack: MsgpackCodec[V]{},
+	}
+	for f, codec := range extra {
+		codecs[f] = codec
+	}
+
+	if _, ok := codecs[format]; !ok {
+		return nil, fmt.Errorf("序列化格式 %s 没有可用的编解码器", format)
+	}
+	if compression > CompressSnappy {
+		return nil, fmt.Errorf("不支持的压缩算法: %s", compression)
+	}
+
+	return &VersionedCodec[V]{
+		format:      format,
+		compression: compression,
+		codecs:      codecs,
+	}, nil
+}
+
+// Name 编解码器名称，与 ParseCodecName 的输入格式一致
+func (c *VersionedCodec[V]) Name() string {
+	if c.compression == CompressNone {
+		return c.format.String()
+	}
+	return c.format.String() + "+" + c.compression.String()
+}
+
+// Marshal 序列化、压缩并加上版本字节
+func (c *VersionedCodec[V]) Marshal(val V) ([]byte, error) {
+	data, err := c.codecs[c.format].Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	if c.plainJSON() {
+		return data, nil
+	}
+
+	data, err = compress(c.compression, data)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(data)+1)
+	out = append(out, versionByte(c.format, c.compression))
+	return append(out, data...), nil
+}
+
+// Unmarshal 根据版本字节解码，没有版本字节时按JSON解析
+func (c *VersionedCodec[V]) Unmarshal(data []byte) (V, error) {
+	var zero V
+	if len(data) == 0 {
+		return zero, fmt.Errorf("缓存数据为空")
+	}
+
+	format, compression, ok := parseVersion(data[0])
+	if !ok {
+		// 引入版本字节之前写入的旧数据
+		return c.codecs[FormatJSON].Unmarshal(data)
+	}
+
+	codec, ok := c.codecs[format]
+	if !ok {
+		return zero, fmt.Errorf("未知的序列化格式: %s", format)
+	}
+
+	payload, err := decompress(compression, data[1:])
+	if err != nil {
+		return zero, err
+	}
+	return codec.Unmarshal(payload)
+}
+
+// NeedsMigration 数据是否不是用当前格式和压缩算法写入的
+func (c *VersionedCodec[V]) NeedsMigration(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	if c.plainJSON() {
+		return data[0]&versionFlag != 0
+	}
+	return data[0] != versionByte(c.format, c.compression)
+}
+
+// plainJSON 当前编码是否为不压缩的JSON（不加版本字节）
+func (c *VersionedCodec[V]) plainJSON() bool {
+	return c.format == FormatJSON && c.compression == CompressNone
+}
+
+// MsgpackCodec 基于 MessagePack 的编解码器（字段名沿用 json 标签）
+type MsgpackCodec[V any] struct{}
+
+// Marshal 序列化为 MessagePack
+func (MsgpackCodec[V]) Marshal(val V) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(val); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal 从 MessagePack 反序列化
+func (MsgpackCodec[V]) Unmarshal(data []byte) (V, error) {
+	var val V
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	err := dec.Decode(&val)
+	return val, err
+}
+
+// compress 按指定算法压缩
+func compress(compression Compression, data []byte) ([]byte, error) {
+	switch compression {
+	case CompressNone:
+		return data, nil
+	case CompressGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, fmt.Errorf("gzip压缩失败: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("gzip压缩失败: %w", err)
+		}
+		return buf.Bytes(), nil
+	case CompressSnappy:
+		return snappy.Encode(nil, data), nil
+	default:
+		return nil, fmt.Errorf("不支持的压缩算法: %s", compression)
+	}
+}
+
+// decompress 按指定算法解压
+func decompress(compression Compression, data []byte) ([]byte, error) {
+	switch compression {
+	case CompressNone:
+		return data, nil
+	case CompressGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("gzip解压失败: %w", err)
+		}
+		defer r.Close()
+		out, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("gzip解压失败: %w", err)
+		}
+		return out, nil
+	case CompressSnappy:
+		out, err := snappy.Decode(nil, data)
+		if err != nil {
+			return nil, fmt.Errorf("snappy解压失败: %w", err)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("不支持的压缩算法: %s", compression)
+	}
+}
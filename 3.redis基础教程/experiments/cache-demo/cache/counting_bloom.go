@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/spaolacci/murmur3"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+// ErrFilterNotDeletable 成员过滤器不支持删除（例如 go-zero 的 bloom.Filter）
+var ErrFilterNotDeletable = errors.New("成员过滤器不支持删除")
+
+const (
+	// CountingBloomHashes 计数布隆过滤器默认的哈希函数个数
+	CountingBloomHashes = 7
+)
+
+// DeletableFilter 支持删除的成员过滤器
+type DeletableFilter interface {
+	Filter
+	Remove(data []byte) error
+}
+
+// 计数器存放在 Redis Hash 中（field 为计数器下标），只保存非0的计数器
+
+// countingAddScript 添加元素：k 个计数器各加1
+const countingAddScript = `
+for _, pos in ipairs(ARGV) do
+	redis.call('HINCRBY', KEYS[1], pos, 1)
+end
+return 1
+`
+
+// countingExistsScript 检查元素是否可能存在
+const countingExistsScript = `
+for _, pos in ipairs(ARGV) do
+	if not redis.call('HGET', KEYS[1], pos) then
+		return 0
+	end
+end
+return 1
+`
+
+// countingRemoveScript 删除元素：一定不存在的元素直接返回，否则每个计数器减1，减到0时删除该 field
+const countingRemoveScript = `
+for _, pos in ipairs(ARGV) do
+	if not redis.call('HGET', KEYS[1], pos) then
+		return 0
+	end
+end
+for _, pos in ipairs(ARGV) do
+	if redis.call('HGET', KEYS[1], pos) then
+		if redis.call('HINCRBY', KEYS[1], pos, -1) <= 0 then
+			redis.call('HDEL', KEYS[1], pos)
+		end
+	end
+end
+return 1
+`
+
+// CountingBloomFilter 基于 Redis 的计数布隆过滤器
+// 每个位置是一个计数器而不是一个bit：添加时 k 个计数器加1，删除时减1，所以支持删除
+//
+// 注意：每个元素只能添加一次（创建用户、全量预热时），删除也只能删除添加过的元素
+// - 重复添加：删除一次后计数器仍大于0，已删除的元素继续通过过滤器（退化为普通布隆过滤器的行为，由空值缓存兜底）
+// - 删除没添加过的元素：如果它恰好被误判为存在，会减掉其他元素的计数，导致存在的元素被拦截
+type CountingBloomFilter struct {
+	rds      *redis.Redis
+	key      string
+	counters uint
+	hashes   uint
+}
+
+// NewCountingBloomFilter 创建计数布隆过滤器
+// counters 为计数器个数（相当于普通布隆过滤器的位数），hashes 为哈希函数个数
+func NewCountingBloomFilter(rds *redis.Redis, key string, counters, hashes uint) *CountingBloomFilter {
+	if hashes == 0 {
+		hashes = CountingBloomHashes
+	}
+	return &CountingBloomFilter{
+		rds:      rds,
+		key:      key,
+		counters: counters,
+		hashes:   hashes,
+	}
+}
+
+// Add 添加元素
+func (f *CountingBloomFilter) Add(data []byte) error {
+	if _, err := f.rds.Eval(countingAddScript, []string{f.key}, f.locations(data)...); err != nil {
+		return fmt.Errorf("添加到计数布隆过滤器失败: %w", err)
+	}
+	return nil
+}
+
+// Exists 检查元素是否可能存在
+func (f *CountingBloomFilter) Exists(data []byte) (bool, error) {
+	resp, err := f.rds.Eval(countingExistsScript, []string{f.key}, f.locations(data)...)
+	if err != nil {
+		return false, fmt.Errorf("查询计数布隆过滤器失败: %w", err)
+	}
+	exists, ok := resp.(int64)
+	return ok && exists == 1, nil
+}
+
+// Remove 删除元素（一定不存在的元素直接忽略）
+func (f *CountingBloomFilter) Remove(data []byte) error {
+	if _, err := f.rds.Eval(countingRemoveScript, []string{f.key}, f.locations(data)...); err != nil {
+		return fmt.Errorf("从计数布隆过滤器删除失败: %w", err)
+	}
+	return nil
+}
+
+// Clear 清空过滤器
+func (f *CountingBloomFilter) Clear() error {
+	_, err := f.rds.Del(f.key)
+	return err
+}
+
+// locations 计算 k 个计数器下标（双重哈希：h1 + i*h2）
+func (f *CountingBloomFilter) locations(data []byte) []any {
+	h1, h2 := murmur3.Sum128(data)
+	locations := make([]any, f.hashes)
+	for i := uint(0); i < f.hashes; i++ {
+		pos := (h1 + uint64(i)*h2) % uint64(f.counters)
+		locations[i] = strconv.FormatUint(pos, 10)
+	}
+	return locations
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+)
+
+// setNullScript 写入空值缓存，并限制空值Key的个数
+// KEYS[1] 空值Key，KEYS[2] 记录空值Key的有序集合（score 为过期时间，毫秒）
+// ARGV: 空值标记、过期时间（秒）、当前时间（毫秒）、最大Key个数
+//
+// 1. 先从有序集合中移除已经过期的Key
+// 2. 新Key写入后会超过上限时，淘汰最早过期的空值缓存（只删除值仍然是空值标记的Key，已经写入真实数据的不删）
+// 3. 写入空值缓存并记录到有序集合，返回淘汰的个数
+//
+// 注意：淘汰的Key没有在 KEYS 中声明，Redis Cluster 下需要让空值Key和有序集合在同一个slot（使用 hash tag）
+const setNullScript = `
+local now, ttl, limit = tonumber(ARGV[3]), tonumber(ARGV[2]), tonumber(ARGV[4])
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', now)
+
+local evicted = 0
+if not redis.call('ZSCORE', KEYS[2], KEYS[1]) then
+	local over = redis.call('ZCARD', KEYS[2]) - limit + 1
+	if over > 0 then
+		local victims = redis.call('ZPOPMIN', KEYS[2], over)
+		for i = 1, #victims, 2 do
+			if redis.call('GET', victims[i]) == ARGV[1] then
+				redis.call('DEL', victims[i])
+			end
+			evicted = evicted + 1
+		end
+	end
+end
+
+redis.call('SET', KEYS[1], ARGV[1], 'EX', ttl)
+redis.call('ZADD', KEYS[2], now + ttl * 1000, KEYS[1])
+return evicted
+`
+
+// setNullArgs setNullScript 的参数
+func setNullArgs(ttl int, nowMillis int64, maxKeys int) []any {
+	return []any{NullCacheValue, strconv.Itoa(ttl), strconv.FormatInt(nowMillis, 10), strconv.Itoa(maxKeys)}
+}
+
+// setNullLimited 写入空值缓存，空值Key超过上限时淘汰最早过期的
+func (c *Cache[K, V]) setNullLimited(ctx context.Context, redisKey string, ttl int) error {
+	var resp any
+	err := c.exec(func() (err error) {
+		resp, err = c.rds.EvalCtx(ctx, setNullScript, []string{redisKey, c.nullIndex}, setNullArgs(ttl, time.Now().UnixMilli(), c.maxNulls)...)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("设置空值缓存失败: %w", err)
+	}
+	if evicted, ok := resp.(int64); ok && evicted > 0 {
+		log.Printf("[空值缓存淘汰] key=%s, 超过上限 %d，淘汰 %d 个最早过期的空值缓存", redisKey, c.maxNulls, evicted)
+	}
+	return nil
+}
+
+// NullKeyCount 当前未过期的空值Key个数（未配置 WithNullKeyLimit 时返回0）
+func (c *Cache[K, V]) NullKeyCount() (int, error) {
+	if c.maxNulls == 0 {
+		return 0, nil
+	}
+	return c.rds.Zcount(c.nullIndex, time.Now().UnixMilli()+1, math.MaxInt64)
+}
@@ -0,0 +1,461 @@
+package cache
+
+import (
+	"cache-demo/metrics"
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrNotFound 数据源中不存在该数据（读穿透缓存的加载结果）
+var ErrNotFound = errors.New("数据不存在")
+
+// DefaultCacheName 未指定缓存名称时使用的指标名称
+const DefaultCacheName = "default"
+
+// Loader 缓存未命中时从数据源加载数据的函数（例如 repo.FindByIDCtx），ctx 是调用方传入的 context
+type Loader[K any, V any] func(ctx context.Context, key K) (V, error)
+
+// BatchLoader 批量加载函数（例如 WHERE id IN (...)），数据源中不存在的Key不出现在结果中
+type BatchLoader[K comparable, V any] func(ctx context.Context, keys []K) (map[K]V, error)
+
+// Store 读穿透缓存依赖的缓存存储，*Cache 满足该接口
+// 读写缓存都使用调用方的 ctx：调用方超时或取消后，不再继续等待Redis
+type Store[K any, V any] interface {
+	// GetCtx 返回 ErrCacheMiss 表示未命中，ErrNullCache 表示命中空值缓存
+	GetCtx(ctx context.Context, key K) (V, error)
+	// MGetCtx 批量读取，返回与 keys 一一对应的值和错误（含义同 GetCtx）
+	MGetCtx(ctx context.Context, keys []K) ([]V, []error, error)
+	SetExCtx(ctx context.Context, key K, val V, expireSeconds int) error
+	SetManyCtx(ctx context.Context, keys []K, vals []V, expireSeconds int) error
+	SetNullCtx(ctx context.Context, key K) error
+	SetNullManyCtx(ctx context.Context, keys []K) error
+	MayExist(key K) (bool, error)
+	AddToFilter(key K) error
+}
+
+// StaleStore 支持旧数据副本的缓存存储（*Cache 配置 WithStaleCopy 后满足），配合 WithStaleFallback 使用
+type StaleStore[K any, V any] interface {
+	// GetStaleCtx 读取旧数据副本，不存在时返回 ErrCacheMiss
+	GetStaleCtx(ctx context.Context, key K) (V, error)
+}
+
+// ReadThroughOption 读穿透缓存的可选配置
+type ReadThroughOption[K comparable, V any] func(rt *ReadThrough[K, V])
+
+// ReadThrough 读穿透缓存：调用方只管 Get，未命中时的加载、回填、空值缓存和过期时间都由缓存自己处理
+type ReadThrough[K comparable, V any] struct {
+	store      Store[K, V]
+	loader     Loader[K, V]
+	batch      BatchLoader[K, V]
+	expire     int
+	negative   bool
+	guard      func(key K) error
+	isNotFound func(err error) bool
+	stale      func(err error) bool
+	metrics    *metrics.CacheMetrics
+}
+
+// NewReadThrough 创建读穿透缓存实例
+func NewReadThrough[K comparable, V any](store Store[K, V], loader Loader[K, V], opts ...ReadThroughOption[K, V]) *ReadThrough[K, V] {
+	rt := &ReadThrough[K, V]{
+		store:  store,
+		loader: loader,
+		expire: DefaultExpireSeconds,
+		isNotFound: func(err error) bool {
+			return errors.Is(err, ErrNotFound)
+		},
+		metrics: metrics.For(DefaultCacheName),
+	}
+	for _, opt := range opts {
+		opt(rt)
+	}
+	return rt
+}
+
+// WithLoadExpire 指定加载后回填缓存的基础过期时间（秒），实际过期时间还会经过存储的过期时间策略
+func WithLoadExpire[K comparable, V any](expireSeconds int) ReadThroughOption[K, V] {
+	return func(rt *ReadThrough[K, V]) {
+		if expireSeconds > 0 {
+			rt.expire = expireSeconds
+		}
+	}
+}
+
+// WithNegativeCache 数据源中不存在时写入空值缓存（防止缓存穿透）
+func WithNegativeCache[K comparable, V any]() ReadThroughOption[K, V] {
+	return func(rt *ReadThrough[K, V]) {
+		rt.negative = true
+	}
+}
+
+// WithLoadGuard 回源加载前先经过 guard（例如限流），guard 返回错误时不加载，Get 直接返回该错误
+// 只有缓存未命中（既没有数据也没有空值缓存）时才会调用，缓存命中不受影响
+func WithLoadGuard[K comparable, V any](guard func(key K) error) ReadThroughOption[K, V] {
+	return func(rt *ReadThrough[K, V]) {
+		rt.guard = guard
+	}
+}
+
+// WithNotFound 指定如何判断加载函数返回的错误是"数据不存在"（例如 gorm.ErrRecordNotFound）
+func WithNotFound[K comparable, V any](isNotFound func(err error) bool) ReadThroughOption[K, V] {
+	return func(rt *ReadThrough[K, V]) {
+		if isNotFound != nil {
+			rt.isNotFound = isNotFound
+		}
+	}
+}
+
+// WithStaleFallback 加载失败且 shouldFallback(err) 返回 true 时（例如数据库熔断器打开），
+// 降级返回存储中的旧数据副本（存储需要实现 StaleStore），没有副本时仍然返回加载错误
+func WithStaleFallback[K comparable, V any](shouldFallback func(err error) bool) ReadThroughOption[K, V] {
+	return func(rt *ReadThrough[K, V]) {
+		rt.stale = shouldFallback
+	}
+}
+
+// WithName 指定缓存名称，命中/未命中/回源等指标按名称统计
+func WithName[K comparable, V any](name string) ReadThroughOption[K, V] {
+	return func(rt *ReadThrough[K, V]) {
+		if name != "" {
+			rt.metrics = metrics.For(name)
+		}
+	}
+}
+
+// Metrics 返回该缓存的指标
+func (rt *ReadThrough[K, V]) Metrics() *metrics.CacheMetrics {
+	return rt.metrics
+}
+
+// WithBatchLoader 指定批量加载函数，未指定时 GetMany 逐个调用加载函数
+func WithBatchLoader[K comparable, V any](loader BatchLoader[K, V]) ReadThroughOption[K, V] {
+	return func(rt *ReadThrough[K, V]) {
+		rt.batch = loader
+	}
+}
+
+// Get 读取数据
+// 1. 成员过滤器判断一定不存在 -> 返回 ErrNotFound
+// 2. 缓存命中 -> 直接返回；命中空值缓存 -> 返回 ErrNotFound
+// 3. 缓存未命中 -> 调用加载函数 -> 回填缓存（不存在时按配置写入空值缓存）
+func (rt *ReadThrough[K, V]) Get(key K) (V, error) {
+	return rt.GetCtx(context.Background(), key)
+}
+
+// GetCtx 读取数据，ctx 传给缓存和加载函数
+// 加载失败时如果 ctx 已经超时或取消，不再降级读取旧数据副本（调用方已经不等结果了）
+func (rt *ReadThrough[K, V]) GetCtx(ctx context.Context, key K) (V, error) {
+	var zero V
+
+	exists, checked := rt.mayExist(key)
+	if !exists {
+		log.Printf("[成员过滤器拦截] key=%v 不存在", key)
+		return zero, ErrNotFound
+	}
+
+	val, err := rt.store.GetCtx(ctx, key)
+	switch {
+	case err == nil:
+		rt.metrics.Hit()
+		log.Printf("[缓存命中] key=%v", key)
+		return val, nil
+	case errors.Is(err, ErrNullCache):
+		rt.metrics.NullHit()
+		log.Printf("[空值缓存命中] key=%v", key)
+		return zero, ErrNotFound
+	case !errors.Is(err, ErrCacheMiss):
+		// Redis错误或缓存数据损坏，按未命中处理，回源加载
+		rt.metrics.Error(metrics.OpGet)
+		log.Printf("[缓存读取失败] key=%v, error=%v (回源加载)", key, err)
+	}
+	rt.metrics.Miss()
+
+	if err := rt.allowLoad(key); err != nil {
+		return zero, err
+	}
+
+	log.Printf("[缓存未命中] key=%v, 加载数据", key)
+	start := time.Now()
+	val, err = rt.loader(ctx, key)
+	rt.metrics.Load(time.Since(start))
+	if err != nil {
+		if rt.isNotFound(err) {
+			rt.setNull(ctx, key)
+			return zero, ErrNotFound
+		}
+		rt.metrics.Error(metrics.OpLoad)
+		if val, ok := rt.getStale(ctx, key, err); ok {
+			return val, nil
+		}
+		return zero, err
+	}
+
+	if err := rt.store.SetExCtx(ctx, key, val, rt.expire); errors.Is(err, ErrStaleVersion) {
+		// 加载期间数据被更新，新版本已经写入缓存
+		log.Printf("[跳过旧版本缓存] key=%v (缓存中已有更新的版本)", key)
+	} else if err != nil {
+		rt.metrics.Error(metrics.OpSet)
+		log.Printf("[缓存写入失败] key=%v, error=%v (不影响返回结果)", key, err)
+	} else {
+		log.Printf("[缓存写入成功] key=%v, expire=%d秒", key, rt.expire)
+	}
+
+	// 成员过滤器查询失败时才补加（已经判断可能存在的Key不重复添加，计数布隆过滤器重复添加后删不干净）
+	if !checked {
+		rt.addToFilter(key)
+	}
+
+	return val, nil
+}
+
+// setNull 数据不存在时按配置写入空值缓存
+func (rt *ReadThrough[K, V]) setNull(ctx context.Context, key K) {
+	if !rt.negative {
+		log.Printf("[数据不存在] key=%v", key)
+		return
+	}
+
+	if err := rt.store.SetNullCtx(ctx, key); err != nil {
+		rt.metrics.Error(metrics.OpSetNull)
+		log.Printf("[空值缓存写入失败] key=%v, error=%v (不影响返回结果)", key, err)
+	} else {
+		log.Printf("[数据不存在] key=%v, 已写入空值缓存", key)
+	}
+}
+
+// GetMany 批量读取数据
+// 返回与 keys 一一对应的值（不存在的位置为零值），以及不存在的Key（保持输入顺序，被 guard 拒绝加载的Key也在其中）
+// 缓存只访问一次（MGET），未命中的Key合并为一次批量加载，回填缓存和空值缓存各用一次 pipeline
+func (rt *ReadThrough[K, V]) GetMany(keys []K) ([]V, []K, error) {
+	return rt.GetManyCtx(context.Background(), keys)
+}
+
+// GetManyCtx 批量读取数据，ctx 传给缓存和批量加载函数
+func (rt *ReadThrough[K, V]) GetManyCtx(ctx context.Context, keys []K) ([]V, []K, error) {
+	vals := make([]V, len(keys))
+	found := make([]bool, len(keys))
+
+	// 1. 成员过滤器判断一定不存在的Key不再查询缓存
+	candidates := make([]int, 0, len(keys))
+	unchecked := make(map[K]bool)
+	for i, key := range keys {
+		exists, checked := rt.mayExist(key)
+		if !exists {
+			continue
+		}
+		candidates = append(candidates, i)
+		if !checked {
+			unchecked[key] = true
+		}
+	}
+
+	// 2. 批量查询缓存
+	cacheKeys := make([]K, len(candidates))
+	for j, i := range candidates {
+		cacheKeys[j] = keys[i]
+	}
+	cached, errs, err := rt.store.MGetCtx(ctx, cacheKeys)
+	if err != nil {
+		rt.metrics.Error(metrics.OpGet)
+		log.Printf("[批量读取缓存失败] keys=%d, error=%v (全部回源加载)", len(cacheKeys), err)
+		cached = make([]V, len(cacheKeys))
+		errs = make([]error, len(cacheKeys))
+		for j := range errs {
+			errs[j] = ErrCacheMiss
+		}
+	}
+
+	// 3. 收集未命中的Key（输入中重复的Key只加载一次）
+	var loadKeys []K
+	positions := make(map[K][]int)
+	hits := 0
+	for j, i := range candidates {
+		switch {
+		case errs[j] == nil:
+			rt.metrics.Hit()
+			vals[i], found[i] = cached[j], true
+			hits++
+			continue
+		case errors.Is(errs[j], ErrNullCache):
+			rt.metrics.NullHit()
+			hits++
+			continue
+		case !errors.Is(errs[j], ErrCacheMiss):
+			rt.metrics.Error(metrics.OpGet)
+			log.Printf("[缓存读取失败] key=%v, error=%v (回源加载)", keys[i], errs[j])
+		}
+		rt.metrics.Miss()
+		if _, ok := positions[keys[i]]; !ok && rt.allowLoad(keys[i]) == nil {
+			loadKeys = append(loadKeys, keys[i])
+		}
+		positions[keys[i]] = append(positions[keys[i]], i)
+	}
+	log.Printf("[批量查询] keys=%d, 缓存命中=%d, 需要加载=%d", len(keys), hits, len(loadKeys))
+
+	// 4. 批量加载并回填缓存
+	if len(loadKeys) > 0 {
+		loaded, err := rt.loadMany(ctx, loadKeys)
+		if err != nil {
+			rt.metrics.Error(metrics.OpLoad)
+			if !rt.canFallback(ctx, err) {
+				return nil, nil, err
+			}
+			// 降级：有旧数据副本的Key返回旧数据，其余的Key没有办法确认是否存在，整批返回加载错误
+			for _, key := range loadKeys {
+				val, ok := rt.getStale(ctx, key, err)
+				if !ok {
+					return nil, nil, err
+				}
+				for _, i := range positions[key] {
+					vals[i], found[i] = val, true
+				}
+			}
+			loadKeys = nil
+		}
+
+		var hitKeys, nullKeys []K
+		var hitVals []V
+		for _, key := range loadKeys {
+			val, ok := loaded[key]
+			if !ok {
+				nullKeys = append(nullKeys, key)
+				continue
+			}
+			hitKeys = append(hitKeys, key)
+			hitVals = append(hitVals, val)
+			for _, i := range positions[key] {
+				vals[i], found[i] = val, true
+			}
+		}
+
+		rt.backfill(ctx, hitKeys, hitVals, nullKeys, unchecked)
+	}
+
+	var missing []K
+	for i, key := range keys {
+		if !found[i] {
+			missing = append(missing, key)
+		}
+	}
+
+	return vals, missing, nil
+}
+
+// loadMany 批量加载，未配置批量加载函数时逐个调用加载函数
+func (rt *ReadThrough[K, V]) loadMany(ctx context.Context, keys []K) (map[K]V, error) {
+	if rt.batch != nil {
+		start := time.Now()
+		result, err := rt.batch(ctx, keys)
+		rt.metrics.Load(time.Since(start))
+		return result, err
+	}
+
+	result := make(map[K]V, len(keys))
+	for _, key := range keys {
+		start := time.Now()
+		val, err := rt.loader(ctx, key)
+		rt.metrics.Load(time.Since(start))
+		if err != nil {
+			if rt.isNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		result[key] = val
+	}
+	return result, nil
+}
+
+// backfill 批量回填缓存和空值缓存，成员过滤器查询失败的Key（unchecked）补加到过滤器
+func (rt *ReadThrough[K, V]) backfill(ctx context.Context, hitKeys []K, hitVals []V, nullKeys []K, unchecked map[K]bool) {
+	if len(hitKeys) > 0 {
+		if err := rt.store.SetManyCtx(ctx, hitKeys, hitVals, rt.expire); err != nil {
+			rt.metrics.Error(metrics.OpSet)
+			log.Printf("[批量缓存写入失败] keys=%d, error=%v (不影响返回结果)", len(hitKeys), err)
+		} else {
+			log.Printf("[批量缓存写入成功] keys=%d, expire=%d秒", len(hitKeys), rt.expire)
+		}
+
+		for _, key := range hitKeys {
+			if unchecked[key] {
+				rt.addToFilter(key)
+			}
+		}
+	}
+
+	if len(nullKeys) == 0 || !rt.negative {
+		return
+	}
+	if err := rt.store.SetNullManyCtx(ctx, nullKeys); err != nil {
+		rt.metrics.Error(metrics.OpSetNull)
+		log.Printf("[批量空值缓存写入失败] keys=%d, error=%v (不影响返回结果)", len(nullKeys), err)
+	} else {
+		log.Printf("[批量空值缓存写入成功] keys=%d", len(nullKeys))
+	}
+}
+
+// canFallback 加载错误是否需要降级返回旧数据（ctx 已经结束时不降级）
+func (rt *ReadThrough[K, V]) canFallback(ctx context.Context, err error) bool {
+	if rt.stale == nil || !rt.stale(err) || ctx.Err() != nil {
+		return false
+	}
+	_, ok := rt.store.(StaleStore[K, V])
+	return ok
+}
+
+// getStale 加载失败时按配置降级读取旧数据副本
+func (rt *ReadThrough[K, V]) getStale(ctx context.Context, key K, loadErr error) (V, bool) {
+	var zero V
+	if !rt.canFallback(ctx, loadErr) {
+		return zero, false
+	}
+
+	val, err := rt.store.(StaleStore[K, V]).GetStaleCtx(ctx, key)
+	if err != nil {
+		if !errors.Is(err, ErrCacheMiss) {
+			rt.metrics.Error(metrics.OpGet)
+		}
+		log.Printf("[降级失败] key=%v, 没有可用的旧数据: %v, 加载错误: %v", key, err, loadErr)
+		return zero, false
+	}
+	rt.metrics.StaleHit()
+	log.Printf("[降级返回旧数据] key=%v, 加载错误: %v", key, loadErr)
+	return val, true
+}
+
+// allowLoad 回源加载前检查 guard，拒绝时记录指标
+func (rt *ReadThrough[K, V]) allowLoad(key K) error {
+	if rt.guard == nil {
+		return nil
+	}
+	if err := rt.guard(key); err != nil {
+		rt.metrics.LoadReject()
+		log.Printf("[回源被拒绝] key=%v, error=%v", key, err)
+		return err
+	}
+	return nil
+}
+
+// mayExist 查询成员过滤器，查询失败时按可能存在处理（checked 为 false）
+func (rt *ReadThrough[K, V]) mayExist(key K) (exists, checked bool) {
+	exists, err := rt.store.MayExist(key)
+	if err != nil {
+		rt.metrics.Error(metrics.OpFilter)
+		log.Printf("[成员过滤器查询失败] key=%v, error=%v (继续查询)", key, err)
+		return true, false
+	}
+	if !exists {
+		rt.metrics.BloomReject()
+	}
+	return exists, true
+}
+
+// addToFilter 把存在的数据加入成员过滤器
+func (rt *ReadThrough[K, V]) addToFilter(key K) {
+	if err := rt.store.AddToFilter(key); err != nil {
+		rt.metrics.Error(metrics.OpFilter)
+		log.Printf("[成员过滤器添加失败] key=%v, error=%v (不影响返回结果)", key, err)
+	}
+}
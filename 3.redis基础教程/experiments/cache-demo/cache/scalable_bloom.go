@@ -0,0 +1,308 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+const (
+	// ScalableBloomGrowth 每一层的容量是上一层的倍数
+	ScalableBloomGrowth = 2
+	// ScalableBloomTightening 每一层的误判率是上一层的倍数
+	// 第 i 层误判率为 P·(1-r)·r^i，所有层加起来不超过目标误判率 P
+	ScalableBloomTightening = 0.5
+)
+
+// 所有层共用一个位数组（KEYS[1]），第 i 层占用 [offset, offset+bits)，offset 按字节对齐，方便用 BITCOUNT 统计每层的填充率
+// 配置和每层参数保存在 KEYS[2]（Hash）：
+//   capacity、fp_rate、growth、tightening、layers
+//   <i>:offset、<i>:bits、<i>:hashes、<i>:capacity、<i>:fp_rate、<i>:items
+
+// scalableBloomLayerLua 公共函数：查询一层的参数、判断元素是否在某一层、追加一层
+// 追加时的参数计算与 OptimalBloomParams 相同：m = -n·ln(p)/(ln2)²，k = m/n·ln2
+const scalableBloomLayerLua = `
+local function layer(l)
+	local p = redis.call('HMGET', KEYS[2], l .. ':offset', l .. ':bits', l .. ':hashes', l .. ':capacity')
+	return tonumber(p[1]), tonumber(p[2]), tonumber(p[3]), tonumber(p[4])
+end
+
+local function contains(l, h1, h2)
+	local offset, bits, hashes = layer(l)
+	for i = 0, hashes - 1 do
+		if redis.call('GETBIT', KEYS[1], offset + (h1 + i * h2) % bits) == 0 then
+			return false
+		end
+	end
+	return true
+end
+
+local function appendLayer(l)
+	local cfg = redis.call('HMGET', KEYS[2], 'capacity', 'fp_rate', 'growth', 'tightening')
+	local capacity = math.floor(tonumber(cfg[1]) * tonumber(cfg[3]) ^ l)
+	local fp = tonumber(cfg[2]) * (1 - tonumber(cfg[4])) * tonumber(cfg[4]) ^ l
+	local bits = math.ceil(-capacity * math.log(fp) / (math.log(2) ^ 2))
+	local hashes = math.max(1, math.min(math.floor(bits / capacity * math.log(2) + 0.5), 30))
+	local offset = 0
+	if l > 0 then
+		local prevOffset, prevBits = layer(l - 1)
+		offset = math.ceil((prevOffset + prevBits) / 8) * 8
+	end
+	if offset + bits > 4294967296 then
+		return redis.error_reply('可扩展布隆过滤器超过512MB')
+	end
+	redis.call('HSET', KEYS[2], l .. ':offset', offset, l .. ':bits', bits, l .. ':hashes', hashes,
+		l .. ':capacity', capacity, l .. ':fp_rate', fp, l .. ':items', 0)
+	redis.call('HSET', KEYS[2], 'layers', l + 1)
+	return true
+end
+`
+
+// scalableBloomInitScript 初始化配置和第0层，已初始化时不做任何修改
+// ARGV: capacity, fp_rate, growth, tightening
+const scalableBloomInitScript = scalableBloomLayerLua + `
+if redis.call('HEXISTS', KEYS[2], 'layers') == 1 then
+	return 0
+end
+redis.call('HSET', KEYS[2], 'capacity', ARGV[1], 'fp_rate', ARGV[2], 'growth', ARGV[3], 'tightening', ARGV[4])
+local ok = appendLayer(0)
+if type(ok) == 'table' then
+	return ok
+end
+return 1
+`
+
+// scalableBloomAddScript 添加元素：任意一层已存在时跳过，否则写入最后一层；最后一层满了就追加新的一层
+// 返回 1 新增，0 已存在，-1 未初始化
+const scalableBloomAddScript = scalableBloomLayerLua + `
+local layers = tonumber(redis.call('HGET', KEYS[2], 'layers'))
+if not layers then
+	return -1
+end
+local h1, h2 = tonumber(ARGV[1]), tonumber(ARGV[2])
+for l = layers - 1, 0, -1 do
+	if contains(l, h1, h2) then
+		return 0
+	end
+end
+
+local last = layers - 1
+local offset, bits, hashes, capacity = layer(last)
+for i = 0, hashes - 1 do
+	redis.call('SETBIT', KEYS[1], offset + (h1 + i * h2) % bits, 1)
+end
+if redis.call('HINCRBY', KEYS[2], last .. ':items', 1) >= capacity then
+	local ok = appendLayer(layers)
+	if type(ok) == 'table' then
+		return ok
+	end
+end
+return 1
+`
+
+// scalableBloomExistsScript 检查元素是否可能存在（任意一层存在即可能存在），未初始化时返回 -1
+const scalableBloomExistsScript = scalableBloomLayerLua + `
+local layers = tonumber(redis.call('HGET', KEYS[2], 'layers'))
+if not layers then
+	return -1
+end
+local h1, h2 = tonumber(ARGV[1]), tonumber(ARGV[2])
+for l = layers - 1, 0, -1 do
+	if contains(l, h1, h2) then
+		return 1
+	end
+end
+return 0
+`
+
+// ScalableBloomFilter 可扩展布隆过滤器（Scalable Bloom Filter）
+// 由多层布隆过滤器组成：最后一层写满后追加一层容量翻倍、误判率减半的新层，
+// 查询时任意一层存在即可能存在。总误判率不超过 Σ P·(1-r)·r^i = P，数据量增长后不需要手动重建
+//
+// 代价：查询要检查每一层，层数随数据量对数增长（容量1万、增长到1000万约10层）
+type ScalableBloomFilter struct {
+	rds      *redis.Redis
+	key      string
+	capacity uint64
+	fpRate   float64
+}
+
+// BloomLayerStats 可扩展布隆过滤器一层的状态
+type BloomLayerStats struct {
+	BloomParams
+	Capacity     uint64  // 本层容量
+	Items        uint64  // 本层元素个数
+	TargetFPRate float64 // 本层目标误判率
+	FillRatio    float64 // 本层位数组中1的比例
+}
+
+// ScalableBloomStats 可扩展布隆过滤器的状态
+type ScalableBloomStats struct {
+	Layers          []BloomLayerStats
+	Items           uint64  // 所有层的元素个数
+	TargetFPRate    float64 // 目标误判率
+	EstimatedFPRate float64 // 按每层填充率估算的误判率：1 - Π(1 - FillRatio^k)
+}
+
+// String 格式化输出
+func (s ScalableBloomStats) String() string {
+	return fmt.Sprintf("层数=%d 元素=%d 目标误判率=%.3f%% 估算误判率=%.3f%%",
+		len(s.Layers), s.Items, s.TargetFPRate*100, s.EstimatedFPRate*100)
+}
+
+// NewScalableBloomFilter 创建可扩展布隆过滤器
+// initialCapacity 为第一层的容量，fpRate 为总的目标误判率；使用前需要调用 Init 或 Load
+func NewScalableBloomFilter(rds *redis.Redis, key string, initialCapacity uint64, fpRate float64) *ScalableBloomFilter {
+	if initialCapacity == 0 {
+		initialCapacity = MinBloomCapacity
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = DefaultBloomFPRate
+	}
+	return &ScalableBloomFilter{
+		rds:      rds,
+		key:      key,
+		capacity: initialCapacity,
+		fpRate:   fpRate,
+	}
+}
+
+// Init 初始化配置和第一层，已初始化时不做修改
+// 没有初始化时 Add、Exists 返回 ErrBloomNotBuilt，避免只加了新用户的过滤器把老用户拦截掉
+func (f *ScalableBloomFilter) Init() error {
+	_, err := f.rds.Eval(scalableBloomInitScript, f.keys(),
+		strconv.FormatUint(f.capacity, 10),
+		strconv.FormatFloat(f.fpRate, 'g', -1, 64),
+		strconv.Itoa(ScalableBloomGrowth),
+		strconv.FormatFloat(ScalableBloomTightening, 'g', -1, 64))
+	if err != nil {
+		return fmt.Errorf("初始化可扩展布隆过滤器失败: %w", err)
+	}
+	return nil
+}
+
+// Add 添加元素
+func (f *ScalableBloomFilter) Add(data []byte) error {
+	resp, err := f.rds.Eval(scalableBloomAddScript, f.keys(), bloomHashArgs(data)...)
+	if err != nil {
+		return fmt.Errorf("添加到可扩展布隆过滤器失败: %w", err)
+	}
+	if code, ok := resp.(int64); ok && code == -1 {
+		return ErrBloomNotBuilt
+	}
+	return nil
+}
+
+// Exists 检查元素是否可能存在
+func (f *ScalableBloomFilter) Exists(data []byte) (bool, error) {
+	resp, err := f.rds.Eval(scalableBloomExistsScript, f.keys(), bloomHashArgs(data)...)
+	if err != nil {
+		return false, fmt.Errorf("查询可扩展布隆过滤器失败: %w", err)
+	}
+	code, ok := resp.(int64)
+	if ok && code == -1 {
+		return false, ErrBloomNotBuilt
+	}
+	return ok && code == 1, nil
+}
+
+// Load 初始化后从数据源分页加载所有ID（已存在的元素会跳过，可以重复执行），返回加载的ID个数
+func (f *ScalableBloomFilter) Load(source IDSource, member func(id int64) string, pageSize int) (int, error) {
+	if err := f.Init(); err != nil {
+		return 0, err
+	}
+	if pageSize <= 0 {
+		pageSize = DefaultBloomPageSize
+	}
+
+	loaded := 0
+	for afterID := int64(0); ; {
+		ids, err := source.ListIDs(afterID, pageSize)
+		if err != nil {
+			return loaded, fmt.Errorf("分页查询ID失败(after=%d): %w", afterID, err)
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		// 每个ID一次Lua脚本（需要按顺序判断是否已存在、是否要追加新层），每页一次 Pipeline
+		err = f.rds.Pipelined(func(pipe redis.Pipeliner) error {
+			ctx := context.Background()
+			for _, id := range ids {
+				pipe.Eval(ctx, scalableBloomAddScript, f.keys(), bloomHashArgs([]byte(member(id)))...)
+			}
+			return nil
+		})
+		if err != nil {
+			return loaded, fmt.Errorf("写入可扩展布隆过滤器失败(after=%d): %w", afterID, err)
+		}
+
+		loaded += len(ids)
+		afterID = ids[len(ids)-1]
+		if len(ids) < pageSize {
+			break
+		}
+	}
+	return loaded, nil
+}
+
+// Stats 查询每一层的参数、元素个数和填充率，未初始化时返回 ErrBloomNotBuilt
+func (f *ScalableBloomFilter) Stats() (ScalableBloomStats, error) {
+	meta, err := f.rds.Hgetall(bloomMetaKey(f.key))
+	if err != nil {
+		return ScalableBloomStats{}, fmt.Errorf("查询可扩展布隆过滤器元数据失败: %w", err)
+	}
+	layers, _ := strconv.Atoi(meta["layers"])
+	if layers == 0 {
+		return ScalableBloomStats{}, ErrBloomNotBuilt
+	}
+
+	stats := ScalableBloomStats{Layers: make([]BloomLayerStats, layers)}
+	stats.TargetFPRate, _ = strconv.ParseFloat(meta[bloomFieldFPRate], 64)
+	notFP := 1.0
+	for i := range stats.Layers {
+		field := func(name string) string {
+			return meta[fmt.Sprintf("%d:%s", i, name)]
+		}
+		layer := &stats.Layers[i]
+		offset, _ := strconv.ParseFloat(field("offset"), 64)
+		bits, _ := strconv.ParseFloat(field(bloomFieldBits), 64)
+		capacity, _ := strconv.ParseFloat(field(bloomFieldCapacity), 64)
+		hashes, _ := strconv.Atoi(field(bloomFieldHashes))
+		layer.Bits = uint64(bits)
+		layer.Hashes = uint(hashes)
+		layer.Capacity = uint64(capacity)
+		layer.Items, _ = strconv.ParseUint(field(bloomFieldItems), 10, 64)
+		layer.TargetFPRate, _ = strconv.ParseFloat(field(bloomFieldFPRate), 64)
+
+		// offset 按字节对齐，本层占用的字节不会和其他层重叠
+		start := int64(offset) / 8
+		end := (int64(offset) + int64(bits) - 1) / 8
+		ones, err := f.rds.BitCount(f.key, start, end)
+		if err != nil {
+			return ScalableBloomStats{}, fmt.Errorf("统计第%d层填充率失败: %w", i, err)
+		}
+		if layer.Bits > 0 {
+			layer.FillRatio = float64(ones) / float64(layer.Bits)
+		}
+
+		stats.Items += layer.Items
+		notFP *= 1 - math.Pow(layer.FillRatio, float64(layer.Hashes))
+	}
+	stats.EstimatedFPRate = 1 - notFP
+	return stats, nil
+}
+
+// Clear 清空过滤器（包括配置，需要重新 Init）
+func (f *ScalableBloomFilter) Clear() error {
+	_, err := f.rds.Del(f.keys()...)
+	return err
+}
+
+// keys 位数组和元数据的key
+func (f *ScalableBloomFilter) keys() []string {
+	return []string{f.key, bloomMetaKey(f.key)}
+}
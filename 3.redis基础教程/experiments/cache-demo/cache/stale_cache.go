@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"cache-demo/breaker"
+	"context"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+const (
+	// StaleKeyPrefix 旧数据副本的Key前缀（stale:user:1）
+	StaleKeyPrefix = "stale:"
+	// DefaultStaleExtraSeconds 旧数据副本默认比正常缓存多保留的时间（1小时）
+	DefaultStaleExtraSeconds = 3600
+)
+
+// WithBreaker 所有Redis操作经过熔断器：Redis故障时直接返回 breaker.ErrOpen，不再等待超时
+// 读取时返回的错误按缓存读取失败处理（回源加载），写入失败只记录日志，所以熔断期间请求直接查数据库
+func WithBreaker[K any, V any](brk *breaker.Breaker) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.breaker = brk
+	}
+}
+
+// WithStaleCopy 写入缓存时同时写一份旧数据副本，比正常缓存多保留 extraSeconds 秒（为0时不写副本）
+// 正常缓存过期后数据库又不可用时（熔断器打开），读穿透缓存可以降级返回副本中的旧数据（见 WithStaleFallback）
+// 删除缓存时副本一起删除：数据已经修改或删除时，不会返回修改之前的数据
+func WithStaleCopy[K any, V any](extraSeconds int) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.staleTTL = max(extraSeconds, 0)
+	}
+}
+
+// GetStale 读取旧数据副本，没有配置 WithStaleCopy 或副本不存在时返回 ErrCacheMiss
+func (c *Cache[K, V]) GetStale(key K) (V, error) {
+	return c.GetStaleCtx(context.Background(), key)
+}
+
+// GetStaleCtx 读取旧数据副本
+func (c *Cache[K, V]) GetStaleCtx(ctx context.Context, key K) (V, error) {
+	var zero V
+	if c.staleTTL == 0 {
+		return zero, ErrCacheMiss
+	}
+
+	redisKey := c.staleKey(key)
+	var val string
+	err := c.exec(func() (err error) {
+		val, err = c.rds.GetCtx(ctx, redisKey)
+		return err
+	})
+	if err != nil {
+		return zero, err
+	}
+	return c.decode(ctx, redisKey, val)
+}
+
+// staleKey 旧数据副本的Key
+func (c *Cache[K, V]) staleKey(key K) string {
+	return StaleKeyPrefix + c.keyFunc(key)
+}
+
+// setWithStale 在一个 pipeline 中写入缓存和旧数据副本
+func (c *Cache[K, V]) setWithStale(ctx context.Context, redisKey, data string, ttl int) error {
+	return c.rds.PipelinedCtx(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SetEX(ctx, redisKey, data, time.Duration(ttl)*time.Second)
+		pipe.SetEX(ctx, StaleKeyPrefix+redisKey, data, time.Duration(ttl+c.staleTTL)*time.Second)
+		return nil
+	})
+}
+
+// exec 执行Redis操作，配置了熔断器时经过熔断器
+func (c *Cache[K, V]) exec(fn func() error) error {
+	if c.breaker == nil {
+		return fn()
+	}
+	return c.breaker.Do(fn)
+}
@@ -2,7 +2,7 @@ package cache
 
 import (
 	"cache-demo/model"
-	"encoding/json"
+	"context"
 	"fmt"
 
 	"github.com/zeromicro/go-zero/core/stores/redis"
@@ -13,23 +13,55 @@ const (
 	UserCacheKeyPrefix = "user:"
 	// DefaultExpireSeconds 默认过期时间（5分钟）
 	DefaultExpireSeconds = 300
+	// UserCacheName 用户缓存的指标名称
+	UserCacheName = "user"
 )
 
 // UserCache 用户缓存接口
+// 带 Ctx 后缀的方法把 ctx 传给Redis客户端，不带 ctx 的方法使用 context.Background()
 type UserCache interface {
 	GetUser(id int64) (*model.User, error)
+	GetUserCtx(ctx context.Context, id int64) (*model.User, error)
 	SetUser(user *model.User, expireSeconds int) error
+	SetUserCtx(ctx context.Context, user *model.User, expireSeconds int) error
 	DeleteUser(id int64) error
+	DeleteUserCtx(ctx context.Context, id int64) error
 }
 
 // userCache 用户缓存实现
+// 各种变体（布隆过滤器、空值缓存、随机过期时间）都基于同一个通用缓存，只是组合的 Option 不同
+// 所有变体都带有用户名二级索引（UsernameIndex）
 type userCache struct {
-	rds *redis.Redis
+	*Cache[int64, *model.User]
+	*usernameIndex
 }
 
-// NewUserCache 创建用户缓存实例
-func NewUserCache(rds *redis.Redis) UserCache {
-	return &userCache{rds: rds}
+// NewUserCache 创建用户缓存实例，可以通过 WithUserCodec 指定编解码器（默认JSON）
+func NewUserCache(rds *redis.Redis, opts ...Option[int64, *model.User]) UserCache {
+	return newUserCache(rds, opts...)
+}
+
+// newUserCache 创建基于通用缓存的用户缓存
+func newUserCache(rds *redis.Redis, opts ...Option[int64, *model.User]) *userCache {
+	return &userCache{
+		Cache:         NewUserEntityCache(rds, opts...),
+		usernameIndex: newUsernameIndex(rds),
+	}
+}
+
+// NewUserEntityCache 创建以用户ID为Key的通用缓存
+// 写入时比较 User.Version（WithVersion）：并发更新、读请求回填不会用旧版本覆盖缓存中的新版本
+func NewUserEntityCache(rds *redis.Redis, opts ...Option[int64, *model.User]) *Cache[int64, *model.User] {
+	opts = append([]Option[int64, *model.User]{WithVersion[int64, *model.User](userVersion)}, opts...)
+	return New[int64, *model.User](rds, getUserKey, opts...)
+}
+
+// userVersion 用户的版本号
+func userVersion(user *model.User) int64 {
+	if user == nil {
+		return 0
+	}
+	return user.Version
 }
 
 // getUserKey 生成用户缓存Key
@@ -39,54 +71,33 @@ func getUserKey(id int64) string {
 
 // GetUser 从Redis获取用户信息
 func (c *userCache) GetUser(id int64) (*model.User, error) {
-	key := getUserKey(id)
-
-	// 从Redis获取数据
-	val, err := c.rds.Get(key)
-	if err != nil {
-		// Redis中没有数据或连接错误
-		return nil, err
-	}
-
-	// 反序列化JSON数据
-	var user model.User
-	if err := json.Unmarshal([]byte(val), &user); err != nil {
-		return nil, fmt.Errorf("反序列化用户数据失败: %w", err)
-	}
+	return c.GetUserCtx(context.Background(), id)
+}
 
-	return &user, nil
+// GetUserCtx 从Redis获取用户信息
+func (c *userCache) GetUserCtx(ctx context.Context, id int64) (*model.User, error) {
+	return c.GetCtx(ctx, id)
 }
 
 // SetUser 设置用户信息到Redis
 func (c *userCache) SetUser(user *model.User, expireSeconds int) error {
+	return c.SetUserCtx(context.Background(), user, expireSeconds)
+}
+
+// SetUserCtx 设置用户信息到Redis
+func (c *userCache) SetUserCtx(ctx context.Context, user *model.User, expireSeconds int) error {
 	if user == nil {
 		return fmt.Errorf("用户数据不能为空")
 	}
-
-	key := getUserKey(user.ID)
-
-	// 序列化为JSON
-	data, err := json.Marshal(user)
-	if err != nil {
-		return fmt.Errorf("序列化用户数据失败: %w", err)
-	}
-
-	// 设置到Redis，带过期时间
-	if expireSeconds <= 0 {
-		expireSeconds = DefaultExpireSeconds
-	}
-
-	err = c.rds.Setex(key, string(data), expireSeconds)
-	if err != nil {
-		return fmt.Errorf("设置缓存失败: %w", err)
-	}
-
-	return nil
+	return c.SetExCtx(ctx, user.ID, user, expireSeconds)
 }
 
 // DeleteUser 删除用户缓存
 func (c *userCache) DeleteUser(id int64) error {
-	key := getUserKey(id)
-	_, err := c.rds.Del(key)
-	return err
+	return c.DeleteUserCtx(context.Background(), id)
+}
+
+// DeleteUserCtx 删除用户缓存
+func (c *userCache) DeleteUserCtx(ctx context.Context, id int64) error {
+	return c.DeleteCtx(ctx, id)
 }
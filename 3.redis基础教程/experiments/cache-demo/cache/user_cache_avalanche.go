@@ -2,7 +2,7 @@ package cache
 
 import (
 	"cache-demo/model"
-	"encoding/json"
+	"context"
 	"fmt"
 	"math/rand"
 	"time"
@@ -20,112 +20,51 @@ const (
 // UserCacheWithAvalanche 支持缓存雪崩优化的用户缓存接口
 type UserCacheWithAvalanche interface {
 	GetUser(id int64) (*model.User, error)
+	GetUserCtx(ctx context.Context, id int64) (*model.User, error)
 	SetUserWithRandomExpire(user *model.User, baseExpireSeconds int) error
+	SetUserWithRandomExpireCtx(ctx context.Context, user *model.User, baseExpireSeconds int) error
 	SetUserWithFixedExpire(user *model.User, expireSeconds int) error
+	SetUserWithFixedExpireCtx(ctx context.Context, user *model.User, expireSeconds int) error
 	DeleteUser(id int64) error
+	DeleteUserCtx(ctx context.Context, id int64) error
 }
 
 // userCacheWithAvalanche 支持缓存雪崩优化的用户缓存实现
+// fixed 和 random 共享同一个Key空间，只是过期时间策略不同
 type userCacheWithAvalanche struct {
-	rds *redis.Redis
+	*userCache
+	random *Cache[int64, *model.User]
 }
 
 // NewUserCacheWithAvalanche 创建支持缓存雪崩优化的用户缓存实例
 func NewUserCacheWithAvalanche(rds *redis.Redis) UserCacheWithAvalanche {
-	return &userCacheWithAvalanche{rds: rds}
-}
-
-// getUserKey 生成用户缓存Key
-func (c *userCacheWithAvalanche) getUserKey(id int64) string {
-	return fmt.Sprintf("%s%d", UserCacheKeyPrefix, id)
-}
-
-// GetUser 从Redis获取用户信息
-func (c *userCacheWithAvalanche) GetUser(id int64) (*model.User, error) {
-	key := c.getUserKey(id)
-
-	// 从Redis获取数据
-	val, err := c.rds.Get(key)
-	if err != nil {
-		return nil, err
+	return &userCacheWithAvalanche{
+		userCache: newUserCache(rds),
+		random:    NewUserEntityCache(rds, WithRandomExpire[int64, *model.User](AvalancheRandomRangePercent)),
 	}
-
-	// 反序列化JSON数据
-	var user model.User
-	if err := json.Unmarshal([]byte(val), &user); err != nil {
-		return nil, fmt.Errorf("反序列化用户数据失败: %w", err)
-	}
-
-	return &user, nil
 }
 
 // SetUserWithRandomExpire 设置用户信息到Redis（使用随机过期时间，防止缓存雪崩）
 func (c *userCacheWithAvalanche) SetUserWithRandomExpire(user *model.User, baseExpireSeconds int) error {
+	return c.SetUserWithRandomExpireCtx(context.Background(), user, baseExpireSeconds)
+}
+
+// SetUserWithRandomExpireCtx 设置用户信息到Redis（使用随机过期时间）
+func (c *userCacheWithAvalanche) SetUserWithRandomExpireCtx(ctx context.Context, user *model.User, baseExpireSeconds int) error {
 	if user == nil {
 		return fmt.Errorf("用户数据不能为空")
 	}
-
-	key := c.getUserKey(user.ID)
-
-	// 序列化为JSON
-	data, err := json.Marshal(user)
-	if err != nil {
-		return fmt.Errorf("序列化用户数据失败: %w", err)
-	}
-
-	// 计算随机过期时间：基础过期时间 + 随机值（0-10%范围）
-	if baseExpireSeconds <= 0 {
-		baseExpireSeconds = DefaultExpireSeconds
-	}
-
-	// 随机范围：基础过期时间的0-10%
-	randomRange := baseExpireSeconds * AvalancheRandomRangePercent / 100
-	if randomRange == 0 {
-		randomRange = 1 // 至少1秒的随机范围
-	}
-	randomExpire := rand.Intn(randomRange)
-	expire := baseExpireSeconds + randomExpire
-
-	err = c.rds.Setex(key, string(data), expire)
-	if err != nil {
-		return fmt.Errorf("设置缓存失败: %w", err)
-	}
-
-	return nil
+	return c.random.SetExCtx(ctx, user.ID, user, baseExpireSeconds)
 }
 
 // SetUserWithFixedExpire 设置用户信息到Redis（使用固定过期时间，用于模拟缓存雪崩）
 func (c *userCacheWithAvalanche) SetUserWithFixedExpire(user *model.User, expireSeconds int) error {
-	if user == nil {
-		return fmt.Errorf("用户数据不能为空")
-	}
-
-	key := c.getUserKey(user.ID)
-
-	// 序列化为JSON
-	data, err := json.Marshal(user)
-	if err != nil {
-		return fmt.Errorf("序列化用户数据失败: %w", err)
-	}
-
-	// 设置到Redis，带固定过期时间
-	if expireSeconds <= 0 {
-		expireSeconds = DefaultExpireSeconds
-	}
-
-	err = c.rds.Setex(key, string(data), expireSeconds)
-	if err != nil {
-		return fmt.Errorf("设置缓存失败: %w", err)
-	}
-
-	return nil
+	return c.SetUserWithFixedExpireCtx(context.Background(), user, expireSeconds)
 }
 
-// DeleteUser 删除用户缓存
-func (c *userCacheWithAvalanche) DeleteUser(id int64) error {
-	key := c.getUserKey(id)
-	_, err := c.rds.Del(key)
-	return err
+// SetUserWithFixedExpireCtx 设置用户信息到Redis（使用固定过期时间）
+func (c *userCacheWithAvalanche) SetUserWithFixedExpireCtx(ctx context.Context, user *model.User, expireSeconds int) error {
+	return c.SetUserCtx(ctx, user, expireSeconds)
 }
 
 // GetRandomExpireTime 计算随机过期时间（用于测试和日志）
@@ -133,12 +72,7 @@ func GetRandomExpireTime(baseExpireSeconds int) int {
 	if baseExpireSeconds <= 0 {
 		baseExpireSeconds = DefaultExpireSeconds
 	}
-	randomRange := baseExpireSeconds * AvalancheRandomRangePercent / 100
-	if randomRange == 0 {
-		randomRange = 1
-	}
-	randomExpire := rand.Intn(randomRange)
-	return baseExpireSeconds + randomExpire
+	return RandomTTL(AvalancheRandomRangePercent)(baseExpireSeconds)
 }
 
 // 初始化随机数种子
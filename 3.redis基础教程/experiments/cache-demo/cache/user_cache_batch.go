@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"cache-demo/model"
+	"context"
+	"fmt"
+)
+
+// UserBatchCache 支持批量读写的用户缓存接口
+// NewUserCache 等基于通用缓存的实现都满足该接口
+type UserBatchCache interface {
+	// GetUsers 批量读取（MGET），返回与 ids 一一对应的用户和错误（含义同 GetUser）
+	GetUsers(ids []int64) ([]*model.User, []error, error)
+	GetUsersCtx(ctx context.Context, ids []int64) ([]*model.User, []error, error)
+	// SetUsers 批量写入（pipeline SETEX）
+	SetUsers(users []*model.User, expireSeconds int) error
+	SetUsersCtx(ctx context.Context, users []*model.User, expireSeconds int) error
+	// SetNullUsers 批量写入空值缓存（pipeline SETEX）
+	SetNullUsers(ids []int64) error
+	SetNullUsersCtx(ctx context.Context, ids []int64) error
+}
+
+// GetUsers 批量读取用户缓存
+func (c *userCache) GetUsers(ids []int64) ([]*model.User, []error, error) {
+	return c.GetUsersCtx(context.Background(), ids)
+}
+
+// GetUsersCtx 批量读取用户缓存
+func (c *userCache) GetUsersCtx(ctx context.Context, ids []int64) ([]*model.User, []error, error) {
+	return c.MGetCtx(ctx, ids)
+}
+
+// SetUsers 批量写入用户缓存
+func (c *userCache) SetUsers(users []*model.User, expireSeconds int) error {
+	return c.SetUsersCtx(context.Background(), users, expireSeconds)
+}
+
+// SetUsersCtx 批量写入用户缓存
+func (c *userCache) SetUsersCtx(ctx context.Context, users []*model.User, expireSeconds int) error {
+	ids := make([]int64, len(users))
+	for i, user := range users {
+		if user == nil {
+			return fmt.Errorf("用户数据不能为空")
+		}
+		ids[i] = user.ID
+	}
+	return c.SetManyCtx(ctx, ids, users, expireSeconds)
+}
+
+// SetNullUsers 批量写入空值缓存
+func (c *userCache) SetNullUsers(ids []int64) error {
+	return c.SetNullUsersCtx(context.Background(), ids)
+}
+
+// SetNullUsersCtx 批量写入空值缓存
+func (c *userCache) SetNullUsersCtx(ctx context.Context, ids []int64) error {
+	return c.SetNullManyCtx(ctx, ids)
+}
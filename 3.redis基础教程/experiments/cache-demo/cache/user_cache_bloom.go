@@ -2,110 +2,72 @@ package cache
 
 import (
 	"cache-demo/model"
-	"encoding/json"
-	"fmt"
 
-	"github.com/zeromicro/go-zero/core/bloom"
 	"github.com/zeromicro/go-zero/core/stores/redis"
 )
 
 const (
-	// BloomFilterKey 布隆过滤器在Redis中的key
+	// BloomFilterKey 布隆过滤器在Redis中的key（参数保存在 user_bloom_filter:meta，由 BloomManager 构建）
 	BloomFilterKey = "user_bloom_filter"
-	// BloomFilterBits 布隆过滤器位数组大小（100万，误判率约1%）
+	// BloomFilterBits 计数布隆过滤器的计数器个数（100万，误判率约1%）
 	BloomFilterBits = 1000000
+	// CountingBloomFilterKey 计数布隆过滤器在Redis中的key
+	CountingBloomFilterKey = "user_counting_bloom_filter"
+	// ScalableBloomFilterKey 可扩展布隆过滤器在Redis中的key
+	ScalableBloomFilterKey = "user_scalable_bloom_filter"
+	// ScalableBloomInitialCapacity 可扩展布隆过滤器第一层的容量
+	ScalableBloomInitialCapacity = 100000
 )
 
 // UserCacheWithBloom 支持布隆过滤器的用户缓存接口
 type UserCacheWithBloom interface {
-	GetUser(id int64) (*model.User, error)
-	SetUser(user *model.User, expireSeconds int) error
-	DeleteUser(id int64) error
+	UserCache
 	AddToBloomFilter(id int64) error
 	ExistsInBloomFilter(id int64) (bool, error)
-}
-
-// userCacheWithBloom 支持布隆过滤器的用户缓存实现
-type userCacheWithBloom struct {
-	rds         *redis.Redis
-	bloomFilter *bloom.Filter
+	// RemoveFromFilter 从过滤器中删除用户ID，过滤器不支持删除时返回 ErrFilterNotDeletable
+	RemoveFromFilter(id int64) error
 }
 
 // NewUserCacheWithBloom 创建支持布隆过滤器的用户缓存实例
+// 过滤器需要先用 NewUserBloomManager(...).Rebuild() 构建，没有构建时查询不拦截（按可能存在处理）
 func NewUserCacheWithBloom(rds *redis.Redis) UserCacheWithBloom {
-	bloomFilter := bloom.New(rds, BloomFilterKey, BloomFilterBits)
-	return &userCacheWithBloom{
-		rds:         rds,
-		bloomFilter: bloomFilter,
-	}
+	bloomFilter := NewBloomFilter(rds, BloomFilterKey)
+	return newUserCache(rds, WithFilter[int64, *model.User](bloomFilter))
 }
 
-// getUserKey 生成用户缓存Key
-func (c *userCacheWithBloom) getUserKey(id int64) string {
-	return fmt.Sprintf("%s%d", UserCacheKeyPrefix, id)
+// NewUserBloomManager 创建用户布隆过滤器的管理器（从 source 分页加载所有用户ID）
+func NewUserBloomManager(rds *redis.Redis, source IDSource, opts ...BloomManagerOption) *BloomManager {
+	return NewBloomManager(rds, BloomFilterKey, source, getUserKey, opts...)
 }
 
-// GetUser 从Redis获取用户信息
-func (c *userCacheWithBloom) GetUser(id int64) (*model.User, error) {
-	key := c.getUserKey(id)
-
-	// 从Redis获取数据
-	val, err := c.rds.Get(key)
-	if err != nil {
-		return nil, err
-	}
-
-	// 反序列化JSON数据
-	var user model.User
-	if err := json.Unmarshal([]byte(val), &user); err != nil {
-		return nil, fmt.Errorf("反序列化用户数据失败: %w", err)
-	}
-
-	return &user, nil
+// NewUserCacheWithCountingBloom 创建使用计数布隆过滤器的用户缓存实例（删除用户时可以从过滤器中删除）
+func NewUserCacheWithCountingBloom(rds *redis.Redis) UserCacheWithBloom {
+	filter := NewCountingBloomFilter(rds, CountingBloomFilterKey, BloomFilterBits, CountingBloomHashes)
+	return newUserCache(rds, WithFilter[int64, *model.User](filter))
 }
 
-// SetUser 设置用户信息到Redis
-func (c *userCacheWithBloom) SetUser(user *model.User, expireSeconds int) error {
-	if user == nil {
-		return fmt.Errorf("用户数据不能为空")
-	}
-
-	key := c.getUserKey(user.ID)
-
-	// 序列化为JSON
-	data, err := json.Marshal(user)
-	if err != nil {
-		return fmt.Errorf("序列化用户数据失败: %w", err)
-	}
-
-	// 设置到Redis，带过期时间
-	if expireSeconds <= 0 {
-		expireSeconds = DefaultExpireSeconds
-	}
-
-	err = c.rds.Setex(key, string(data), expireSeconds)
-	if err != nil {
-		return fmt.Errorf("设置缓存失败: %w", err)
-	}
+// NewUserScalableBloomFilter 创建用户的可扩展布隆过滤器（目标误判率 DefaultBloomFPRate）
+func NewUserScalableBloomFilter(rds *redis.Redis) *ScalableBloomFilter {
+	return NewScalableBloomFilter(rds, ScalableBloomFilterKey, ScalableBloomInitialCapacity, DefaultBloomFPRate)
+}
 
-	return nil
+// NewUserCacheWithScalableBloom 创建使用可扩展布隆过滤器的用户缓存实例（用户数增长后误判率不变，不需要重建）
+// 过滤器需要先用 LoadUserScalableBloom 初始化并加载现有用户
+func NewUserCacheWithScalableBloom(rds *redis.Redis) UserCacheWithBloom {
+	return newUserCache(rds, WithFilter[int64, *model.User](NewUserScalableBloomFilter(rds)))
 }
 
-// DeleteUser 删除用户缓存
-func (c *userCacheWithBloom) DeleteUser(id int64) error {
-	key := c.getUserKey(id)
-	_, err := c.rds.Del(key)
-	return err
+// LoadUserScalableBloom 初始化用户的可扩展布隆过滤器，并从 source 分页加载所有用户ID
+func LoadUserScalableBloom(rds *redis.Redis, source IDSource) (int, error) {
+	return NewUserScalableBloomFilter(rds).Load(source, getUserKey, DefaultBloomPageSize)
 }
 
 // AddToBloomFilter 添加用户ID到布隆过滤器
-func (c *userCacheWithBloom) AddToBloomFilter(id int64) error {
-	key := fmt.Sprintf("user:%d", id)
-	return c.bloomFilter.Add([]byte(key))
+func (c *userCache) AddToBloomFilter(id int64) error {
+	return c.AddToFilter(id)
 }
 
 // ExistsInBloomFilter 检查用户ID是否在布隆过滤器中
-func (c *userCacheWithBloom) ExistsInBloomFilter(id int64) (bool, error) {
-	key := fmt.Sprintf("user:%d", id)
-	return c.bloomFilter.Exists([]byte(key))
+func (c *userCache) ExistsInBloomFilter(id int64) (bool, error) {
+	return c.MayExist(id)
 }
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"cache-demo/model"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+const (
+	// LogicalUserCacheKeyPrefix 逻辑过期用户缓存的Key前缀（user:logical:1）
+	// 缓存的是 LogicalEntry 包装后的数据，格式和普通用户缓存（user:1）不同，不能共用同一个Key
+	LogicalUserCacheKeyPrefix = "user:logical:"
+	// LogicalExpireSeconds 默认逻辑过期时间（60秒）
+	LogicalExpireSeconds = 60
+	// LogicalPhysicalExpireSeconds 物理过期时间（7天，远大于逻辑过期时间，只用于兜底清理冷数据）
+	LogicalPhysicalExpireSeconds = 7 * 24 * 3600
+)
+
+// LogicalEntry 带逻辑过期时间的缓存条目
+// 数据在Redis中不会因为逻辑过期而消失，过期后仍可返回旧值，由后台异步重建
+type LogicalEntry[V any] struct {
+	Data            V     `json:"data"`
+	LogicalExpireAt int64 `json:"logical_expire_at"`
+}
+
+// Expired 是否已经逻辑过期
+func (e *LogicalEntry[V]) Expired() bool {
+	return time.Now().Unix() >= e.LogicalExpireAt
+}
+
+// UserCacheWithLogicalExpire 支持逻辑过期的用户缓存接口
+type UserCacheWithLogicalExpire interface {
+	// GetUser 返回缓存中的用户以及是否已经逻辑过期
+	GetUser(id int64) (*model.User, bool, error)
+	GetUserCtx(ctx context.Context, id int64) (*model.User, bool, error)
+	SetUser(user *model.User, logicalExpireSeconds int) error
+	SetUserCtx(ctx context.Context, user *model.User, logicalExpireSeconds int) error
+	DeleteUser(id int64) error
+	DeleteUserCtx(ctx context.Context, id int64) error
+}
+
+// userCacheWithLogicalExpire 支持逻辑过期的用户缓存实现
+type userCacheWithLogicalExpire struct {
+	entries *Cache[int64, *LogicalEntry[*model.User]]
+	*usernameIndex
+}
+
+// NewUserCacheWithLogicalExpire 创建支持逻辑过期的用户缓存实例
+// 写入时比较用户的版本号（见 WithVersion）：慢的重建、慢的更新不会用旧版本覆盖缓存，
+// 逻辑过期的数据永远不会从Redis中消失，被旧版本覆盖后会一直返回旧数据
+func NewUserCacheWithLogicalExpire(rds *redis.Redis) UserCacheWithLogicalExpire {
+	return &userCacheWithLogicalExpire{
+		entries: New[int64, *LogicalEntry[*model.User]](rds, getLogicalUserKey,
+			WithExpire[int64, *LogicalEntry[*model.User]](LogicalPhysicalExpireSeconds),
+			WithVersion[int64, *LogicalEntry[*model.User]](logicalUserVersion)),
+		usernameIndex: newUsernameIndex(rds),
+	}
+}
+
+// getLogicalUserKey 生成逻辑过期用户缓存Key
+func getLogicalUserKey(id int64) string {
+	return fmt.Sprintf("%s%d", LogicalUserCacheKeyPrefix, id)
+}
+
+// logicalUserVersion 逻辑过期条目中用户的版本号
+func logicalUserVersion(entry *LogicalEntry[*model.User]) int64 {
+	if entry == nil {
+		return 0
+	}
+	return userVersion(entry.Data)
+}
+
+// GetUser 从Redis获取用户信息及其逻辑过期状态
+func (c *userCacheWithLogicalExpire) GetUser(id int64) (*model.User, bool, error) {
+	return c.GetUserCtx(context.Background(), id)
+}
+
+// GetUserCtx 从Redis获取用户信息及其逻辑过期状态
+func (c *userCacheWithLogicalExpire) GetUserCtx(ctx context.Context, id int64) (*model.User, bool, error) {
+	entry, err := c.entries.GetCtx(ctx, id)
+	if err != nil {
+		return nil, false, err
+	}
+	if entry == nil || entry.Data == nil {
+		return nil, false, ErrCacheMiss
+	}
+	return entry.Data, entry.Expired(), nil
+}
+
+// SetUser 写入用户信息，并设置逻辑过期时间（物理上使用很长的过期时间）
+func (c *userCacheWithLogicalExpire) SetUser(user *model.User, logicalExpireSeconds int) error {
+	return c.SetUserCtx(context.Background(), user, logicalExpireSeconds)
+}
+
+// SetUserCtx 写入用户信息，并设置逻辑过期时间
+// 缓存中已经有更新的版本时不写入，返回 ErrStaleVersion；版本号相同时照常写入（重建会刷新逻辑过期时间）
+func (c *userCacheWithLogicalExpire) SetUserCtx(ctx context.Context, user *model.User, logicalExpireSeconds int) error {
+	if user == nil {
+		return fmt.Errorf("用户数据不能为空")
+	}
+	if logicalExpireSeconds <= 0 {
+		logicalExpireSeconds = LogicalExpireSeconds
+	}
+
+	entry := &LogicalEntry[*model.User]{
+		Data:            user,
+		LogicalExpireAt: time.Now().Add(time.Duration(logicalExpireSeconds) * time.Second).Unix(),
+	}
+	return c.entries.SetCtx(ctx, user.ID, entry)
+}
+
+// DeleteUser 删除用户缓存
+func (c *userCacheWithLogicalExpire) DeleteUser(id int64) error {
+	return c.DeleteUserCtx(context.Background(), id)
+}
+
+// DeleteUserCtx 删除用户缓存
+func (c *userCacheWithLogicalExpire) DeleteUserCtx(ctx context.Context, id int64) error {
+	return c.entries.DeleteCtx(ctx, id)
+}
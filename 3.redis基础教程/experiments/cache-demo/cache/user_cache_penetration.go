@@ -2,7 +2,7 @@ package cache
 
 import (
 	"cache-demo/model"
-	"encoding/json"
+	"context"
 	"fmt"
 
 	"github.com/zeromicro/go-zero/core/stores/redis"
@@ -13,111 +13,70 @@ const (
 	NullCacheValue = "NULL"
 	// NullCacheExpireSeconds 空值缓存过期时间（60秒）
 	NullCacheExpireSeconds = 60
+	// NullCacheRandomPercent 用户空值缓存过期时间的随机范围（60-90秒）
+	NullCacheRandomPercent = 50
+	// UserNullKeyIndex 记录用户空值缓存Key的有序集合
+	UserNullKeyIndex = "user:null_keys"
+	// MaxUserNullKeys 用户空值缓存Key的个数上限
+	MaxUserNullKeys = 100000
 )
 
 // UserCacheWithPenetration 支持缓存穿透防护的用户缓存接口
 type UserCacheWithPenetration interface {
-	GetUser(id int64) (*model.User, error)
-	SetUser(user *model.User, expireSeconds int) error
+	UserCache
 	SetNullUser(id int64) error
+	SetNullUserCtx(ctx context.Context, id int64) error
 	IsNullCache(id int64) (bool, error)
-	DeleteUser(id int64) error
-}
-
-// userCacheWithPenetration 支持缓存穿透防护的用户缓存实现
-type userCacheWithPenetration struct {
-	rds *redis.Redis
+	IsNullCacheCtx(ctx context.Context, id int64) (bool, error)
+	// NullKeyCount 当前未过期的空值缓存Key个数
+	NullKeyCount() (int, error)
+	// CompareAndSetUser 缓存中的版本号等于 expected 时写入 user（Write-Behind 在缓存上做乐观锁，见 Cache.CompareAndSetCtx）
+	CompareAndSetUser(user *model.User, expected int64, expireSeconds int, queued ...QueuedWrite) (int64, error)
+	CompareAndSetUserCtx(ctx context.Context, user *model.User, expected int64, expireSeconds int, queued ...QueuedWrite) (int64, error)
 }
 
 // NewUserCacheWithPenetration 创建支持缓存穿透防护的用户缓存实例
-func NewUserCacheWithPenetration(rds *redis.Redis) UserCacheWithPenetration {
-	return &userCacheWithPenetration{rds: rds}
+// 默认空值缓存过期时间为60-90秒的随机值，最多保留 MaxUserNullKeys 个空值Key，可以通过 opts 覆盖
+func NewUserCacheWithPenetration(rds *redis.Redis, opts ...Option[int64, *model.User]) UserCacheWithPenetration {
+	opts = append([]Option[int64, *model.User]{
+		WithNullCache[int64, *model.User](NullCacheExpireSeconds),
+		WithRandomNullExpire[int64, *model.User](NullCacheRandomPercent),
+		WithNullKeyLimit[int64, *model.User](UserNullKeyIndex, MaxUserNullKeys),
+	}, opts...)
+	return newUserCache(rds, opts...)
 }
 
-// getUserKey 生成用户缓存Key（复用原有函数）
-func (c *userCacheWithPenetration) getUserKey(id int64) string {
-	return fmt.Sprintf("%s%d", UserCacheKeyPrefix, id)
+// SetNullUser 设置空值缓存（用于防止缓存穿透）
+func (c *userCache) SetNullUser(id int64) error {
+	return c.SetNullUserCtx(context.Background(), id)
 }
 
-// GetUser 从Redis获取用户信息（支持空值缓存）
-func (c *userCacheWithPenetration) GetUser(id int64) (*model.User, error) {
-	key := c.getUserKey(id)
-
-	// 从Redis获取数据
-	val, err := c.rds.Get(key)
-	if err != nil {
-		// Redis中没有数据或连接错误
-		return nil, err
-	}
-
-	// 检查是否是空值缓存
-	if val == NullCacheValue {
-		// 返回特殊错误，表示是空值缓存
-		return nil, ErrNullCache
-	}
-
-	// 反序列化JSON数据
-	var user model.User
-	if err := json.Unmarshal([]byte(val), &user); err != nil {
-		return nil, fmt.Errorf("反序列化用户数据失败: %w", err)
-	}
-
-	return &user, nil
+// SetNullUserCtx 设置空值缓存
+func (c *userCache) SetNullUserCtx(ctx context.Context, id int64) error {
+	return c.SetNullCtx(ctx, id)
 }
 
-// SetUser 设置用户信息到Redis
-func (c *userCacheWithPenetration) SetUser(user *model.User, expireSeconds int) error {
-	if user == nil {
-		return fmt.Errorf("用户数据不能为空")
-	}
-
-	key := c.getUserKey(user.ID)
-
-	// 序列化为JSON
-	data, err := json.Marshal(user)
-	if err != nil {
-		return fmt.Errorf("序列化用户数据失败: %w", err)
-	}
-
-	// 设置到Redis，带过期时间
-	if expireSeconds <= 0 {
-		expireSeconds = DefaultExpireSeconds
-	}
-
-	err = c.rds.Setex(key, string(data), expireSeconds)
-	if err != nil {
-		return fmt.Errorf("设置缓存失败: %w", err)
-	}
-
-	return nil
+// IsNullCache 检查是否是空值缓存
+func (c *userCache) IsNullCache(id int64) (bool, error) {
+	return c.IsNullCacheCtx(context.Background(), id)
 }
 
-// SetNullUser 设置空值缓存（用于防止缓存穿透）
-func (c *userCacheWithPenetration) SetNullUser(id int64) error {
-	key := c.getUserKey(id)
-	err := c.rds.Setex(key, NullCacheValue, NullCacheExpireSeconds)
-	if err != nil {
-		return fmt.Errorf("设置空值缓存失败: %w", err)
-	}
-	return nil
+// IsNullCacheCtx 检查是否是空值缓存
+func (c *userCache) IsNullCacheCtx(ctx context.Context, id int64) (bool, error) {
+	return c.IsNullCtx(ctx, id)
 }
 
-// IsNullCache 检查是否是空值缓存
-func (c *userCacheWithPenetration) IsNullCache(id int64) (bool, error) {
-	key := c.getUserKey(id)
-	val, err := c.rds.Get(key)
-	if err != nil {
-		return false, err
-	}
-	return val == NullCacheValue, nil
+// CompareAndSetUser 缓存中的版本号等于 expected 时写入用户
+func (c *userCache) CompareAndSetUser(user *model.User, expected int64, expireSeconds int, queued ...QueuedWrite) (int64, error) {
+	return c.CompareAndSetUserCtx(context.Background(), user, expected, expireSeconds, queued...)
 }
 
-// DeleteUser 删除用户缓存（包括空值缓存）
-func (c *userCacheWithPenetration) DeleteUser(id int64) error {
-	key := c.getUserKey(id)
-	_, err := c.rds.Del(key)
-	return err
+// CompareAndSetUserCtx 缓存中的版本号等于 expected 时写入用户，返回缓存中的版本号
+// 传了 queued 时写入成功后在同一个脚本中入队（Write-Behind 的写队列）
+func (c *userCache) CompareAndSetUserCtx(ctx context.Context, user *model.User, expected int64, expireSeconds int,
+	queued ...QueuedWrite) (int64, error) {
+	if user == nil {
+		return 0, fmt.Errorf("用户数据不能为空")
+	}
+	return c.CompareAndSetCtx(ctx, user.ID, expected, user, expireSeconds, queued...)
 }
-
-// ErrNullCache 空值缓存错误（用于标识空值缓存命中）
-var ErrNullCache = fmt.Errorf("空值缓存命中")
@@ -0,0 +1,27 @@
+package cache
+
+import (
+	"cache-demo/breaker"
+	"cache-demo/model"
+	"context"
+)
+
+// WithUserBreaker 用户缓存的Redis操作经过熔断器
+func WithUserBreaker(brk *breaker.Breaker) Option[int64, *model.User] {
+	return WithBreaker[int64, *model.User](brk)
+}
+
+// WithUserStaleCopy 用户缓存写入时同时写旧数据副本（stale:user:<id>），比正常缓存多保留 extraSeconds 秒
+func WithUserStaleCopy(extraSeconds int) Option[int64, *model.User] {
+	return WithStaleCopy[int64, *model.User](extraSeconds)
+}
+
+// GetStaleUser 读取用户的旧数据副本（数据库不可用时降级使用）
+func (c *userCache) GetStaleUser(id int64) (*model.User, error) {
+	return c.GetStaleUserCtx(context.Background(), id)
+}
+
+// GetStaleUserCtx 读取用户的旧数据副本
+func (c *userCache) GetStaleUserCtx(ctx context.Context, id int64) (*model.User, error) {
+	return c.GetStaleCtx(ctx, id)
+}
@@ -0,0 +1,272 @@
+package cache
+
+import (
+	"cache-demo/model"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	red "github.com/go-redis/redis/v8"
+	"github.com/zeromicro/go-zero/core/collection"
+)
+
+// errUsernameIndexUnsupported L2缓存没有实现用户名索引
+var errUsernameIndexUnsupported = errors.New("L2缓存不支持用户名索引")
+
+const (
+	// L1InvalidateChannel 一级缓存失效通知的 pub/sub 频道
+	L1InvalidateChannel = "user_cache:l1_invalidate"
+	// L1DefaultSize 一级缓存默认容量（LRU淘汰）
+	L1DefaultSize = 10000
+	// L1DefaultExpire 一级缓存默认过期时间（远小于Redis的过期时间，限制不一致窗口）
+	L1DefaultExpire = 30 * time.Second
+)
+
+// l1GenerationStripes L1失效代数的分段数：按用户ID取模，同一段的用户共用一个计数器
+// 不同用户落在同一段时只会多跳过几次回填，不影响正确性
+const l1GenerationStripes = 1024
+
+// TwoLevelUserCache 二级用户缓存接口：进程内LRU（L1） + Redis（L2）
+type TwoLevelUserCache interface {
+	UserCache
+	// Close 停止订阅失效通知
+	Close() error
+}
+
+// twoLevelUserCache 二级用户缓存实现
+// 写操作（SetUser/DeleteUser）通过 Redis pub/sub 广播，其他实例收到后删除自己的L1副本
+type twoLevelUserCache struct {
+	l1         *collection.Cache
+	l1Expire   time.Duration
+	l2         UserCache
+	names      UsernameIndex
+	client     *red.Client
+	pubsub     *red.PubSub
+	instanceID string
+	done       chan struct{}
+	// generations L1失效代数：写入、删除、收到失效通知时加1
+	// 读L2之前记下代数，回填L1前后代数变了说明期间有失效，不回填（回填的可能是失效之前读到的旧数据）
+	generations [l1GenerationStripes]atomic.Uint64
+}
+
+// NewTwoLevelUserCache 创建二级用户缓存实例
+// l2 可以是任意 UserCache 实现；client 用于订阅/发布失效通知
+func NewTwoLevelUserCache(l2 UserCache, client *red.Client, l1Size int, l1Expire time.Duration) (TwoLevelUserCache, error) {
+	if l1Size <= 0 {
+		l1Size = L1DefaultSize
+	}
+	if l1Expire <= 0 {
+		l1Expire = L1DefaultExpire
+	}
+
+	l1, err := collection.NewCache(l1Expire, collection.WithLimit(l1Size), collection.WithName("user_l1"))
+	if err != nil {
+		return nil, fmt.Errorf("创建一级缓存失败: %w", err)
+	}
+
+	// 先确认订阅成功，避免漏掉启动期间的失效通知
+	ctx := context.Background()
+	pubsub := client.Subscribe(ctx, L1InvalidateChannel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("订阅失效通知失败: %w", err)
+	}
+
+	// 用户名索引不进L1（改名时无法广播旧用户名），直接使用L2的实现
+	names, _ := l2.(UsernameIndex)
+
+	c := &twoLevelUserCache{
+		l1:         l1,
+		l1Expire:   l1Expire,
+		l2:         l2,
+		names:      names,
+		client:     client,
+		pubsub:     pubsub,
+		instanceID: fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano()),
+		done:       make(chan struct{}),
+	}
+	go c.listen()
+
+	return c, nil
+}
+
+// GetUser 先查L1，未命中再查L2并回填L1
+func (c *twoLevelUserCache) GetUser(id int64) (*model.User, error) {
+	return c.GetUserCtx(context.Background(), id)
+}
+
+// GetUserCtx 先查L1，未命中再查L2并回填L1（ctx 只用于L2）
+// 读L2和回填L1之间收到失效通知（或本实例写入、删除）时不回填，否则失效之前读到的旧数据会在L1中一直留到过期
+func (c *twoLevelUserCache) GetUserCtx(ctx context.Context, id int64) (*model.User, error) {
+	key := getUserKey(id)
+	if val, ok := c.l1.Get(key); ok {
+		return copyUser(val.(*model.User)), nil
+	}
+
+	generation := c.generation(id)
+	before := generation.Load()
+	user, err := c.l2.GetUserCtx(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if generation.Load() == before {
+		c.l1.Set(key, copyUser(user))
+		// 检查和写入之间也可能发生失效，写入后再检查一次
+		if generation.Load() != before {
+			c.l1.Del(key)
+		}
+	}
+	return user, nil
+}
+
+// SetUser 写L2和本地L1，并通知其他实例删除L1副本
+func (c *twoLevelUserCache) SetUser(user *model.User, expireSeconds int) error {
+	return c.SetUserCtx(context.Background(), user, expireSeconds)
+}
+
+// SetUserCtx 写L2和本地L1，并通知其他实例删除L1副本
+func (c *twoLevelUserCache) SetUserCtx(ctx context.Context, user *model.User, expireSeconds int) error {
+	if err := c.l2.SetUserCtx(ctx, user, expireSeconds); err != nil {
+		return err
+	}
+
+	c.generation(user.ID).Add(1)
+	// L1过期时间不超过L2，避免L1比L2活得更久
+	expire := c.l1Expire
+	if l2Expire := time.Duration(expireSeconds) * time.Second; l2Expire > 0 && l2Expire < expire {
+		expire = l2Expire
+	}
+	c.l1.SetWithExpire(getUserKey(user.ID), copyUser(user), expire)
+
+	c.publishInvalidate(ctx, user.ID)
+	return nil
+}
+
+// DeleteUser 删除L2和本地L1，并通知其他实例删除L1副本
+func (c *twoLevelUserCache) DeleteUser(id int64) error {
+	return c.DeleteUserCtx(context.Background(), id)
+}
+
+// DeleteUserCtx 删除L2和本地L1，并通知其他实例删除L1副本
+func (c *twoLevelUserCache) DeleteUserCtx(ctx context.Context, id int64) error {
+	c.generation(id).Add(1)
+	c.l1.Del(getUserKey(id))
+	if err := c.l2.DeleteUserCtx(ctx, id); err != nil {
+		return err
+	}
+	// 删除L2期间的读请求可能读到了旧数据，再失效一次
+	c.generation(id).Add(1)
+	c.l1.Del(getUserKey(id))
+
+	c.publishInvalidate(ctx, id)
+	return nil
+}
+
+// GetUserIDByUsername 根据用户名查询用户ID（L2）
+func (c *twoLevelUserCache) GetUserIDByUsername(username string) (int64, error) {
+	return c.GetUserIDByUsernameCtx(context.Background(), username)
+}
+
+// GetUserIDByUsernameCtx 根据用户名查询用户ID（L2）
+func (c *twoLevelUserCache) GetUserIDByUsernameCtx(ctx context.Context, username string) (int64, error) {
+	if c.names == nil {
+		return 0, errUsernameIndexUnsupported
+	}
+	return c.names.GetUserIDByUsernameCtx(ctx, username)
+}
+
+// SetUsernameIndex 写入用户名索引（L2）
+func (c *twoLevelUserCache) SetUsernameIndex(username string, id int64, expireSeconds int) error {
+	return c.SetUsernameIndexCtx(context.Background(), username, id, expireSeconds)
+}
+
+// SetUsernameIndexCtx 写入用户名索引（L2）
+func (c *twoLevelUserCache) SetUsernameIndexCtx(ctx context.Context, username string, id int64, expireSeconds int) error {
+	if c.names == nil {
+		return errUsernameIndexUnsupported
+	}
+	return c.names.SetUsernameIndexCtx(ctx, username, id, expireSeconds)
+}
+
+// SetNullUsername 写入用户名空值缓存（L2）
+func (c *twoLevelUserCache) SetNullUsername(username string) error {
+	return c.SetNullUsernameCtx(context.Background(), username)
+}
+
+// SetNullUsernameCtx 写入用户名空值缓存（L2）
+func (c *twoLevelUserCache) SetNullUsernameCtx(ctx context.Context, username string) error {
+	if c.names == nil {
+		return errUsernameIndexUnsupported
+	}
+	return c.names.SetNullUsernameCtx(ctx, username)
+}
+
+// DeleteUsernameIndex 删除用户名索引（L2）
+func (c *twoLevelUserCache) DeleteUsernameIndex(usernames ...string) error {
+	return c.DeleteUsernameIndexCtx(context.Background(), usernames...)
+}
+
+// DeleteUsernameIndexCtx 删除用户名索引（L2）
+func (c *twoLevelUserCache) DeleteUsernameIndexCtx(ctx context.Context, usernames ...string) error {
+	if c.names == nil {
+		return errUsernameIndexUnsupported
+	}
+	return c.names.DeleteUsernameIndexCtx(ctx, usernames...)
+}
+
+// Close 停止订阅失效通知
+func (c *twoLevelUserCache) Close() error {
+	err := c.pubsub.Close()
+	<-c.done
+	return err
+}
+
+// publishInvalidate 广播失效通知，消息格式: <实例ID>:<用户ID>
+// L2已经修改，调用方超时或取消后仍然要发出通知，否则其他实例的L1会一直保留旧数据
+func (c *twoLevelUserCache) publishInvalidate(ctx context.Context, id int64) {
+	msg := fmt.Sprintf("%s:%d", c.instanceID, id)
+	if err := c.client.Publish(context.WithoutCancel(ctx), L1InvalidateChannel, msg).Err(); err != nil {
+		log.Printf("[L1失效通知发送失败] user_id=%d, error=%v", id, err)
+	}
+}
+
+// listen 接收其他实例的失效通知并删除本地L1副本
+func (c *twoLevelUserCache) listen() {
+	defer close(c.done)
+
+	for msg := range c.pubsub.Channel() {
+		instanceID, idStr, ok := strings.Cut(msg.Payload, ":")
+		if !ok || instanceID == c.instanceID {
+			// 自己发出的通知，本地L1已经是最新的
+			continue
+		}
+
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			log.Printf("[L1失效通知格式错误] payload=%s", msg.Payload)
+			continue
+		}
+
+		c.generation(id).Add(1)
+		c.l1.Del(getUserKey(id))
+		log.Printf("[L1失效] user_id=%d, 来自实例=%s", id, instanceID)
+	}
+}
+
+// generation 用户所在分段的L1失效代数
+func (c *twoLevelUserCache) generation(id int64) *atomic.Uint64 {
+	return &c.generations[uint64(id)%l1GenerationStripes]
+}
+
+// copyUser 复制用户数据，防止调用方修改返回值污染L1
+func copyUser(user *model.User) *model.User {
+	u := *user
+	return &u
+}
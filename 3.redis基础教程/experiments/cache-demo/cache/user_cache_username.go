@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+const (
+	// UsernameIndexKeyPrefix 用户名二级索引的Key前缀（user:username:<小写的用户名> -> 用户ID）
+	UsernameIndexKeyPrefix = "user:username:"
+	// UsernameIndexCacheName 用户名二级索引的指标名称
+	UsernameIndexCacheName = "user_username"
+)
+
+// UsernameIndex 用户名二级索引接口
+// 索引只保存用户ID，用户数据仍然通过 user:<id> 缓存读取，避免同一份数据缓存两份
+type UsernameIndex interface {
+	// GetUserIDByUsername 返回 ErrCacheMiss 表示未命中，ErrNullCache 表示用户名不存在（空值缓存）
+	GetUserIDByUsername(username string) (int64, error)
+	GetUserIDByUsernameCtx(ctx context.Context, username string) (int64, error)
+	SetUsernameIndex(username string, id int64, expireSeconds int) error
+	SetUsernameIndexCtx(ctx context.Context, username string, id int64, expireSeconds int) error
+	SetNullUsername(username string) error
+	SetNullUsernameCtx(ctx context.Context, username string) error
+	DeleteUsernameIndex(usernames ...string) error
+	DeleteUsernameIndexCtx(ctx context.Context, usernames ...string) error
+}
+
+// usernameIndex 用户名二级索引实现，嵌入到各个用户缓存实现中
+type usernameIndex struct {
+	index *Cache[string, int64]
+}
+
+// NewUsernameIndex 创建用户名二级索引实例
+func NewUsernameIndex(rds *redis.Redis) UsernameIndex {
+	return newUsernameIndex(rds)
+}
+
+// newUsernameIndex 创建基于通用缓存的用户名二级索引
+func newUsernameIndex(rds *redis.Redis) *usernameIndex {
+	return &usernameIndex{index: New[string, int64](rds, getUsernameKey)}
+}
+
+// getUsernameKey 生成用户名索引Key
+// 用户名统一转成小写：MySQL默认排序规则不区分大小写，Alice 和 alice 是同一个用户，写入和查询必须落到同一个Key
+func getUsernameKey(username string) string {
+	return fmt.Sprintf("%s%s", UsernameIndexKeyPrefix, strings.ToLower(username))
+}
+
+// GetUserIDByUsername 根据用户名查询用户ID
+func (c *usernameIndex) GetUserIDByUsername(username string) (int64, error) {
+	return c.GetUserIDByUsernameCtx(context.Background(), username)
+}
+
+// GetUserIDByUsernameCtx 根据用户名查询用户ID
+func (c *usernameIndex) GetUserIDByUsernameCtx(ctx context.Context, username string) (int64, error) {
+	return c.index.GetCtx(ctx, username)
+}
+
+// SetUsernameIndex 写入用户名 -> 用户ID 的索引
+func (c *usernameIndex) SetUsernameIndex(username string, id int64, expireSeconds int) error {
+	return c.SetUsernameIndexCtx(context.Background(), username, id, expireSeconds)
+}
+
+// SetUsernameIndexCtx 写入用户名 -> 用户ID 的索引
+func (c *usernameIndex) SetUsernameIndexCtx(ctx context.Context, username string, id int64, expireSeconds int) error {
+	if username == "" {
+		return fmt.Errorf("用户名不能为空")
+	}
+	return c.index.SetExCtx(ctx, username, id, expireSeconds)
+}
+
+// SetNullUsername 用户名不存在时写入空值缓存（登录接口经常被不存在的用户名刷）
+func (c *usernameIndex) SetNullUsername(username string) error {
+	return c.SetNullUsernameCtx(context.Background(), username)
+}
+
+// SetNullUsernameCtx 用户名不存在时写入空值缓存
+func (c *usernameIndex) SetNullUsernameCtx(ctx context.Context, username string) error {
+	return c.index.SetNullCtx(ctx, username)
+}
+
+// DeleteUsernameIndex 删除用户名索引（包括空值缓存），空用户名会被忽略
+func (c *usernameIndex) DeleteUsernameIndex(usernames ...string) error {
+	return c.DeleteUsernameIndexCtx(context.Background(), usernames...)
+}
+
+// DeleteUsernameIndexCtx 删除用户名索引（包括空值缓存），空用户名会被忽略
+func (c *usernameIndex) DeleteUsernameIndexCtx(ctx context.Context, usernames ...string) error {
+	for _, username := range usernames {
+		if username == "" {
+			continue
+		}
+		if err := c.index.DeleteCtx(ctx, username); err != nil {
+			return fmt.Errorf("删除用户名索引失败: username=%s, %w", username, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"cache-demo/model"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// NewUserCodec 创建用户缓存的编解码器（支持 JSON / MessagePack / protobuf，可选 gzip / snappy 压缩）
+func NewUserCodec(format Format, compression Compression) (*VersionedCodec[*model.User], error) {
+	return NewVersionedCodec(format, compression, map[Format]Codec[*model.User]{
+		FormatProtobuf: UserProtoCodec{},
+	})
+}
+
+// ParseUserCodec 按名称创建用户缓存的编解码器，例如 "msgpack+snappy"
+func ParseUserCodec(name string) (*VersionedCodec[*model.User], error) {
+	format, compression, err := ParseCodecName(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewUserCodec(format, compression)
+}
+
+// WithUserCodec 用户缓存使用指定的编解码器
+func WithUserCodec(codec Codec[*model.User]) Option[int64, *model.User] {
+	return WithCodec[int64, *model.User](codec)
+}
+
+// UserProtoCodec 用户的 protobuf 编解码器，直接按下面的消息定义读写 wire format（不依赖 protoc 生成代码）
+//
+//	message User {
+//	  int64  id         = 1;
+//	  string username   = 2;
+//	  string email      = 3;
+//	  int64  age        = 4;
+//	  int64  created_at = 5; // Unix 纳秒
+//	  int64  updated_at = 6; // Unix 纳秒
+//	  int64  version    = 7;
+//	}
+type UserProtoCodec struct{}
+
+// protobuf 字段编号
+const (
+	userFieldID        protowire.Number = 1
+	userFieldUsername  protowire.Number = 2
+	userFieldEmail     protowire.Number = 3
+	userFieldAge       protowire.Number = 4
+	userFieldCreatedAt protowire.Number = 5
+	userFieldUpdatedAt protowire.Number = 6
+	userFieldVersion   protowire.Number = 7
+)
+
+// Marshal 序列化为 protobuf（与 proto3 一样省略零值字段）
+func (UserProtoCodec) Marshal(user *model.User) ([]byte, error) {
+	if user == nil {
+		return nil, fmt.Errorf("用户数据不能为空")
+	}
+
+	var b []byte
+	appendVarint := func(num protowire.Number, v int64) {
+		if v != 0 {
+			b = protowire.AppendTag(b, num, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(v))
+		}
+	}
+	appendString := func(num protowire.Number, v string) {
+		if v != "" {
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendString(b, v)
+		}
+	}
+
+	appendVarint(userFieldID, user.ID)
+	appendString(userFieldUsername, user.Username)
+	appendString(userFieldEmail, user.Email)
+	appendVarint(userFieldAge, int64(user.Age))
+	appendVarint(userFieldCreatedAt, unixNano(user.CreatedAt))
+	appendVarint(userFieldUpdatedAt, unixNano(user.UpdatedAt))
+	appendVarint(userFieldVersion, user.Version)
+	return b, nil
+}
+
+// Unmarshal 从 protobuf 反序列化，跳过不认识的字段（向前兼容）
+func (UserProtoCodec) Unmarshal(data []byte) (*model.User, error) {
+	user := &model.User{}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, fmt.Errorf("解析protobuf字段失败: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return nil, fmt.Errorf("解析protobuf字段失败: %w", protowire.ParseError(n))
+			}
+			data = data[n:]
+			switch num {
+			case userFieldID:
+				user.ID = int64(v)
+			case userFieldAge:
+				user.Age = int(int64(v))
+			case userFieldCreatedAt:
+				user.CreatedAt = fromUnixNano(int64(v))
+			case userFieldUpdatedAt:
+				user.UpdatedAt = fromUnixNano(int64(v))
+			case userFieldVersion:
+				user.Version = int64(v)
+			}
+		case protowire.BytesType:
+			v, n := protowire.ConsumeString(data)
+			if n < 0 {
+				return nil, fmt.Errorf("解析protobuf字段失败: %w", protowire.ParseError(n))
+			}
+			data = data[n:]
+			switch num {
+			case userFieldUsername:
+				user.Username = v
+			case userFieldEmail:
+				user.Email = v
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return nil, fmt.Errorf("解析protobuf字段失败: %w", protowire.ParseError(n))
+			}
+			data = data[n:]
+		}
+	}
+	return user, nil
+}
+
+// unixNano 零值时间编码为0（省略该字段）
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano 0 解码为零值时间
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"cache-demo/model"
+	"context"
+	"fmt"
+)
+
+// UserReadThrough 以用户ID为Key的读穿透缓存
+type UserReadThrough = ReadThrough[int64, *model.User]
+
+// NewUserReadThrough 基于任意 UserCache 实现创建读穿透缓存
+// 缓存实现如果支持空值缓存（SetNullUserCtx）、布隆过滤器（ExistsInBloomFilter/AddToBloomFilter）或旧数据副本（GetStaleUserCtx），会自动启用对应能力
+func NewUserReadThrough(c UserCache, loader Loader[int64, *model.User], opts ...ReadThroughOption[int64, *model.User]) *UserReadThrough {
+	return NewReadThrough[int64, *model.User](userStore{c}, loader, opts...)
+}
+
+// userStore 把 UserCache 适配为读穿透缓存的 Store
+type userStore struct {
+	UserCache
+}
+
+// GetCtx 读取用户缓存
+func (s userStore) GetCtx(ctx context.Context, id int64) (*model.User, error) {
+	return s.GetUserCtx(ctx, id)
+}
+
+// SetExCtx 写入用户缓存
+func (s userStore) SetExCtx(ctx context.Context, id int64, user *model.User, expireSeconds int) error {
+	return s.SetUserCtx(ctx, user, expireSeconds)
+}
+
+// MGetCtx 批量读取用户缓存，缓存实现不支持批量读取时逐个读取
+func (s userStore) MGetCtx(ctx context.Context, ids []int64) ([]*model.User, []error, error) {
+	if c, ok := s.UserCache.(UserBatchCache); ok {
+		return c.GetUsersCtx(ctx, ids)
+	}
+
+	users := make([]*model.User, len(ids))
+	errs := make([]error, len(ids))
+	for i, id := range ids {
+		users[i], errs[i] = s.GetUserCtx(ctx, id)
+	}
+	return users, errs, nil
+}
+
+// SetManyCtx 批量写入用户缓存，缓存实现不支持批量写入时逐个写入
+func (s userStore) SetManyCtx(ctx context.Context, ids []int64, users []*model.User, expireSeconds int) error {
+	if c, ok := s.UserCache.(UserBatchCache); ok {
+		return c.SetUsersCtx(ctx, users, expireSeconds)
+	}
+
+	for _, user := range users {
+		if err := s.SetUserCtx(ctx, user, expireSeconds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetStaleCtx 读取旧数据副本，缓存实现不支持时返回 ErrCacheMiss
+func (s userStore) GetStaleCtx(ctx context.Context, id int64) (*model.User, error) {
+	if c, ok := s.UserCache.(interface {
+		GetStaleUserCtx(ctx context.Context, id int64) (*model.User, error)
+	}); ok {
+		return c.GetStaleUserCtx(ctx, id)
+	}
+	return nil, ErrCacheMiss
+}
+
+// SetNullCtx 写入空值缓存，缓存实现不支持时返回错误
+func (s userStore) SetNullCtx(ctx context.Context, id int64) error {
+	if c, ok := s.UserCache.(interface {
+		SetNullUserCtx(ctx context.Context, id int64) error
+	}); ok {
+		return c.SetNullUserCtx(ctx, id)
+	}
+	return fmt.Errorf("缓存实现不支持空值缓存: user_id=%d", id)
+}
+
+// SetNullManyCtx 批量写入空值缓存，缓存实现不支持批量写入时逐个写入
+func (s userStore) SetNullManyCtx(ctx context.Context, ids []int64) error {
+	if c, ok := s.UserCache.(UserBatchCache); ok {
+		return c.SetNullUsersCtx(ctx, ids)
+	}
+
+	for _, id := range ids {
+		if err := s.SetNullCtx(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MayExist 检查布隆过滤器，缓存实现不支持时总是返回 true
+func (s userStore) MayExist(id int64) (bool, error) {
+	if c, ok := s.UserCache.(interface {
+		ExistsInBloomFilter(id int64) (bool, error)
+	}); ok {
+		return c.ExistsInBloomFilter(id)
+	}
+	return true, nil
+}
+
+// AddToFilter 添加到布隆过滤器，缓存实现不支持时直接返回
+func (s userStore) AddToFilter(id int64) error {
+	if c, ok := s.UserCache.(interface{ AddToBloomFilter(id int64) error }); ok {
+		return c.AddToBloomFilter(id)
+	}
+	return nil
+}
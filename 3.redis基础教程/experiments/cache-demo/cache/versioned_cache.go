@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+)
+
+// VersionKeySuffix 数据版本号Key的后缀（user:1 的版本号保存在 user:1:version）
+const VersionKeySuffix = ":version"
+
+// setIfNewerScript 版本号不小于缓存中的版本号时才写入
+// KEYS[1] 缓存，KEYS[2] 版本号，KEYS[3] 旧数据副本（没有配置 WithStaleCopy 时不传）
+// ARGV[1] 数据，ARGV[2] 版本号，ARGV[3] 过期时间（秒），ARGV[4] 副本的过期时间（秒）
+// 返回 1 已写入；0 缓存中已经有更新的版本，没有写入
+// 版本号Key的过期时间取 缓存、副本、原来的版本号Key 中最长的，版本号不会比数据先过期
+const setIfNewerScript = `
+local current = tonumber(redis.call('GET', KEYS[2]) or '-1')
+if current > tonumber(ARGV[2]) then
+	return 0
+end
+local keep = tonumber(ARGV[3])
+redis.call('SET', KEYS[1], ARGV[1], 'EX', keep)
+if KEYS[3] then
+	redis.call('SET', KEYS[3], ARGV[1], 'EX', ARGV[4])
+	keep = math.max(keep, tonumber(ARGV[4]))
+end
+keep = math.max(keep, redis.call('TTL', KEYS[2]))
+redis.call('SET', KEYS[2], ARGV[2], 'EX', keep)
+return 1
+`
+
+// compareAndSetScript 缓存中的版本号等于期望的版本号时才写入
+// KEYS[1] 缓存，KEYS[2] 版本号，之后是可选的 旧数据副本、队列、墓碑（在 KEYS 中的下标见 ARGV[7]、ARGV[8]、ARGV[10]）
+// ARGV[1] 数据，ARGV[2] 期望的版本号，ARGV[3] 新版本号，ARGV[4] 过期时间（秒），ARGV[5] 副本的过期时间（秒），
+// ARGV[6] 空值标记，ARGV[7] 副本Key的下标，ARGV[8] 队列Key的下标，ARGV[9] 写入后追加到队列的内容，
+// ARGV[10] 墓碑Key的下标（下标为 0 表示没有）
+// 返回写入前缓存中的版本号（等于期望的版本号时已写入）；-1 没有版本号；-2 空值标记或墓碑（数据已删除），都没有写入
+const compareAndSetScript = `
+local tombstone = tonumber(ARGV[10])
+if redis.call('GET', KEYS[1]) == ARGV[6] then
+	return -2
+end
+if tombstone > 0 and redis.call('EXISTS', KEYS[tombstone]) == 1 then
+	return -2
+end
+local current = redis.call('GET', KEYS[2])
+if not current then
+	return -1
+end
+if tonumber(current) ~= tonumber(ARGV[2]) then
+	return tonumber(current)
+end
+local stale = tonumber(ARGV[7])
+local queue = tonumber(ARGV[8])
+local keep = tonumber(ARGV[4])
+redis.call('SET', KEYS[1], ARGV[1], 'EX', keep)
+if stale > 0 then
+	redis.call('SET', KEYS[stale], ARGV[1], 'EX', ARGV[5])
+	keep = math.max(keep, tonumber(ARGV[5]))
+end
+keep = math.max(keep, redis.call('TTL', KEYS[2]))
+redis.call('SET', KEYS[2], ARGV[3], 'EX', keep)
+if queue > 0 then
+	redis.call('RPUSH', KEYS[queue], ARGV[9])
+end
+return tonumber(current)
+`
+
+// QueuedWrite 比较并写入成功时，在同一个Lua脚本中追加到队列（Redis List）的内容
+// 缓存写入和入队要么都成功要么都没有执行，不会出现缓存已经是新版本、写操作却没有入队的情况
+// Tombstone 不为空时这个Key存在就按数据已删除处理（返回 ErrNullCache），空值标记过期、被淘汰后也不会写回已删除的数据
+type QueuedWrite struct {
+	Queue     string // 队列的Key
+	Item      string // 追加的内容
+	Tombstone string // 删除墓碑的Key（可选）
+}
+
+// WithVersion 写入缓存时比较数据的版本号（乐观锁的版本号，和编解码器的编码版本无关）
+// version 返回数据的版本号，单独保存在 <Key>:version。缓存中已经有更新的版本时不写入，SetEx 返回 ErrStaleVersion，
+// 并发的更新、读请求回填都不会用旧数据覆盖新数据；版本号相同时照常写入
+// 删除缓存时保留版本号：删除之后迟到的旧版本写入仍然会被拒绝
+func WithVersion[K any, V any](version func(val V) int64) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.version = version
+	}
+}
+
+// versionKey 数据版本号的Key
+func versionKey(redisKey string) string {
+	return redisKey + VersionKeySuffix
+}
+
+// setIfNewer 版本号不小于缓存中的版本号时写入缓存（以及旧数据副本），返回是否写入
+func (c *Cache[K, V]) setIfNewer(ctx context.Context, redisKey, data string, version int64, ttl int) (bool, error) {
+	keys, args := c.setIfNewerArgs(redisKey, data, version, ttl)
+	result, err := c.rds.EvalCtx(ctx, setIfNewerScript, keys, args...)
+	if err != nil {
+		return false, err
+	}
+	written, _ := result.(int64)
+	return written == 1, nil
+}
+
+// setIfNewerArgs setIfNewerScript 的 KEYS 和 ARGV
+func (c *Cache[K, V]) setIfNewerArgs(redisKey, data string, version int64, ttl int) ([]string, []any) {
+	return c.versionedKeys(redisKey), []any{data, version, ttl, ttl + c.staleTTL}
+}
+
+// versionedKeys 带版本号写入时的 KEYS：缓存、版本号、旧数据副本（没有配置 WithStaleCopy 时不传）
+func (c *Cache[K, V]) versionedKeys(redisKey string) []string {
+	keys := []string{redisKey, versionKey(redisKey)}
+	if c.staleTTL > 0 {
+		keys = append(keys, StaleKeyPrefix+redisKey)
+	}
+	return keys
+}
+
+// CompareAndSet 缓存中的版本号等于 expected 时写入 val（见 CompareAndSetCtx）
+func (c *Cache[K, V]) CompareAndSet(key K, expected int64, val V, expireSeconds int, queued ...QueuedWrite) (int64, error) {
+	return c.CompareAndSetCtx(context.Background(), key, expected, val, expireSeconds, queued...)
+}
+
+// CompareAndSetCtx 缓存中的版本号等于 expected 时写入 val，写入后缓存中的版本号为 val 的版本号
+// 缓存是权威数据时（Write-Behind）在缓存上做乐观锁：两个请求读到同一个版本，只有一个写入成功
+// 版本号不同时返回缓存中的版本号和 ErrVersionMismatch；缓存中是空值标记时返回 ErrNullCache，
+// 没有版本号（缓存过期、被淘汰）时返回 ErrCacheMiss，调用方重新读取（回填缓存）后再写入
+// 传了 queued 时写入成功后在同一个脚本中把 queued.Item 追加到 queued.Queue（只支持一个），queued.Tombstone 存在时返回 ErrNullCache
+// 需要配置 WithVersion；比较的结果不经过熔断器统计
+func (c *Cache[K, V]) CompareAndSetCtx(ctx context.Context, key K, expected int64, val V, expireSeconds int,
+	queued ...QueuedWrite) (int64, error) {
+	if c.version == nil {
+		return 0, fmt.Errorf("比较并写入缓存需要配置 WithVersion")
+	}
+
+	data, err := c.codec.Marshal(val)
+	if err != nil {
+		return 0, fmt.Errorf("序列化缓存数据失败: %w", err)
+	}
+
+	if expireSeconds <= 0 {
+		expireSeconds = c.expire
+	}
+
+	if len(queued) > 1 {
+		return 0, fmt.Errorf("比较并写入缓存只支持追加到一个队列")
+	}
+
+	redisKey := c.keyFunc(key)
+	ttl := c.ttlPolicy(expireSeconds)
+	keys := c.versionedKeys(redisKey)
+	staleIndex, queueIndex, tombstoneIndex, item := 0, 0, 0, ""
+	if c.staleTTL > 0 {
+		staleIndex = 3
+	}
+	if len(queued) == 1 {
+		keys = append(keys, queued[0].Queue)
+		queueIndex, item = len(keys), queued[0].Item
+		if queued[0].Tombstone != "" {
+			keys = append(keys, queued[0].Tombstone)
+			tombstoneIndex = len(keys)
+		}
+	}
+	var result any
+	err = c.exec(func() (err error) {
+		result, err = c.rds.EvalCtx(ctx, compareAndSetScript, keys,
+			string(data), expected, c.version(val), ttl, ttl+c.staleTTL, NullCacheValue, staleIndex, queueIndex, item, tombstoneIndex)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("设置缓存失败: %w", err)
+	}
+
+	current, _ := result.(int64)
+	switch {
+	case current == -2:
+		return 0, ErrNullCache
+	case current == -1:
+		return 0, ErrCacheMiss
+	case current != expected:
+		return current, fmt.Errorf("%w: key=%s, 期望版本=%d, 当前版本=%d", ErrVersionMismatch, redisKey, expected, current)
+	}
+	return current, nil
+}
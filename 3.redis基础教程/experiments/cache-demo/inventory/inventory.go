@@ -0,0 +1,189 @@
+// Package inventory 基于Redis Lua脚本的库存服务（秒杀场景的预留-确认-取消模式）
+//
+// 分布式锁实验中的扣减库存是 加锁 → GET → 计算 → SET → 释放锁，所有请求在锁上排队。
+// 库存扣减本身只是"检查 + 减少"，放在一个 Lua 脚本中由Redis原子执行，不需要锁：
+// 1. Reserve 预留：可用库存足够时扣减，记录预留（订单ID是幂等键，重复请求不会重复扣减）
+// 2. Confirm 确认：支付成功，预留的库存真正卖出
+// 3. Cancel 取消：归还可用库存
+// 4. 预留超时没有确认时自动归还（后台定期扫描，确认、取消时也会检查）
+// 5. 每次成功修改可用库存都写一条MySQL流水（model.InventoryLedger），Reconcile 对比流水和Redis中的库存
+package inventory
+
+import (
+	"cache-demo/model"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrInsufficientStock 可用库存不足
+	ErrInsufficientStock = errors.New("库存不足")
+	// ErrProductNotFound 商品没有设置库存
+	ErrProductNotFound = errors.New("商品没有设置库存")
+	// ErrReservationNotFound 预留记录不存在（没有预留，或者结束后超过保留时间已经删除）
+	ErrReservationNotFound = errors.New("预留记录不存在")
+	// ErrReservationExpired 预留已经超时，库存已归还
+	ErrReservationExpired = errors.New("预留已超时")
+	// ErrInvalidState 预留的状态不允许该操作（例如取消已确认的预留）
+	ErrInvalidState = errors.New("预留状态不允许该操作")
+	// ErrIdempotencyConflict 同一个订单ID重复预留，但商品或数量和第一次不同
+	ErrIdempotencyConflict = errors.New("订单ID已用于其他预留")
+	// ErrNoLedger 没有配置流水仓储，不能对账
+	ErrNoLedger = errors.New("没有配置库存流水")
+)
+
+const (
+	// StockKeyPrefix 可用库存的Key前缀（stock:product:1001，和分布式锁实验使用同一个Key）
+	StockKeyPrefix = "stock:"
+	// ReservationKeyPrefix 预留记录的Key前缀（hash：product、quantity、status、expire_at）
+	ReservationKeyPrefix = "inventory:reservation:"
+	// ExpiryKey 未结束的预留按超时时间排序（有序集合，member 为订单ID，score 为超时时间，毫秒）
+	ExpiryKey = "inventory:reservations:expiry"
+
+	// DefaultReserveTTL 预留默认超时时间：超过该时间没有确认，库存自动归还
+	DefaultReserveTTL = 15 * time.Minute
+	// DefaultRecordTTL 预留结束（确认、取消、超时）后记录默认保留时间，保留期间同一个订单ID的重复请求按幂等处理
+	DefaultRecordTTL = 24 * time.Hour
+	// DefaultSweepInterval 默认每隔多久扫描一次超时的预留
+	DefaultSweepInterval = time.Second
+	// DefaultSweepBatch 每次扫描最多处理的预留数
+	DefaultSweepBatch = 100
+)
+
+// Status 预留状态
+type Status string
+
+const (
+	StatusReserved  Status = "reserved"  // 已预留，等待确认
+	StatusConfirmed Status = "confirmed" // 已确认（卖出）
+	StatusCancelled Status = "cancelled" // 已取消，库存已归还
+	StatusExpired   Status = "expired"   // 超时未确认，库存已归还
+)
+
+// Reservation 一次预留
+type Reservation struct {
+	OrderID   string
+	ProductID string
+	Quantity  int64
+	Status    Status
+	ExpireAt  time.Time // 预留的超时时间，确认之后不再有意义
+	// Replayed 本次调用是重复请求：没有修改库存，返回的是之前的结果
+	Replayed bool
+}
+
+// Report 对账结果
+type Report struct {
+	ProductID   string
+	RedisStock  int64    // Redis中的可用库存
+	LedgerStock int64    // 按流水计算的可用库存（补记之后）
+	Backfilled  []string // 流水中只有预留、Redis中已经结束的订单，已补记结束流水
+	Unresolved  []string // 流水中只有预留、Redis中的预留记录已经删除的订单，需要人工核对
+}
+
+// Diff Redis中的可用库存减去按流水计算的可用库存
+// 不为0的常见原因：写流水失败（日志中有 [库存流水写入失败]）、绕过 inventory 直接修改了库存Key
+func (r *Report) Diff() int64 {
+	return r.RedisStock - r.LedgerStock
+}
+
+// Inventory 库存服务
+type Inventory interface {
+	// SetStock 设置可用库存（初始化、补货），会覆盖当前值
+	SetStock(ctx context.Context, productID string, quantity int64) error
+	// Stock 当前可用库存
+	Stock(ctx context.Context, productID string) (int64, error)
+	// Reserve 预留库存，orderID 是幂等键：同一个订单重复预留返回之前的结果（Replayed 为 true）
+	Reserve(ctx context.Context, orderID, productID string, quantity int64) (*Reservation, error)
+	// Confirm 确认预留，重复确认返回之前的结果；预留已超时返回 ErrReservationExpired
+	Confirm(ctx context.Context, orderID string) (*Reservation, error)
+	// Cancel 取消预留并归还库存，重复取消（或已超时）返回之前的结果；已确认返回 ErrInvalidState
+	Cancel(ctx context.Context, orderID string) (*Reservation, error)
+	// Get 查询预留
+	Get(ctx context.Context, orderID string) (*Reservation, error)
+	// ReleaseExpired 归还最多 limit 个已超时的预留，返回归还的个数
+	ReleaseExpired(ctx context.Context, limit int) (int, error)
+	// Reconcile 对账：补记流水中缺少的结束记录，对比流水和Redis中的可用库存
+	Reconcile(ctx context.Context, productID string) (*Report, error)
+	// Close 停止后台扫描
+	Close()
+}
+
+// options 库存服务选项
+type options struct {
+	reserveTTL    time.Duration
+	recordTTL     time.Duration
+	sweepInterval time.Duration
+	ledger        model.InventoryLedgerRepo
+}
+
+// Option 库存服务选项
+type Option func(o *options)
+
+// WithReserveTTL 预留超时时间，至少1秒
+func WithReserveTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.reserveTTL = max(ttl, time.Second)
+	}
+}
+
+// WithRecordTTL 预留结束后记录的保留时间（幂等窗口），应该大于调用方重试的最长时间
+func WithRecordTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		if ttl > 0 {
+			o.recordTTL = ttl
+		}
+	}
+}
+
+// WithSweepInterval 后台扫描超时预留的间隔，小于等于0时不启动后台扫描（调用方自己调用 ReleaseExpired）
+// 多个实例同时扫描是安全的：每个预留的归还都是原子的，只会归还一次
+func WithSweepInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.sweepInterval = d
+	}
+}
+
+// WithLedger 库存流水仓储，不设置时不写流水，也不能对账
+func WithLedger(ledger model.InventoryLedgerRepo) Option {
+	return func(o *options) {
+		o.ledger = ledger
+	}
+}
+
+// StockKey 商品的可用库存Key
+func StockKey(productID string) string {
+	return StockKeyPrefix + productID
+}
+
+// reservationKey 订单的预留记录Key
+func reservationKey(orderID string) string {
+	return ReservationKeyPrefix + orderID
+}
+
+// ledgerAction 预留状态对应的流水动作和可用库存变化量
+func ledgerAction(r *Reservation) (string, int64) {
+	switch r.Status {
+	case StatusReserved:
+		return model.LedgerActionReserve, -r.Quantity
+	case StatusConfirmed:
+		return model.LedgerActionConfirm, 0
+	case StatusCancelled:
+		return model.LedgerActionCancel, r.Quantity
+	case StatusExpired:
+		return model.LedgerActionExpire, r.Quantity
+	}
+	return "", 0
+}
+
+// validate 检查参数
+func validate(orderID, productID string, quantity int64) error {
+	if orderID == "" || productID == "" {
+		return errors.New("订单ID和商品ID不能为空")
+	}
+	if quantity <= 0 {
+		return fmt.Errorf("预留数量必须大于0: %d", quantity)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package inventory
+
+import (
+	"cache-demo/model"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Reconcile 对账
+//
+// 流水在Redis脚本执行成功之后写入，两者不在一个事务中：进程在两步之间崩溃、MySQL暂时不可用，流水都会缺少记录。
+// 1. 流水中只有预留的订单：查询Redis中的预留，已经结束（确认、取消、超时）的补记结束流水
+// 2. 预留记录已经删除（超过保留时间）的订单无法判断，放入 Unresolved
+// 3. 对比按流水计算的可用库存和Redis中的可用库存，差额见 Report.Diff
+//
+// 对账期间仍有请求在修改库存时，两次读取之间的修改会表现为差额，应该在低峰期执行或者多对账几次
+func (inv *redisInventory) Reconcile(ctx context.Context, productID string) (*Report, error) {
+	if inv.opts.ledger == nil {
+		return nil, ErrNoLedger
+	}
+
+	pending, err := inv.opts.ledger.Pending(ctx, productID)
+	if err != nil {
+		return nil, fmt.Errorf("查询未结束的流水失败: %w", err)
+	}
+
+	report := &Report{ProductID: productID}
+	for _, orderID := range pending {
+		r, err := inv.Get(ctx, orderID)
+		if errors.Is(err, ErrReservationNotFound) {
+			report.Unresolved = append(report.Unresolved, orderID)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if r.Status == StatusReserved {
+			continue
+		}
+
+		action, delta := ledgerAction(r)
+		added, err := inv.opts.ledger.Record(ctx, &model.InventoryLedger{
+			OrderID:   orderID,
+			ProductID: productID,
+			Action:    action,
+			Delta:     delta,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("补记流水失败 (订单=%s): %w", orderID, err)
+		}
+		if added {
+			log.Printf("[补记库存流水] order=%s, product=%s, action=%s, delta=%d", orderID, productID, action, delta)
+			report.Backfilled = append(report.Backfilled, orderID)
+		}
+	}
+
+	if report.LedgerStock, err = inv.opts.ledger.Balance(ctx, productID); err != nil {
+		return nil, fmt.Errorf("计算流水库存失败: %w", err)
+	}
+	if report.RedisStock, err = inv.Stock(ctx, productID); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
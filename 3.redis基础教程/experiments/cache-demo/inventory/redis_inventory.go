@@ -0,0 +1,426 @@
+package inventory
+
+import (
+	"cache-demo/model"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+// scriptPrelude 各脚本共用的开头
+// KEYS[1] 可用库存，KEYS[2] 预留记录，KEYS[3] 未结束预留的超时时间；ARGV[1] 订单ID，ARGV[2] 结束后记录的保留时间（毫秒）
+// 定义 now（毫秒，Redis服务器时间）和：
+// result(code) 返回 {结果码, 状态, 商品, 数量, 超时时间, 当前可用库存}
+// finish(status) 结束预留：写入状态，移出超时集合，记录保留 ARGV[2] 毫秒
+//
+// 注意：Redis Cluster 下三个Key需要在同一个slot，超时集合是所有商品共用的Key，当前实现不适用于 Redis Cluster
+const scriptPrelude = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local function result(code)
+	local r = redis.call('HMGET', KEYS[2], 'status', 'product', 'quantity', 'expire_at')
+	local stock = tonumber(redis.call('GET', KEYS[1]) or '-1')
+	return {code, r[1] or '', r[2] or '', tonumber(r[3] or '0'), tonumber(r[4] or '0'), stock}
+end
+local function finish(status)
+	redis.call('HSET', KEYS[2], 'status', status)
+	redis.call('ZREM', KEYS[3], ARGV[1])
+	redis.call('PEXPIRE', KEYS[2], ARGV[2])
+end
+`
+
+// reserveScript 预留
+// ARGV[3] 商品ID，ARGV[4] 数量，ARGV[5] 预留超时时间（毫秒）
+// 1 预留成功；0 重复请求（返回已有的预留）；-1 商品没有设置库存；-2 库存不足；-3 订单ID已用于其他商品或数量
+const reserveScript = scriptPrelude + `
+if redis.call('EXISTS', KEYS[2]) == 1 then
+	if redis.call('HGET', KEYS[2], 'product') ~= ARGV[3] or redis.call('HGET', KEYS[2], 'quantity') ~= ARGV[4] then
+		return result(-3)
+	end
+	return result(0)
+end
+local stock = redis.call('GET', KEYS[1])
+if not stock then
+	return result(-1)
+end
+local quantity = tonumber(ARGV[4])
+if tonumber(stock) < quantity then
+	return result(-2)
+end
+local ttl = tonumber(ARGV[5])
+redis.call('DECRBY', KEYS[1], quantity)
+redis.call('HSET', KEYS[2], 'product', ARGV[3], 'quantity', quantity, 'status', 'reserved', 'expire_at', now + ttl)
+redis.call('PEXPIRE', KEYS[2], ttl + tonumber(ARGV[2]))
+redis.call('ZADD', KEYS[3], now + ttl, ARGV[1])
+return result(1)
+`
+
+// confirmScript 确认
+// 1 确认成功；0 重复确认；-1 预留不存在；-2 已取消；-3 已超时；-4 已超时，本次归还库存（后台扫描还没有处理）
+const confirmScript = scriptPrelude + `
+local status = redis.call('HGET', KEYS[2], 'status')
+if not status then
+	return result(-1)
+end
+if status == 'confirmed' then
+	return result(0)
+end
+if status == 'expired' then
+	return result(-3)
+end
+if status ~= 'reserved' then
+	return result(-2)
+end
+if tonumber(redis.call('HGET', KEYS[2], 'expire_at')) <= now then
+	redis.call('INCRBY', KEYS[1], redis.call('HGET', KEYS[2], 'quantity'))
+	finish('expired')
+	return result(-4)
+end
+finish('confirmed')
+return result(1)
+`
+
+// cancelScript 取消
+// 1 取消成功，归还库存；0 重复取消（或已超时，库存已归还）；-1 预留不存在；-2 已确认
+const cancelScript = scriptPrelude + `
+local status = redis.call('HGET', KEYS[2], 'status')
+if not status then
+	return result(-1)
+end
+if status == 'cancelled' or status == 'expired' then
+	return result(0)
+end
+if status ~= 'reserved' then
+	return result(-2)
+end
+redis.call('INCRBY', KEYS[1], redis.call('HGET', KEYS[2], 'quantity'))
+finish('cancelled')
+return result(1)
+`
+
+// expireScript 归还超时的预留
+// 1 已归还；0 还没有超时或已经结束（已经结束时移出超时集合）；-1 预留记录不存在（移出超时集合）
+const expireScript = scriptPrelude + `
+local status = redis.call('HGET', KEYS[2], 'status')
+if not status then
+	redis.call('ZREM', KEYS[3], ARGV[1])
+	return result(-1)
+end
+if status ~= 'reserved' then
+	redis.call('ZREM', KEYS[3], ARGV[1])
+	return result(0)
+end
+if tonumber(redis.call('HGET', KEYS[2], 'expire_at')) > now then
+	return result(0)
+end
+redis.call('INCRBY', KEYS[1], redis.call('HGET', KEYS[2], 'quantity'))
+finish('expired')
+return result(1)
+`
+
+// setStockScript 设置可用库存
+// KEYS[1] 可用库存；ARGV: 新的库存；返回变化量（新值 - 旧值，Key不存在时旧值按0计算）
+const setStockScript = `
+local old = tonumber(redis.call('GET', KEYS[1]) or '0')
+redis.call('SET', KEYS[1], ARGV[1])
+return tonumber(ARGV[1]) - old
+`
+
+// redisInventory Redis库存服务
+type redisInventory struct {
+	rds  *redis.Redis
+	opts options
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// New 创建库存服务，并启动后台扫描超时预留的协程（WithSweepInterval 小于等于0时不启动）
+func New(rds *redis.Redis, opts ...Option) Inventory {
+	o := options{
+		reserveTTL:    DefaultReserveTTL,
+		recordTTL:     DefaultRecordTTL,
+		sweepInterval: DefaultSweepInterval,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	inv := &redisInventory{
+		rds:  rds,
+		opts: o,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if o.sweepInterval > 0 {
+		go inv.sweepLoop()
+	} else {
+		close(inv.done)
+	}
+	return inv
+}
+
+// SetStock 设置可用库存，流水记录变化量
+func (inv *redisInventory) SetStock(ctx context.Context, productID string, quantity int64) error {
+	if quantity < 0 {
+		return fmt.Errorf("库存不能小于0: %d", quantity)
+	}
+	resp, err := inv.rds.EvalCtx(ctx, setStockScript, []string{StockKey(productID)}, strconv.FormatInt(quantity, 10))
+	if err != nil {
+		return fmt.Errorf("设置库存失败: %w", err)
+	}
+	delta, _ := resp.(int64)
+	// 设置库存没有订单ID，用时间戳区分每一次设置
+	inv.record(ctx, &model.InventoryLedger{
+		OrderID:   fmt.Sprintf("set:%s:%d", productID, time.Now().UnixNano()),
+		ProductID: productID,
+		Action:    model.LedgerActionSet,
+		Delta:     delta,
+	})
+	return nil
+}
+
+// Stock 当前可用库存
+func (inv *redisInventory) Stock(ctx context.Context, productID string) (int64, error) {
+	val, err := inv.rds.GetCtx(ctx, StockKey(productID))
+	if err != nil {
+		return 0, fmt.Errorf("查询库存失败: %w", err)
+	}
+	if val == "" {
+		return 0, ErrProductNotFound
+	}
+	return strconv.ParseInt(val, 10, 64)
+}
+
+// Reserve 预留库存
+func (inv *redisInventory) Reserve(ctx context.Context, orderID, productID string, quantity int64) (*Reservation, error) {
+	if err := validate(orderID, productID, quantity); err != nil {
+		return nil, err
+	}
+	code, r, stock, err := inv.eval(ctx, reserveScript, orderID, productID,
+		productID, strconv.FormatInt(quantity, 10), strconv.FormatInt(inv.opts.reserveTTL.Milliseconds(), 10))
+	if err != nil {
+		return nil, fmt.Errorf("预留库存失败: %w", err)
+	}
+
+	switch code {
+	case 1:
+		inv.recordReservation(ctx, r)
+		return r, nil
+	case 0:
+		// 重复请求也补写预留流水：第一次预留后可能没来得及写流水（写入失败、进程崩溃），客户端重试时补上
+		// 流水按 订单+动作 去重，已经写过时没有影响；预留之后已经确认或取消时，结束流水由对应的请求写
+		reserved := *r
+		reserved.Status = StatusReserved
+		inv.recordReservation(ctx, &reserved)
+		r.Replayed = true
+		return r, nil
+	case -1:
+		return nil, fmt.Errorf("预留库存失败 (商品=%s): %w", productID, ErrProductNotFound)
+	case -2:
+		return nil, fmt.Errorf("预留库存失败 (商品=%s, 需要=%d, 可用=%d): %w", productID, quantity, stock, ErrInsufficientStock)
+	default:
+		return nil, fmt.Errorf("预留库存失败 (订单=%s 已预留 商品=%s 数量=%d): %w", orderID, r.ProductID, r.Quantity, ErrIdempotencyConflict)
+	}
+}
+
+// Confirm 确认预留
+func (inv *redisInventory) Confirm(ctx context.Context, orderID string) (*Reservation, error) {
+	code, r, err := inv.finish(ctx, confirmScript, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("确认预留失败: %w", err)
+	}
+
+	switch code {
+	case 1:
+		inv.recordReservation(ctx, r)
+		return r, nil
+	case 0:
+		// 重复确认也补写流水（第一次确认后写流水可能失败），流水去重
+		inv.recordReservation(ctx, r)
+		r.Replayed = true
+		return r, nil
+	case -1:
+		return nil, fmt.Errorf("确认预留失败 (订单=%s): %w", orderID, ErrReservationNotFound)
+	case -2:
+		return r, fmt.Errorf("确认预留失败 (订单=%s, 状态=%s): %w", orderID, r.Status, ErrInvalidState)
+	case -4:
+		// 后台扫描还没有处理，本次确认时归还了库存
+		inv.recordReservation(ctx, r)
+		fallthrough
+	default:
+		return r, fmt.Errorf("确认预留失败 (订单=%s): %w", orderID, ErrReservationExpired)
+	}
+}
+
+// Cancel 取消预留并归还库存
+func (inv *redisInventory) Cancel(ctx context.Context, orderID string) (*Reservation, error) {
+	code, r, err := inv.finish(ctx, cancelScript, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("取消预留失败: %w", err)
+	}
+
+	switch code {
+	case 1:
+		inv.recordReservation(ctx, r)
+		return r, nil
+	case 0:
+		// 重复取消也补写流水（按当前状态：已取消或已超时），流水去重
+		inv.recordReservation(ctx, r)
+		r.Replayed = true
+		return r, nil
+	case -1:
+		return nil, fmt.Errorf("取消预留失败 (订单=%s): %w", orderID, ErrReservationNotFound)
+	default:
+		return r, fmt.Errorf("取消预留失败 (订单=%s, 状态=%s): %w", orderID, r.Status, ErrInvalidState)
+	}
+}
+
+// Get 查询预留
+func (inv *redisInventory) Get(ctx context.Context, orderID string) (*Reservation, error) {
+	fields, err := inv.rds.HgetallCtx(ctx, reservationKey(orderID))
+	if err != nil {
+		return nil, fmt.Errorf("查询预留失败: %w", err)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("查询预留失败 (订单=%s): %w", orderID, ErrReservationNotFound)
+	}
+	quantity, _ := strconv.ParseInt(fields["quantity"], 10, 64)
+	expireAt, _ := strconv.ParseInt(fields["expire_at"], 10, 64)
+	return &Reservation{
+		OrderID:   orderID,
+		ProductID: fields["product"],
+		Quantity:  quantity,
+		Status:    Status(fields["status"]),
+		ExpireAt:  time.UnixMilli(expireAt),
+	}, nil
+}
+
+// ReleaseExpired 归还最多 limit 个已超时的预留
+func (inv *redisInventory) ReleaseExpired(ctx context.Context, limit int) (int, error) {
+	pairs, err := inv.rds.ZrangebyscoreWithScoresAndLimitCtx(ctx, ExpiryKey, 0, time.Now().UnixMilli(), 0, limit)
+	if err != nil {
+		return 0, fmt.Errorf("查询超时的预留失败: %w", err)
+	}
+
+	var released int
+	for _, pair := range pairs {
+		code, r, err := inv.finish(ctx, expireScript, pair.Key)
+		if err == nil && code == -1 {
+			// 预留记录已经删除，只剩超时集合中的订单ID
+			_, _ = inv.rds.ZremCtx(ctx, ExpiryKey, pair.Key)
+			continue
+		}
+		if err != nil {
+			return released, fmt.Errorf("归还超时的预留失败 (订单=%s): %w", pair.Key, err)
+		}
+		if code == 1 {
+			log.Printf("[预留超时] order=%s, product=%s, quantity=%d (库存已归还)", r.OrderID, r.ProductID, r.Quantity)
+			inv.recordReservation(ctx, r)
+			released++
+		}
+	}
+	return released, nil
+}
+
+// Close 停止后台扫描
+func (inv *redisInventory) Close() {
+	inv.closeOnce.Do(func() {
+		close(inv.stop)
+	})
+	<-inv.done
+}
+
+// sweepLoop 后台定期归还超时的预留
+func (inv *redisInventory) sweepLoop() {
+	defer close(inv.done)
+
+	ticker := time.NewTicker(inv.opts.sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-inv.stop:
+			return
+		case <-ticker.C:
+			if _, err := inv.ReleaseExpired(context.Background(), DefaultSweepBatch); err != nil {
+				log.Printf("[扫描超时预留失败] error=%v (下次重试)", err)
+			}
+		}
+	}
+}
+
+// finish 确认、取消、超时：先查询预留的商品（脚本需要声明库存Key），再执行脚本
+// 预留记录不存在时返回结果码 -1（查询商品之后、执行脚本之前记录刚好过期删除时，脚本同样返回 -1）
+func (inv *redisInventory) finish(ctx context.Context, script, orderID string) (int64, *Reservation, error) {
+	productID, err := inv.rds.HgetCtx(ctx, reservationKey(orderID), "product")
+	if errors.Is(err, redis.Nil) || (err == nil && productID == "") {
+		return -1, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	code, r, _, err := inv.eval(ctx, script, orderID, productID)
+	return code, r, err
+}
+
+// eval 执行脚本，解析 result(code) 返回的 {结果码, 状态, 商品, 数量, 超时时间, 当前可用库存}
+func (inv *redisInventory) eval(ctx context.Context, script, orderID, productID string, args ...string) (int64, *Reservation, int64, error) {
+	argv := []any{orderID, strconv.FormatInt(inv.opts.recordTTL.Milliseconds(), 10)}
+	for _, arg := range args {
+		argv = append(argv, arg)
+	}
+	keys := []string{StockKey(productID), reservationKey(orderID), ExpiryKey}
+	resp, err := inv.rds.EvalCtx(ctx, script, keys, argv...)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	vals, ok := resp.([]any)
+	if !ok || len(vals) != 6 {
+		return 0, nil, 0, fmt.Errorf("脚本返回格式错误: %v", resp)
+	}
+	code, _ := vals[0].(int64)
+	status, _ := vals[1].(string)
+	product, _ := vals[2].(string)
+	quantity, _ := vals[3].(int64)
+	expireAt, _ := vals[4].(int64)
+	stock, _ := vals[5].(int64)
+	return code, &Reservation{
+		OrderID:   orderID,
+		ProductID: product,
+		Quantity:  quantity,
+		Status:    Status(status),
+		ExpireAt:  time.UnixMilli(expireAt),
+	}, stock, nil
+}
+
+// recordReservation 按预留的当前状态写流水
+func (inv *redisInventory) recordReservation(ctx context.Context, r *Reservation) {
+	action, delta := ledgerAction(r)
+	inv.record(ctx, &model.InventoryLedger{
+		OrderID:   r.OrderID,
+		ProductID: r.ProductID,
+		Action:    action,
+		Delta:     delta,
+	})
+}
+
+// record 写流水：Redis中的库存已经修改，流水写入失败只记录日志，由对账发现
+// 不继承 ctx 的取消：库存已经修改，调用方超时也要把流水写完
+func (inv *redisInventory) record(ctx context.Context, entry *model.InventoryLedger) {
+	if inv.opts.ledger == nil {
+		return
+	}
+	if _, err := inv.opts.ledger.Record(context.WithoutCancel(ctx), entry); err != nil {
+		log.Printf("[库存流水写入失败] order=%s, product=%s, action=%s, delta=%d, error=%v",
+			entry.OrderID, entry.ProductID, entry.Action, entry.Delta, err)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"cache-demo/lock"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	red "github.com/go-redis/redis/v8"
+	"github.com/zeromicro/go-zero/core/conf"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+// Config 配置结构
+type LockConfig struct {
+	Redis struct {
+		Host        string `json:"host" yaml:"host"`
+		Password    string `json:"password" yaml:"password"`
+		Type        string `json:"type" yaml:"type"`
+		PingTimeout string `json:"ping_timeout" yaml:"ping_timeout"`
+	} `json:"redis" yaml:"redis"`
+}
+
+const (
+	// benchDuration 每个进程循环获取锁的时间
+	benchDuration = 5 * time.Second
+	// startDelay 第一个进程约定的开始时间：留给其他进程编译启动，所有进程同时开始
+	startDelay = 3 * time.Second
+	// holdTime 公平性实验中每次持有锁的时间
+	holdTime = 20 * time.Millisecond
+	// readTime、writeTime 读写锁实验中读、写操作持有锁的时间
+	readTime  = 50 * time.Millisecond
+	writeTime = 50 * time.Millisecond
+	// writeInterval 写者两次写入之间的间隔（读多写少：读者不停读取，写者偶尔扣减库存）
+	writeInterval = 500 * time.Millisecond
+	// waitTimeout 单次最多等待锁的时间
+	waitTimeout = 10 * time.Second
+)
+
+// 实验模式
+const (
+	modeUnfair = "unfair" // 普通锁（lock.New）：按退避策略重试
+	modeFair   = "fair"   // 公平锁（lock.NewFair）：排队 + pub/sub 唤醒
+	modeMutex  = "mutex"  // 读写混合负载 + 互斥锁：读操作之间也要排队
+	modeRW     = "rw"     // 读写混合负载 + 读写锁：读操作之间共享
+)
+
+// event 一次加锁记录：请求、获取、释放的时间（微秒）
+type event struct {
+	process    string
+	role       string
+	requested  int64
+	acquired   int64
+	released   int64
+	waitMicros int64
+}
+
+// eventsKey 实验记录的Key
+func eventsKey(mode string) string {
+	return fmt.Sprintf("lockbench:%s:events", mode)
+}
+
+// startKey 所有进程约定的开始时间的Key
+func startKey(mode string) string {
+	return fmt.Sprintf("lockbench:%s:start", mode)
+}
+
+// encode 记录格式：进程,角色,请求时间,获取时间,释放时间
+func (e event) encode() string {
+	return fmt.Sprintf("%s,%s,%d,%d,%d", e.process, e.role, e.requested, e.acquired, e.released)
+}
+
+// decodeEvent 解析记录
+func decodeEvent(s string) (event, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 5 {
+		return event{}, fmt.Errorf("记录格式错误: %s", s)
+	}
+	var e event
+	var err error
+	e.process, e.role = parts[0], parts[1]
+	times := []*int64{&e.requested, &e.acquired, &e.released}
+	for i, p := range times {
+		if *p, err = strconv.ParseInt(parts[i+2], 10, 64); err != nil {
+			return event{}, fmt.Errorf("记录格式错误: %s", s)
+		}
+	}
+	e.waitMicros = e.acquired - e.requested
+	return e, nil
+}
+
+// newLocker 按实验模式和角色创建锁
+func newLocker(mode, role string, rds *redis.Redis, client *red.Client, lockKey string) lock.Locker {
+	opts := []lock.Option{lock.WithTTL(2 * time.Second)}
+	switch mode {
+	case modeFair:
+		return lock.NewFair(rds, client, lockKey, opts...)
+	case modeRW:
+		rw := lock.NewRWLock(rds, lockKey, opts...)
+		if role == "writer" {
+			return rw.WLocker()
+		}
+		return rw.RLocker()
+	default:
+		return lock.New(rds, lockKey, opts...)
+	}
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		fmt.Println("使用方法:")
+		fmt.Println("  go run test_lock_fairness.go <unfair|fair> <进程名>")
+		fmt.Println("  go run test_lock_fairness.go <mutex|rw> <进程名> <reader|writer>")
+		fmt.Println("  go run test_lock_fairness.go report <unfair|fair|mutex|rw>")
+		return
+	}
+
+	// 加载配置
+	var c LockConfig
+	err := conf.Load("../config.yaml", &c)
+	if err != nil {
+		fmt.Printf("加载配置失败: %v\n", err)
+		return
+	}
+
+	// 初始化Redis
+	pingTimeout, _ := time.ParseDuration(c.Redis.PingTimeout)
+	if pingTimeout == 0 {
+		pingTimeout = 10 * time.Second
+	}
+
+	redisConf := redis.RedisConf{
+		Host:        c.Redis.Host,
+		Pass:        c.Redis.Password,
+		Type:        c.Redis.Type,
+		PingTimeout: pingTimeout,
+	}
+
+	redisClient, err := redis.NewRedis(redisConf)
+	if err != nil {
+		fmt.Printf("连接Redis失败: %v\n", err)
+		return
+	}
+
+	if os.Args[1] == "report" {
+		report(redisClient, os.Args[2])
+		return
+	}
+
+	mode, processName := os.Args[1], os.Args[2]
+	role := "worker"
+	if mode == modeMutex || mode == modeRW {
+		role = "reader"
+		if len(os.Args) > 3 {
+			role = os.Args[3]
+		}
+	}
+
+	// 公平锁的唤醒通知使用 go-redis 客户端订阅
+	pubsubClient := red.NewClient(&red.Options{
+		Addr:     c.Redis.Host,
+		Password: c.Redis.Password,
+	})
+	defer pubsubClient.Close()
+
+	run(redisClient, pubsubClient, mode, role, processName)
+}
+
+// run 在约定的时间内循环：获取锁 → 读/写库存 → 释放锁，记录每次的等待时间
+func run(rds *redis.Redis, client *red.Client, mode, role, processName string) {
+	ctx := context.Background()
+	productID := "product:1001"
+	stockKey := fmt.Sprintf("stock:%s", productID)
+	lockKey := fmt.Sprintf("lock:stock:%s:%s", productID, mode)
+	l := newLocker(mode, role, rds, client, lockKey)
+
+	// 第一个进程约定开始时间，其他进程读取同一个时间
+	start := time.Now().Add(startDelay)
+	if _, err := rds.SetnxExCtx(ctx, startKey(mode), strconv.FormatInt(start.UnixMicro(), 10), 60); err != nil {
+		fmt.Printf("%s: ❌ 约定开始时间失败: %v\n", processName, err)
+		return
+	}
+	val, _ := rds.GetCtx(ctx, startKey(mode))
+	startMicros, _ := strconv.ParseInt(val, 10, 64)
+	start = time.UnixMicro(startMicros)
+	fmt.Printf("%s: [%s/%s] 等待 %v 后开始，持续 %v\n", processName, mode, role, time.Until(start).Round(time.Millisecond), benchDuration)
+	time.Sleep(time.Until(start))
+
+	deadline := start.Add(benchDuration)
+	var count int
+	var maxWait time.Duration
+	for time.Now().Before(deadline) {
+		requested := time.Now()
+		lockCtx, cancel := context.WithTimeout(ctx, waitTimeout)
+		err := l.Lock(lockCtx)
+		cancel()
+		if err != nil {
+			fmt.Printf("%s: ❌ 获取锁失败: %v\n", processName, err)
+			continue
+		}
+		acquired := time.Now()
+
+		// 临界区：读操作只读取库存，写操作扣减库存
+		switch role {
+		case "writer":
+			rds.IncrbyCtx(ctx, stockKey, -1)
+			time.Sleep(writeTime)
+		case "reader":
+			rds.GetCtx(ctx, stockKey)
+			time.Sleep(readTime)
+		default:
+			time.Sleep(holdTime)
+		}
+		released := time.Now()
+		if err := l.Unlock(ctx); err != nil {
+			fmt.Printf("%s: ⚠️  释放锁: %v\n", processName, err)
+		}
+
+		e := event{process: processName, role: role,
+			requested: requested.UnixMicro(), acquired: acquired.UnixMicro(), released: released.UnixMicro()}
+		rds.RpushCtx(ctx, eventsKey(mode), e.encode())
+		count++
+		maxWait = max(maxWait, acquired.Sub(requested))
+		if role == "writer" {
+			time.Sleep(writeInterval)
+		}
+	}
+	fmt.Printf("%s: ✅ 完成，获取锁 %d 次，最长等待 %v\n", processName, count, maxWait.Round(time.Millisecond))
+}
+
+// report 汇总所有进程的记录：吞吐量、每个进程获取的次数和等待时间、插队次数、互斥检查
+func report(rds *redis.Redis, mode string) {
+	vals, err := rds.Lrange(eventsKey(mode), 0, -1)
+	if err != nil || len(vals) == 0 {
+		fmt.Printf("没有 %s 模式的实验记录: %v\n", mode, err)
+		return
+	}
+	events := make([]event, 0, len(vals))
+	for _, v := range vals {
+		e, err := decodeEvent(v)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		events = append(events, e)
+	}
+
+	fmt.Println(strings.Repeat("=", 80))
+	fmt.Printf("【%s】实验结果（%d 次加锁）\n", mode, len(events))
+	fmt.Println(strings.Repeat("=", 80))
+
+	// 1. 吞吐量
+	first, last := events[0].requested, events[0].released
+	for _, e := range events {
+		first, last = min(first, e.requested), max(last, e.released)
+	}
+	elapsed := time.Duration(last-first) * time.Microsecond
+	fmt.Printf("吞吐量: %.1f 次/秒（%d 次 / %v）\n", float64(len(events))/elapsed.Seconds(), len(events), elapsed.Round(time.Millisecond))
+	roles := map[string]int{}
+	for _, e := range events {
+		roles[e.role]++
+	}
+	if len(roles) > 1 || roles["worker"] == 0 {
+		fmt.Printf("  读: %.1f 次/秒，写: %.1f 次/秒\n",
+			float64(roles["reader"])/elapsed.Seconds(), float64(roles["writer"])/elapsed.Seconds())
+	}
+
+	// 2. 每个进程获取的次数和等待时间
+	type stat struct {
+		role  string
+		count int
+		total int64
+		max   int64
+	}
+	stats := map[string]*stat{}
+	var names []string
+	for _, e := range events {
+		s, ok := stats[e.process]
+		if !ok {
+			s = &stat{role: e.role}
+			stats[e.process] = s
+			names = append(names, e.process)
+		}
+		s.count++
+		s.total += e.waitMicros
+		s.max = max(s.max, e.waitMicros)
+	}
+	sort.Strings(names)
+	fmt.Println("\n各进程获取锁的次数和等待时间:")
+	var sum, sumSquares float64
+	for _, name := range names {
+		s := stats[name]
+		fmt.Printf("  %-8s %-6s 获取 %4d 次，平均等待 %8v，最长等待 %8v\n", name, s.role, s.count,
+			(time.Duration(s.total/int64(s.count)) * time.Microsecond).Round(time.Millisecond),
+			(time.Duration(s.max) * time.Microsecond).Round(time.Millisecond))
+		sum += float64(s.count)
+		sumSquares += float64(s.count) * float64(s.count)
+	}
+	// Jain 公平性指数：各进程获取次数完全相同时为1，只有一个进程获取到锁时为 1/进程数
+	fmt.Printf("公平性指数（Jain）: %.3f（1 表示各进程获取次数相同）\n", sum*sum/(float64(len(names))*sumSquares))
+
+	// 3. 插队次数：请求更晚却更早获取锁（读者之间共享锁，不算插队）
+	var overtakes int
+	for _, a := range events {
+		for _, b := range events {
+			if b.requested > a.requested && b.acquired < a.acquired && !(a.role == "reader" && b.role == "reader") {
+				overtakes++
+			}
+		}
+	}
+	fmt.Printf("插队次数: %d（请求更晚却更早获取锁）\n", overtakes)
+
+	// 4. 互斥检查：写者（以及互斥锁的所有持有者）的临界区不能和其他临界区重叠
+	var overlaps, sharedReads int
+	for i, a := range events {
+		for _, b := range events[i+1:] {
+			if a.acquired >= b.released || b.acquired >= a.released {
+				continue
+			}
+			if mode == modeRW && a.role == "reader" && b.role == "reader" {
+				sharedReads++
+				continue
+			}
+			overlaps++
+		}
+	}
+	if overlaps == 0 {
+		fmt.Println("互斥检查: ✅ 没有重叠的临界区")
+	} else {
+		fmt.Printf("互斥检查: ❌ %d 对临界区重叠\n", overlaps)
+	}
+	if mode == modeRW {
+		fmt.Printf("读者并发: %d 对读操作同时持有读锁\n", sharedReads)
+	}
+}
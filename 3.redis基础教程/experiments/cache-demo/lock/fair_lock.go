@@ -0,0 +1,224 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	red "github.com/go-redis/redis/v8"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+const (
+	// QueueKeySuffix 公平锁等待队列的Key后缀（有序集合，score 为排队时间，微秒）
+	QueueKeySuffix = ":queue"
+	// QueueTimeoutKeySuffix 等待者超时时间的Key后缀（有序集合，score 为超时时间，毫秒）
+	QueueTimeoutKeySuffix = ":queue:timeout"
+	// WakeupChannelSuffix 唤醒通知的 pub/sub 频道后缀，消息内容为轮到的持有者
+	WakeupChannelSuffix = ":wakeup"
+)
+
+// cleanWaitersScript 移除超时的等待者（排队的进程崩溃或放弃），各公平锁脚本的开头都先执行
+// KEYS[3] 等待队列，KEYS[4] 等待者超时时间；定义 now（毫秒，Redis服务器时间，不受客户端时钟影响）
+const cleanWaitersScript = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local expired = redis.call('ZRANGEBYSCORE', KEYS[4], '-inf', now)
+for _, waiter in ipairs(expired) do
+	redis.call('ZREM', KEYS[3], waiter)
+	redis.call('ZREM', KEYS[4], waiter)
+end
+`
+
+// fairAcquireScript 获取公平锁
+// KEYS[1] 锁，KEYS[2] fencing token 计数器，KEYS[3] 等待队列，KEYS[4] 等待者超时时间
+// ARGV: 持有者、过期时间（毫秒）
+//
+// 1. 锁属于同一个持有者：重入
+// 2. 锁空闲，并且队列为空或者自己排在队首：获取锁并移出队列
+// 3. 其他情况返回0：即使锁空闲，不在队首也不能获取（不允许插队）
+const fairAcquireScript = cleanWaitersScript + `
+local owner = redis.call('HGET', KEYS[1], 'owner')
+if owner == ARGV[1] then
+	redis.call('HINCRBY', KEYS[1], 'count', 1)
+	return tonumber(redis.call('HGET', KEYS[1], 'token'))
+end
+if owner then
+	return 0
+end
+local head = redis.call('ZRANGE', KEYS[3], 0, 0)
+if #head > 0 and head[1] ~= ARGV[1] then
+	return 0
+end
+redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('ZREM', KEYS[4], ARGV[1])
+local token = redis.call('INCR', KEYS[2])
+redis.call('HSET', KEYS[1], 'owner', ARGV[1], 'count', 1, 'token', token)
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return token
+`
+
+// enqueueScript 排队（已经在队列中时保持原来的位置），并续期等待者超时时间
+// KEYS[1] 锁，KEYS[2] 未使用，KEYS[3] 等待队列，KEYS[4] 等待者超时时间
+// ARGV: 持有者、等待者超时时间（毫秒）、唤醒频道
+// 返回排队位置（从1开始）；锁空闲并且自己排在队首时（之前的队首超时被移除）发布唤醒通知
+const enqueueScript = cleanWaitersScript + `
+if not redis.call('ZSCORE', KEYS[3], ARGV[1]) then
+	redis.call('ZADD', KEYS[3], tonumber(t[1]) * 1000000 + tonumber(t[2]), ARGV[1])
+end
+redis.call('ZADD', KEYS[4], now + tonumber(ARGV[2]), ARGV[1])
+local position = redis.call('ZRANK', KEYS[3], ARGV[1]) + 1
+if position == 1 and redis.call('EXISTS', KEYS[1]) == 0 then
+	redis.call('PUBLISH', ARGV[3], ARGV[1])
+end
+return position
+`
+
+// dequeueScript 放弃排队；锁空闲时唤醒新的队首
+// KEYS 同 enqueueScript；ARGV: 持有者、唤醒频道
+const dequeueScript = cleanWaitersScript + `
+redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('ZREM', KEYS[4], ARGV[1])
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	local head = redis.call('ZRANGE', KEYS[3], 0, 0)
+	if #head > 0 then
+		redis.call('PUBLISH', ARGV[2], head[1])
+	end
+end
+return 1
+`
+
+// fairReleaseScript 释放一次公平锁；完全释放时唤醒队首
+// KEYS 同 enqueueScript；ARGV: 持有者、唤醒频道
+const fairReleaseScript = cleanWaitersScript + `
+if redis.call('HGET', KEYS[1], 'owner') ~= ARGV[1] then
+	return -1
+end
+local count = redis.call('HINCRBY', KEYS[1], 'count', -1)
+if count > 0 then
+	return count
+end
+redis.call('DEL', KEYS[1])
+local head = redis.call('ZRANGE', KEYS[3], 0, 0)
+if #head > 0 then
+	redis.call('PUBLISH', ARGV[2], head[1])
+end
+return 0
+`
+
+// fairNode 公平锁：获取锁的顺序和排队顺序一致
+//
+// 普通锁释放时，所有等待者按各自的退避时间重试，谁先重试谁获取：刚释放锁的进程马上再次获取最容易成功，
+// 其他进程可能一直获取不到（饥饿）。公平锁：
+// 1. Lock 获取失败时进入等待队列（有序集合，按排队时间排序），只有队首能获取锁
+// 2. 释放锁时通过 pub/sub 通知队首，不需要轮询
+// 3. 等待者每隔 waiterTimeout/3 续期一次排队位置；进程崩溃没有续期时移出队列，不会堵住后面的等待者
+type fairNode struct {
+	*redisNode
+	client        *red.Client
+	queueKey      string
+	timeoutKey    string
+	channel       string
+	waiterTimeout time.Duration
+}
+
+// NewFair 创建公平锁，client 用于订阅唤醒通知（go-zero 的 Redis 客户端不支持 pub/sub）
+// TryLock 不排队：锁空闲但有其他进程在排队时也返回 false
+func NewFair(rds *redis.Redis, client *red.Client, key string, opts ...Option) Locker {
+	o := newOptions(opts)
+	return &locker{
+		key: key,
+		backend: &fairNode{
+			redisNode:     newRedisNode(rds, key),
+			client:        client,
+			queueKey:      key + QueueKeySuffix,
+			timeoutKey:    key + QueueTimeoutKeySuffix,
+			channel:       key + WakeupChannelSuffix,
+			waiterTimeout: o.waiterTimeout,
+		},
+		opts: o,
+	}
+}
+
+// keys 公平锁脚本使用的Key
+func (n *fairNode) keys() []string {
+	return []string{n.key, n.tokenKey, n.queueKey, n.timeoutKey}
+}
+
+// acquire 队列为空或者自己排在队首时获取锁
+func (n *fairNode) acquire(ctx context.Context, owner string, ttl time.Duration) (int64, error) {
+	resp, err := n.rds.EvalCtx(ctx, fairAcquireScript, n.keys(), owner, strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return 0, err
+	}
+	token, _ := resp.(int64)
+	return token, nil
+}
+
+// release 释放一次锁，完全释放时唤醒队首
+func (n *fairNode) release(ctx context.Context, owner string) (bool, error) {
+	resp, err := n.rds.EvalCtx(ctx, fairReleaseScript, n.keys(), owner, n.channel)
+	if err != nil {
+		return false, err
+	}
+	remaining, _ := resp.(int64)
+	return remaining >= 0, nil
+}
+
+// wait 排队等待：先订阅唤醒通知再排队，轮到自己（或者定期续期排队位置）时调用 try
+func (n *fairNode) wait(ctx context.Context, owner string, try func(ctx context.Context) (bool, error)) error {
+	if ok, err := try(ctx); ok || err != nil {
+		return err
+	}
+
+	// 先订阅再排队，避免漏掉排队之后、订阅之前发布的唤醒通知
+	pubsub := n.client.Subscribe(ctx, n.channel)
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return fmt.Errorf("订阅唤醒通知失败: %w", err)
+	}
+	wakeups := pubsub.Channel()
+	heartbeat := time.NewTicker(n.waiterTimeout / 3)
+	defer heartbeat.Stop()
+
+	for attempt := 1; ; attempt++ {
+		ok, err := n.enqueueAndTry(ctx, owner, try)
+		if err != nil || ok {
+			if err != nil {
+				n.dequeue(context.WithoutCancel(ctx), owner)
+			}
+			return err
+		}
+
+	waiting:
+		for {
+			select {
+			case <-ctx.Done():
+				// 放弃排队，锁空闲时让下一个等待者获取
+				n.dequeue(context.WithoutCancel(ctx), owner)
+				return fmt.Errorf("%w (排队 %d 轮后放弃: %w)", ErrNotAcquired, attempt, ctx.Err())
+			case msg := <-wakeups:
+				if msg.Payload == owner {
+					break waiting
+				}
+			case <-heartbeat.C:
+				break waiting
+			}
+		}
+	}
+}
+
+// enqueueAndTry 排队（或续期排队位置）后尝试获取
+func (n *fairNode) enqueueAndTry(ctx context.Context, owner string, try func(ctx context.Context) (bool, error)) (bool, error) {
+	if _, err := n.rds.EvalCtx(ctx, enqueueScript, n.keys(),
+		owner, strconv.FormatInt(n.waiterTimeout.Milliseconds(), 10), n.channel); err != nil {
+		return false, fmt.Errorf("排队失败: %w", err)
+	}
+	return try(ctx)
+}
+
+// dequeue 放弃排队，忽略错误（没有移出队列时最多 waiterTimeout 后超时移除）
+func (n *fairNode) dequeue(ctx context.Context, owner string) {
+	_, _ = n.rds.EvalCtx(ctx, dequeueScript, n.keys(), owner, n.channel)
+}
@@ -0,0 +1,322 @@
+// Package lock 基于Redis的分布式锁
+//
+// 与 go-zero 的 redis.RedisLock（SET NX + 固定过期时间）相比：
+// 1. 看门狗自动续期：持有期间每隔 ttl/3 续期一次，临界区执行时间超过 ttl 也不会被其他进程抢走
+// 2. fencing token：每次获取锁时分配一个单调递增的 token，受保护的资源检查 token（见 Fence、SetWithFence），持有者暂停期间锁被其他进程获取后，旧持有者的写入会被拒绝
+// 3. 可重入：同一个持有者（owner）可以多次获取同一把锁，释放相同次数后才真正释放
+// 4. 阻塞获取：按退避策略重试，直到获取成功或 context 超时
+//
+// 另外提供公平锁（NewFair，按排队顺序获取，pub/sub 唤醒）、读写锁（NewRWLock，读锁共享、写锁独占）
+// 和多节点锁（NewRedlock），共用同一个 Locker 接口
+package lock
+
+import (
+	"cache-demo/retry"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotAcquired 锁已被其他持有者持有
+	ErrNotAcquired = errors.New("锁已被其他持有者持有")
+	// ErrNotHeld 锁没有被当前持有者持有（没有获取、已经释放，或者已经过期被其他持有者获取）
+	ErrNotHeld = errors.New("锁未被当前持有者持有")
+	// ErrFenced 写入的 fencing token 小于资源已经见过的 token，锁已经被其他持有者获取过
+	ErrFenced = errors.New("fencing token 已过期")
+)
+
+const (
+	// DefaultTTL 默认锁过期时间：看门狗每隔 ttl/3 续期，进程崩溃后最多 ttl 时间锁自动释放
+	DefaultTTL = 30 * time.Second
+	// DefaultRetryBaseDelay 阻塞获取时第一次重试前的等待时间
+	DefaultRetryBaseDelay = 10 * time.Millisecond
+	// DefaultRetryMaxDelay 阻塞获取时单次等待时间上限
+	DefaultRetryMaxDelay = 500 * time.Millisecond
+	// DefaultNodeTimeout Redlock 访问单个节点的默认超时时间
+	DefaultNodeTimeout = 50 * time.Millisecond
+	// DefaultWaiterTimeout 公平锁等待者默认超时时间：排队期间每隔 1/3 续期一次，进程崩溃后超过该时间移出队列
+	DefaultWaiterTimeout = 5 * time.Second
+)
+
+// Locker 分布式锁，一个 Locker 对应一个持有者（owner）的一把锁
+// 同一个 Locker 不是为多个 goroutine 同时加锁设计的：同一个持有者重复获取会按重入处理
+type Locker interface {
+	// TryLock 尝试获取锁，锁被其他持有者持有时立即返回 false
+	TryLock(ctx context.Context) (bool, error)
+	// Lock 阻塞获取锁，按退避策略重试（公平锁排队等待），直到获取成功或 ctx 结束（返回 ErrNotAcquired）
+	Lock(ctx context.Context) error
+	// Unlock 释放一次锁，重入的锁释放相同次数后才真正释放；锁已经丢失时返回 ErrNotHeld
+	Unlock(ctx context.Context) error
+	// Token 当前持有的 fencing token，没有持有锁时返回0
+	Token() int64
+	// Lost 锁丢失（续期失败，锁已过期或被其他持有者获取）时关闭；没有持有锁时返回已关闭的 channel
+	Lost() <-chan struct{}
+}
+
+// backend 锁的存储：单节点（redisNode）、公平锁、读写锁或多节点（Redlock）
+type backend interface {
+	// acquire 获取或重入锁，返回 fencing token；锁被其他持有者持有时返回0
+	acquire(ctx context.Context, owner string, ttl time.Duration) (int64, error)
+	// release 释放一次锁，锁不属于 owner 时返回 false
+	release(ctx context.Context, owner string) (bool, error)
+	// renew 续期，锁不属于 owner 时返回 false
+	renew(ctx context.Context, owner string, ttl time.Duration) (bool, error)
+	// drift 时钟漂移补偿：获取或续期成功后，锁的有效时间按 ttl - drift 估算
+	drift(ttl time.Duration) time.Duration
+}
+
+// queuedBackend 自己实现阻塞等待的 backend（公平锁排队等待唤醒），Lock 不再按退避策略重试
+type queuedBackend interface {
+	backend
+	// wait 等待直到 try 获取成功、出错或 ctx 结束
+	wait(ctx context.Context, owner string, try func(ctx context.Context) (bool, error)) error
+}
+
+// options 锁选项
+type options struct {
+	ttl           time.Duration
+	owner         string
+	backoff       retry.Backoff
+	watchdog      bool
+	nodeTimeout   time.Duration
+	waiterTimeout time.Duration
+}
+
+// Option 锁选项
+type Option func(o *options)
+
+// WithTTL 锁过期时间，小于 10ms 时按 10ms 处理
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = max(ttl, 10*time.Millisecond)
+	}
+}
+
+// WithOwner 持有者标识，默认每个 Locker 随机生成
+// 多个 Locker 使用同一个 owner 时互相重入（例如同一个请求中的不同函数）
+func WithOwner(owner string) Option {
+	return func(o *options) {
+		if owner != "" {
+			o.owner = owner
+		}
+	}
+}
+
+// WithBackoff 阻塞获取时的退避策略，默认 retry.ExponentialBackoff(10ms, 500ms, retry.FullJitter)
+func WithBackoff(backoff retry.Backoff) Option {
+	return func(o *options) {
+		o.backoff = backoff
+	}
+}
+
+// WithWatchdog 是否启用看门狗自动续期（默认启用）
+// 不启用时锁在 ttl 后过期，持有者需要自己保证临界区在 ttl 内执行完
+func WithWatchdog(enabled bool) Option {
+	return func(o *options) {
+		o.watchdog = enabled
+	}
+}
+
+// WithNodeTimeout Redlock 访问单个节点的超时时间，只对 NewRedlock 有效
+// 应该远小于 ttl：一个节点宕机时不能等太久，否则获取成功时锁的有效时间已经所剩无几
+func WithNodeTimeout(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.nodeTimeout = d
+		}
+	}
+}
+
+// WithWaiterTimeout 公平锁等待者的超时时间，只对 NewFair 有效
+// 排队期间每隔 d/3 续期一次排队位置（同时检查一次锁），进程崩溃后最多 d 时间移出队列
+func WithWaiterTimeout(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.waiterTimeout = d
+		}
+	}
+}
+
+// newOptions 默认选项加上 opts
+func newOptions(opts []Option) options {
+	o := options{
+		ttl:           DefaultTTL,
+		backoff:       retry.ExponentialBackoff(DefaultRetryBaseDelay, DefaultRetryMaxDelay, retry.FullJitter),
+		watchdog:      true,
+		nodeTimeout:   DefaultNodeTimeout,
+		waiterTimeout: DefaultWaiterTimeout,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.owner == "" {
+		o.owner = newOwner()
+	}
+	return o
+}
+
+// newOwner 生成持有者标识：主机名:进程ID:随机数，排查问题时能看出锁被哪个进程持有
+func newOwner() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// acquire 按退避策略重复调用 try，直到获取成功、出错或 ctx 结束
+func acquire(ctx context.Context, try func(ctx context.Context) (bool, error), backoff retry.Backoff) error {
+	var delay time.Duration
+	for attempt := 1; ; attempt++ {
+		ok, err := try(ctx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		delay = backoff(attempt, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (尝试 %d 次后放弃: %w)", ErrNotAcquired, attempt, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// locker 本地持有状态（重入次数、token、看门狗），各种锁共用，区别只在 backend
+type locker struct {
+	key     string
+	backend backend
+	opts    options
+
+	mu       sync.Mutex
+	holds    int   // 本 Locker 的重入次数
+	token    int64 // 当前持有的 fencing token
+	watchdog *watchdog
+}
+
+// TryLock 尝试获取锁
+func (l *locker) TryLock(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	start := time.Now()
+	token, err := l.backend.acquire(ctx, l.opts.owner, l.opts.ttl)
+	if err != nil {
+		return false, fmt.Errorf("获取锁失败: %w", err)
+	}
+	if token == 0 {
+		if l.holds > 0 {
+			// 本地认为持有，Redis中的锁已经属于其他持有者
+			l.resetLocked()
+			return false, fmt.Errorf("重入失败: %w", ErrNotHeld)
+		}
+		return false, nil
+	}
+
+	l.holds++
+	if l.holds == 1 {
+		l.token = token
+		var renew func(ctx context.Context) (bool, error)
+		if l.opts.watchdog {
+			renew = l.renew
+		}
+		validity := l.opts.ttl - l.backend.drift(l.opts.ttl)
+		l.watchdog = startWatchdog(ctx, l.key, l.opts.ttl, validity, start, renew, l.lost)
+	}
+	return true, nil
+}
+
+// Lock 阻塞获取锁：公平锁排队等待唤醒，其他锁按退避策略重试
+func (l *locker) Lock(ctx context.Context) error {
+	if q, ok := l.backend.(queuedBackend); ok {
+		return q.wait(ctx, l.opts.owner, l.TryLock)
+	}
+	return acquire(ctx, l.TryLock, l.opts.backoff)
+}
+
+// Unlock 释放一次锁
+// 释放锁不继承 ctx 的取消（临界区执行完时 ctx 可能已经超时）；Redis出错时本地仍然按已释放处理，锁最多 ttl 后过期
+func (l *locker) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holds == 0 {
+		return ErrNotHeld
+	}
+	l.holds--
+	if l.holds == 0 {
+		l.watchdog.Stop()
+	}
+
+	held, err := l.backend.release(context.WithoutCancel(ctx), l.opts.owner)
+	if l.holds == 0 {
+		l.token = 0
+		l.watchdog = nil
+	}
+	if err != nil {
+		return fmt.Errorf("释放锁失败: %w", err)
+	}
+	if !held {
+		l.resetLocked()
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// Token 当前持有的 fencing token
+func (l *locker) Token() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.token
+}
+
+// Lost 锁丢失时关闭
+func (l *locker) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.watchdog == nil {
+		return closedChan
+	}
+	return l.watchdog.lost
+}
+
+// renew 看门狗续期
+func (l *locker) renew(ctx context.Context) (bool, error) {
+	return l.backend.renew(ctx, l.opts.owner, l.opts.ttl)
+}
+
+// lost 看门狗发现锁已丢失：只处理同一次持有的看门狗，释放后重新获取的不受影响
+func (l *locker) lost(w *watchdog) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.watchdog == w {
+		l.resetLocked()
+	}
+}
+
+// resetLocked 清空本地持有状态，调用方持有 l.mu
+func (l *locker) resetLocked() {
+	if l.watchdog != nil {
+		l.watchdog.Stop()
+	}
+	l.holds = 0
+	l.token = 0
+	l.watchdog = nil
+}
+
+// closedChan 没有持有锁时 Lost 返回的 channel
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
@@ -0,0 +1,119 @@
+package lock
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+// TokenKeySuffix fencing token 计数器的Key后缀（lock:stock:product:1001:token）
+const TokenKeySuffix = ":token"
+
+// acquireScript 获取锁
+// KEYS[1] 锁（hash：owner 持有者、count 重入次数、token fencing token），KEYS[2] fencing token 计数器
+// ARGV: 持有者、过期时间（毫秒）
+//
+// 1. 锁不存在：分配新的 token（计数器加1），写入持有者，返回 token
+// 2. 锁属于同一个持有者：重入次数加1，返回原来的 token（不延长过期时间，由看门狗续期）
+// 3. 锁属于其他持有者：返回0
+//
+// 注意：Redis Cluster 下两个Key需要在同一个slot，锁名称使用 hash tag（lock:{stock:product:1001}）
+const acquireScript = `
+local owner = redis.call('HGET', KEYS[1], 'owner')
+if not owner then
+	local token = redis.call('INCR', KEYS[2])
+	redis.call('HSET', KEYS[1], 'owner', ARGV[1], 'count', 1, 'token', token)
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return token
+end
+if owner == ARGV[1] then
+	redis.call('HINCRBY', KEYS[1], 'count', 1)
+	return tonumber(redis.call('HGET', KEYS[1], 'token'))
+end
+return 0
+`
+
+// releaseScript 释放一次锁
+// KEYS[1] 锁；ARGV: 持有者
+// 锁不属于该持有者时返回 -1；否则重入次数减1，减到0时删除锁，返回剩余的重入次数
+const releaseScript = `
+if redis.call('HGET', KEYS[1], 'owner') ~= ARGV[1] then
+	return -1
+end
+local count = redis.call('HINCRBY', KEYS[1], 'count', -1)
+if count <= 0 then
+	redis.call('DEL', KEYS[1])
+	return 0
+end
+return count
+`
+
+// renewScript 续期
+// KEYS[1] 锁；ARGV: 持有者、过期时间（毫秒）
+// 锁属于该持有者时延长过期时间并返回1，否则返回0
+const renewScript = `
+if redis.call('HGET', KEYS[1], 'owner') == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`
+
+// redisNode 单个Redis节点上的锁
+type redisNode struct {
+	rds      *redis.Redis
+	key      string
+	tokenKey string
+}
+
+// New 创建单节点Redis分布式锁，key 为锁的Key（例如 lock:stock:product:1001）
+func New(rds *redis.Redis, key string, opts ...Option) Locker {
+	return &locker{
+		key:     key,
+		backend: newRedisNode(rds, key),
+		opts:    newOptions(opts),
+	}
+}
+
+// newRedisNode 创建单个节点上的锁
+func newRedisNode(rds *redis.Redis, key string) *redisNode {
+	return &redisNode{rds: rds, key: key, tokenKey: key + TokenKeySuffix}
+}
+
+// acquire 获取或重入锁
+func (n *redisNode) acquire(ctx context.Context, owner string, ttl time.Duration) (int64, error) {
+	resp, err := n.rds.EvalCtx(ctx, acquireScript, []string{n.key, n.tokenKey},
+		owner, strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return 0, err
+	}
+	token, _ := resp.(int64)
+	return token, nil
+}
+
+// release 释放一次锁
+func (n *redisNode) release(ctx context.Context, owner string) (bool, error) {
+	resp, err := n.rds.EvalCtx(ctx, releaseScript, []string{n.key}, owner)
+	if err != nil {
+		return false, err
+	}
+	remaining, _ := resp.(int64)
+	return remaining >= 0, nil
+}
+
+// renew 续期
+func (n *redisNode) renew(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	resp, err := n.rds.EvalCtx(ctx, renewScript, []string{n.key},
+		owner, strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return false, err
+	}
+	renewed, _ := resp.(int64)
+	return renewed == 1, nil
+}
+
+// drift 单节点的过期时间由Redis计算，不需要补偿
+func (n *redisNode) drift(time.Duration) time.Duration {
+	return 0
+}
@@ -0,0 +1,164 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+// DriftFactor Redlock 的时钟漂移系数：锁的有效时间 = ttl - 获取耗时 - (ttl × DriftFactor + 2ms)
+// 各节点按自己的时钟计算过期时间，节点之间、节点和客户端之间的时钟速度可能不同
+const DriftFactor = 0.01
+
+// redlock 多个独立Redis节点上的锁（Redlock 算法）
+//
+// 单节点锁在主从切换时不安全：锁写入主节点后还没同步到从节点，主节点宕机，从节点升级为主节点，
+// 其他客户端又能获取同一把锁。Redlock 在 N 个独立节点（不是主从、不是集群）上分别加锁：
+// 1. 记录开始时间，并发在所有节点上加锁，每个节点的超时时间远小于 ttl
+// 2. 超过半数节点加锁成功，并且 ttl 减去获取耗时和时钟漂移后仍大于0，才算获取成功
+// 3. 获取失败时释放所有节点（包括没有响应的节点：请求可能已经执行，只是响应丢失）
+// 4. 释放、续期也要超过半数节点确认
+//
+// fencing token 取加锁成功的节点中最大的 token。各节点的计数器相互独立，节点宕机丢失数据后计数器可能回退，
+// 所以 Redlock 的 token 不保证严格单调，需要严格单调的 token 时应该使用共识系统（etcd、ZooKeeper）
+type redlock struct {
+	nodes       []*redisNode
+	quorum      int
+	nodeTimeout time.Duration
+}
+
+// NewRedlock 创建 Redlock 分布式锁，nodes 为相互独立的Redis节点（建议5个，至少3个才能容忍节点故障）
+func NewRedlock(nodes []*redis.Redis, key string, opts ...Option) Locker {
+	if len(nodes) == 0 {
+		panic("lock: NewRedlock 至少需要一个Redis节点")
+	}
+	o := newOptions(opts)
+	r := &redlock{
+		nodes:       make([]*redisNode, len(nodes)),
+		quorum:      len(nodes)/2 + 1,
+		nodeTimeout: o.nodeTimeout,
+	}
+	for i, rds := range nodes {
+		r.nodes[i] = newRedisNode(rds, key)
+	}
+	return &locker{key: key, backend: r, opts: o}
+}
+
+// nodeResult 单个节点的执行结果
+type nodeResult[T any] struct {
+	val T
+	err error
+}
+
+// broadcast 并发在所有节点上执行 fn，每个节点单独超时
+func broadcast[T any](ctx context.Context, r *redlock, fn func(ctx context.Context, n *redisNode) (T, error)) []nodeResult[T] {
+	results := make([]nodeResult[T], len(r.nodes))
+	var wg sync.WaitGroup
+	for i, n := range r.nodes {
+		wg.Add(1)
+		go func(i int, n *redisNode) {
+			defer wg.Done()
+			nodeCtx, cancel := context.WithTimeout(ctx, r.nodeTimeout)
+			defer cancel()
+			results[i].val, results[i].err = fn(nodeCtx, n)
+		}(i, n)
+	}
+	wg.Wait()
+	return results
+}
+
+// acquire 在超过半数节点上获取或重入锁
+func (r *redlock) acquire(ctx context.Context, owner string, ttl time.Duration) (int64, error) {
+	start := time.Now()
+	results := broadcast(ctx, r, func(ctx context.Context, n *redisNode) (int64, error) {
+		return n.acquire(ctx, owner, ttl)
+	})
+
+	var acquired int
+	var token int64
+	var errs []error
+	for _, res := range results {
+		switch {
+		case res.err != nil:
+			errs = append(errs, res.err)
+		case res.val > 0:
+			acquired++
+			token = max(token, res.val)
+		}
+	}
+
+	validity := ttl - time.Since(start) - r.drift(ttl)
+	if acquired >= r.quorum && validity > 0 {
+		return token, nil
+	}
+
+	// 获取失败（或者获取耗时太长，锁已经快过期）：释放所有节点
+	r.releaseAll(context.WithoutCancel(ctx), owner)
+	if len(errs) > len(r.nodes)-r.quorum {
+		return 0, fmt.Errorf("可用节点不足 %d 个（成功 %d 个，出错 %d 个）: %w",
+			r.quorum, acquired, len(errs), errs[0])
+	}
+	return 0, nil
+}
+
+// release 在所有节点上释放一次锁，超过半数节点确认才算成功
+func (r *redlock) release(ctx context.Context, owner string) (bool, error) {
+	results := broadcast(ctx, r, func(ctx context.Context, n *redisNode) (bool, error) {
+		return n.release(ctx, owner)
+	})
+	return r.quorumOf(results, "释放")
+}
+
+// renew 在所有节点上续期，超过半数节点确认才算成功
+func (r *redlock) renew(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	start := time.Now()
+	results := broadcast(ctx, r, func(ctx context.Context, n *redisNode) (bool, error) {
+		return n.renew(ctx, owner, ttl)
+	})
+	ok, err := r.quorumOf(results, "续期")
+	if ok && time.Since(start)+r.drift(ttl) >= ttl {
+		// 续期耗时太长，锁可能已经在部分节点上过期
+		return false, nil
+	}
+	return ok, err
+}
+
+// releaseAll 获取失败后释放所有节点，忽略错误（没有释放的节点最多 ttl 后过期）
+func (r *redlock) releaseAll(ctx context.Context, owner string) {
+	broadcast(ctx, r, func(ctx context.Context, n *redisNode) (bool, error) {
+		return n.release(ctx, owner)
+	})
+}
+
+// quorumOf 汇总各节点的结果：超过半数确认返回 true；确认的节点不可能再超过半数时返回 false；
+// 其他情况（出错的节点太多，无法判断）返回错误
+func (r *redlock) quorumOf(results []nodeResult[bool], op string) (bool, error) {
+	var confirmed, denied int
+	var errs []error
+	for _, res := range results {
+		switch {
+		case res.err != nil:
+			errs = append(errs, res.err)
+		case res.val:
+			confirmed++
+		default:
+			denied++
+		}
+	}
+	if confirmed >= r.quorum {
+		return true, nil
+	}
+	if denied > len(r.nodes)-r.quorum {
+		return false, nil
+	}
+	// 确认和拒绝都不超过半数时，一定有节点出错
+	return false, fmt.Errorf("%s时只有 %d 个节点确认（需要 %d 个，出错 %d 个）: %w", op, confirmed, r.quorum, len(errs), errs[0])
+}
+
+// drift 时钟漂移补偿
+func (r *redlock) drift(ttl time.Duration) time.Duration {
+	return time.Duration(float64(ttl)*DriftFactor) + 2*time.Millisecond
+}
@@ -0,0 +1,255 @@
+package lock
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+const (
+	// ReadersKeySuffix 读写锁读者的Key后缀（有序集合，member 为持有者，score 为该读者的过期时间，毫秒）
+	ReadersKeySuffix = ":readers"
+	// ReaderCountKeySuffix 读者重入次数的Key后缀（hash：持有者 → 重入次数）
+	ReaderCountKeySuffix = ":readers:count"
+	// writerIntentTTL 等待中的写者阻止新读者进入的时间：写者每次重试都会刷新，放弃等待后最多这么久读者恢复进入
+	// 应该大于阻塞获取时的单次等待时间上限（DefaultRetryMaxDelay），否则写者重试的间隙读者又能进入
+	writerIntentTTL = time.Second
+)
+
+// cleanReadersScript 移除过期的读者（持有读锁的进程崩溃，没有续期），各读写锁脚本的开头都先执行
+// KEYS[2] 读者，KEYS[3] 读者重入次数；定义 now（毫秒，Redis服务器时间）
+//
+// 每个读者单独记录过期时间：所有读者共用一个 PEXPIRE 时，只要还有读者在续期，崩溃的读者就永远不会过期，写者一直获取不到
+const cleanReadersScript = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', now)
+for _, reader in ipairs(expired) do
+	redis.call('HDEL', KEYS[3], reader)
+end
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', now)
+`
+
+// readAcquireScript 获取读锁
+// KEYS[1] 写锁（hash：owner、count、token，以及 wwait 等待中的写者过期时间），KEYS[2] 读者，KEYS[3] 读者重入次数
+// ARGV: 持有者、过期时间（毫秒）
+//
+// 1. 写锁属于其他持有者：返回0
+// 2. 有写者在等待，并且自己还没有持有读锁：返回0（写者优先，避免读者源源不断时写者饥饿）
+// 3. 其他情况（包括自己持有写锁时获取读锁，即锁降级）：记录读者和重入次数，返回1
+const readAcquireScript = cleanReadersScript + `
+local writer = redis.call('HGET', KEYS[1], 'owner')
+if writer and writer ~= ARGV[1] then
+	return 0
+end
+if not writer and not redis.call('ZSCORE', KEYS[2], ARGV[1]) then
+	local wwait = tonumber(redis.call('HGET', KEYS[1], 'wwait') or '0')
+	if wwait > now then
+		return 0
+	end
+end
+local ttl = tonumber(ARGV[2])
+redis.call('ZADD', KEYS[2], now + ttl, ARGV[1])
+redis.call('HINCRBY', KEYS[3], ARGV[1], 1)
+redis.call('PEXPIRE', KEYS[2], ttl)
+redis.call('PEXPIRE', KEYS[3], ttl)
+return 1
+`
+
+// readReleaseScript 释放一次读锁
+// KEYS 同 readAcquireScript；ARGV: 持有者
+// 不是读者时返回 -1；否则重入次数减1，减到0时移除读者，返回剩余的重入次数
+const readReleaseScript = cleanReadersScript + `
+if not redis.call('ZSCORE', KEYS[2], ARGV[1]) then
+	return -1
+end
+local count = redis.call('HINCRBY', KEYS[3], ARGV[1], -1)
+if count > 0 then
+	return count
+end
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+return 0
+`
+
+// readRenewScript 续期读锁
+// KEYS 同 readAcquireScript；ARGV: 持有者、过期时间（毫秒）
+// 是读者时延长该读者的过期时间并返回1，否则返回0
+const readRenewScript = cleanReadersScript + `
+if not redis.call('ZSCORE', KEYS[2], ARGV[1]) then
+	return 0
+end
+local ttl = tonumber(ARGV[2])
+redis.call('ZADD', KEYS[2], now + ttl, ARGV[1])
+redis.call('PEXPIRE', KEYS[2], ttl)
+redis.call('PEXPIRE', KEYS[3], ttl)
+return 1
+`
+
+// writeAcquireScript 获取写锁
+// KEYS[1] 写锁，KEYS[2] 读者，KEYS[3] 读者重入次数，KEYS[4] fencing token 计数器
+// ARGV: 持有者、过期时间（毫秒）、写者等待标记的过期时间（毫秒）
+//
+// 1. 写锁属于同一个持有者：重入，返回原来的 token
+// 2. 写锁属于其他持有者：返回0
+// 3. 还有读者（包括自己，不支持读锁升级）：记录 wwait（有写者在等待，新的读者不再进入），返回0
+// 4. 分配新的 token，写入持有者，返回 token
+//
+// 注意：Redis Cluster 下所有Key需要在同一个slot，锁名称使用 hash tag（lock:{stock:product:1001}）
+const writeAcquireScript = cleanReadersScript + `
+local writer = redis.call('HGET', KEYS[1], 'owner')
+if writer == ARGV[1] then
+	redis.call('HINCRBY', KEYS[1], 'count', 1)
+	return tonumber(redis.call('HGET', KEYS[1], 'token'))
+end
+if writer then
+	return 0
+end
+if redis.call('ZCARD', KEYS[2]) > 0 then
+	local intent = tonumber(ARGV[3])
+	redis.call('HSET', KEYS[1], 'wwait', now + intent)
+	redis.call('PEXPIRE', KEYS[1], intent)
+	return 0
+end
+local token = redis.call('INCR', KEYS[4])
+redis.call('HSET', KEYS[1], 'owner', ARGV[1], 'count', 1, 'token', token)
+redis.call('HDEL', KEYS[1], 'wwait')
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return token
+`
+
+// writeReleaseScript 释放一次写锁
+// KEYS[1] 写锁；ARGV: 持有者
+// 和 releaseScript 相同，只是完全释放时只删除持有者字段，保留其他写者的 wwait
+const writeReleaseScript = `
+if redis.call('HGET', KEYS[1], 'owner') ~= ARGV[1] then
+	return -1
+end
+local count = redis.call('HINCRBY', KEYS[1], 'count', -1)
+if count <= 0 then
+	redis.call('HDEL', KEYS[1], 'owner', 'count', 'token')
+	return 0
+end
+return count
+`
+
+// RWLock 分布式读写锁：读锁之间共享，写锁和其他任何锁互斥
+//
+// 读多写少的资源（例如库存查询远多于扣减）用互斥锁时，读操作之间也要排队；读写锁让多个读者同时持有读锁。
+// 同一个 RWLock 的读锁和写锁使用同一个持有者：持有写锁时可以获取读锁（锁降级）；
+// 持有读锁时不能获取写锁（两个读者同时升级会互相等待，直到超时）。
+// 写者等待期间新的读者不能进入（写者优先），已经持有读锁的读者仍然可以重入
+type RWLock interface {
+	// RLocker 读锁：Token 固定为1（读操作不写入受保护的资源，不需要 fencing）
+	RLocker() Locker
+	// WLocker 写锁：和互斥锁一样分配 fencing token
+	WLocker() Locker
+}
+
+// rwLock 同一个持有者的读锁和写锁
+type rwLock struct {
+	reader *locker
+	writer *locker
+}
+
+// NewRWLock 创建单节点Redis读写锁，key 为写锁的Key，读者记录在 key:readers
+func NewRWLock(rds *redis.Redis, key string, opts ...Option) RWLock {
+	o := newOptions(opts)
+	rw := &rwNode{
+		rds:        rds,
+		key:        key,
+		readersKey: key + ReadersKeySuffix,
+		countKey:   key + ReaderCountKeySuffix,
+		tokenKey:   key + TokenKeySuffix,
+	}
+	return &rwLock{
+		reader: &locker{key: rw.readersKey, backend: &readNode{rw}, opts: o},
+		writer: &locker{key: key, backend: &writeNode{rw}, opts: o},
+	}
+}
+
+// RLocker 读锁
+func (l *rwLock) RLocker() Locker {
+	return l.reader
+}
+
+// WLocker 写锁
+func (l *rwLock) WLocker() Locker {
+	return l.writer
+}
+
+// rwNode 读写锁使用的Key
+type rwNode struct {
+	rds        *redis.Redis
+	key        string
+	readersKey string
+	countKey   string
+	tokenKey   string
+}
+
+// keys 读写锁脚本使用的Key
+func (n *rwNode) keys() []string {
+	return []string{n.key, n.readersKey, n.countKey, n.tokenKey}
+}
+
+// eval 执行脚本，返回整数结果
+func (n *rwNode) eval(ctx context.Context, script string, args ...any) (int64, error) {
+	resp, err := n.rds.EvalCtx(ctx, script, n.keys(), args...)
+	if err != nil {
+		return 0, err
+	}
+	val, _ := resp.(int64)
+	return val, nil
+}
+
+// drift 过期时间由Redis计算，不需要补偿
+func (n *rwNode) drift(time.Duration) time.Duration {
+	return 0
+}
+
+// readNode 读锁
+type readNode struct {
+	*rwNode
+}
+
+// acquire 获取或重入读锁，成功返回1
+func (n *readNode) acquire(ctx context.Context, owner string, ttl time.Duration) (int64, error) {
+	return n.eval(ctx, readAcquireScript, owner, strconv.FormatInt(ttl.Milliseconds(), 10))
+}
+
+// release 释放一次读锁
+func (n *readNode) release(ctx context.Context, owner string) (bool, error) {
+	remaining, err := n.eval(ctx, readReleaseScript, owner)
+	return remaining >= 0, err
+}
+
+// renew 续期读锁
+func (n *readNode) renew(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	renewed, err := n.eval(ctx, readRenewScript, owner, strconv.FormatInt(ttl.Milliseconds(), 10))
+	return renewed == 1, err
+}
+
+// writeNode 写锁
+type writeNode struct {
+	*rwNode
+}
+
+// acquire 获取或重入写锁，返回 fencing token
+func (n *writeNode) acquire(ctx context.Context, owner string, ttl time.Duration) (int64, error) {
+	return n.eval(ctx, writeAcquireScript, owner, strconv.FormatInt(ttl.Milliseconds(), 10),
+		strconv.FormatInt(writerIntentTTL.Milliseconds(), 10))
+}
+
+// release 释放一次写锁
+func (n *writeNode) release(ctx context.Context, owner string) (bool, error) {
+	remaining, err := n.eval(ctx, writeReleaseScript, owner)
+	return remaining >= 0, err
+}
+
+// renew 续期写锁（写锁的格式和互斥锁相同）
+func (n *writeNode) renew(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	renewed, err := n.eval(ctx, renewScript, owner, strconv.FormatInt(ttl.Milliseconds(), 10))
+	return renewed == 1, err
+}
@@ -0,0 +1,104 @@
+package lock
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// watchdog 看门狗：持有锁期间每隔 ttl/3 续期一次
+// 续期返回 false（锁已经不属于自己）或者续期一直失败、锁按估算已经过期时，认为锁已丢失：关闭 lost 并回调 onLost
+// renew 为 nil 时不续期（没有启用看门狗），锁过期后认为锁已丢失
+type watchdog struct {
+	key      string
+	ttl      time.Duration
+	validity time.Duration // 获取或续期成功后锁的有效时间（ttl 减去时钟漂移补偿）
+	expireAt time.Time     // 按本地时间估算的锁过期时间
+	renew    func(ctx context.Context) (bool, error)
+	onLost   func(w *watchdog)
+
+	stop     chan struct{}
+	lost     chan struct{}
+	stopOnce sync.Once
+}
+
+// startWatchdog 启动看门狗，acquiredAt 为开始获取锁的时间（锁的有效时间从这时开始计算）
+// ctx 只用于传递值，不继承取消（锁的持有时间不受获取锁时的 ctx 限制）
+func startWatchdog(ctx context.Context, key string, ttl, validity time.Duration, acquiredAt time.Time,
+	renew func(ctx context.Context) (bool, error), onLost func(w *watchdog)) *watchdog {
+	w := &watchdog{
+		key:      key,
+		ttl:      ttl,
+		validity: validity,
+		expireAt: acquiredAt.Add(validity),
+		renew:    renew,
+		onLost:   onLost,
+		stop:     make(chan struct{}),
+		lost:     make(chan struct{}),
+	}
+	go w.run(context.WithoutCancel(ctx))
+	return w
+}
+
+// run 续期循环
+func (w *watchdog) run(ctx context.Context) {
+	if w.renew == nil {
+		timer := time.NewTimer(time.Until(w.expireAt))
+		defer timer.Stop()
+		select {
+		case <-w.stop:
+		case <-timer.C:
+			w.markLost("锁已过期（没有启用看门狗）")
+		}
+		return
+	}
+
+	interval := w.ttl / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		renewCtx, cancel := context.WithTimeout(ctx, interval)
+		ok, err := w.renew(renewCtx)
+		cancel()
+
+		switch {
+		case err == nil && ok:
+			w.expireAt = start.Add(w.validity)
+		case err == nil:
+			w.markLost("锁已被删除或被其他持有者获取")
+			return
+		case time.Now().After(w.expireAt):
+			w.markLost("续期一直失败，锁已过期: " + err.Error())
+			return
+		default:
+			log.Printf("[锁续期失败] key=%s, error=%v (锁过期前会继续重试)", w.key, err)
+		}
+	}
+}
+
+// markLost 锁已丢失
+func (w *watchdog) markLost(reason string) {
+	select {
+	case <-w.stop:
+		// 已经释放，不算丢失
+		return
+	default:
+	}
+	log.Printf("[锁已丢失] key=%s, 原因=%s", w.key, reason)
+	close(w.lost)
+	w.onLost(w)
+}
+
+// Stop 停止续期（释放锁时调用）
+func (w *watchdog) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+}
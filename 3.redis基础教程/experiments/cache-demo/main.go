@@ -1,8 +1,11 @@
 package main
 
 import (
+	"cache-demo/breaker"
 	"cache-demo/cache"
+	"cache-demo/metrics"
 	"cache-demo/model"
+	"cache-demo/retry"
 	"cache-demo/service"
 	"fmt"
 	"log"
@@ -33,12 +36,45 @@ type Config struct {
 		Type        string `json:"type" yaml:"type"`
 		PingTimeout string `json:"ping_timeout" yaml:"ping_timeout"`
 	} `json:"redis" yaml:"redis"`
+	Cache struct {
+		Codec string `json:"codec,optional" yaml:"codec"` // 用户缓存的编码，例如 json、msgpack+snappy，默认json
+	} `json:"cache,optional" yaml:"cache"`
+	Metrics struct {
+		Addr string `json:"addr,optional" yaml:"addr"` // 为空时不启动指标服务
+	} `json:"metrics,optional" yaml:"metrics"`
+	Bloom struct {
+		FPRate float64 `json:"fp_rate,optional" yaml:"fp_rate"` // 目标误判率，默认0.01
+		Growth float64 `json:"growth,optional" yaml:"growth"`   // 容量相对当前数据量的倍数，默认2
+	} `json:"bloom,optional" yaml:"bloom"`
+	Guard struct {
+		Enabled        bool     `json:"enabled,optional" yaml:"enabled"`
+		IDFormat       string   `json:"id_format,optional" yaml:"id_format"`             // auto_increment / snowflake
+		CheckMaxID     bool     `json:"check_max_id,optional" yaml:"check_max_id"`       // 是否拒绝超过当前最大ID的请求
+		MaxIDSlack     int64    `json:"max_id_slack,optional" yaml:"max_id_slack"`       // 允许超过已知最大ID的范围，默认1000
+		MaxIDRefresh   string   `json:"max_id_refresh,optional" yaml:"max_id_refresh"`   // 最大ID的最短刷新间隔，默认10s
+		SnowflakeEpoch int64    `json:"snowflake_epoch,optional" yaml:"snowflake_epoch"` // 雪花算法起始时间（毫秒）
+		Blacklist      []string `json:"blacklist,optional" yaml:"blacklist"`             // IP、CIDR 或客户端标识
+		BlacklistKey   string   `json:"blacklist_key,optional" yaml:"blacklist_key"`     // 动态黑名单的 Redis Set
+	} `json:"guard,optional" yaml:"guard"`
+	Breaker struct {
+		Enabled           bool    `json:"enabled,optional" yaml:"enabled"`
+		Window            string  `json:"window,optional" yaml:"window"`                           // 错误率统计窗口，默认10s
+		MinRequests       int     `json:"min_requests,optional" yaml:"min_requests"`               // 窗口内请求数达到该值才判断错误率，默认20
+		ErrorRate         float64 `json:"error_rate,optional" yaml:"error_rate"`                   // 错误率阈值，默认0.5
+		OpenTimeout       string  `json:"open_timeout,optional" yaml:"open_timeout"`               // 打开后多久进入半开，默认5s
+		HalfOpenRequests  int     `json:"half_open_requests,optional" yaml:"half_open_requests"`   // 半开状态的探测请求数，默认3
+		StaleExtraSeconds int     `json:"stale_extra_seconds,optional" yaml:"stale_extra_seconds"` // 旧数据副本比正常缓存多保留的秒数，默认3600
+	} `json:"breaker,optional" yaml:"breaker"`
 }
 
 func main() {
 	// 检查命令参数
+	mode := ""
 	if len(os.Args) > 1 {
 		switch os.Args[1] {
+		case "write-through", "write-behind":
+			// 使用其他缓存更新模式运行演示程序
+			mode = os.Args[1]
 		case "reset":
 			resetCache()
 			return
@@ -48,6 +84,9 @@ func main() {
 		case "init-db":
 			initDatabase()
 			return
+		case "bloom":
+			bloomCommand(os.Args[2:])
+			return
 		case "help":
 			showHelp()
 			return
@@ -63,6 +102,11 @@ func main() {
 		c.MySQL.Host, c.MySQL.Port, c.MySQL.User, c.MySQL.Database)
 	log.Printf("Redis配置: Host=%s", c.Redis.Host)
 
+	// 可选：启动 Prometheus 指标服务
+	if c.Metrics.Addr != "" {
+		metrics.StartServer(c.Metrics.Addr)
+	}
+
 	// 2. 初始化数据库连接
 	db, err := initDB(c)
 	if err != nil {
@@ -70,6 +114,11 @@ func main() {
 	}
 	log.Println("数据库连接成功")
 
+	// 已有的用户表自动增加新列（乐观锁的 version 列），不修改已有的列和数据
+	if err := db.AutoMigrate(&model.User{}); err != nil {
+		log.Fatalf("迁移用户表失败: %v", err)
+	}
+
 	// 3. 初始化Redis连接
 	rds, err := initRedis(c)
 	if err != nil {
@@ -83,12 +132,138 @@ func main() {
 	}
 
 	// 5. 初始化服务层
-	userRepo := model.NewUserRepo(db)
-	userCache := cache.NewUserCache(rds)
-	userService := service.NewUserService(userRepo, userCache)
+	userCodec, err := cache.ParseUserCodec(c.Cache.Codec)
+	if err != nil {
+		log.Fatalf("缓存编码配置错误: %v", err)
+	}
+	log.Printf("用户缓存编码: %s", userCodec.Name())
+	userRepo, cacheOpts, err := withBreakers(c, model.NewUserRepo(db))
+	if err != nil {
+		log.Fatalf("熔断器配置错误: %v", err)
+	}
+	// 所有模式使用同一个编码，切换模式时缓存中的数据可以互相读取
+	cacheOpts = append(cacheOpts, cache.WithUserCodec(userCodec))
+	userCache := cache.NewUserCache(rds, cacheOpts...)
+	guard, err := newRequestGuard(c, userRepo, rds)
+	if err != nil {
+		log.Fatalf("请求校验配置错误: %v", err)
+	}
 
 	// 6. 演示缓存的基本使用
-	demonstrateCache(userService)
+	switch mode {
+	case "write-through":
+		log.Println("缓存模式: Write-Through")
+		demonstrateCache(withMiddlewares(service.NewWriteThroughUserService(userRepo, userCache), guard))
+	case "write-behind":
+		log.Println("缓存模式: Write-Behind")
+		writeBehind := service.NewWriteBehindUserService(userRepo, cache.NewUserCacheWithPenetration(rds, cacheOpts...), rds,
+			service.WriteBehindFlushInterval, service.WriteBehindBatchSize)
+		demonstrateCache(withMiddlewares(writeBehind, guard))
+		// 退出前把队列中剩余的写操作刷到数据库
+		if err := writeBehind.Close(); err != nil {
+			log.Printf("Write-Behind 刷盘失败: %v", err)
+		}
+	default:
+		demonstrateCache(withMiddlewares(service.NewUserService(userRepo, userCache), guard))
+	}
+}
+
+// newRequestGuard 按 config.yaml 的 guard 配置创建请求校验器，未启用时返回 nil
+func newRequestGuard(c Config, userRepo model.UserRepo, rds *redis.Redis) (*service.RequestGuard, error) {
+	if !c.Guard.Enabled {
+		return nil, nil
+	}
+	var refresh time.Duration
+	if c.Guard.MaxIDRefresh != "" {
+		d, err := time.ParseDuration(c.Guard.MaxIDRefresh)
+		if err != nil {
+			return nil, fmt.Errorf("max_id_refresh 格式错误: %w", err)
+		}
+		refresh = d
+	}
+	log.Printf("请求校验: 已启用, id_format=%s, check_max_id=%v, 静态黑名单=%d条",
+		c.Guard.IDFormat, c.Guard.CheckMaxID, len(c.Guard.Blacklist))
+	return service.NewRequestGuard(userRepo, rds, service.GuardConfig{
+		IDFormat:       c.Guard.IDFormat,
+		CheckMaxID:     c.Guard.CheckMaxID,
+		MaxIDSlack:     c.Guard.MaxIDSlack,
+		MaxIDRefresh:   refresh,
+		SnowflakeEpoch: c.Guard.SnowflakeEpoch,
+		Blacklist:      c.Guard.Blacklist,
+		BlacklistKey:   c.Guard.BlacklistKey,
+	})
+}
+
+// withBreakers 按 config.yaml 的 breaker 配置给数据库和Redis加上熔断器，未启用时原样返回
+// 返回包装后的用户仓储，以及用户缓存需要追加的选项（Redis熔断器 + 旧数据副本）
+func withBreakers(c Config, userRepo model.UserRepo) (model.UserRepo, []cache.Option[int64, *model.User], error) {
+	if !c.Breaker.Enabled {
+		return userRepo, nil, nil
+	}
+
+	opts := []breaker.Option{
+		breaker.WithMinRequests(c.Breaker.MinRequests),
+		breaker.WithErrorRate(c.Breaker.ErrorRate),
+		breaker.WithHalfOpenRequests(c.Breaker.HalfOpenRequests),
+	}
+	if c.Breaker.Window != "" {
+		d, err := time.ParseDuration(c.Breaker.Window)
+		if err != nil {
+			return nil, nil, fmt.Errorf("window 格式错误: %w", err)
+		}
+		opts = append(opts, breaker.WithWindow(d, breaker.DefaultBuckets))
+	}
+	if c.Breaker.OpenTimeout != "" {
+		d, err := time.ParseDuration(c.Breaker.OpenTimeout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open_timeout 格式错误: %w", err)
+		}
+		opts = append(opts, breaker.WithOpenTimeout(d))
+	}
+	staleExtra := c.Breaker.StaleExtraSeconds
+	if staleExtra == 0 {
+		staleExtra = cache.DefaultStaleExtraSeconds
+	}
+
+	log.Printf("熔断器: 已启用（mysql、redis）, 旧数据副本多保留 %d 秒", staleExtra)
+	return model.NewBreakerUserRepo(userRepo, service.NewBreaker("mysql", opts...)),
+		[]cache.Option[int64, *model.User]{
+			cache.WithUserBreaker(service.NewBreaker("redis", opts...)),
+			cache.WithUserStaleCopy(staleExtra),
+		}, nil
+}
+
+// 演示程序使用的中间件参数
+const (
+	slowCallThreshold = 100 * time.Millisecond // 超过该耗时输出慢调用日志
+	retryAttempts     = 3                      // 数据库、Redis临时故障时最多执行的次数
+	retryBaseDelay    = 50 * time.Millisecond  // 第一次重试前的等待时间，之后指数增长并加随机抖动
+	retryMaxDelay     = 500 * time.Millisecond
+	retryMaxElapsed   = 2 * time.Second
+)
+
+// withMiddlewares 在用户服务外面组合中间件（从外到内）：
+// 链路追踪 -> 指标 -> 日志 -> 慢调用 -> 请求校验（配置了才启用）-> 熔断 -> 重试 -> 用户服务
+// 熔断在重试外面：一次调用的多次重试只算一次熔断器请求；请求校验在熔断外面：被拒绝的请求不影响熔断统计
+func withMiddlewares(userService service.UserService, guard *service.RequestGuard) service.UserService {
+	var guardMiddleware service.Middleware
+	if guard != nil {
+		guardMiddleware = service.Guard(guard)
+	}
+	return service.Chain(userService,
+		service.Tracing(),
+		service.Metrics(),
+		service.Logging(),
+		service.Timing(slowCallThreshold),
+		guardMiddleware,
+		service.Breaker("user-service"),
+		service.Retry(
+			retry.WithMaxAttempts(retryAttempts),
+			retry.WithBackoff(retry.ExponentialBackoff(retryBaseDelay, retryMaxDelay, retry.FullJitter)),
+			retry.WithMaxElapsed(retryMaxElapsed),
+			retry.WithBudget(retry.NewBudget(retry.DefaultBudgetTokens, retry.DefaultBudgetRatio)),
+		),
+	)
 }
 
 // ensureTestData 确保测试数据存在
@@ -280,6 +455,34 @@ func demonstrateCache(userService service.UserService) {
 		fmt.Printf("查询结果: %v\n", err)
 	}
 
+	// 场景8: 批量查询（一次MGET，未命中的用户合并为一次 WHERE id IN 查询）
+	fmt.Println("\n【场景8】批量查询用户ID=[1, 2, 3, 99999]（MGET + 批量回源）")
+	users, missing, err := userService.GetUsersByIDs([]int64{1, 2, 3, 99999})
+	if err != nil {
+		log.Printf("批量查询失败: %v", err)
+	} else {
+		for _, u := range users {
+			fmt.Printf("查询结果: ID=%d, Username=%s, Email=%s, Age=%d\n", u.ID, u.Username, u.Email, u.Age)
+		}
+		fmt.Printf("不存在的用户ID: %v\n", missing)
+	}
+
+	// 场景9: 按用户名查询（用户名二级索引 user:username:<name> -> id，再按ID查缓存）
+	fmt.Println("\n【场景9】按用户名查询 alice 两次（第一次查数据库建索引，第二次索引命中）")
+	for i := 0; i < 2; i++ {
+		user, err := userService.GetUserByUsername("alice")
+		if err != nil {
+			log.Printf("查询失败: %v", err)
+			continue
+		}
+		fmt.Printf("查询结果: ID=%d, Username=%s, Email=%s, Age=%d\n", user.ID, user.Username, user.Email, user.Age)
+	}
+
+	// 缓存指标汇总
+	fmt.Println("\n【缓存指标】")
+	fmt.Printf("%s: %s\n", cache.UserCacheName, metrics.For(cache.UserCacheName).Snapshot())
+	fmt.Printf("%s: %s\n", cache.UsernameIndexCacheName, metrics.For(cache.UsernameIndexCacheName).Snapshot())
+
 	fmt.Println("\n========== 演示完成 ==========")
 	fmt.Println("\n提示:")
 	fmt.Println("1. 观察日志输出，可以看到缓存命中/未命中的情况")
@@ -287,6 +490,7 @@ func demonstrateCache(userService service.UserService) {
 	fmt.Println("3. 查看缓存Key: KEYS user:*")
 	fmt.Println("4. 查看具体缓存: GET user:1")
 	fmt.Println("5. 查看TTL: TTL user:1")
+	fmt.Println("6. 查看用户名索引: GET user:username:alice")
 }
 
 // resetCache 只重置缓存（不重置数据库）
@@ -304,24 +508,33 @@ func resetCache() {
 	}
 
 	// 3. 清理 Redis 缓存
+	// 除了用户缓存（user:*，包括版本号、用户名索引、逻辑过期缓存），还有旧数据副本，
+	// 以及 Write-Behind 的写队列、死信队列、死信用户集合和删除墓碑（write_behind:user:*，未刷盘的写操作会丢失）
 	fmt.Println("\n清理 Redis 缓存...")
-	keys, err := rds.Keys("user:*")
-	if err != nil {
-		log.Printf("获取缓存Key失败: %v", err)
-	} else {
-		if len(keys) > 0 {
-			for _, key := range keys {
-				rds.Del(key)
-			}
-			fmt.Printf("✓ 已清理 %d 个缓存Key\n", len(keys))
-		} else {
-			fmt.Println("✓ 缓存已为空，无需清理")
+	patterns := []string{"user:*", cache.StaleKeyPrefix + "user:*", "write_behind:user:*"}
+	for _, pattern := range patterns {
+		keys, err := rds.Keys(pattern)
+		if err != nil {
+			log.Printf("获取缓存Key失败: pattern=%s, error=%v", pattern, err)
+			continue
 		}
+		if len(keys) == 0 {
+			fmt.Printf("✓ %s 已为空，无需清理\n", pattern)
+			continue
+		}
+		for _, key := range keys {
+			rds.Del(key)
+		}
+		fmt.Printf("✓ %s 已清理 %d 个Key\n", pattern, len(keys))
 	}
 
 	// 验证缓存是否已清理
-	remainingKeys, _ := rds.Keys("user:*")
-	fmt.Printf("剩余缓存数量: %d\n", len(remainingKeys))
+	remaining := 0
+	for _, pattern := range patterns {
+		keys, _ := rds.Keys(pattern)
+		remaining += len(keys)
+	}
+	fmt.Printf("剩余缓存数量: %d\n", remaining)
 
 	fmt.Println("\n========== 缓存重置完成 ==========")
 	fmt.Println("\n现在可以运行程序进行新的实验：")
@@ -407,20 +620,116 @@ func initDatabase() {
 	fmt.Println()
 }
 
+// bloomCommand 用户布隆过滤器的维护命令
+func bloomCommand(args []string) {
+	action := ""
+	if len(args) > 0 {
+		action = args[0]
+	}
+	ifNeeded := len(args) > 1 && args[1] == "--if-needed"
+	if action != "rebuild" && action != "stats" && action != "scalable" {
+		showHelp()
+		return
+	}
+
+	// 1. 加载配置
+	var c Config
+	conf.MustLoad("config.yaml", &c)
+
+	// 2. 初始化数据库和Redis连接
+	db, err := initDB(c)
+	if err != nil {
+		log.Fatalf("初始化数据库失败: %v", err)
+	}
+	rds, err := initRedis(c)
+	if err != nil {
+		log.Fatalf("初始化Redis失败: %v", err)
+	}
+
+	userRepo := model.NewUserRepo(db)
+	if action == "scalable" {
+		loadScalableBloom(rds, userRepo)
+		return
+	}
+
+	manager := cache.NewUserBloomManager(rds, userRepo,
+		cache.WithBloomFPRate(c.Bloom.FPRate), cache.WithBloomGrowth(c.Bloom.Growth))
+
+	// 3. 查看状态
+	fmt.Println("========== 用户布隆过滤器 ==========")
+	if stats, err := manager.Stats(); err != nil {
+		fmt.Printf("当前状态: %v\n", err)
+	} else {
+		fmt.Printf("当前状态: %s\n", stats)
+	}
+	reason, needed, err := manager.NeedsRebuild()
+	if err != nil {
+		log.Fatalf("检查布隆过滤器失败: %v", err)
+	}
+	if needed {
+		fmt.Printf("需要重建: %s\n", reason)
+	} else {
+		fmt.Println("需要重建: 否")
+	}
+	if action == "stats" || (ifNeeded && !needed) {
+		return
+	}
+
+	// 4. 重建：分页加载所有用户ID到临时key，再原子替换
+	fmt.Println("\n重建布隆过滤器...")
+	stats, err := manager.Rebuild()
+	if err != nil {
+		log.Fatalf("重建布隆过滤器失败: %v", err)
+	}
+	fmt.Printf("✓ 重建完成: %s\n", stats)
+}
+
+// loadScalableBloom 初始化用户的可扩展布隆过滤器并加载所有用户ID，打印每一层的填充率
+func loadScalableBloom(rds *redis.Redis, userRepo model.UserRepo) {
+	fmt.Println("========== 用户可扩展布隆过滤器 ==========")
+	loaded, err := cache.LoadUserScalableBloom(rds, userRepo)
+	if err != nil {
+		log.Fatalf("加载可扩展布隆过滤器失败: %v", err)
+	}
+
+	stats, err := cache.NewUserScalableBloomFilter(rds).Stats()
+	if err != nil {
+		log.Fatalf("查询可扩展布隆过滤器失败: %v", err)
+	}
+	fmt.Printf("✓ 加载 %d 个用户ID: %s\n", loaded, stats)
+	for i, layer := range stats.Layers {
+		fmt.Printf("  第%d层: bits=%d, hashes=%d, 容量=%d, 元素=%d, 目标误判率=%.4f%%, 填充率=%.2f%%\n",
+			i, layer.Bits, layer.Hashes, layer.Capacity, layer.Items, layer.TargetFPRate*100, layer.FillRatio*100)
+	}
+}
+
 // showHelp 显示帮助信息
 func showHelp() {
 	fmt.Println("缓存演示程序 - 使用说明")
 	fmt.Println()
 	fmt.Println("命令:")
 	fmt.Println("  go run main.go         运行缓存演示程序")
+	fmt.Println("  go run main.go write-through 使用 Write-Through 模式运行缓存演示程序")
+	fmt.Println("  go run main.go write-behind  使用 Write-Behind 模式运行缓存演示程序")
 	fmt.Println("  go run main.go reset   重置缓存（清理所有 user:* 缓存）")
 	fmt.Println("  go run main.go reset-db 重置数据库（删除并重新插入测试数据）")
 	fmt.Println("  go run main.go init-db  初始化数据库（创建表并插入测试数据）")
+	fmt.Println("  go run main.go bloom stats   查看用户布隆过滤器的参数、填充率和估算误判率")
+	fmt.Println("  go run main.go bloom rebuild [--if-needed] 重建用户布隆过滤器（加 --if-needed 时只在需要时重建）")
+	fmt.Println("  go run main.go bloom scalable 初始化用户可扩展布隆过滤器并加载所有用户ID，打印每层填充率")
 	fmt.Println("  go run main.go help     显示此帮助信息")
 	fmt.Println()
 	fmt.Println("说明:")
 	fmt.Println("  - reset: 只清理 Redis 缓存，不影响数据库")
 	fmt.Println("  - reset-db: 重置数据库数据，不影响缓存")
 	fmt.Println("  - init-db: 创建表并插入测试数据（如果表已存在则跳过）")
+	fmt.Println("  - write-through: 写操作同步写数据库和缓存，读请求以缓存为准")
+	fmt.Println("  - write-behind: 写操作只写Redis和写队列，后台按批次合并后写入数据库")
+	fmt.Println("  - bloom rebuild: 按用户数和 config.yaml 中的 bloom.fp_rate 计算位数和哈希函数个数，")
+	fmt.Println("    分页加载所有用户ID到临时key后用 RENAME 原子替换；未构建、超过容量或误判率漂移时需要重建")
+	fmt.Println("  - 演示用的用户服务外面组合了中间件：链路追踪、指标、日志、慢调用、请求校验、熔断、重试（见 withMiddlewares）")
+	fmt.Println("  - config.yaml 中 guard.enabled 为 true 时，查询缓存前先校验ID范围、ID格式和客户端黑名单")
+	fmt.Println("  - config.yaml 中 breaker.enabled 为 true 时，MySQL、Redis出故障后熔断（直接失败，不等超时），数据库熔断时返回旧数据副本")
+	fmt.Println("  - bloom scalable: 可扩展布隆过滤器写满后自动追加新层，只需要上线前加载一次（可以重复执行）")
 	fmt.Println()
 }
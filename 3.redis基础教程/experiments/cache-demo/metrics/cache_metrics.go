@@ -0,0 +1,293 @@
+package metrics
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "cache_demo"
+
+// 缓存请求结果（requests_total 的 result 标签）
+const (
+	ResultHit         = "hit"
+	ResultMiss        = "miss"
+	ResultNullHit     = "null_hit"
+	ResultBloomReject = "bloom_reject"
+)
+
+// 出错的操作（errors_total 的 op 标签）
+const (
+	OpGet     = "get"
+	OpSet     = "set"
+	OpSetNull = "set_null"
+	OpDelete  = "delete"
+	OpFilter  = "filter"
+	OpLoad    = "load"
+)
+
+// loadHistorySeconds 按秒统计回源次数时保留的历史长度（用于计算QPS峰值）
+const loadHistorySeconds = 600
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "requests_total",
+		Help:      "缓存请求数，按结果分类：hit/miss/null_hit/bloom_reject",
+	}, []string{"cache", "result"})
+
+	loadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "loads_total",
+		Help:      "缓存未命中后回源加载（查询数据库）的次数",
+	}, []string{"cache"})
+
+	loadRejectsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "load_rejects_total",
+		Help:      "缓存未命中后被限流、没有回源加载的次数",
+	}, []string{"cache"})
+
+	loadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "load_duration_seconds",
+		Help:      "回源加载耗时",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"cache"})
+
+	staleHitsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "stale_hits_total",
+		Help:      "回源失败（依赖熔断）后降级返回旧数据副本的次数",
+	}, []string{"cache"})
+
+	errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "errors_total",
+		Help:      "缓存操作错误数，按操作分类：get/set/set_null/delete/filter/load",
+	}, []string{"cache", "op"})
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, loadsTotal, loadRejectsTotal, loadDuration, staleHitsTotal, errorsTotal)
+}
+
+var (
+	registryLock sync.Mutex
+	registry     = make(map[string]*CacheMetrics)
+)
+
+// CacheMetrics 单个缓存的指标
+// 每次记录同时写入 Prometheus 和进程内计数器，实验程序可以直接读取 Snapshot 而不用解析 /metrics
+type CacheMetrics struct {
+	name string
+
+	hits         atomic.Int64
+	misses       atomic.Int64
+	nullHits     atomic.Int64
+	bloomRejects atomic.Int64
+	loads        atomic.Int64
+	loadNanos    atomic.Int64
+	loadRejects  atomic.Int64
+	staleHits    atomic.Int64
+	errors       atomic.Int64
+
+	secondsLock sync.Mutex
+	loadsPerSec map[int64]int64
+}
+
+// For 返回指定缓存名称的指标（同名缓存共享同一组指标）
+func For(name string) *CacheMetrics {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if m, ok := registry[name]; ok {
+		return m
+	}
+
+	m := &CacheMetrics{
+		name:        name,
+		loadsPerSec: make(map[int64]int64),
+	}
+	registry[name] = m
+	return m
+}
+
+// Name 缓存名称
+func (m *CacheMetrics) Name() string {
+	return m.name
+}
+
+// Hit 缓存命中
+func (m *CacheMetrics) Hit() {
+	m.hits.Add(1)
+	requestsTotal.WithLabelValues(m.name, ResultHit).Inc()
+}
+
+// Miss 缓存未命中
+func (m *CacheMetrics) Miss() {
+	m.misses.Add(1)
+	requestsTotal.WithLabelValues(m.name, ResultMiss).Inc()
+}
+
+// NullHit 命中空值缓存
+func (m *CacheMetrics) NullHit() {
+	m.nullHits.Add(1)
+	requestsTotal.WithLabelValues(m.name, ResultNullHit).Inc()
+}
+
+// BloomReject 布隆过滤器判断不存在，直接拦截
+func (m *CacheMetrics) BloomReject() {
+	m.bloomRejects.Add(1)
+	requestsTotal.WithLabelValues(m.name, ResultBloomReject).Inc()
+}
+
+// Load 记录一次回源加载（一次数据库查询）及其耗时
+func (m *CacheMetrics) Load(duration time.Duration) {
+	m.loads.Add(1)
+	m.loadNanos.Add(int64(duration))
+	loadsTotal.WithLabelValues(m.name).Inc()
+	loadDuration.WithLabelValues(m.name).Observe(duration.Seconds())
+
+	now := time.Now().Unix()
+	m.secondsLock.Lock()
+	m.loadsPerSec[now]++
+	for sec := range m.loadsPerSec {
+		if sec <= now-loadHistorySeconds {
+			delete(m.loadsPerSec, sec)
+		}
+	}
+	m.secondsLock.Unlock()
+}
+
+// LoadReject 缓存未命中后回源被限流拒绝
+func (m *CacheMetrics) LoadReject() {
+	m.loadRejects.Add(1)
+	loadRejectsTotal.WithLabelValues(m.name).Inc()
+}
+
+// StaleHit 回源失败后降级返回了旧数据
+func (m *CacheMetrics) StaleHit() {
+	m.staleHits.Add(1)
+	staleHitsTotal.WithLabelValues(m.name).Inc()
+}
+
+// Error 记录一次缓存操作错误
+func (m *CacheMetrics) Error(op string) {
+	m.errors.Add(1)
+	errorsTotal.WithLabelValues(m.name, op).Inc()
+}
+
+// PeakLoadQPS 返回 since 之后回源加载的每秒峰值（最多统计最近10分钟）
+func (m *CacheMetrics) PeakLoadQPS(since time.Time) int64 {
+	m.secondsLock.Lock()
+	defer m.secondsLock.Unlock()
+
+	var peak int64
+	for sec, count := range m.loadsPerSec {
+		if sec >= since.Unix() && count > peak {
+			peak = count
+		}
+	}
+	return peak
+}
+
+// Snapshot 读取当前计数
+func (m *CacheMetrics) Snapshot() Snapshot {
+	return Snapshot{
+		Hits:         m.hits.Load(),
+		Misses:       m.misses.Load(),
+		NullHits:     m.nullHits.Load(),
+		BloomRejects: m.bloomRejects.Load(),
+		Loads:        m.loads.Load(),
+		LoadTime:     time.Duration(m.loadNanos.Load()),
+		LoadRejects:  m.loadRejects.Load(),
+		StaleHits:    m.staleHits.Load(),
+		Errors:       m.errors.Load(),
+	}
+}
+
+// Snapshot 某一时刻的缓存指标
+type Snapshot struct {
+	Hits         int64
+	Misses       int64
+	NullHits     int64
+	BloomRejects int64
+	Loads        int64
+	LoadTime     time.Duration
+	LoadRejects  int64
+	StaleHits    int64
+	Errors       int64
+}
+
+// Sub 计算两次快照之间的增量（实验程序用来统计单个场景）
+func (s Snapshot) Sub(prev Snapshot) Snapshot {
+	return Snapshot{
+		Hits:         s.Hits - prev.Hits,
+		Misses:       s.Misses - prev.Misses,
+		NullHits:     s.NullHits - prev.NullHits,
+		BloomRejects: s.BloomRejects - prev.BloomRejects,
+		Loads:        s.Loads - prev.Loads,
+		LoadTime:     s.LoadTime - prev.LoadTime,
+		LoadRejects:  s.LoadRejects - prev.LoadRejects,
+		StaleHits:    s.StaleHits - prev.StaleHits,
+		Errors:       s.Errors - prev.Errors,
+	}
+}
+
+// Requests 请求总数
+func (s Snapshot) Requests() int64 {
+	return s.Hits + s.Misses + s.NullHits + s.BloomRejects
+}
+
+// HitRate 命中率（空值缓存命中和布隆过滤器拦截也算没有访问数据库）
+func (s Snapshot) HitRate() float64 {
+	if s.Requests() == 0 {
+		return 0
+	}
+	return float64(s.Hits+s.NullHits+s.BloomRejects) / float64(s.Requests())
+}
+
+// AvgLoadTime 平均回源耗时
+func (s Snapshot) AvgLoadTime() time.Duration {
+	if s.Loads == 0 {
+		return 0
+	}
+	return s.LoadTime / time.Duration(s.Loads)
+}
+
+// String 格式化输出
+func (s Snapshot) String() string {
+	return fmt.Sprintf("请求=%d, 命中=%d, 未命中=%d, 空值命中=%d, 布隆拦截=%d, 回源=%d (平均耗时 %v), 回源限流=%d, 降级旧数据=%d, 错误=%d, 命中率=%.1f%%",
+		s.Requests(), s.Hits, s.Misses, s.NullHits, s.BloomRejects, s.Loads, s.AvgLoadTime(), s.LoadRejects, s.StaleHits, s.Errors, s.HitRate()*100)
+}
+
+// Handler Prometheus 指标的 HTTP Handler
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// StartServer 在后台启动指标服务（GET <addr>/metrics）
+func StartServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+
+	go func() {
+		log.Printf("[指标服务] 监听 %s/metrics", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil && err != http.ErrServerClosed {
+			log.Printf("[指标服务启动失败] addr=%s, error=%v", addr, err)
+		}
+	}()
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 服务调用结果（service_requests_total 的 result 标签）
+const (
+	ResultOK       = "ok"
+	ResultNotFound = "not_found"
+	ResultRejected = "rejected"
+	ResultError    = "error"
+)
+
+// RetryEventRetry 发起一次重试（retry_events_total 的 event 标签，放弃重试时为放弃的原因）
+const RetryEventRetry = "retry"
+
+var (
+	serviceRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "service",
+		Name:      "requests_total",
+		Help:      "用户服务调用次数，按方法和结果分类：ok/not_found/rejected/error",
+	}, []string{"method", "result"})
+
+	serviceDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "service",
+		Name:      "request_duration_seconds",
+		Help:      "用户服务调用耗时",
+		Buckets:   []float64{.0005, .001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"method"})
+
+	breakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "breaker",
+		Name:      "state",
+		Help:      "熔断器状态：0 关闭，1 打开，2 半开",
+	}, []string{"name"})
+
+	retriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "retry",
+		Name:      "events_total",
+		Help:      "重试事件数，按操作和事件分类：retry（发起一次重试）或放弃重试的原因",
+	}, []string{"op", "event"})
+)
+
+func init() {
+	prometheus.MustRegister(serviceRequestsTotal, serviceDuration, breakerState, retriesTotal)
+}
+
+// ObserveServiceCall 记录一次用户服务调用
+func ObserveServiceCall(method, result string, duration time.Duration) {
+	serviceRequestsTotal.WithLabelValues(method, result).Inc()
+	serviceDuration.WithLabelValues(method).Observe(duration.Seconds())
+}
+
+// SetBreakerState 记录熔断器的当前状态
+func SetBreakerState(name string, state int) {
+	breakerState.WithLabelValues(name).Set(float64(state))
+}
+
+// ObserveRetry 记录一次重试事件
+func ObserveRetry(op, event string) {
+	retriesTotal.WithLabelValues(op, event).Inc()
+}
@@ -0,0 +1,84 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 库存流水的动作
+const (
+	LedgerActionSet     = "set"     // 设置库存（初始化、补货）
+	LedgerActionReserve = "reserve" // 预留（下单时扣减可用库存）
+	LedgerActionConfirm = "confirm" // 确认（支付成功，预留的库存真正卖出，可用库存不变）
+	LedgerActionCancel  = "cancel"  // 取消（归还可用库存）
+	LedgerActionExpire  = "expire"  // 预留超时（自动归还可用库存）
+)
+
+// InventoryLedger 库存流水：Redis中每次成功修改可用库存都记录一条，用于对账
+// 同一个订单的同一个动作只记录一次（唯一索引 order_id + action），重复请求不会重复记录
+type InventoryLedger struct {
+	ID        int64     `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	OrderID   string    `gorm:"column:order_id;type:varchar(64);not null;uniqueIndex:uk_order_action" json:"order_id"`
+	ProductID string    `gorm:"column:product_id;type:varchar(64);not null;index" json:"product_id"`
+	Action    string    `gorm:"column:action;type:varchar(16);not null;uniqueIndex:uk_order_action" json:"action"`
+	Delta     int64     `gorm:"column:delta;not null" json:"delta"` // 可用库存的变化量：预留为负数，取消、超时为正数
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+// TableName 指定表名
+func (InventoryLedger) TableName() string {
+	return "inventory_ledger"
+}
+
+// InventoryLedgerRepo 库存流水仓储接口（只有带 context 的方法）
+type InventoryLedgerRepo interface {
+	// Record 记录一条流水，同一个订单的同一个动作已经记录过时返回 false
+	Record(ctx context.Context, entry *InventoryLedger) (bool, error)
+	// Balance 按流水计算的可用库存（SUM(delta)）
+	Balance(ctx context.Context, productID string) (int64, error)
+	// Pending 只有预留、还没有确认/取消/超时记录的订单
+	Pending(ctx context.Context, productID string) ([]string, error)
+}
+
+// inventoryLedgerRepo 库存流水仓储实现
+type inventoryLedgerRepo struct {
+	db *gorm.DB
+}
+
+// NewInventoryLedgerRepo 创建库存流水仓储实例
+func NewInventoryLedgerRepo(db *gorm.DB) InventoryLedgerRepo {
+	return &inventoryLedgerRepo{db: db}
+}
+
+// Record 记录一条流水（INSERT ... ON DUPLICATE KEY UPDATE id = id），重复记录时影响行数为0
+func (r *inventoryLedgerRepo) Record(ctx context.Context, entry *InventoryLedger) (bool, error) {
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(entry)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// Balance 按流水计算的可用库存，没有流水时返回0
+func (r *inventoryLedgerRepo) Balance(ctx context.Context, productID string) (int64, error) {
+	var balance int64
+	err := r.db.WithContext(ctx).Model(&InventoryLedger{}).
+		Where("product_id = ?", productID).
+		Select("COALESCE(SUM(delta), 0)").Scan(&balance).Error
+	return balance, err
+}
+
+// Pending 只有预留记录的订单（GROUP BY order_id HAVING 所有记录都是 reserve）
+func (r *inventoryLedgerRepo) Pending(ctx context.Context, productID string) ([]string, error) {
+	var orderIDs []string
+	err := r.db.WithContext(ctx).Model(&InventoryLedger{}).
+		Where("product_id = ?", productID).
+		Group("order_id").
+		Having("SUM(action = ?) > 0 AND SUM(action <> ?) = 0", LedgerActionReserve, LedgerActionReserve).
+		Order("order_id").
+		Pluck("order_id", &orderIDs).Error
+	return orderIDs, err
+}
@@ -1,17 +1,43 @@
 package model
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// ErrVersionConflict 版本冲突：用户在读取之后已经被其他请求修改（乐观锁）
+var ErrVersionConflict = errors.New("版本冲突")
+
+// VersionConflictError 版本冲突的详细信息，errors.Is(err, ErrVersionConflict) 为 true
+type VersionConflictError struct {
+	ID       int64
+	Expected int64 // 调用方读取到的版本号
+	Current  int64 // 数据库中的当前版本号
+}
+
+// Error 错误信息
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("%v: user_id=%d, 期望版本=%d, 当前版本=%d（用户已被其他请求修改，请重新读取后再更新）",
+		ErrVersionConflict, e.ID, e.Expected, e.Current)
+}
+
+// Unwrap 返回 ErrVersionConflict
+func (e *VersionConflictError) Unwrap() error {
+	return ErrVersionConflict
+}
+
 // User 用户模型
+// Version 是乐观锁的版本号：每次更新加1，更新时要求数据库中的版本号和读取时相同
 type User struct {
 	ID        int64     `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
 	Username  string    `gorm:"column:username;type:varchar(50);uniqueIndex;not null" json:"username"`
 	Email     string    `gorm:"column:email;type:varchar(100);index;not null" json:"email"`
 	Age       int       `gorm:"column:age;type:int;default:0" json:"age"`
+	Version   int64     `gorm:"column:version;not null;default:0" json:"version"`
 	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
 	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
 }
@@ -22,12 +48,29 @@ func (User) TableName() string {
 }
 
 // UserRepo 用户仓储接口
+// 每个方法都有带 context 的版本（XxxCtx），超时和取消会传到 gorm（WithContext）和数据库驱动；
+// 不带 context 的方法使用 context.Background()，保留给不关心超时的调用方
 type UserRepo interface {
 	FindByID(id int64) (*User, error)
+	FindByIDCtx(ctx context.Context, id int64) (*User, error)
+	FindByIDs(ids []int64) ([]*User, error)
+	FindByIDsCtx(ctx context.Context, ids []int64) ([]*User, error)
 	FindByUsername(username string) (*User, error)
+	FindByUsernameCtx(ctx context.Context, username string) (*User, error)
+	Count() (int64, error)
+	CountCtx(ctx context.Context) (int64, error)
+	MaxID() (int64, error)
+	MaxIDCtx(ctx context.Context) (int64, error)
+	ListIDs(afterID int64, limit int) ([]int64, error)
+	ListIDsCtx(ctx context.Context, afterID int64, limit int) ([]int64, error)
 	Create(user *User) error
+	CreateCtx(ctx context.Context, user *User) error
 	Update(user *User) error
+	UpdateCtx(ctx context.Context, user *User) error
+	UpdateVersion(user *User, expected int64) error
+	UpdateVersionCtx(ctx context.Context, user *User, expected int64) error
 	Delete(id int64) error
+	DeleteCtx(ctx context.Context, id int64) error
 }
 
 // userRepo 用户仓储实现
@@ -42,35 +85,165 @@ func NewUserRepo(db *gorm.DB) UserRepo {
 
 // FindByID 根据ID查询用户
 func (r *userRepo) FindByID(id int64) (*User, error) {
+	return r.FindByIDCtx(context.Background(), id)
+}
+
+// FindByIDCtx 根据ID查询用户
+func (r *userRepo) FindByIDCtx(ctx context.Context, id int64) (*User, error) {
 	var user User
-	err := r.db.Where("id = ?", id).First(&user).Error
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
+// FindByIDs 根据ID批量查询用户
+func (r *userRepo) FindByIDs(ids []int64) ([]*User, error) {
+	return r.FindByIDsCtx(context.Background(), ids)
+}
+
+// FindByIDsCtx 根据ID批量查询用户（WHERE id IN (...)），不存在的ID不出现在结果中，结果不保证顺序
+func (r *userRepo) FindByIDsCtx(ctx context.Context, ids []int64) ([]*User, error) {
+	var users []*User
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	if err := r.db.WithContext(ctx).Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 // FindByUsername 根据用户名查询用户
 func (r *userRepo) FindByUsername(username string) (*User, error) {
+	return r.FindByUsernameCtx(context.Background(), username)
+}
+
+// FindByUsernameCtx 根据用户名查询用户
+func (r *userRepo) FindByUsernameCtx(ctx context.Context, username string) (*User, error) {
 	var user User
-	err := r.db.Where("username = ?", username).First(&user).Error
+	err := r.db.WithContext(ctx).Where("username = ?", username).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
+// Count 查询用户总数
+func (r *userRepo) Count() (int64, error) {
+	return r.CountCtx(context.Background())
+}
+
+// CountCtx 查询用户总数
+func (r *userRepo) CountCtx(ctx context.Context) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&User{}).Count(&count).Error
+	return count, err
+}
+
+// MaxID 查询当前最大的用户ID
+func (r *userRepo) MaxID() (int64, error) {
+	return r.MaxIDCtx(context.Background())
+}
+
+// MaxIDCtx 查询当前最大的用户ID（SELECT MAX(id)），没有用户时返回0
+func (r *userRepo) MaxIDCtx(ctx context.Context) (int64, error) {
+	var maxID int64
+	err := r.db.WithContext(ctx).Model(&User{}).Select("COALESCE(MAX(id), 0)").Scan(&maxID).Error
+	return maxID, err
+}
+
+// ListIDs 按ID升序分页查询用户ID
+func (r *userRepo) ListIDs(afterID int64, limit int) ([]int64, error) {
+	return r.ListIDsCtx(context.Background(), afterID, limit)
+}
+
+// ListIDsCtx 按ID升序分页查询大于 afterID 的用户ID（WHERE id > ? ORDER BY id LIMIT ?）
+// 用上一页的最后一个ID作为下一页的起点，翻页不会随着偏移量增大而变慢
+func (r *userRepo) ListIDsCtx(ctx context.Context, afterID int64, limit int) ([]int64, error) {
+	var ids []int64
+	err := r.db.WithContext(ctx).Model(&User{}).Where("id > ?", afterID).Order("id").Limit(limit).Pluck("id", &ids).Error
+	return ids, err
+}
+
 // Create 创建用户
 func (r *userRepo) Create(user *User) error {
-	return r.db.Create(user).Error
+	return r.CreateCtx(context.Background(), user)
+}
+
+// CreateCtx 创建用户
+func (r *userRepo) CreateCtx(ctx context.Context, user *User) error {
+	return r.db.WithContext(ctx).Create(user).Error
 }
 
-// Update 更新用户
+// Update 更新用户（乐观锁，见 UpdateCtx）
 func (r *userRepo) Update(user *User) error {
-	return r.db.Save(user).Error
+	return r.UpdateCtx(context.Background(), user)
+}
+
+// UpdateCtx 更新用户（乐观锁）
+// UPDATE users SET ..., version = version + 1 WHERE id = ? AND version = ?
+// 1. 更新成功：user.Version 加1，和数据库一致，可以继续用同一个 user 再次更新
+// 2. 版本号不同（读取之后被其他请求修改）：返回 *VersionConflictError，不修改数据库
+// 3. 用户不存在：返回 gorm.ErrRecordNotFound
+func (r *userRepo) UpdateCtx(ctx context.Context, user *User) error {
+	now := time.Now()
+	if err := r.updateIfVersion(ctx, user, user.Version, gorm.Expr("version + 1"), now); err != nil {
+		return err
+	}
+
+	user.Version++
+	user.UpdatedAt = now
+	return nil
+}
+
+// UpdateVersion 更新用户，版本号直接设置为 user.Version（见 UpdateVersionCtx）
+func (r *userRepo) UpdateVersion(user *User, expected int64) error {
+	return r.UpdateVersionCtx(context.Background(), user, expected)
+}
+
+// UpdateVersionCtx 更新用户，版本号直接设置为 user.Version
+// UPDATE users SET ..., version = user.Version WHERE id = ? AND version = expected
+// Write-Behind 刷盘把同一个用户的多次更新合并成一次时使用：expected 是第一次更新之前的版本号，user 是最后一次更新
+// 版本号不同时返回 *VersionConflictError，用户不存在时返回 gorm.ErrRecordNotFound
+func (r *userRepo) UpdateVersionCtx(ctx context.Context, user *User, expected int64) error {
+	return r.updateIfVersion(ctx, user, expected, user.Version, user.UpdatedAt)
+}
+
+// updateIfVersion 数据库中的版本号等于 expected 时更新用户，version 为更新后的版本号（值或表达式）
+func (r *userRepo) updateIfVersion(ctx context.Context, user *User, expected int64, version interface{}, updatedAt time.Time) error {
+	result := r.db.WithContext(ctx).Model(&User{}).
+		Where("id = ? AND version = ?", user.ID, expected).
+		Updates(map[string]interface{}{
+			"username":   user.Username,
+			"email":      user.Email,
+			"age":        user.Age,
+			"updated_at": updatedAt,
+			"version":    version,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		// 没有更新任何行：用户不存在，或者版本号已经变化
+		var current User
+		if err := r.db.WithContext(ctx).Select("id", "version").Where("id = ?", user.ID).First(&current).Error; err != nil {
+			return err
+		}
+		return &VersionConflictError{ID: user.ID, Expected: expected, Current: current.Version}
+	}
+	return nil
 }
 
 // Delete 删除用户
 func (r *userRepo) Delete(id int64) error {
-	return r.db.Delete(&User{}, id).Error
+	return r.DeleteCtx(context.Background(), id)
+}
+
+// DeleteCtx 删除用户
+func (r *userRepo) DeleteCtx(ctx context.Context, id int64) error {
+	return r.db.WithContext(ctx).Delete(&User{}, id).Error
 }
@@ -0,0 +1,161 @@
+package model
+
+import (
+	"cache-demo/breaker"
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// breakerUserRepo 带熔断器的用户仓储：数据库故障时直接返回 breaker.ErrOpen，不再等待驱动超时
+type breakerUserRepo struct {
+	next UserRepo
+	brk  *breaker.Breaker
+}
+
+// NewBreakerUserRepo 用熔断器包装用户仓储
+// 记录不存在（gorm.ErrRecordNotFound）、版本冲突（ErrVersionConflict）和调用方主动取消（context.Canceled）不算失败
+func NewBreakerUserRepo(repo UserRepo, brk *breaker.Breaker) UserRepo {
+	return &breakerUserRepo{next: repo, brk: brk}
+}
+
+// do 通过熔断器执行数据库操作
+func (r *breakerUserRepo) do(fn func() error) error {
+	return r.brk.DoWithAcceptable(fn, func(err error) bool {
+		return err == nil || errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, ErrVersionConflict) ||
+			errors.Is(err, context.Canceled)
+	})
+}
+
+// FindByID 根据ID查询用户
+func (r *breakerUserRepo) FindByID(id int64) (*User, error) {
+	return r.FindByIDCtx(context.Background(), id)
+}
+
+// FindByIDCtx 根据ID查询用户
+func (r *breakerUserRepo) FindByIDCtx(ctx context.Context, id int64) (user *User, err error) {
+	err = r.do(func() error {
+		user, err = r.next.FindByIDCtx(ctx, id)
+		return err
+	})
+	return user, err
+}
+
+// FindByIDs 根据ID批量查询用户
+func (r *breakerUserRepo) FindByIDs(ids []int64) ([]*User, error) {
+	return r.FindByIDsCtx(context.Background(), ids)
+}
+
+// FindByIDsCtx 根据ID批量查询用户
+func (r *breakerUserRepo) FindByIDsCtx(ctx context.Context, ids []int64) (users []*User, err error) {
+	err = r.do(func() error {
+		users, err = r.next.FindByIDsCtx(ctx, ids)
+		return err
+	})
+	return users, err
+}
+
+// FindByUsername 根据用户名查询用户
+func (r *breakerUserRepo) FindByUsername(username string) (*User, error) {
+	return r.FindByUsernameCtx(context.Background(), username)
+}
+
+// FindByUsernameCtx 根据用户名查询用户
+func (r *breakerUserRepo) FindByUsernameCtx(ctx context.Context, username string) (user *User, err error) {
+	err = r.do(func() error {
+		user, err = r.next.FindByUsernameCtx(ctx, username)
+		return err
+	})
+	return user, err
+}
+
+// Count 查询用户总数
+func (r *breakerUserRepo) Count() (int64, error) {
+	return r.CountCtx(context.Background())
+}
+
+// CountCtx 查询用户总数
+func (r *breakerUserRepo) CountCtx(ctx context.Context) (count int64, err error) {
+	err = r.do(func() error {
+		count, err = r.next.CountCtx(ctx)
+		return err
+	})
+	return count, err
+}
+
+// MaxID 查询当前最大的用户ID
+func (r *breakerUserRepo) MaxID() (int64, error) {
+	return r.MaxIDCtx(context.Background())
+}
+
+// MaxIDCtx 查询当前最大的用户ID
+func (r *breakerUserRepo) MaxIDCtx(ctx context.Context) (maxID int64, err error) {
+	err = r.do(func() error {
+		maxID, err = r.next.MaxIDCtx(ctx)
+		return err
+	})
+	return maxID, err
+}
+
+// ListIDs 按ID升序分页查询用户ID
+func (r *breakerUserRepo) ListIDs(afterID int64, limit int) ([]int64, error) {
+	return r.ListIDsCtx(context.Background(), afterID, limit)
+}
+
+// ListIDsCtx 按ID升序分页查询用户ID
+func (r *breakerUserRepo) ListIDsCtx(ctx context.Context, afterID int64, limit int) (ids []int64, err error) {
+	err = r.do(func() error {
+		ids, err = r.next.ListIDsCtx(ctx, afterID, limit)
+		return err
+	})
+	return ids, err
+}
+
+// Create 创建用户
+func (r *breakerUserRepo) Create(user *User) error {
+	return r.CreateCtx(context.Background(), user)
+}
+
+// CreateCtx 创建用户
+func (r *breakerUserRepo) CreateCtx(ctx context.Context, user *User) error {
+	return r.do(func() error {
+		return r.next.CreateCtx(ctx, user)
+	})
+}
+
+// Update 更新用户
+func (r *breakerUserRepo) Update(user *User) error {
+	return r.UpdateCtx(context.Background(), user)
+}
+
+// UpdateCtx 更新用户
+func (r *breakerUserRepo) UpdateCtx(ctx context.Context, user *User) error {
+	return r.do(func() error {
+		return r.next.UpdateCtx(ctx, user)
+	})
+}
+
+// UpdateVersion 更新用户，版本号直接设置为 user.Version
+func (r *breakerUserRepo) UpdateVersion(user *User, expected int64) error {
+	return r.UpdateVersionCtx(context.Background(), user, expected)
+}
+
+// UpdateVersionCtx 更新用户，版本号直接设置为 user.Version
+func (r *breakerUserRepo) UpdateVersionCtx(ctx context.Context, user *User, expected int64) error {
+	return r.do(func() error {
+		return r.next.UpdateVersionCtx(ctx, user, expected)
+	})
+}
+
+// Delete 删除用户
+func (r *breakerUserRepo) Delete(id int64) error {
+	return r.DeleteCtx(context.Background(), id)
+}
+
+// DeleteCtx 删除用户
+func (r *breakerUserRepo) DeleteCtx(ctx context.Context, id int64) error {
+	return r.do(func() error {
+		return r.next.DeleteCtx(ctx, id)
+	})
+}
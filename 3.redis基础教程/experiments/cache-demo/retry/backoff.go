@@ -0,0 +1,78 @@
+package retry
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff 计算第 attempt 次失败后的等待时间，prev 为上一次的等待时间（第一次为0）
+type Backoff func(attempt int, prev time.Duration) time.Duration
+
+// Jitter 抖动方式
+type Jitter int
+
+const (
+	// NoJitter 不加抖动：base, 2·base, 4·base ...
+	NoJitter Jitter = iota
+	// FullJitter 全抖动：在 [0, base·2^(n-1)] 中随机，多个客户端的重试时间完全分散
+	FullJitter
+	// DecorrelatedJitter 去相关抖动：在 [base, 3·上一次等待时间] 中随机（第一次按上一次为 base 计算），等待时间随机增长，不依赖重试次数
+	DecorrelatedJitter
+)
+
+// String 抖动方式名称
+func (j Jitter) String() string {
+	switch j {
+	case NoJitter:
+		return "none"
+	case FullJitter:
+		return "full"
+	case DecorrelatedJitter:
+		return "decorrelated"
+	default:
+		return "unknown"
+	}
+}
+
+// ExponentialBackoff 指数退避，base 为第一次重试前的等待时间，maxDelay 为单次等待上限
+func ExponentialBackoff(base, maxDelay time.Duration, jitter Jitter) Backoff {
+	if base <= 0 {
+		base = DefaultBaseDelay
+	}
+	if maxDelay < base {
+		maxDelay = base
+	}
+	return func(attempt int, prev time.Duration) time.Duration {
+		if jitter == DecorrelatedJitter {
+			upper := max(prev, base) * 3
+			return min(base+randDuration(upper-base), maxDelay)
+		}
+
+		// base·2^(attempt-1)，先用浮点数判断是否超过上限，避免移位溢出
+		exp := float64(base) * math.Pow(2, float64(attempt-1))
+		delay := maxDelay
+		if exp < float64(maxDelay) {
+			delay = time.Duration(exp)
+		}
+		if jitter == FullJitter {
+			return randDuration(delay)
+		}
+		return delay
+	}
+}
+
+// ConstantBackoff 固定间隔（decorator_demo.go 中 retryDecorator 的行为）
+func ConstantBackoff(delay time.Duration) Backoff {
+	return func(int, time.Duration) time.Duration {
+		return delay
+	}
+}
+
+// randDuration 返回 [0, d] 中的随机时间
+func randDuration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
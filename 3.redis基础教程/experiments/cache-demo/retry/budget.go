@@ -0,0 +1,61 @@
+package retry
+
+import "sync"
+
+const (
+	// DefaultBudgetTokens 默认的预算令牌数
+	DefaultBudgetTokens = 100
+	// DefaultBudgetRatio 默认每次成功补充的令牌数：大约每10次成功允许1次重试
+	DefaultBudgetRatio = 0.1
+)
+
+// Budget 重试预算（gRPC retryThrottling 的算法）
+// 每次可重试的失败消耗1个令牌，每次成功补充 ratio 个令牌，令牌不超过一半时停止重试
+// 依赖正常时偶尔的失败都能重试；依赖持续故障时失败很快把令牌耗尽，调用退化为只执行一次，
+// 不会因为重试让故障中的数据库或Redis承受数倍的请求
+type Budget struct {
+	mu        sync.Mutex
+	tokens    float64
+	maxTokens float64
+	ratio     float64
+}
+
+// NewBudget 创建重试预算，maxTokens 越大能容忍的连续失败越多
+func NewBudget(maxTokens int, ratio float64) *Budget {
+	if maxTokens <= 0 {
+		maxTokens = DefaultBudgetTokens
+	}
+	if ratio <= 0 {
+		ratio = DefaultBudgetRatio
+	}
+	return &Budget{
+		tokens:    float64(maxTokens),
+		maxTokens: float64(maxTokens),
+		ratio:     ratio,
+	}
+}
+
+// Tokens 当前剩余的令牌数
+func (b *Budget) Tokens() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens
+}
+
+// record 记录一次执行结果
+func (b *Budget) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if success {
+		b.tokens = min(b.tokens+b.ratio, b.maxTokens)
+	} else {
+		b.tokens = max(b.tokens-1, 0)
+	}
+}
+
+// allow 是否还允许重试
+func (b *Budget) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens > b.maxTokens/2
+}
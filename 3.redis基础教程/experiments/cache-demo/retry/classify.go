@@ -0,0 +1,80 @@
+package retry
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	red "github.com/go-redis/redis/v8"
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+// 可以重试的 MySQL 错误码
+const (
+	mysqlLockWaitTimeout = 1205 // Lock wait timeout exceeded
+	mysqlDeadlock        = 1213 // Deadlock found when trying to get lock
+)
+
+// redisRetryablePrefixes 可以重试的 Redis 服务端错误（加载数据、主从切换、集群迁移中）
+var redisRetryablePrefixes = []string{"LOADING", "READONLY", "MASTERDOWN", "CLUSTERDOWN", "TRYAGAIN", "BUSY"}
+
+// IsRetryable 默认的错误分类：只有确定是临时故障的错误才重试，不认识的错误不重试
+//
+// 可以重试：MySQL 死锁、锁等待超时、连接断开；网络超时、连接被拒绝或重置；Redis 连接池超时、主从切换
+// 不能重试：记录不存在（gorm.ErrRecordNotFound、redis.Nil）、唯一键冲突等业务错误；context 取消；Permanent 标记的错误
+func IsRetryable(err error) bool {
+	if err == nil || IsPermanent(err) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, red.Nil) || errors.Is(err, red.ErrClosed) {
+		return false
+	}
+
+	// MySQL
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlDeadlock || mysqlErr.Number == mysqlLockWaitTimeout
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+
+	// 网络
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	// Redis
+	var redisErr red.Error
+	if errors.As(err, &redisErr) {
+		msg := redisErr.Error()
+		for _, prefix := range redisRetryablePrefixes {
+			if strings.HasPrefix(msg, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	// go-redis v8 的连接池超时没有导出错误变量
+	return strings.Contains(err.Error(), "connection pool timeout")
+}
+
+// Not 在分类器的基础上排除某些错误（例如服务层的业务错误）
+func (c Classifier) Not(exclude func(err error) bool) Classifier {
+	return func(err error) bool {
+		return !exclude(err) && c(err)
+	}
+}
@@ -0,0 +1,202 @@
+// Package retry 带退避、抖动和错误分类的重试
+//
+// 与 decorator_demo.go 中固定间隔的重试装饰器相比：
+// 1. 指数退避 + 抖动（全抖动、去相关抖动），多个客户端同时失败时不会在同一时刻一起重试
+// 2. 最大重试次数和最长总耗时两个上限，支持 context 取消
+// 3. 错误分类：只重试临时错误（MySQL死锁、连接断开、Redis超时），记录不存在、参数错误等直接返回
+// 4. 重试预算：失败太多时停止重试，避免重试把故障中的依赖彻底压垮
+// 5. 钩子：每次重试、最终放弃时回调，用于日志和指标
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultMaxAttempts 默认最多执行次数（含第一次）
+	DefaultMaxAttempts = 3
+	// DefaultBaseDelay 默认第一次重试前的等待时间
+	DefaultBaseDelay = 50 * time.Millisecond
+	// DefaultMaxDelay 默认单次等待时间上限
+	DefaultMaxDelay = time.Second
+)
+
+// 放弃重试的原因（OnGiveUp 的 reason 参数）
+const (
+	GiveUpNotRetryable = "not_retryable" // 错误不可重试
+	GiveUpMaxAttempts  = "max_attempts"  // 达到最大执行次数
+	GiveUpMaxElapsed   = "max_elapsed"   // 再等待就会超过最长总耗时
+	GiveUpBudget       = "budget"        // 重试预算耗尽
+	GiveUpCanceled     = "canceled"      // context 被取消或超时
+)
+
+// Classifier 判断错误是否可以重试
+type Classifier func(err error) bool
+
+// Policy 重试策略，创建后可以被多个 goroutine 共享
+type Policy struct {
+	maxAttempts int
+	maxElapsed  time.Duration
+	backoff     Backoff
+	classifier  Classifier
+	budget      *Budget
+	onRetry     func(op string, attempt int, err error, delay time.Duration)
+	onGiveUp    func(op string, attempts int, err error, reason string)
+}
+
+// Option 重试策略选项
+type Option func(p *Policy)
+
+// WithMaxAttempts 最多执行次数（含第一次），小于1时按1处理（不重试）
+func WithMaxAttempts(attempts int) Option {
+	return func(p *Policy) {
+		p.maxAttempts = max(attempts, 1)
+	}
+}
+
+// WithMaxElapsed 从第一次执行开始的最长总耗时，0 表示不限制
+// 下一次等待会超过该时间时不再重试
+func WithMaxElapsed(d time.Duration) Option {
+	return func(p *Policy) {
+		p.maxElapsed = d
+	}
+}
+
+// WithBackoff 退避策略，默认 ExponentialBackoff(DefaultBaseDelay, DefaultMaxDelay, FullJitter)
+func WithBackoff(backoff Backoff) Option {
+	return func(p *Policy) {
+		p.backoff = backoff
+	}
+}
+
+// WithClassifier 错误分类，默认 IsRetryable
+func WithClassifier(classifier Classifier) Option {
+	return func(p *Policy) {
+		p.classifier = classifier
+	}
+}
+
+// WithBudget 重试预算，多个策略可以共享同一个预算（例如同一个依赖的所有调用）
+func WithBudget(budget *Budget) Option {
+	return func(p *Policy) {
+		p.budget = budget
+	}
+}
+
+// WithOnRetry 每次重试之前回调，attempt 为刚失败的是第几次执行
+func WithOnRetry(fn func(op string, attempt int, err error, delay time.Duration)) Option {
+	return func(p *Policy) {
+		p.onRetry = fn
+	}
+}
+
+// WithOnGiveUp 失败且不再重试时回调，attempts 为已经执行的次数
+func WithOnGiveUp(fn func(op string, attempts int, err error, reason string)) Option {
+	return func(p *Policy) {
+		p.onGiveUp = fn
+	}
+}
+
+// New 创建重试策略
+func New(opts ...Option) *Policy {
+	p := &Policy{
+		maxAttempts: DefaultMaxAttempts,
+		backoff:     ExponentialBackoff(DefaultBaseDelay, DefaultMaxDelay, FullJitter),
+		classifier:  IsRetryable,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Do 执行 fn，失败时按策略重试；op 为操作名称，传给钩子用于日志和指标
+// 返回最后一次的错误：重试过的错误会加上重试次数，仍然可以用 errors.Is 判断原始错误
+func (p *Policy) Do(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	start := time.Now()
+	var prevDelay time.Duration
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			if p.budget != nil {
+				p.budget.record(true)
+			}
+			return nil
+		}
+
+		// 只有可重试的失败消耗预算：不可重试的错误（记录不存在、参数错误）不说明依赖出了故障
+		retryable := p.classifier(err)
+		if p.budget != nil && retryable {
+			p.budget.record(false)
+		}
+
+		reason := ""
+		var delay time.Duration
+		switch {
+		case ctx.Err() != nil:
+			reason = GiveUpCanceled
+		case !retryable:
+			reason = GiveUpNotRetryable
+		case attempt >= p.maxAttempts:
+			reason = GiveUpMaxAttempts
+		default:
+			delay = p.backoff(attempt, prevDelay)
+			if p.maxElapsed > 0 && time.Since(start)+delay > p.maxElapsed {
+				reason = GiveUpMaxElapsed
+			} else if p.budget != nil && !p.budget.allow() {
+				reason = GiveUpBudget
+			}
+		}
+		if reason != "" {
+			return p.giveUp(op, attempt, err, reason)
+		}
+
+		if p.onRetry != nil {
+			p.onRetry(op, attempt, err, delay)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return p.giveUp(op, attempt, fmt.Errorf("%w (等待重试时取消: %w)", err, ctx.Err()), GiveUpCanceled)
+		case <-timer.C:
+		}
+		prevDelay = delay
+	}
+}
+
+// giveUp 回调并返回最终错误
+func (p *Policy) giveUp(op string, attempts int, err error, reason string) error {
+	if p.onGiveUp != nil {
+		p.onGiveUp(op, attempts, err, reason)
+	}
+	if attempts > 1 {
+		return fmt.Errorf("执行 %d 次后仍然失败: %w", attempts, err)
+	}
+	return err
+}
+
+// permanentError 标记为不可重试的错误
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent 把错误标记为不可重试（fn 中已经知道重试没有意义时使用），IsRetryable 会返回 false
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent 是否被 Permanent 标记过
+func IsPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe)
+}
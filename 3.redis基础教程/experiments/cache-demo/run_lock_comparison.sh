@@ -30,11 +30,38 @@ if [ -z "$1" ]; then
     echo "  1. 实验1: 多进程普通锁（mutex）- 展示问题"
     echo "  2. 实验2: 多进程分布式锁 - 正确解决方案"
     echo "  3. 对比实验: 同时运行两个实验进行对比"
+    echo "  4. 实验3: 锁过期 + fencing token（进程暂停超过锁过期时间）"
+    echo "  5. 实验4: 公平性 - 普通锁 vs 公平锁（排队 + pub/sub 唤醒）"
+    echo "  6. 实验5: 读多写少吞吐量 - 互斥锁 vs 读写锁"
     echo ""
-    echo "使用方法: $0 [1|2|3]"
+    echo "使用方法: $0 [1|2|3|4|5|6]"
     exit 1
 fi
 
+# 运行一组压测进程（每个进程循环获取锁5秒），然后汇总结果
+# 用法: run_bench <模式> <进程名[:角色]>...
+run_bench() {
+    MODE=$1
+    shift
+    redis-cli DEL lockbench:$MODE:events lockbench:$MODE:start > /dev/null 2>&1
+
+    PIDS=""
+    for P in "$@"; do
+        NAME=${P%%:*}
+        ROLE=""
+        if [ "$NAME" != "$P" ]; then
+            ROLE=${P#*:}
+        fi
+        go run test_lock_fairness.go $MODE $NAME $ROLE &
+        PIDS="$PIDS $!"
+    done
+    wait $PIDS
+
+    echo ""
+    go run test_lock_fairness.go report $MODE
+    echo ""
+}
+
 EXPERIMENT=$1
 
 case $EXPERIMENT in
@@ -211,9 +238,109 @@ case $EXPERIMENT in
         echo ""
         ;;
         
+    4)
+        echo "=========================================="
+        echo "【实验3】锁过期 + fencing token"
+        echo "=========================================="
+        echo ""
+        echo "⚠️  进程A关闭看门狗并暂停3s（锁2s过期），进程B在锁过期后获取锁"
+        echo "   观察进程A恢复后的写入如何被 fencing token 拒绝"
+        echo ""
+        read -p "按Enter键开始..."
+        echo ""
+        
+        # 重置库存
+        redis-cli SET stock:product:1001 100 > /dev/null 2>&1
+        
+        cd lock-demo
+        echo "启动进程A（pause 模式）..."
+        go run test_distributed_lock.go 进程A pause &
+        PID1=$!
+        
+        sleep 1
+        
+        echo "启动进程B..."
+        go run test_distributed_lock.go 进程B &
+        PID2=$!
+        
+        echo ""
+        echo "等待所有进程完成..."
+        wait $PID1 $PID2
+        
+        echo ""
+        echo "=========================================="
+        echo "实验3完成"
+        echo "=========================================="
+        echo ""
+        echo "📊 最终库存:"
+        redis-cli GET stock:product:1001
+        echo ""
+        echo "✅ 进程A的过期写入被拒绝，只有进程B扣减成功（库存90）"
+        ;;
+        
+    5)
+        echo "=========================================="
+        echo "【实验4】公平性 - 普通锁 vs 公平锁"
+        echo "=========================================="
+        echo ""
+        echo "✅ 4个进程同时循环获取同一把锁（每次持有20ms），持续5秒"
+        echo "   观察每个进程获取锁的次数、最长等待时间和插队次数"
+        echo ""
+        read -p "按Enter键开始..."
+        echo ""
+
+        cd lock-demo
+        echo "第一步：普通锁（按退避策略重试）"
+        run_bench unfair 进程A 进程B 进程C 进程D
+
+        echo "第二步：公平锁（排队 + pub/sub 唤醒）"
+        run_bench fair 进程A 进程B 进程C 进程D
+
+        echo "=========================================="
+        echo "实验4完成"
+        echo "=========================================="
+        echo ""
+        echo "分析："
+        echo "  - 普通锁：刚释放锁的进程马上再次获取最容易成功，其他进程在退避中错过，部分进程几乎获取不到（饥饿）"
+        echo "  - 公平锁：按排队顺序获取，各进程获取次数相同，最长等待时间约为 (进程数-1) × 持有时间"
+        ;;
+
+    6)
+        echo "=========================================="
+        echo "【实验5】读多写少 - 互斥锁 vs 读写锁"
+        echo "=========================================="
+        echo ""
+        echo "✅ 4个读者不停读取库存（每次50ms），1个写者每500ms扣减一次库存，持续5秒"
+        echo "   观察读、写吞吐量和读者并发"
+        echo ""
+        read -p "按Enter键开始..."
+        echo ""
+
+        redis-cli SET stock:product:1001 100 > /dev/null 2>&1
+
+        cd lock-demo
+        echo "第一步：互斥锁（读操作之间也要排队）"
+        run_bench mutex 读者A:reader 读者B:reader 读者C:reader 读者D:reader 写者W:writer
+
+        echo "第二步：读写锁（读锁共享，写锁独占）"
+        run_bench rw 读者A:reader 读者B:reader 读者C:reader 读者D:reader 写者W:writer
+
+        echo "=========================================="
+        echo "实验5完成"
+        echo "=========================================="
+        echo ""
+        echo "📊 最终库存:"
+        redis-cli GET stock:product:1001
+        echo ""
+        echo "分析："
+        echo "  - 互斥锁：同一时刻只有一个读者，读吞吐量上限约 1 / 50ms = 20 次/秒"
+        echo "  - 读写锁：多个读者同时持有读锁，读吞吐量接近 读者数 × 20 次/秒"
+        echo "  - 写者等待期间新的读者不能进入（写者优先），写者不会被源源不断的读者饿死"
+        ;;
+
     *)
         echo "❌ 无效的实验编号: $EXPERIMENT"
-        echo "   请使用: 1, 2, 或 3"
+        echo "   请使用: 1, 2, 3, 4, 5, 或 6"
         exit 1
         ;;
 esac
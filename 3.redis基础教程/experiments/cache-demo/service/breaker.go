@@ -0,0 +1,25 @@
+package service
+
+import (
+	"cache-demo/breaker"
+	"cache-demo/metrics"
+	"errors"
+	"log"
+)
+
+// NewBreaker 创建带日志和指标钩子的熔断器（opts 中的钩子会覆盖默认钩子）
+// 用于包装数据库（model.NewBreakerUserRepo）、Redis（cache.WithUserBreaker）和服务方法（Breaker 中间件）
+func NewBreaker(name string, opts ...breaker.Option) *breaker.Breaker {
+	metrics.SetBreakerState(name, int(breaker.StateClosed))
+	return breaker.New(name, append([]breaker.Option{
+		breaker.WithOnStateChange(func(name string, from, to breaker.State) {
+			metrics.SetBreakerState(name, int(to))
+			log.Printf("[熔断器状态变化] name=%s, %s -> %s", name, from, to)
+		}),
+	}, opts...)...)
+}
+
+// isBreakerOpen 错误是否为熔断器打开（依赖没有被访问）
+func isBreakerOpen(err error) bool {
+	return errors.Is(err, breaker.ErrOpen)
+}
@@ -0,0 +1,332 @@
+package service
+
+import (
+	"cache-demo/breaker"
+	"cache-demo/metrics"
+	"cache-demo/model"
+	"cache-demo/retry"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/trace"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Middleware 用户服务中间件：包装一个 UserService，返回增加了日志、限流、重试等能力的 UserService
+type Middleware func(next UserService) UserService
+
+// Chain 组合中间件，第一个中间件在最外层：Chain(s, a, b) 等价于 a(b(s))
+// 为 nil 的中间件会被跳过，方便按配置选择性启用
+func Chain(userService UserService, middlewares ...Middleware) UserService {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if middlewares[i] != nil {
+			userService = middlewares[i](userService)
+		}
+	}
+	return userService
+}
+
+// Call 一次用户服务调用的描述，拦截器根据它输出日志、记录指标
+type Call struct {
+	Method     string        // 方法名，例如 GetUserByID
+	Client     string        // 通过 ForClient 绑定的客户端，可能为空
+	Idempotent bool          // 是否可以安全重试（CreateUser 重试可能重复创建，UpdateUser 重试可能被自己的更新判为版本冲突）
+	Args       func() string // 参数描述，例如 user_id=1；在调用结束后求值，可以带上调用结果（例如新用户的ID）
+}
+
+// Name 方法的中文名称，用于日志
+func (c Call) Name() string {
+	if name, ok := methodNames[c.Method]; ok {
+		return name
+	}
+	return c.Method
+}
+
+// methodNames 方法的中文名称（与原来各服务实现里的日志标签一致）
+var methodNames = map[string]string{
+	"GetUserByID":       "查询用户",
+	"GetUsersByIDs":     "批量查询用户",
+	"GetUserByUsername": "按用户名查询用户",
+	"CreateUser":        "创建用户",
+	"UpdateUser":        "更新用户",
+	"DeleteUser":        "删除用户",
+}
+
+// Interceptor 拦截器：invoke 执行被包装的服务方法，拦截器可以在前后增加逻辑、多次执行或者不执行
+// 所有方法都经过同一个拦截器，写一个拦截器就能覆盖 UserService 的全部方法
+// ctx 是调用方传入的 context，拦截器可以换成派生的 ctx 再传给 invoke（例如带上 span）
+type Interceptor func(ctx context.Context, call Call, invoke func(ctx context.Context) error) error
+
+// Intercept 把拦截器转换为中间件
+func Intercept(interceptor Interceptor) Middleware {
+	return func(next UserService) UserService {
+		return &interceptedUserService{next: next, interceptor: interceptor}
+	}
+}
+
+// interceptedUserService 每个方法都经过拦截器的用户服务
+type interceptedUserService struct {
+	next        UserService
+	interceptor Interceptor
+	client      string
+}
+
+// ForClient 被包装的服务支持按客户端区分时，返回绑定到客户端的服务（同样经过拦截器）
+func (s *interceptedUserService) ForClient(clientID string) UserService {
+	next := s.next
+	if clientService, ok := s.next.(ClientUserService); ok {
+		next = clientService.ForClient(clientID)
+	}
+	return &interceptedUserService{next: next, interceptor: s.interceptor, client: clientID}
+}
+
+// GetUserByID 根据ID获取用户
+func (s *interceptedUserService) GetUserByID(id int64) (*model.User, error) {
+	return s.GetUserByIDCtx(context.Background(), id)
+}
+
+// GetUserByIDCtx 根据ID获取用户
+func (s *interceptedUserService) GetUserByIDCtx(ctx context.Context, id int64) (user *model.User, err error) {
+	err = s.interceptor(ctx, s.call("GetUserByID", true, func() string {
+		return fmt.Sprintf("user_id=%d", id)
+	}), func(ctx context.Context) error {
+		user, err = s.next.GetUserByIDCtx(ctx, id)
+		return err
+	})
+	return user, err
+}
+
+// GetUsersByIDs 批量获取用户
+func (s *interceptedUserService) GetUsersByIDs(ids []int64) ([]*model.User, []int64, error) {
+	return s.GetUsersByIDsCtx(context.Background(), ids)
+}
+
+// GetUsersByIDsCtx 批量获取用户
+func (s *interceptedUserService) GetUsersByIDsCtx(ctx context.Context, ids []int64) (users []*model.User, missing []int64, err error) {
+	err = s.interceptor(ctx, s.call("GetUsersByIDs", true, func() string {
+		return fmt.Sprintf("ids=%v, 返回=%d, 不存在=%v", ids, len(users), missing)
+	}), func(ctx context.Context) error {
+		users, missing, err = s.next.GetUsersByIDsCtx(ctx, ids)
+		return err
+	})
+	return users, missing, err
+}
+
+// GetUserByUsername 根据用户名获取用户
+func (s *interceptedUserService) GetUserByUsername(username string) (*model.User, error) {
+	return s.GetUserByUsernameCtx(context.Background(), username)
+}
+
+// GetUserByUsernameCtx 根据用户名获取用户
+func (s *interceptedUserService) GetUserByUsernameCtx(ctx context.Context, username string) (user *model.User, err error) {
+	err = s.interceptor(ctx, s.call("GetUserByUsername", true, func() string {
+		return fmt.Sprintf("username=%s", username)
+	}), func(ctx context.Context) error {
+		user, err = s.next.GetUserByUsernameCtx(ctx, username)
+		return err
+	})
+	return user, err
+}
+
+// CreateUser 创建用户
+func (s *interceptedUserService) CreateUser(user *model.User) error {
+	return s.CreateUserCtx(context.Background(), user)
+}
+
+// CreateUserCtx 创建用户
+func (s *interceptedUserService) CreateUserCtx(ctx context.Context, user *model.User) error {
+	return s.interceptor(ctx, s.call("CreateUser", false, func() string {
+		return fmt.Sprintf("user_id=%d, username=%s", user.ID, user.Username)
+	}), func(ctx context.Context) error {
+		return s.next.CreateUserCtx(ctx, user)
+	})
+}
+
+// UpdateUser 更新用户
+func (s *interceptedUserService) UpdateUser(user *model.User) error {
+	return s.UpdateUserCtx(context.Background(), user)
+}
+
+// UpdateUserCtx 更新用户
+// 乐观锁让更新不再幂等：提交成功但响应丢失（连接断开）时，重试会返回版本冲突，所以不重试
+func (s *interceptedUserService) UpdateUserCtx(ctx context.Context, user *model.User) error {
+	return s.interceptor(ctx, s.call("UpdateUser", false, func() string {
+		return fmt.Sprintf("user_id=%d", user.ID)
+	}), func(ctx context.Context) error {
+		return s.next.UpdateUserCtx(ctx, user)
+	})
+}
+
+// DeleteUser 删除用户
+func (s *interceptedUserService) DeleteUser(id int64) error {
+	return s.DeleteUserCtx(context.Background(), id)
+}
+
+// DeleteUserCtx 删除用户
+func (s *interceptedUserService) DeleteUserCtx(ctx context.Context, id int64) error {
+	return s.interceptor(ctx, s.call("DeleteUser", true, func() string {
+		return fmt.Sprintf("user_id=%d", id)
+	}), func(ctx context.Context) error {
+		return s.next.DeleteUserCtx(ctx, id)
+	})
+}
+
+// call 构造调用描述
+func (s *interceptedUserService) call(method string, idempotent bool, args func() string) Call {
+	return Call{Method: method, Client: s.client, Idempotent: idempotent, Args: args}
+}
+
+// IsBusinessError 判断是否为业务错误（用户不存在、参数不合法、版本冲突、被限流、被熔断等）
+// 业务错误说明服务本身是正常的：不需要重试，也不计入熔断器的失败次数
+// 版本冲突需要调用方重新读取后再更新，原样重试同一个请求仍然会冲突
+func IsBusinessError(err error) bool {
+	return errors.Is(err, ErrUserNotFound) ||
+		errors.Is(err, ErrInvalidUserID) ||
+		errors.Is(err, model.ErrVersionConflict) ||
+		errors.Is(err, ErrUserPoisoned) ||
+		errors.Is(err, ErrInvalidUsername) ||
+		errors.Is(err, ErrClientBlocked) ||
+		errors.Is(err, ErrTooManyProbes) ||
+		isBreakerOpen(err)
+}
+
+// callResult 调用结果分类（metrics 的 result 标签）
+func callResult(err error) string {
+	switch {
+	case err == nil:
+		return metrics.ResultOK
+	case errors.Is(err, ErrUserNotFound):
+		return metrics.ResultNotFound
+	case IsBusinessError(err):
+		return metrics.ResultRejected
+	default:
+		return metrics.ResultError
+	}
+}
+
+// describe 调用描述：参数 + 客户端
+func describe(call Call) string {
+	args := call.Args()
+	if call.Client != "" {
+		args += ", client=" + call.Client
+	}
+	return args
+}
+
+// Logging 日志中间件：每次调用结束后输出一行日志（替代各服务实现中的 [创建用户]、[更新用户] 等日志）
+// 调用的结果（命中、未命中、写缓存成功）由这里和 Metrics 记录，服务实现中只保留调用方看不到的事件：
+// 被忽略的缓存写入失败、跳过旧版本、互斥锁和异步重建等
+func Logging() Middleware {
+	return Intercept(func(ctx context.Context, call Call, invoke func(ctx context.Context) error) error {
+		start := time.Now()
+		err := invoke(ctx)
+		elapsed := time.Since(start)
+		switch {
+		case err == nil:
+			log.Printf("[%s成功] %s, 耗时=%v", call.Name(), describe(call), elapsed)
+		case IsBusinessError(err):
+			log.Printf("[%s] %s, 结果=%v, 耗时=%v", call.Name(), describe(call), err, elapsed)
+		default:
+			log.Printf("[%s失败] %s, error=%v, 耗时=%v", call.Name(), describe(call), err, elapsed)
+		}
+		return err
+	})
+}
+
+// Timing 慢调用中间件：耗时超过 threshold 的调用输出告警日志
+func Timing(threshold time.Duration) Middleware {
+	return Intercept(func(ctx context.Context, call Call, invoke func(ctx context.Context) error) error {
+		start := time.Now()
+		err := invoke(ctx)
+		if elapsed := time.Since(start); elapsed > threshold {
+			log.Printf("[慢调用] %s %s, 耗时=%v, 阈值=%v", call.Name(), describe(call), elapsed, threshold)
+		}
+		return err
+	})
+}
+
+// Retry 重试中间件：幂等的方法遇到临时错误（MySQL死锁、连接断开、Redis超时等）时按退避策略重试
+// 默认使用 retry.IsRetryable 分类并排除业务错误，opts 可以覆盖次数、退避、预算等参数
+func Retry(opts ...retry.Option) Middleware {
+	policy := newRetryPolicy(append([]retry.Option{
+		retry.WithClassifier(retry.Classifier(retry.IsRetryable).Not(IsBusinessError)),
+	}, opts...)...)
+	return Intercept(func(ctx context.Context, call Call, invoke func(ctx context.Context) error) error {
+		if !call.Idempotent {
+			return invoke(ctx)
+		}
+		// ctx 超时或取消后不再重试，等待重试的间隔也会被中断
+		return policy.Do(ctx, call.Method, invoke)
+	})
+}
+
+// Breaker 熔断中间件：滑动窗口内非业务错误的比例过高时打开，直接返回 breaker.ErrOpen，
+// 不再访问已经出故障的数据库或Redis，经过一段时间后放行少量探测请求，全部成功后恢复
+// 每个方法一个熔断器，批量查询出故障不影响单个查询；opts 可以覆盖窗口、阈值等参数
+func Breaker(name string, opts ...breaker.Option) Middleware {
+	breakers := make(map[string]*breaker.Breaker, len(methodNames))
+	for method := range methodNames {
+		breakers[method] = NewBreaker(name+"."+method, opts...)
+	}
+	return Intercept(func(ctx context.Context, call Call, invoke func(ctx context.Context) error) error {
+		brk, ok := breakers[call.Method]
+		if !ok {
+			return invoke(ctx)
+		}
+		invoked := false
+		err := brk.DoWithAcceptable(func() error {
+			invoked = true
+			return invoke(ctx)
+		}, func(err error) bool {
+			// 调用方取消不说明服务有问题
+			return err == nil || IsBusinessError(err) || errors.Is(err, context.Canceled)
+		})
+		// 只有本熔断器拒绝的请求才加上方法名（内层数据库、Redis熔断器的错误原样返回）
+		if !invoked && isBreakerOpen(err) {
+			return fmt.Errorf("%s已熔断: %w", call.Name(), err)
+		}
+		return err
+	})
+}
+
+// Metrics 指标中间件：按方法记录调用次数和耗时（Prometheus 中为 cache_demo_service_*）
+func Metrics() Middleware {
+	return Intercept(func(ctx context.Context, call Call, invoke func(ctx context.Context) error) error {
+		start := time.Now()
+		err := invoke(ctx)
+		metrics.ObserveServiceCall(call.Method, callResult(err), time.Since(start))
+		return err
+	})
+}
+
+// Tracing 链路追踪中间件：每次调用创建一个 span（UserService/<方法名>）
+// span 的父节点取自调用方的 ctx，新的 ctx 继续往下传，内层的 span 会挂在它下面
+// 使用 go-zero 的全局 TracerProvider，没有配置 Telemetry 时不会导出任何数据
+func Tracing() Middleware {
+	return Intercept(func(ctx context.Context, call Call, invoke func(ctx context.Context) error) error {
+		ctx, span := trace.TracerFromContext(ctx).Start(ctx, "UserService/"+call.Method)
+		defer span.End()
+
+		err := invoke(ctx)
+		span.SetAttributes(attribute.String("user_service.args", call.Args()))
+		if call.Client != "" {
+			span.SetAttributes(attribute.String("user_service.client", call.Client))
+		}
+		if err != nil && !IsBusinessError(err) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	})
+}
+
+// Guard 请求校验中间件（见 NewGuardedUserService）
+func Guard(guard *RequestGuard) Middleware {
+	return func(next UserService) UserService {
+		return NewGuardedUserService(next, guard)
+	}
+}
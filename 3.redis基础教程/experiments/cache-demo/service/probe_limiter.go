@@ -0,0 +1,89 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/collection"
+	"github.com/zeromicro/go-zero/core/limit"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+// ErrTooManyProbes 缓存未命中时访问数据库的请求过多，被限流
+var ErrTooManyProbes = errors.New("查询过于频繁，请稍后再试")
+
+const (
+	// ProbeLimitKeyPrefix 回源限流令牌桶的Key前缀
+	ProbeLimitKeyPrefix = "user_probe_limit:"
+	// DefaultGlobalProbeRate 所有客户端每秒最多回源次数
+	DefaultGlobalProbeRate = 100
+	// DefaultClientProbeRate 单个客户端每秒最多回源次数
+	DefaultClientProbeRate = 10
+	// MaxProbeClients 进程内最多保留的客户端个数（令牌桶、读穿透缓存），超过时淘汰最久未使用的
+	// 攻击者每个请求换一个客户端ID时，内存不会无限增长
+	MaxProbeClients = 10000
+	// ProbeClientExpire 客户端的令牌桶、读穿透缓存在进程内的保留时间，过期后重新创建（令牌保存在Redis，不受影响）
+	ProbeClientExpire = 10 * time.Minute
+)
+
+// ProbeLimiter 回源限流器：缓存未命中（缓存中既没有数据也没有空值缓存）时才需要访问数据库，
+// 攻击者用随机ID刷接口时每个请求都会落到这里，所以只对这部分请求限流，缓存命中不受影响
+//
+// 两级令牌桶（go-zero limit.TokenLimiter，令牌保存在Redis，多个实例共享；Redis不可用时退化为进程内限流）：
+// 1. 每个客户端一个令牌桶：单个客户端刷不存在的ID，只会把自己限流
+// 2. 全局一个令牌桶：大量客户端一起刷时保护数据库
+type ProbeLimiter struct {
+	rds         *redis.Redis
+	global      *limit.TokenLimiter
+	clientRate  int
+	clientBurst int
+	clients     *collection.Cache // clientID -> *limit.TokenLimiter
+}
+
+// NewProbeLimiter 创建回源限流器，rate 为每秒生成的令牌数，burst 为令牌桶容量（允许的突发请求数）
+// clientRate 为0时不按客户端限流
+func NewProbeLimiter(rds *redis.Redis, globalRate, globalBurst, clientRate, clientBurst int) *ProbeLimiter {
+	return &ProbeLimiter{
+		rds:         rds,
+		global:      limit.NewTokenLimiter(globalRate, globalBurst, rds, ProbeLimitKeyPrefix+"global"),
+		clientRate:  clientRate,
+		clientBurst: clientBurst,
+		clients:     newClientCache("probe_limiters"),
+	}
+}
+
+// NewDefaultProbeLimiter 使用默认速率创建回源限流器（突发请求数与速率相同）
+func NewDefaultProbeLimiter(rds *redis.Redis) *ProbeLimiter {
+	return NewProbeLimiter(rds, DefaultGlobalProbeRate, DefaultGlobalProbeRate, DefaultClientProbeRate, DefaultClientProbeRate)
+}
+
+// Allow 检查客户端本次回源是否允许，先检查客户端令牌桶，被拒绝的请求不消耗全局令牌
+// clientID 为空时只检查全局令牌桶
+func (l *ProbeLimiter) Allow(clientID string) error {
+	if clientID != "" && l.clientRate > 0 && !l.client(clientID).Allow() {
+		return fmt.Errorf("%w: client=%s", ErrTooManyProbes, clientID)
+	}
+	if !l.global.Allow() {
+		return fmt.Errorf("%w: 全局回源限流", ErrTooManyProbes)
+	}
+	return nil
+}
+
+// client 返回客户端的令牌桶（同一个客户端复用，Redis不可用时进程内的兜底限流才能生效）
+func (l *ProbeLimiter) client(clientID string) *limit.TokenLimiter {
+	lim, _ := l.clients.Take(clientID, func() (any, error) {
+		return limit.NewTokenLimiter(l.clientRate, l.clientBurst, l.rds, ProbeLimitKeyPrefix+"client:"+clientID), nil
+	})
+	return lim.(*limit.TokenLimiter)
+}
+
+// newClientCache 创建按客户端ID保存对象的进程内缓存（最多 MaxProbeClients 个，保留 ProbeClientExpire）
+func newClientCache(name string) *collection.Cache {
+	clients, err := collection.NewCache(ProbeClientExpire, collection.WithLimit(MaxProbeClients), collection.WithName(name))
+	if err != nil {
+		// 只有时间轮参数不合法时才会失败，这里的参数是固定的
+		panic(fmt.Sprintf("创建客户端缓存失败: %v", err))
+	}
+	return clients
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"cache-demo/metrics"
+	"cache-demo/model"
+	"cache-demo/retry"
+	"context"
+	"log"
+	"time"
+)
+
+// 缓存写入的重试参数：Redis的临时故障（超时、主从切换）通常很快恢复，
+// 写入失败又会留下旧数据，所以快速重试几次，总耗时不超过 cacheWriteMaxElapsed
+const (
+	cacheWriteMaxAttempts = 3
+	cacheWriteBaseDelay   = 20 * time.Millisecond
+	cacheWriteMaxDelay    = 200 * time.Millisecond
+	cacheWriteMaxElapsed  = 500 * time.Millisecond
+)
+
+// cacheWriteBudget 所有缓存写入共享的重试预算：Redis持续故障时停止重试，避免把写请求放大几倍
+var cacheWriteBudget = retry.NewBudget(retry.DefaultBudgetTokens, retry.DefaultBudgetRatio)
+
+// cacheWriteRetry 缓存写入（更新、删除、写空值）的重试策略
+var cacheWriteRetry = newRetryPolicy(
+	retry.WithMaxAttempts(cacheWriteMaxAttempts),
+	retry.WithBackoff(retry.ExponentialBackoff(cacheWriteBaseDelay, cacheWriteMaxDelay, retry.FullJitter)),
+	retry.WithMaxElapsed(cacheWriteMaxElapsed),
+	retry.WithBudget(cacheWriteBudget),
+)
+
+// newRetryPolicy 创建带日志和指标钩子的重试策略（opts 中的钩子会覆盖默认钩子）
+func newRetryPolicy(opts ...retry.Option) *retry.Policy {
+	return retry.New(append([]retry.Option{
+		retry.WithOnRetry(func(op string, attempt int, err error, delay time.Duration) {
+			metrics.ObserveRetry(op, metrics.RetryEventRetry)
+			log.Printf("[重试] op=%s, 第%d次失败: %v, %v 后重试", op, attempt, err, delay)
+		}),
+		retry.WithOnGiveUp(func(op string, attempts int, err error, reason string) {
+			// 第一次就遇到不可重试的错误属于正常失败，不算放弃重试
+			if attempts == 1 && reason == retry.GiveUpNotRetryable {
+				return
+			}
+			metrics.ObserveRetry(op, reason)
+			log.Printf("[放弃重试] op=%s, 已执行%d次, 原因=%s, error=%v", op, attempts, reason, err)
+		}),
+	}, opts...)...)
+}
+
+// retryCacheWrite 按缓存写入的重试策略执行写操作，op 使用 metrics.OpSet 等操作名
+// 缓存写入一般发生在数据库修改之后，调用方超时或取消时也要写完，否则缓存里留下旧数据，
+// 所以不继承 ctx 的取消（保留 ctx 中的值，例如链路追踪），总耗时由 cacheWriteMaxElapsed 限制
+func retryCacheWrite(ctx context.Context, op string, write func(ctx context.Context) error) error {
+	return cacheWriteRetry.Do(context.WithoutCancel(ctx), op, write)
+}
+
+// setUserCache 写入用户缓存（失败时重试）
+func setUserCache(ctx context.Context, c interface {
+	SetUserCtx(ctx context.Context, user *model.User, expireSeconds int) error
+}, user *model.User, expireSeconds int) error {
+	return retryCacheWrite(ctx, metrics.OpSet, func(ctx context.Context) error {
+		return c.SetUserCtx(ctx, user, expireSeconds)
+	})
+}
+
+// deleteUserCache 删除用户缓存（失败时重试）
+func deleteUserCache(ctx context.Context, c interface {
+	DeleteUserCtx(ctx context.Context, id int64) error
+}, id int64) error {
+	return retryCacheWrite(ctx, metrics.OpDelete, func(ctx context.Context) error {
+		return c.DeleteUserCtx(ctx, id)
+	})
+}
@@ -0,0 +1,457 @@
+package service
+
+import (
+	"cache-demo/model"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+var (
+	// ErrInvalidUserID 用户ID不合法（非正数、超出当前最大ID、不符合ID格式），这样的用户一定不存在
+	ErrInvalidUserID = errors.New("用户ID不合法")
+	// ErrInvalidUsername 用户名不合法（为空或超过字段长度）
+	ErrInvalidUsername = errors.New("用户名不合法")
+	// ErrClientBlocked 客户端在黑名单中
+	ErrClientBlocked = errors.New("客户端已被禁止访问")
+)
+
+const (
+	// IDFormatAutoIncrement 数据库自增ID
+	IDFormatAutoIncrement = "auto_increment"
+	// IDFormatSnowflake 雪花算法ID：最高位为0，接下来41位是相对 SnowflakeEpoch 的毫秒时间戳
+	IDFormatSnowflake = "snowflake"
+
+	// DefaultMaxIDSlack 允许超过已知最大ID的范围（其他实例刚创建、本实例还没刷新到的用户）
+	DefaultMaxIDSlack = 1000
+	// DefaultMaxIDRefresh 最大ID的最短刷新间隔，超出范围的ID最多每隔这么久查询一次数据库
+	DefaultMaxIDRefresh = 10 * time.Second
+	// DefaultSnowflakeEpoch 雪花算法的起始时间（毫秒，Twitter 的默认值）
+	DefaultSnowflakeEpoch = 1288834974657
+	// GuardBlacklistKey 动态黑名单（Redis Set），可以用 SADD 随时拉黑客户端，不需要重启
+	GuardBlacklistKey = "user_guard:blacklist"
+
+	// snowflakeTimestampShift 雪花ID中时间戳之后的位数（10位机器ID + 12位序列号）
+	snowflakeTimestampShift = 22
+	// snowflakeClockSkew 允许雪花ID的时间戳比本机时间超前的范围（机器之间的时钟误差）
+	snowflakeClockSkew = time.Minute
+	// maxUsernameLength 用户名的最大长度，与 users.username 字段一致
+	maxUsernameLength = 50
+)
+
+// GuardConfig 请求校验配置（对应 config.yaml 的 guard 部分）
+type GuardConfig struct {
+	IDFormat       string        // ID格式：auto_increment（默认）或 snowflake
+	CheckMaxID     bool          // 是否拒绝超过当前最大ID的请求
+	MaxIDSlack     int64         // 允许超过已知最大ID的范围，0 使用 DefaultMaxIDSlack
+	MaxIDRefresh   time.Duration // 最大ID的最短刷新间隔，0 使用 DefaultMaxIDRefresh
+	SnowflakeEpoch int64         // 雪花算法的起始时间（毫秒），0 使用 DefaultSnowflakeEpoch
+	Blacklist      []string      // 静态黑名单：IP、CIDR（例如 10.0.0.0/8）或任意客户端标识
+	BlacklistKey   string        // 动态黑名单的 Redis Set，为空时不检查
+}
+
+// GuardStats 请求校验的拒绝次数统计
+type GuardStats struct {
+	Invalid    int64 // ID或用户名格式不合法
+	OutOfRange int64 // ID超过当前最大ID
+	Blocked    int64 // 客户端在黑名单中
+}
+
+// String 格式化输出
+func (s GuardStats) String() string {
+	return fmt.Sprintf("格式不合法=%d, 超出ID范围=%d, 黑名单拦截=%d", s.Invalid, s.OutOfRange, s.Blocked)
+}
+
+// RequestGuard 请求校验器：在查询缓存和数据库之前拒绝明显不合法的请求
+// 空值缓存和布隆过滤器都要先访问Redis，而ID格式、ID范围、黑名单只需要本地判断（或一次 SISMEMBER），
+// 随机ID攻击中绝大部分请求在这里就被拒绝，不会产生空值缓存，也不会消耗回源限流的令牌
+//
+// 最大ID保存在内存中：超出范围的ID会触发刷新（最多每 MaxIDRefresh 一次），本实例创建用户时直接更新；
+// 查询最大ID失败时放行请求，由后面的空值缓存兜底；还没有成功查询过最大ID时也放行
+type RequestGuard struct {
+	conf        GuardConfig
+	repo        model.UserRepo
+	rds         *redis.Redis
+	prefixes    []netip.Prefix      // 黑名单中的IP和网段
+	clients     map[string]struct{} // 黑名单中的其他客户端标识
+	maxID       atomic.Int64
+	refreshedAt atomic.Int64 // 上次刷新最大ID的时间（UnixNano），0 表示还没有刷新过
+	maxIDLoaded atomic.Bool  // 是否成功查询过最大ID（之前 maxID 只有本实例创建的用户ID，不能用来拒绝请求）
+	invalid     atomic.Int64
+	outOfRange  atomic.Int64
+	blocked     atomic.Int64
+}
+
+// NewRequestGuard 创建请求校验器，rds 为 nil 时不检查动态黑名单
+func NewRequestGuard(repo model.UserRepo, rds *redis.Redis, conf GuardConfig) (*RequestGuard, error) {
+	switch conf.IDFormat {
+	case "":
+		conf.IDFormat = IDFormatAutoIncrement
+	case IDFormatAutoIncrement, IDFormatSnowflake:
+	default:
+		return nil, fmt.Errorf("不支持的ID格式: %s", conf.IDFormat)
+	}
+	if conf.MaxIDSlack <= 0 {
+		conf.MaxIDSlack = DefaultMaxIDSlack
+	}
+	if conf.MaxIDRefresh <= 0 {
+		conf.MaxIDRefresh = DefaultMaxIDRefresh
+	}
+	if conf.SnowflakeEpoch <= 0 {
+		conf.SnowflakeEpoch = DefaultSnowflakeEpoch
+	}
+
+	g := &RequestGuard{
+		conf:    conf,
+		repo:    repo,
+		rds:     rds,
+		clients: make(map[string]struct{}),
+	}
+	for _, entry := range conf.Blacklist {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			g.prefixes = append(g.prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			g.prefixes = append(g.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		} else {
+			g.clients[entry] = struct{}{}
+		}
+	}
+	return g, nil
+}
+
+// CheckID 检查用户ID：必须为正数、符合ID格式、不超过当前最大ID
+func (g *RequestGuard) CheckID(id int64) error {
+	return g.CheckIDCtx(context.Background(), id)
+}
+
+// CheckIDCtx 检查用户ID：必须为正数、符合ID格式、不超过当前最大ID
+func (g *RequestGuard) CheckIDCtx(ctx context.Context, id int64) error {
+	if id <= 0 {
+		return g.reject(&g.invalid, ErrInvalidUserID, "user_id=%d, 不是正数", id)
+	}
+	if g.conf.IDFormat == IDFormatSnowflake {
+		issuedAt := time.UnixMilli(id>>snowflakeTimestampShift + g.conf.SnowflakeEpoch)
+		if issuedAt.After(time.Now().Add(snowflakeClockSkew)) {
+			return g.reject(&g.invalid, ErrInvalidUserID, "user_id=%d, 雪花ID的时间戳 %s 晚于当前时间",
+				id, issuedAt.Format(time.DateTime))
+		}
+	}
+	if g.conf.CheckMaxID && !g.withinMaxID(ctx, id) {
+		return g.reject(&g.outOfRange, ErrInvalidUserID, "user_id=%d, 超过当前最大ID %d", id, g.maxID.Load())
+	}
+	return nil
+}
+
+// CheckUsername 检查用户名长度
+func (g *RequestGuard) CheckUsername(username string) error {
+	if username == "" || len(username) > maxUsernameLength {
+		return g.reject(&g.invalid, ErrInvalidUsername, "username=%q, 长度应为1~%d", username, maxUsernameLength)
+	}
+	return nil
+}
+
+// CheckClient 检查客户端是否在黑名单中，clientID 为空时不检查
+// clientID 可以是IP、IP:端口或其他标识；动态黑名单只按完整的 clientID 匹配
+func (g *RequestGuard) CheckClient(clientID string) error {
+	return g.CheckClientCtx(context.Background(), clientID)
+}
+
+// CheckClientCtx 检查客户端是否在黑名单中，clientID 为空时不检查
+func (g *RequestGuard) CheckClientCtx(ctx context.Context, clientID string) error {
+	if clientID == "" {
+		return nil
+	}
+	if g.inStaticBlacklist(clientID) {
+		return g.reject(&g.blocked, ErrClientBlocked, "client=%s, 命中静态黑名单", clientID)
+	}
+	if g.rds == nil || g.conf.BlacklistKey == "" {
+		return nil
+	}
+	blocked, err := g.rds.SismemberCtx(ctx, g.conf.BlacklistKey, clientID)
+	if err != nil {
+		// 黑名单查询失败时放行，不因为Redis故障拒绝正常用户
+		log.Printf("[黑名单查询失败] client=%s, error=%v", clientID, err)
+		return nil
+	}
+	if blocked {
+		return g.reject(&g.blocked, ErrClientBlocked, "client=%s, 命中动态黑名单", clientID)
+	}
+	return nil
+}
+
+// Block 把客户端加入动态黑名单
+func (g *RequestGuard) Block(clientID string) error {
+	if g.rds == nil || g.conf.BlacklistKey == "" {
+		return errors.New("未配置动态黑名单")
+	}
+	if _, err := g.rds.Sadd(g.conf.BlacklistKey, clientID); err != nil {
+		return fmt.Errorf("加入黑名单失败: %w", err)
+	}
+	log.Printf("[加入黑名单] client=%s", clientID)
+	return nil
+}
+
+// Unblock 把客户端移出动态黑名单
+func (g *RequestGuard) Unblock(clientID string) error {
+	if g.rds == nil || g.conf.BlacklistKey == "" {
+		return errors.New("未配置动态黑名单")
+	}
+	if _, err := g.rds.Srem(g.conf.BlacklistKey, clientID); err != nil {
+		return fmt.Errorf("移出黑名单失败: %w", err)
+	}
+	log.Printf("[移出黑名单] client=%s", clientID)
+	return nil
+}
+
+// ObserveID 记录新创建的用户ID，本实例创建的用户不需要等刷新就能通过范围检查
+func (g *RequestGuard) ObserveID(id int64) {
+	for {
+		current := g.maxID.Load()
+		if id <= current || g.maxID.CompareAndSwap(current, id) {
+			return
+		}
+	}
+}
+
+// Stats 返回拒绝次数统计
+func (g *RequestGuard) Stats() GuardStats {
+	return GuardStats{
+		Invalid:    g.invalid.Load(),
+		OutOfRange: g.outOfRange.Load(),
+		Blocked:    g.blocked.Load(),
+	}
+}
+
+// withinMaxID 检查ID是否不超过 最大ID + MaxIDSlack，超出时按刷新间隔重新查询最大ID
+func (g *RequestGuard) withinMaxID(ctx context.Context, id int64) bool {
+	if id <= g.maxID.Load()+g.conf.MaxIDSlack {
+		return true
+	}
+
+	// 只有一个请求负责刷新，其他请求使用当前的最大ID；刷新间隔内超出范围的请求直接拒绝
+	// 第一次刷新完成之前（包括正在刷新时）最大ID还不可信，放行
+	last := g.refreshedAt.Load()
+	now := time.Now().UnixNano()
+	if last != 0 && now-last < int64(g.conf.MaxIDRefresh) {
+		return !g.maxIDLoaded.Load()
+	}
+	if !g.refreshedAt.CompareAndSwap(last, now) {
+		return !g.maxIDLoaded.Load()
+	}
+
+	maxID, err := g.repo.MaxIDCtx(ctx)
+	if err != nil {
+		// 恢复刷新时间，下一个超出范围的请求重新查询，而不是在整个刷新间隔内拒绝请求
+		g.refreshedAt.CompareAndSwap(now, last)
+		log.Printf("[查询最大ID失败] error=%v (放行请求)", err)
+		return true
+	}
+	g.ObserveID(maxID)
+	g.maxIDLoaded.Store(true)
+	log.Printf("[刷新最大ID] max_id=%d", g.maxID.Load())
+	return id <= g.maxID.Load()+g.conf.MaxIDSlack
+}
+
+// inStaticBlacklist 检查客户端是否命中静态黑名单（IP:端口 按IP匹配）
+func (g *RequestGuard) inStaticBlacklist(clientID string) bool {
+	if _, ok := g.clients[clientID]; ok {
+		return true
+	}
+	addr, err := netip.ParseAddr(clientID)
+	if err != nil {
+		addrPort, err := netip.ParseAddrPort(clientID)
+		if err != nil {
+			return false
+		}
+		addr = addrPort.Addr()
+	}
+	addr = addr.Unmap()
+	for _, prefix := range g.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// reject 记录一次拒绝并返回包装后的错误
+func (g *RequestGuard) reject(counter *atomic.Int64, err error, format string, args ...any) error {
+	counter.Add(1)
+	detail := fmt.Sprintf(format, args...)
+	log.Printf("[请求校验拒绝] %s", detail)
+	return fmt.Errorf("%w: %s", err, detail)
+}
+
+// guardedUserService 请求校验中间件：先校验客户端和参数，通过后才交给被包装的服务（查询缓存和数据库）
+type guardedUserService struct {
+	base   UserService // 被包装的原始服务，ForClient 时从它派生
+	next   UserService
+	guard  *RequestGuard
+	client string
+}
+
+// NewGuardedUserService 用请求校验包装任意用户服务
+// 被包装的服务实现了 ClientUserService 时，ForClient 会把客户端一起传下去（例如按客户端回源限流）
+func NewGuardedUserService(next UserService, guard *RequestGuard) ClientUserService {
+	return &guardedUserService{
+		base:  next,
+		next:  next,
+		guard: guard,
+	}
+}
+
+// ForClient 返回绑定到指定客户端的服务，按客户端检查黑名单
+func (s *guardedUserService) ForClient(clientID string) UserService {
+	next := s.base
+	if clientService, ok := s.base.(ClientUserService); ok {
+		next = clientService.ForClient(clientID)
+	}
+	return &guardedUserService{
+		base:   s.base,
+		next:   next,
+		guard:  s.guard,
+		client: clientID,
+	}
+}
+
+// GetUserByID 根据ID获取用户，不合法的ID直接返回 ErrInvalidUserID，不访问Redis和MySQL
+func (s *guardedUserService) GetUserByID(id int64) (*model.User, error) {
+	return s.GetUserByIDCtx(context.Background(), id)
+}
+
+// GetUserByIDCtx 根据ID获取用户，不合法的ID直接返回 ErrInvalidUserID，不访问Redis和MySQL
+func (s *guardedUserService) GetUserByIDCtx(ctx context.Context, id int64) (*model.User, error) {
+	if err := s.guard.CheckClientCtx(ctx, s.client); err != nil {
+		return nil, err
+	}
+	if err := s.guard.CheckIDCtx(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.next.GetUserByIDCtx(ctx, id)
+}
+
+// GetUsersByIDs 批量获取用户，不合法的ID直接算作不存在，只把合法的ID交给被包装的服务
+func (s *guardedUserService) GetUsersByIDs(ids []int64) ([]*model.User, []int64, error) {
+	return s.GetUsersByIDsCtx(context.Background(), ids)
+}
+
+// GetUsersByIDsCtx 批量获取用户，不合法的ID直接算作不存在，只把合法的ID交给被包装的服务
+func (s *guardedUserService) GetUsersByIDsCtx(ctx context.Context, ids []int64) ([]*model.User, []int64, error) {
+	if err := s.guard.CheckClientCtx(ctx, s.client); err != nil {
+		return nil, nil, err
+	}
+
+	valid := make([]int64, 0, len(ids))
+	rejected := make(map[int64]bool)
+	for _, id := range ids {
+		if err := s.guard.CheckIDCtx(ctx, id); err != nil {
+			rejected[id] = true
+			continue
+		}
+		valid = append(valid, id)
+	}
+	if len(rejected) == 0 {
+		return s.next.GetUsersByIDsCtx(ctx, ids)
+	}
+
+	var users []*model.User
+	var nextMissing []int64
+	if len(valid) > 0 {
+		var err error
+		if users, nextMissing, err = s.next.GetUsersByIDsCtx(ctx, valid); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// missing 保持输入顺序
+	for _, id := range nextMissing {
+		rejected[id] = true
+	}
+	var missing []int64
+	for _, id := range ids {
+		if rejected[id] {
+			missing = append(missing, id)
+			delete(rejected, id)
+		}
+	}
+	return users, missing, nil
+}
+
+// GetUserByUsername 根据用户名获取用户
+func (s *guardedUserService) GetUserByUsername(username string) (*model.User, error) {
+	return s.GetUserByUsernameCtx(context.Background(), username)
+}
+
+// GetUserByUsernameCtx 根据用户名获取用户
+func (s *guardedUserService) GetUserByUsernameCtx(ctx context.Context, username string) (*model.User, error) {
+	if err := s.guard.CheckClientCtx(ctx, s.client); err != nil {
+		return nil, err
+	}
+	if err := s.guard.CheckUsername(username); err != nil {
+		return nil, err
+	}
+	return s.next.GetUserByUsernameCtx(ctx, username)
+}
+
+// CreateUser 创建用户，成功后更新已知的最大ID
+func (s *guardedUserService) CreateUser(user *model.User) error {
+	return s.CreateUserCtx(context.Background(), user)
+}
+
+// CreateUserCtx 创建用户，成功后更新已知的最大ID
+func (s *guardedUserService) CreateUserCtx(ctx context.Context, user *model.User) error {
+	if err := s.guard.CheckClientCtx(ctx, s.client); err != nil {
+		return err
+	}
+	if err := s.guard.CheckUsername(user.Username); err != nil {
+		return err
+	}
+	if err := s.next.CreateUserCtx(ctx, user); err != nil {
+		return err
+	}
+	s.guard.ObserveID(user.ID)
+	return nil
+}
+
+// UpdateUser 更新用户
+func (s *guardedUserService) UpdateUser(user *model.User) error {
+	return s.UpdateUserCtx(context.Background(), user)
+}
+
+// UpdateUserCtx 更新用户
+func (s *guardedUserService) UpdateUserCtx(ctx context.Context, user *model.User) error {
+	if err := s.guard.CheckClientCtx(ctx, s.client); err != nil {
+		return err
+	}
+	if err := s.guard.CheckIDCtx(ctx, user.ID); err != nil {
+		return err
+	}
+	return s.next.UpdateUserCtx(ctx, user)
+}
+
+// DeleteUser 删除用户
+func (s *guardedUserService) DeleteUser(id int64) error {
+	return s.DeleteUserCtx(context.Background(), id)
+}
+
+// DeleteUserCtx 删除用户
+func (s *guardedUserService) DeleteUserCtx(ctx context.Context, id int64) error {
+	if err := s.guard.CheckClientCtx(ctx, s.client); err != nil {
+		return err
+	}
+	if err := s.guard.CheckIDCtx(ctx, id); err != nil {
+		return err
+	}
+	return s.next.DeleteUserCtx(ctx, id)
+}
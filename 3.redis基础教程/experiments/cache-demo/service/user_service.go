@@ -2,7 +2,9 @@ package service
 
 import (
 	"cache-demo/cache"
+	"cache-demo/metrics"
 	"cache-demo/model"
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -10,124 +12,249 @@ import (
 	"gorm.io/gorm"
 )
 
+// ErrUserNotFound 用户不存在
+var ErrUserNotFound = errors.New("用户不存在")
+
 // UserService 用户服务接口
+// 带 Ctx 后缀的方法把 ctx 一直传到Redis和数据库：调用方超时或取消后，正在执行的查询会被中断
+// 不带 ctx 的方法使用 context.Background()
 type UserService interface {
 	GetUserByID(id int64) (*model.User, error)
+	GetUserByIDCtx(ctx context.Context, id int64) (*model.User, error)
+	// GetUsersByIDs 批量获取用户，users 保持输入顺序（不含不存在的用户），missing 为不存在的用户ID
+	GetUsersByIDs(ids []int64) (users []*model.User, missing []int64, err error)
+	GetUsersByIDsCtx(ctx context.Context, ids []int64) (users []*model.User, missing []int64, err error)
+	// GetUserByUsername 根据用户名获取用户（用户名二级索引 user:username:<name> -> id）
+	GetUserByUsername(username string) (*model.User, error)
+	GetUserByUsernameCtx(ctx context.Context, username string) (*model.User, error)
 	CreateUser(user *model.User) error
+	CreateUserCtx(ctx context.Context, user *model.User) error
 	UpdateUser(user *model.User) error
+	UpdateUserCtx(ctx context.Context, user *model.User) error
 	DeleteUser(id int64) error
+	DeleteUserCtx(ctx context.Context, id int64) error
 }
 
 // userService 用户服务实现
 type userService struct {
-	repo  model.UserRepo
-	cache cache.UserCache
+	repo   model.UserRepo
+	cache  cache.UserCache
+	reader *cache.UserReadThrough
+	names  usernameLookup
 }
 
 // NewUserService 创建用户服务实例
 func NewUserService(repo model.UserRepo, cache cache.UserCache) UserService {
 	return &userService{
-		repo:  repo,
-		cache: cache,
+		repo:   repo,
+		cache:  cache,
+		reader: newUserReadThrough(repo, cache),
+		names:  newUsernameLookup(repo, cache),
 	}
 }
 
-// GetUserByID 根据ID获取用户（Cache-Aside 模式）
-// Cache-Aside 模式流程：
-// 1. 先查缓存
-// 2. 缓存命中 -> 直接返回
-// 3. 缓存未命中 -> 查数据库 -> 写入缓存 -> 返回
-func (s *userService) GetUserByID(id int64) (*model.User, error) {
-	// 1. 先查缓存
-	user, err := s.cache.GetUser(id)
-	if err == nil && user != nil {
-		log.Printf("[缓存命中] user_id=%d, username=%s", id, user.Username)
-		return user, nil
-	}
+// newUserReadThrough 创建以 repo.FindByIDCtx / repo.FindByIDsCtx 为加载函数的读穿透缓存
+func newUserReadThrough(repo model.UserRepo, c cache.UserCache, opts ...cache.ReadThroughOption[int64, *model.User]) *cache.UserReadThrough {
+	opts = append([]cache.ReadThroughOption[int64, *model.User]{
+		cache.WithName[int64, *model.User](cache.UserCacheName),
+		cache.WithNotFound[int64, *model.User](func(err error) bool {
+			return errors.Is(err, gorm.ErrRecordNotFound)
+		}),
+		// 数据库熔断时降级返回旧数据副本（缓存配置了 WithUserStaleCopy 才有副本）
+		cache.WithStaleFallback[int64, *model.User](isBreakerOpen),
+		cache.WithBatchLoader[int64, *model.User](func(ctx context.Context, ids []int64) (map[int64]*model.User, error) {
+			users, err := repo.FindByIDsCtx(ctx, ids)
+			if err != nil {
+				return nil, err
+			}
+			result := make(map[int64]*model.User, len(users))
+			for _, user := range users {
+				result[user.ID] = user
+			}
+			return result, nil
+		}),
+	}, opts...)
+	return cache.NewUserReadThrough(c, repo.FindByIDCtx, opts...)
+}
+
+// userCacheMetrics 用户缓存的指标（自己编排读流程的服务使用，读穿透缓存会自动记录）
+func userCacheMetrics() *metrics.CacheMetrics {
+	return metrics.For(cache.UserCacheName)
+}
 
-	// 2. 缓存未命中，查数据库
-	log.Printf("[缓存未命中] user_id=%d, 查询数据库", id)
-	user, err = s.repo.FindByID(id)
+// withNegativeCache 用户不存在时写入空值缓存（构造函数的参数名 cache 会遮蔽包名，所以单独定义）
+func withNegativeCache() cache.ReadThroughOption[int64, *model.User] {
+	return cache.WithNegativeCache[int64, *model.User]()
+}
+
+// withLoadExpire 指定加载后回填缓存的基础过期时间（秒）
+func withLoadExpire(expireSeconds int) cache.ReadThroughOption[int64, *model.User] {
+	return cache.WithLoadExpire[int64, *model.User](expireSeconds)
+}
+
+// readUser 通过读穿透缓存查询用户，并转换为服务层的错误信息
+func readUser(ctx context.Context, reader *cache.UserReadThrough, id int64) (*model.User, error) {
+	user, err := reader.GetCtx(ctx, id)
 	if err != nil {
-		// 检查是否是记录不存在的错误
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			log.Printf("[用户不存在] user_id=%d", id)
-			return nil, fmt.Errorf("用户不存在: user_id=%d", id)
+		if errors.Is(err, cache.ErrNotFound) {
+			return nil, fmt.Errorf("%w: user_id=%d", ErrUserNotFound, id)
+		}
+		if errors.Is(err, ErrTooManyProbes) {
+			return nil, err
+		}
+		if isBreakerOpen(err) || ctx.Err() != nil {
+			// 数据库熔断，又没有旧数据可以降级返回（熔断器已经输出过日志）；或者调用方已经超时、取消
+			return nil, fmt.Errorf("查询用户失败: %w", err)
 		}
-		// 其他数据库错误（如连接失败等）
 		log.Printf("[数据库查询失败] user_id=%d, error=%v", id, err)
 		return nil, fmt.Errorf("查询用户失败: %w", err)
 	}
+	return user, nil
+}
 
-	// 如果用户不存在，返回nil（注意：此实现不缓存空值，存在缓存穿透风险）
-	// 如需防止缓存穿透，请使用 user_service_penetration.go 中的实现
-	if user == nil {
-		log.Printf("[用户不存在] user_id=%d", id)
-		return nil, fmt.Errorf("用户不存在: user_id=%d", id)
+// readUsers 通过读穿透缓存批量查询用户（一次MGET + 一次 WHERE id IN + pipeline 回填）
+func readUsers(ctx context.Context, reader *cache.UserReadThrough, ids []int64) ([]*model.User, []int64, error) {
+	vals, missing, err := reader.GetManyCtx(ctx, ids)
+	if err != nil {
+		log.Printf("[批量查询数据库失败] ids=%v, error=%v", ids, err)
+		return nil, nil, fmt.Errorf("批量查询用户失败: %w", err)
 	}
 
-	// 3. 写入缓存（设置过期时间 5 分钟）
-	if err := s.cache.SetUser(user, cache.DefaultExpireSeconds); err != nil {
-		log.Printf("[缓存写入失败] user_id=%d, error=%v (不影响返回结果)", id, err)
-		// 缓存写入失败不影响业务逻辑，只记录日志
-	} else {
-		log.Printf("[缓存写入成功] user_id=%d, username=%s, expire=%d秒", id, user.Username, cache.DefaultExpireSeconds)
+	users := make([]*model.User, 0, len(vals))
+	for _, user := range vals {
+		if user != nil {
+			users = append(users, user)
+		}
 	}
+	return users, missing, nil
+}
 
-	return user, nil
+// getUsersOneByOne 逐个查询用户（用于无法批量读取缓存的实现）
+func getUsersOneByOne(ctx context.Context, get func(ctx context.Context, id int64) (*model.User, error), ids []int64) ([]*model.User, []int64, error) {
+	users := make([]*model.User, 0, len(ids))
+	var missing []int64
+	for _, id := range ids {
+		user, err := get(ctx, id)
+		if err != nil {
+			if errors.Is(err, ErrUserNotFound) {
+				missing = append(missing, id)
+				continue
+			}
+			return nil, nil, err
+		}
+		users = append(users, user)
+	}
+	return users, missing, nil
+}
+
+// GetUserByID 根据ID获取用户（Cache-Aside 模式）
+// Cache-Aside 模式流程（由读穿透缓存完成）：
+// 1. 先查缓存
+// 2. 缓存命中 -> 直接返回
+// 3. 缓存未命中 -> 查数据库 -> 写入缓存 -> 返回
+// 注意：此实现不缓存空值，存在缓存穿透风险，如需防止缓存穿透，请使用 user_service_penetration.go 中的实现
+func (s *userService) GetUserByID(id int64) (*model.User, error) {
+	return s.GetUserByIDCtx(context.Background(), id)
+}
+
+// GetUserByIDCtx 根据ID获取用户（Cache-Aside 模式）
+func (s *userService) GetUserByIDCtx(ctx context.Context, id int64) (*model.User, error) {
+	return readUser(ctx, s.reader, id)
+}
+
+// GetUsersByIDs 批量获取用户
+func (s *userService) GetUsersByIDs(ids []int64) ([]*model.User, []int64, error) {
+	return s.GetUsersByIDsCtx(context.Background(), ids)
+}
+
+// GetUsersByIDsCtx 批量获取用户
+func (s *userService) GetUsersByIDsCtx(ctx context.Context, ids []int64) ([]*model.User, []int64, error) {
+	return readUsers(ctx, s.reader, ids)
+}
+
+// GetUserByUsername 根据用户名获取用户（用户名二级索引 -> 按ID查询）
+func (s *userService) GetUserByUsername(username string) (*model.User, error) {
+	return s.GetUserByUsernameCtx(context.Background(), username)
+}
+
+// GetUserByUsernameCtx 根据用户名获取用户
+func (s *userService) GetUserByUsernameCtx(ctx context.Context, username string) (*model.User, error) {
+	return s.names.get(ctx, username, s.GetUserByIDCtx)
 }
 
 // CreateUser 创建用户
 // 创建用户时不需要更新缓存（新用户，缓存中不存在）
 func (s *userService) CreateUser(user *model.User) error {
-	log.Printf("[创建用户] username=%s", user.Username)
+	return s.CreateUserCtx(context.Background(), user)
+}
 
-	if err := s.repo.Create(user); err != nil {
+// CreateUserCtx 创建用户
+func (s *userService) CreateUserCtx(ctx context.Context, user *model.User) error {
+	if err := s.repo.CreateCtx(ctx, user); err != nil {
 		return fmt.Errorf("创建用户失败: %w", err)
 	}
 
-	log.Printf("[创建用户成功] user_id=%d, username=%s", user.ID, user.Username)
+	s.names.bind(ctx, user.Username, user.ID)
+
 	return nil
 }
 
 // UpdateUser 更新用户
 // 更新用户时，需要同时更新缓存
+// 乐观锁：user.Version 必须是读取时的版本号，期间被其他请求修改过时返回 model.ErrVersionConflict（重新读取后再更新）
 func (s *userService) UpdateUser(user *model.User) error {
-	log.Printf("[更新用户] user_id=%d", user.ID)
+	return s.UpdateUserCtx(context.Background(), user)
+}
 
-	// 1. 更新数据库
-	if err := s.repo.Update(user); err != nil {
+// UpdateUserCtx 更新用户
+func (s *userService) UpdateUserCtx(ctx context.Context, user *model.User) error {
+	oldUsername := s.names.usernameOf(ctx, user.ID, s.repo.FindByIDCtx)
+
+	// 1. 更新数据库（WHERE version = ?），只有一个并发更新能成功
+	if err := s.repo.UpdateCtx(ctx, user); err != nil {
+		if errors.Is(err, model.ErrVersionConflict) {
+			// 没有更新数据库，也不写缓存：缓存由成功的那个请求更新
+			log.Printf("[版本冲突] user_id=%d, version=%d (用户已被其他请求修改)", user.ID, user.Version)
+		}
 		return fmt.Errorf("更新用户失败: %w", err)
 	}
 
-	// 2. 更新缓存（使用相同的过期时间）
-	if err := s.cache.SetUser(user, cache.DefaultExpireSeconds); err != nil {
+	// 2. 更新缓存（使用相同的过期时间），user.Version 已经是新版本号
+	// 缓存中已经有更新的版本时（更晚的更新先写入了缓存）不覆盖
+	if err := setUserCache(ctx, s.cache, user, cache.DefaultExpireSeconds); errors.Is(err, cache.ErrStaleVersion) {
+		log.Printf("[跳过旧版本缓存] user_id=%d, version=%d (缓存中已有更新的版本)", user.ID, user.Version)
+	} else if err != nil {
 		log.Printf("[缓存更新失败] user_id=%d, error=%v (不影响业务逻辑)", user.ID, err)
 		// 缓存更新失败不影响业务逻辑
-	} else {
-		log.Printf("[缓存更新成功] user_id=%d", user.ID)
 	}
 
+	s.names.renamed(ctx, oldUsername, user)
+
 	return nil
 }
 
 // DeleteUser 删除用户
 // 删除用户时，需要同时删除缓存
 func (s *userService) DeleteUser(id int64) error {
-	log.Printf("[删除用户] user_id=%d", id)
+	return s.DeleteUserCtx(context.Background(), id)
+}
+
+// DeleteUserCtx 删除用户
+func (s *userService) DeleteUserCtx(ctx context.Context, id int64) error {
+	oldUsername := s.names.usernameOf(ctx, id, s.repo.FindByIDCtx)
 
 	// 1. 删除数据库记录
-	if err := s.repo.Delete(id); err != nil {
+	if err := s.repo.DeleteCtx(ctx, id); err != nil {
 		return fmt.Errorf("删除用户失败: %w", err)
 	}
 
 	// 2. 删除缓存
-	if err := s.cache.DeleteUser(id); err != nil {
+	if err := deleteUserCache(ctx, s.cache, id); err != nil {
 		log.Printf("[缓存删除失败] user_id=%d, error=%v (不影响业务逻辑)", id, err)
 		// 缓存删除失败不影响业务逻辑
-	} else {
-		log.Printf("[缓存删除成功] user_id=%d", id)
 	}
 
+	s.names.unbind(ctx, oldUsername)
+
 	return nil
 }
@@ -2,12 +2,12 @@ package service
 
 import (
 	"cache-demo/cache"
+	"cache-demo/metrics"
 	"cache-demo/model"
+	"context"
 	"errors"
 	"fmt"
 	"log"
-
-	"gorm.io/gorm"
 )
 
 // ExpireMode 过期时间模式
@@ -26,6 +26,8 @@ type userServiceWithAvalanche struct {
 	cache      cache.UserCacheWithAvalanche
 	expireMode ExpireMode
 	baseExpire int
+	reader     *cache.UserReadThrough
+	names      usernameLookup
 }
 
 // NewUserServiceWithAvalanche 创建支持缓存雪崩优化的用户服务实例
@@ -35,118 +37,137 @@ func NewUserServiceWithAvalanche(repo model.UserRepo, cache cache.UserCacheWithA
 		cache:      cache,
 		expireMode: mode,
 		baseExpire: baseExpire,
+		reader:     newUserReadThrough(repo, avalancheUserCache{cache, mode}, withLoadExpire(baseExpire)),
+		names:      newUsernameLookup(repo, cache),
+	}
+}
+
+// avalancheUserCache 按过期时间模式把 UserCacheWithAvalanche 适配为 UserCache
+type avalancheUserCache struct {
+	cache.UserCacheWithAvalanche
+	mode ExpireMode
+}
+
+// SetUser 根据模式选择固定或随机过期时间写入缓存
+func (c avalancheUserCache) SetUser(user *model.User, expireSeconds int) error {
+	return c.SetUserCtx(context.Background(), user, expireSeconds)
+}
+
+// SetUserCtx 根据模式选择固定或随机过期时间写入缓存
+func (c avalancheUserCache) SetUserCtx(ctx context.Context, user *model.User, expireSeconds int) error {
+	if c.mode == RandomExpire {
+		return c.SetUserWithRandomExpireCtx(ctx, user, expireSeconds)
 	}
+	return c.SetUserWithFixedExpireCtx(ctx, user, expireSeconds)
 }
 
 // GetUserByID 根据ID获取用户（支持固定/随机过期时间）
+// 缓存未命中时回填缓存的过期时间由 expireMode 决定：固定（模拟缓存雪崩）或随机（解决缓存雪崩）
 func (s *userServiceWithAvalanche) GetUserByID(id int64) (*model.User, error) {
-	// 1. 先查缓存
-	user, err := s.cache.GetUser(id)
-	if err == nil && user != nil {
-		log.Printf("[缓存命中] user_id=%d, username=%s", id, user.Username)
-		return user, nil
-	}
+	return s.GetUserByIDCtx(context.Background(), id)
+}
 
-	// 2. 缓存未命中，查数据库
-	log.Printf("[缓存未命中] user_id=%d, 查询数据库", id)
-	user, err = s.repo.FindByID(id)
-	if err != nil {
-		// 检查是否是记录不存在的错误
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			log.Printf("[用户不存在] user_id=%d", id)
-			return nil, fmt.Errorf("用户不存在: user_id=%d", id)
-		}
-		// 其他数据库错误
-		log.Printf("[数据库查询失败] user_id=%d, error=%v", id, err)
-		return nil, fmt.Errorf("查询用户失败: %w", err)
-	}
+// GetUserByIDCtx 根据ID获取用户（支持固定/随机过期时间）
+func (s *userServiceWithAvalanche) GetUserByIDCtx(ctx context.Context, id int64) (*model.User, error) {
+	return readUser(ctx, s.reader, id)
+}
 
-	// 3. 写入缓存（根据模式选择固定或随机过期时间）
-	if user == nil {
-		log.Printf("[用户不存在] user_id=%d", id)
-		return nil, fmt.Errorf("用户不存在: user_id=%d", id)
-	}
+// GetUsersByIDs 批量获取用户
+func (s *userServiceWithAvalanche) GetUsersByIDs(ids []int64) ([]*model.User, []int64, error) {
+	return s.GetUsersByIDsCtx(context.Background(), ids)
+}
 
-	switch s.expireMode {
-	case FixedExpire:
-		// 固定过期时间（模拟缓存雪崩）
-		if err := s.cache.SetUserWithFixedExpire(user, s.baseExpire); err != nil {
-			log.Printf("[缓存写入失败] user_id=%d, error=%v (不影响返回结果)", id, err)
-		} else {
-			log.Printf("[缓存写入成功] user_id=%d, username=%s, expire=%d秒 (固定)", id, user.Username, s.baseExpire)
-		}
+// GetUsersByIDsCtx 批量获取用户
+func (s *userServiceWithAvalanche) GetUsersByIDsCtx(ctx context.Context, ids []int64) ([]*model.User, []int64, error) {
+	return readUsers(ctx, s.reader, ids)
+}
 
-	case RandomExpire:
-		// 随机过期时间（解决缓存雪崩）
-		// 注意：实际过期时间在SetUserWithRandomExpire内部计算，这里只是估算用于日志
-		if err := s.cache.SetUserWithRandomExpire(user, s.baseExpire); err != nil {
-			log.Printf("[缓存写入失败] user_id=%d, error=%v (不影响返回结果)", id, err)
-		} else {
-			// 估算过期时间范围用于日志（实际值在缓存层计算）
-			maxExpire := s.baseExpire + s.baseExpire*cache.AvalancheRandomRangePercent/100
-			log.Printf("[缓存写入成功] user_id=%d, username=%s, expire=%d-%d秒 (随机)", id, user.Username, s.baseExpire, maxExpire)
-		}
-	}
+// GetUserByUsername 根据用户名获取用户（用户名二级索引 -> 按ID查询）
+func (s *userServiceWithAvalanche) GetUserByUsername(username string) (*model.User, error) {
+	return s.GetUserByUsernameCtx(context.Background(), username)
+}
 
-	return user, nil
+// GetUserByUsernameCtx 根据用户名获取用户（用户名二级索引 -> 按ID查询）
+func (s *userServiceWithAvalanche) GetUserByUsernameCtx(ctx context.Context, username string) (*model.User, error) {
+	return s.names.get(ctx, username, s.GetUserByIDCtx)
 }
 
 // CreateUser 创建用户
 func (s *userServiceWithAvalanche) CreateUser(user *model.User) error {
-	log.Printf("[创建用户] username=%s", user.Username)
+	return s.CreateUserCtx(context.Background(), user)
+}
 
-	if err := s.repo.Create(user); err != nil {
+// CreateUserCtx 创建用户
+func (s *userServiceWithAvalanche) CreateUserCtx(ctx context.Context, user *model.User) error {
+	if err := s.repo.CreateCtx(ctx, user); err != nil {
 		return fmt.Errorf("创建用户失败: %w", err)
 	}
 
-	log.Printf("[创建用户成功] user_id=%d, username=%s", user.ID, user.Username)
+	s.names.bind(ctx, user.Username, user.ID)
+
 	return nil
 }
 
 // UpdateUser 更新用户
 func (s *userServiceWithAvalanche) UpdateUser(user *model.User) error {
-	log.Printf("[更新用户] user_id=%d", user.ID)
+	return s.UpdateUserCtx(context.Background(), user)
+}
+
+// UpdateUserCtx 更新用户
+func (s *userServiceWithAvalanche) UpdateUserCtx(ctx context.Context, user *model.User) error {
+	oldUsername := s.names.usernameOf(ctx, user.ID, s.repo.FindByIDCtx)
 
 	// 1. 更新数据库
-	if err := s.repo.Update(user); err != nil {
+	if err := s.repo.UpdateCtx(ctx, user); err != nil {
 		return fmt.Errorf("更新用户失败: %w", err)
 	}
 
 	// 2. 更新缓存（根据模式选择固定或随机过期时间）
 	switch s.expireMode {
 	case FixedExpire:
-		if err := s.cache.SetUserWithFixedExpire(user, s.baseExpire); err != nil {
+		if err := retryCacheWrite(ctx, metrics.OpSet, func(ctx context.Context) error {
+			return s.cache.SetUserWithFixedExpireCtx(ctx, user, s.baseExpire)
+		}); errors.Is(err, cache.ErrStaleVersion) {
+			log.Printf("[跳过旧版本缓存] user_id=%d, version=%d (缓存中已有更新的版本)", user.ID, user.Version)
+		} else if err != nil {
 			log.Printf("[缓存更新失败] user_id=%d, error=%v (不影响业务逻辑)", user.ID, err)
-		} else {
-			log.Printf("[缓存更新成功] user_id=%d (固定过期时间)", user.ID)
 		}
 
 	case RandomExpire:
-		if err := s.cache.SetUserWithRandomExpire(user, s.baseExpire); err != nil {
+		if err := retryCacheWrite(ctx, metrics.OpSet, func(ctx context.Context) error {
+			return s.cache.SetUserWithRandomExpireCtx(ctx, user, s.baseExpire)
+		}); errors.Is(err, cache.ErrStaleVersion) {
+			log.Printf("[跳过旧版本缓存] user_id=%d, version=%d (缓存中已有更新的版本)", user.ID, user.Version)
+		} else if err != nil {
 			log.Printf("[缓存更新失败] user_id=%d, error=%v (不影响业务逻辑)", user.ID, err)
-		} else {
-			log.Printf("[缓存更新成功] user_id=%d (随机过期时间)", user.ID)
 		}
 	}
 
+	s.names.renamed(ctx, oldUsername, user)
+
 	return nil
 }
 
 // DeleteUser 删除用户
 func (s *userServiceWithAvalanche) DeleteUser(id int64) error {
-	log.Printf("[删除用户] user_id=%d", id)
+	return s.DeleteUserCtx(context.Background(), id)
+}
+
+// DeleteUserCtx 删除用户
+func (s *userServiceWithAvalanche) DeleteUserCtx(ctx context.Context, id int64) error {
+	oldUsername := s.names.usernameOf(ctx, id, s.repo.FindByIDCtx)
 
 	// 1. 删除数据库记录
-	if err := s.repo.Delete(id); err != nil {
+	if err := s.repo.DeleteCtx(ctx, id); err != nil {
 		return fmt.Errorf("删除用户失败: %w", err)
 	}
 
 	// 2. 删除缓存
-	if err := s.cache.DeleteUser(id); err != nil {
+	if err := deleteUserCache(ctx, s.cache, id); err != nil {
 		log.Printf("[缓存删除失败] user_id=%d, error=%v (不影响业务逻辑)", id, err)
-	} else {
-		log.Printf("[缓存删除成功] user_id=%d", id)
 	}
 
+	s.names.unbind(ctx, oldUsername)
+
 	return nil
 }
@@ -3,140 +3,141 @@ package service
 import (
 	"cache-demo/cache"
 	"cache-demo/model"
+	"context"
 	"errors"
 	"fmt"
 	"log"
-
-	"gorm.io/gorm"
 )
 
 // userServiceWithBloom 支持布隆过滤器的用户服务实现
 type userServiceWithBloom struct {
-	repo  model.UserRepo
-	cache cache.UserCacheWithBloom
+	repo   model.UserRepo
+	cache  cache.UserCacheWithBloom
+	reader *cache.UserReadThrough
+	names  usernameLookup
 }
 
 // NewUserServiceWithBloom 创建支持布隆过滤器的用户服务实例
+// 使用 cache.NewUserCacheWithCountingBloom 时，删除用户会同时从过滤器中删除
 func NewUserServiceWithBloom(repo model.UserRepo, cache cache.UserCacheWithBloom) UserService {
 	return &userServiceWithBloom{
-		repo:  repo,
-		cache: cache,
+		repo:   repo,
+		cache:  cache,
+		reader: newUserReadThrough(repo, cache),
+		names:  newUsernameLookup(repo, cache),
 	}
 }
 
 // GetUserByID 根据ID获取用户（使用布隆过滤器防止缓存穿透）
+// 布隆过滤器判断不存在时直接返回；从数据库加载到的用户会加入布隆过滤器
+// 注意：数据库中不存在的用户不加入布隆过滤器，否则会增加误判率
 func (s *userServiceWithBloom) GetUserByID(id int64) (*model.User, error) {
-	// 1. 先检查布隆过滤器（第一道防线）
-	exists, err := s.cache.ExistsInBloomFilter(id)
-	if err != nil {
-		log.Printf("[布隆过滤器查询失败] user_id=%d, error=%v (继续查询)", id, err)
-		// 布隆过滤器查询失败，继续查询（容错处理）
-	} else if !exists {
-		// 布隆过滤器判断不存在，一定不存在，直接返回
-		log.Printf("[布隆过滤器拦截] user_id=%d 不存在 (防止缓存穿透)", id)
-		return nil, fmt.Errorf("用户不存在: user_id=%d", id)
-	}
+	return s.GetUserByIDCtx(context.Background(), id)
+}
 
-	// 2. 布隆过滤器判断可能存在，继续查询缓存
-	user, err := s.cache.GetUser(id)
-	if err == nil && user != nil {
-		log.Printf("[缓存命中] user_id=%d, username=%s", id, user.Username)
-		return user, nil
-	}
+// GetUserByIDCtx 根据ID获取用户（使用布隆过滤器防止缓存穿透）
+func (s *userServiceWithBloom) GetUserByIDCtx(ctx context.Context, id int64) (*model.User, error) {
+	return readUser(ctx, s.reader, id)
+}
 
-	// 3. 缓存未命中，查数据库
-	log.Printf("[缓存未命中] user_id=%d, 查询数据库", id)
-	user, err = s.repo.FindByID(id)
-	if err != nil {
-		// 检查是否是记录不存在的错误
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			log.Printf("[用户不存在] user_id=%d (布隆过滤器误判)", id)
-			// 注意：这里不添加到布隆过滤器，因为数据不存在
-			// 如果添加会导致误判率增加
-			return nil, fmt.Errorf("用户不存在: user_id=%d", id)
-		}
-		// 其他数据库错误
-		log.Printf("[数据库查询失败] user_id=%d, error=%v", id, err)
-		return nil, fmt.Errorf("查询用户失败: %w", err)
-	}
+// GetUsersByIDs 批量获取用户
+func (s *userServiceWithBloom) GetUsersByIDs(ids []int64) ([]*model.User, []int64, error) {
+	return s.GetUsersByIDsCtx(context.Background(), ids)
+}
 
-	// 4. 用户存在，写入缓存和布隆过滤器
-	log.Printf("[用户存在] user_id=%d, username=%s, 写入缓存和布隆过滤器", id, user.Username)
-	if err := s.cache.SetUser(user, cache.DefaultExpireSeconds); err != nil {
-		log.Printf("[缓存写入失败] user_id=%d, error=%v (不影响返回结果)", id, err)
-	} else {
-		log.Printf("[缓存写入成功] user_id=%d, username=%s, expire=%d秒", id, user.Username, cache.DefaultExpireSeconds)
-	}
+// GetUsersByIDsCtx 批量获取用户
+func (s *userServiceWithBloom) GetUsersByIDsCtx(ctx context.Context, ids []int64) ([]*model.User, []int64, error) {
+	return readUsers(ctx, s.reader, ids)
+}
 
-	// 添加到布隆过滤器（只添加存在的用户）
-	if err := s.cache.AddToBloomFilter(id); err != nil {
-		log.Printf("[布隆过滤器添加失败] user_id=%d, error=%v (不影响返回结果)", id, err)
-	} else {
-		log.Printf("[布隆过滤器添加成功] user_id=%d", id)
-	}
+// GetUserByUsername 根据用户名获取用户（用户名二级索引 -> 按ID查询）
+func (s *userServiceWithBloom) GetUserByUsername(username string) (*model.User, error) {
+	return s.GetUserByUsernameCtx(context.Background(), username)
+}
 
-	return user, nil
+// GetUserByUsernameCtx 根据用户名获取用户（用户名二级索引 -> 按ID查询）
+func (s *userServiceWithBloom) GetUserByUsernameCtx(ctx context.Context, username string) (*model.User, error) {
+	return s.names.get(ctx, username, s.GetUserByIDCtx)
 }
 
 // CreateUser 创建用户
 func (s *userServiceWithBloom) CreateUser(user *model.User) error {
-	log.Printf("[创建用户] username=%s", user.Username)
+	return s.CreateUserCtx(context.Background(), user)
+}
 
-	if err := s.repo.Create(user); err != nil {
+// CreateUserCtx 创建用户
+func (s *userServiceWithBloom) CreateUserCtx(ctx context.Context, user *model.User) error {
+	if err := s.repo.CreateCtx(ctx, user); err != nil {
 		return fmt.Errorf("创建用户失败: %w", err)
 	}
 
 	// 添加到布隆过滤器
 	if err := s.cache.AddToBloomFilter(user.ID); err != nil {
 		log.Printf("[布隆过滤器添加失败] user_id=%d, error=%v (不影响业务逻辑)", user.ID, err)
-	} else {
-		log.Printf("[布隆过滤器添加成功] user_id=%d", user.ID)
 	}
 
-	log.Printf("[创建用户成功] user_id=%d, username=%s", user.ID, user.Username)
+	s.names.bind(ctx, user.Username, user.ID)
+
 	return nil
 }
 
 // UpdateUser 更新用户
 func (s *userServiceWithBloom) UpdateUser(user *model.User) error {
-	log.Printf("[更新用户] user_id=%d", user.ID)
+	return s.UpdateUserCtx(context.Background(), user)
+}
+
+// UpdateUserCtx 更新用户
+func (s *userServiceWithBloom) UpdateUserCtx(ctx context.Context, user *model.User) error {
+	oldUsername := s.names.usernameOf(ctx, user.ID, s.repo.FindByIDCtx)
 
 	// 1. 更新数据库
-	if err := s.repo.Update(user); err != nil {
+	if err := s.repo.UpdateCtx(ctx, user); err != nil {
 		return fmt.Errorf("更新用户失败: %w", err)
 	}
 
 	// 2. 更新缓存
-	if err := s.cache.SetUser(user, cache.DefaultExpireSeconds); err != nil {
+	if err := setUserCache(ctx, s.cache, user, cache.DefaultExpireSeconds); errors.Is(err, cache.ErrStaleVersion) {
+		log.Printf("[跳过旧版本缓存] user_id=%d, version=%d (缓存中已有更新的版本)", user.ID, user.Version)
+	} else if err != nil {
 		log.Printf("[缓存更新失败] user_id=%d, error=%v (不影响业务逻辑)", user.ID, err)
-	} else {
-		log.Printf("[缓存更新成功] user_id=%d", user.ID)
 	}
 
-	// 注意：布隆过滤器不支持删除，所以不需要更新
-	// 如果用户ID不变，布隆过滤器中已经存在
+	// 用户ID不变，过滤器中已经存在，不需要更新
+
+	s.names.renamed(ctx, oldUsername, user)
 
 	return nil
 }
 
 // DeleteUser 删除用户
 func (s *userServiceWithBloom) DeleteUser(id int64) error {
-	log.Printf("[删除用户] user_id=%d", id)
+	return s.DeleteUserCtx(context.Background(), id)
+}
+
+// DeleteUserCtx 删除用户
+func (s *userServiceWithBloom) DeleteUserCtx(ctx context.Context, id int64) error {
+	oldUsername := s.names.usernameOf(ctx, id, s.repo.FindByIDCtx)
 
 	// 1. 删除数据库记录
-	if err := s.repo.Delete(id); err != nil {
+	if err := s.repo.DeleteCtx(ctx, id); err != nil {
 		return fmt.Errorf("删除用户失败: %w", err)
 	}
 
 	// 2. 删除缓存
-	if err := s.cache.DeleteUser(id); err != nil {
+	if err := deleteUserCache(ctx, s.cache, id); err != nil {
 		log.Printf("[缓存删除失败] user_id=%d, error=%v (不影响业务逻辑)", id, err)
-	} else {
-		log.Printf("[缓存删除成功] user_id=%d", id)
 	}
 
-	// 注意：布隆过滤器不支持删除，所以无法从布隆过滤器中删除
-	// 这会导致误判率略微增加，但影响不大
+	// 3. 从过滤器中删除（普通布隆过滤器不支持删除，已删除的用户会一直通过过滤器，只能靠空值缓存兜底）
+	if err := s.cache.RemoveFromFilter(id); err != nil {
+		if errors.Is(err, cache.ErrFilterNotDeletable) {
+			log.Printf("[布隆过滤器不支持删除] user_id=%d", id)
+		} else {
+			log.Printf("[布隆过滤器删除失败] user_id=%d, error=%v (不影响业务逻辑)", id, err)
+		}
+	}
+
+	s.names.unbind(ctx, oldUsername)
 
 	return nil
 }
@@ -0,0 +1,294 @@
+package service
+
+import (
+	"cache-demo/cache"
+	"cache-demo/metrics"
+	"cache-demo/model"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	"github.com/zeromicro/go-zero/core/syncx"
+	"gorm.io/gorm"
+)
+
+const (
+	// BreakdownLockKeyPrefix 缓存重建互斥锁的Key前缀
+	BreakdownLockKeyPrefix = "lock:user:"
+	// BreakdownLockExpireSeconds 缓存重建互斥锁的过期时间（秒）
+	BreakdownLockExpireSeconds = 5
+	// BreakdownLockRetryInterval 未抢到锁时，等待其他进程重建缓存的轮询间隔
+	BreakdownLockRetryInterval = 50 * time.Millisecond
+	// BreakdownLockMaxRetries 未抢到锁时的最大轮询次数（超过后直接查询数据库兜底）
+	BreakdownLockMaxRetries = 40
+)
+
+// userServiceWithBreakdown 支持缓存击穿防护的用户服务实现
+// 热点Key过期时：
+// 1. 进程内：singleflight 合并同一个Key的并发未命中，只有一个协程查询数据库
+// 2. 跨进程（可选）：Redis 互斥锁保证所有进程中只有一个去重建缓存
+type userServiceWithBreakdown struct {
+	repo    model.UserRepo
+	cache   cache.UserCache
+	rds     *redis.Redis
+	flight  syncx.SingleFlight
+	reader  *cache.UserReadThrough
+	names   usernameLookup
+	metrics *metrics.CacheMetrics
+}
+
+// NewUserServiceWithBreakdownProtection 创建支持缓存击穿防护的用户服务实例
+// rds 为 nil 时只做进程内合并，不使用分布式互斥锁
+func NewUserServiceWithBreakdownProtection(repo model.UserRepo, cache cache.UserCache, rds *redis.Redis) UserService {
+	return &userServiceWithBreakdown{
+		repo:    repo,
+		cache:   cache,
+		rds:     rds,
+		flight:  syncx.NewSingleFlight(),
+		reader:  newUserReadThrough(repo, cache),
+		names:   newUsernameLookup(repo, cache),
+		metrics: userCacheMetrics(),
+	}
+}
+
+// GetUserByID 根据ID获取用户（防止缓存击穿）
+func (s *userServiceWithBreakdown) GetUserByID(id int64) (*model.User, error) {
+	return s.GetUserByIDCtx(context.Background(), id)
+}
+
+// GetUserByIDCtx 根据ID获取用户（防止缓存击穿）
+func (s *userServiceWithBreakdown) GetUserByIDCtx(ctx context.Context, id int64) (*model.User, error) {
+	// 1. 先查缓存
+	user, err := s.cache.GetUserCtx(ctx, id)
+	switch {
+	case err == nil && user != nil:
+		s.metrics.Hit()
+		return user, nil
+	case errors.Is(err, cache.ErrNullCache):
+		s.metrics.NullHit()
+		return nil, fmt.Errorf("%w: user_id=%d", ErrUserNotFound, id)
+	case err != nil && !errors.Is(err, cache.ErrCacheMiss):
+		s.metrics.Error(metrics.OpGet)
+	}
+	s.metrics.Miss()
+
+	// 2. 缓存未命中，同一个Key的并发请求合并为一次加载
+	return s.loadShared(ctx, id)
+}
+
+// loadShared 合并同一个Key的并发加载
+// 合并后的加载被多个请求共享，不能因为发起它的请求超时或取消而让其他请求一起失败，所以加载不继承 ctx 的取消；
+// 每个请求仍然只等到自己的 ctx 结束为止；返回的用户是每个请求各自的副本
+func (s *userServiceWithBreakdown) loadShared(ctx context.Context, id int64) (*model.User, error) {
+	type result struct {
+		user *model.User
+		err  error
+	}
+
+	loadCtx := context.WithoutCancel(ctx)
+	done := make(chan result, 1)
+	go func() {
+		val, err := s.flight.Do(fmt.Sprintf("user:%d", id), func() (any, error) {
+			if s.rds == nil {
+				return s.loadAndCache(loadCtx, id)
+			}
+			return s.loadWithMutex(loadCtx, id)
+		})
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		// 合并的请求拿到的是同一个指针，每个请求返回自己的副本，调用方修改时不影响其他请求
+		user := *val.(*model.User)
+		done <- result{user: &user}
+	}()
+
+	select {
+	case r := <-done:
+		return r.user, r.err
+	case <-ctx.Done():
+		log.Printf("[等待加载超时] user_id=%d, error=%v (加载继续进行，完成后回填缓存)", id, ctx.Err())
+		return nil, fmt.Errorf("查询用户失败: %w", ctx.Err())
+	}
+}
+
+// loadWithMutex 使用Redis互斥锁重建缓存（跨进程只有一个请求查询数据库）
+func (s *userServiceWithBreakdown) loadWithMutex(ctx context.Context, id int64) (*model.User, error) {
+	lock := redis.NewRedisLock(s.rds, fmt.Sprintf("%s%d", BreakdownLockKeyPrefix, id))
+	lock.SetExpire(BreakdownLockExpireSeconds)
+
+	for i := 0; i < BreakdownLockMaxRetries; i++ {
+		ok, err := lock.AcquireCtx(ctx)
+		if err != nil {
+			log.Printf("[获取互斥锁失败] user_id=%d, error=%v (直接查询数据库)", id, err)
+			return s.loadAndCache(ctx, id)
+		}
+
+		if ok {
+			defer func() {
+				if _, err := lock.ReleaseCtx(ctx); err != nil {
+					log.Printf("[释放互斥锁失败] user_id=%d, error=%v", id, err)
+				}
+			}()
+
+			// 双重检查：等锁期间其他进程可能已经重建了缓存（或者写入了空值缓存）
+			if user, err := s.cache.GetUserCtx(ctx, id); err == nil && user != nil {
+				log.Printf("[缓存已被重建] user_id=%d", id)
+				return user, nil
+			} else if errors.Is(err, cache.ErrNullCache) {
+				return nil, fmt.Errorf("%w: user_id=%d", ErrUserNotFound, id)
+			}
+
+			return s.loadAndCache(ctx, id)
+		}
+
+		// 没抢到锁，说明其他进程正在重建缓存，稍后再查缓存
+		time.Sleep(BreakdownLockRetryInterval)
+		if user, err := s.cache.GetUserCtx(ctx, id); err == nil && user != nil {
+			log.Printf("[等待重建后缓存命中] user_id=%d, 等待次数=%d", id, i+1)
+			return user, nil
+		} else if errors.Is(err, cache.ErrNullCache) {
+			return nil, fmt.Errorf("%w: user_id=%d", ErrUserNotFound, id)
+		}
+	}
+
+	// 等待超时，查询数据库兜底
+	log.Printf("[等待缓存重建超时] user_id=%d, 直接查询数据库", id)
+	return s.loadAndCache(ctx, id)
+}
+
+// loadAndCache 查询数据库并写入缓存
+func (s *userServiceWithBreakdown) loadAndCache(ctx context.Context, id int64) (*model.User, error) {
+	start := time.Now()
+	user, err := s.repo.FindByIDCtx(ctx, id)
+	s.metrics.Load(time.Since(start))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// 写入空值缓存，不存在的ID不会每次都穿透到数据库（也不会每次都抢互斥锁）
+			s.setNull(ctx, id)
+			return nil, fmt.Errorf("%w: user_id=%d", ErrUserNotFound, id)
+		}
+		s.metrics.Error(metrics.OpLoad)
+		return nil, fmt.Errorf("查询用户失败: %w", err)
+	}
+
+	if err := setUserCache(ctx, s.cache, user, cache.DefaultExpireSeconds); errors.Is(err, cache.ErrStaleVersion) {
+		// 查询期间数据被更新，新版本已经写入缓存
+		log.Printf("[跳过旧版本缓存] user_id=%d, version=%d (缓存中已有更新的版本)", id, user.Version)
+	} else if err != nil {
+		s.metrics.Error(metrics.OpSet)
+		log.Printf("[缓存写入失败] user_id=%d, error=%v (不影响返回结果)", id, err)
+	}
+
+	return user, nil
+}
+
+// setNull 用户不存在时写入空值缓存（缓存实现不支持空值缓存时跳过）
+func (s *userServiceWithBreakdown) setNull(ctx context.Context, id int64) {
+	c, ok := s.cache.(interface {
+		SetNullUserCtx(ctx context.Context, id int64) error
+	})
+	if !ok {
+		return
+	}
+	if err := retryCacheWrite(ctx, metrics.OpSetNull, func(ctx context.Context) error {
+		return c.SetNullUserCtx(ctx, id)
+	}); err != nil {
+		s.metrics.Error(metrics.OpSetNull)
+		log.Printf("[空值缓存写入失败] user_id=%d, error=%v (不影响返回结果)", id, err)
+	}
+}
+
+// GetUsersByIDs 批量获取用户
+func (s *userServiceWithBreakdown) GetUsersByIDs(ids []int64) ([]*model.User, []int64, error) {
+	return s.GetUsersByIDsCtx(context.Background(), ids)
+}
+
+// GetUsersByIDsCtx 批量获取用户
+func (s *userServiceWithBreakdown) GetUsersByIDsCtx(ctx context.Context, ids []int64) ([]*model.User, []int64, error) {
+	return readUsers(ctx, s.reader, ids)
+}
+
+// GetUserByUsername 根据用户名获取用户（用户名二级索引 -> 按ID查询）
+func (s *userServiceWithBreakdown) GetUserByUsername(username string) (*model.User, error) {
+	return s.GetUserByUsernameCtx(context.Background(), username)
+}
+
+// GetUserByUsernameCtx 根据用户名获取用户（用户名二级索引 -> 按ID查询）
+func (s *userServiceWithBreakdown) GetUserByUsernameCtx(ctx context.Context, username string) (*model.User, error) {
+	return s.names.get(ctx, username, s.GetUserByIDCtx)
+}
+
+// CreateUser 创建用户
+func (s *userServiceWithBreakdown) CreateUser(user *model.User) error {
+	return s.CreateUserCtx(context.Background(), user)
+}
+
+// CreateUserCtx 创建用户
+func (s *userServiceWithBreakdown) CreateUserCtx(ctx context.Context, user *model.User) error {
+	if err := s.repo.CreateCtx(ctx, user); err != nil {
+		return fmt.Errorf("创建用户失败: %w", err)
+	}
+
+	// 如果之前有空值缓存，需要删除（因为现在用户已存在）
+	if err := deleteUserCache(ctx, s.cache, user.ID); err != nil {
+		log.Printf("[删除空值缓存失败] user_id=%d, error=%v (不影响业务逻辑)", user.ID, err)
+	}
+
+	s.names.bind(ctx, user.Username, user.ID)
+
+	return nil
+}
+
+// UpdateUser 更新用户
+func (s *userServiceWithBreakdown) UpdateUser(user *model.User) error {
+	return s.UpdateUserCtx(context.Background(), user)
+}
+
+// UpdateUserCtx 更新用户
+func (s *userServiceWithBreakdown) UpdateUserCtx(ctx context.Context, user *model.User) error {
+	oldUsername := s.names.usernameOf(ctx, user.ID, s.repo.FindByIDCtx)
+
+	// 1. 更新数据库
+	if err := s.repo.UpdateCtx(ctx, user); err != nil {
+		return fmt.Errorf("更新用户失败: %w", err)
+	}
+
+	// 2. 更新缓存
+	if err := setUserCache(ctx, s.cache, user, cache.DefaultExpireSeconds); errors.Is(err, cache.ErrStaleVersion) {
+		log.Printf("[跳过旧版本缓存] user_id=%d, version=%d (缓存中已有更新的版本)", user.ID, user.Version)
+	} else if err != nil {
+		log.Printf("[缓存更新失败] user_id=%d, error=%v (不影响业务逻辑)", user.ID, err)
+	}
+
+	s.names.renamed(ctx, oldUsername, user)
+
+	return nil
+}
+
+// DeleteUser 删除用户
+func (s *userServiceWithBreakdown) DeleteUser(id int64) error {
+	return s.DeleteUserCtx(context.Background(), id)
+}
+
+// DeleteUserCtx 删除用户
+func (s *userServiceWithBreakdown) DeleteUserCtx(ctx context.Context, id int64) error {
+	oldUsername := s.names.usernameOf(ctx, id, s.repo.FindByIDCtx)
+
+	// 1. 删除数据库记录
+	if err := s.repo.DeleteCtx(ctx, id); err != nil {
+		return fmt.Errorf("删除用户失败: %w", err)
+	}
+
+	// 2. 删除缓存
+	if err := deleteUserCache(ctx, s.cache, id); err != nil {
+		log.Printf("[缓存删除失败] user_id=%d, error=%v (不影响业务逻辑)", id, err)
+	}
+
+	s.names.unbind(ctx, oldUsername)
+
+	return nil
+}
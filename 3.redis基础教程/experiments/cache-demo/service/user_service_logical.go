@@ -0,0 +1,222 @@
+package service
+
+import (
+	"cache-demo/cache"
+	"cache-demo/metrics"
+	"cache-demo/model"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	"gorm.io/gorm"
+)
+
+const (
+	// RebuildLockKeyPrefix 逻辑过期缓存重建锁的Key前缀
+	RebuildLockKeyPrefix = "lock:rebuild:user:"
+	// RebuildLockExpireSeconds 重建锁的过期时间（秒）
+	RebuildLockExpireSeconds = 10
+)
+
+// userServiceWithLogicalExpire 基于逻辑过期的用户服务实现（stale-while-revalidate）
+// 逻辑过期后直接返回旧值，同时只有一个请求在后台异步重建缓存，热点Key永远不会出现同步查库
+type userServiceWithLogicalExpire struct {
+	repo          model.UserRepo
+	cache         cache.UserCacheWithLogicalExpire
+	rds           *redis.Redis
+	logicalExpire int
+	names         usernameLookup
+	metrics       *metrics.CacheMetrics
+}
+
+// NewUserServiceWithLogicalExpire 创建基于逻辑过期的用户服务实例
+func NewUserServiceWithLogicalExpire(repo model.UserRepo, cache cache.UserCacheWithLogicalExpire, rds *redis.Redis, logicalExpire int) UserService {
+	return &userServiceWithLogicalExpire{
+		repo:          repo,
+		cache:         cache,
+		rds:           rds,
+		logicalExpire: logicalExpire,
+		names:         newUsernameLookup(repo, cache),
+		metrics:       userCacheMetrics(),
+	}
+}
+
+// GetUserByID 根据ID获取用户（逻辑过期 + 异步重建）
+func (s *userServiceWithLogicalExpire) GetUserByID(id int64) (*model.User, error) {
+	return s.GetUserByIDCtx(context.Background(), id)
+}
+
+// GetUserByIDCtx 根据ID获取用户（逻辑过期 + 异步重建）
+func (s *userServiceWithLogicalExpire) GetUserByIDCtx(ctx context.Context, id int64) (*model.User, error) {
+	// 1. 先查缓存
+	user, expired, err := s.cache.GetUserCtx(ctx, id)
+	if err == nil && user != nil {
+		// 逻辑过期也直接返回缓存中的旧值，算作命中
+		s.metrics.Hit()
+		if !expired {
+			return user, nil
+		}
+
+		// 2. 逻辑过期：直接返回旧值，后台异步重建
+		s.rebuildAsync(ctx, id)
+		return user, nil
+	}
+
+	// 3. 缓存中完全没有数据（冷启动/未预热），只能同步查询数据库
+	if err != nil && !errors.Is(err, cache.ErrCacheMiss) {
+		s.metrics.Error(metrics.OpGet)
+	}
+	s.metrics.Miss()
+	return s.loadAndCache(ctx, id)
+}
+
+// GetUsersByIDs 批量获取用户
+// 逻辑过期缓存需要逐个判断是否过期并触发异步重建，所以逐个查询
+func (s *userServiceWithLogicalExpire) GetUsersByIDs(ids []int64) ([]*model.User, []int64, error) {
+	return s.GetUsersByIDsCtx(context.Background(), ids)
+}
+
+// GetUsersByIDsCtx 批量获取用户
+func (s *userServiceWithLogicalExpire) GetUsersByIDsCtx(ctx context.Context, ids []int64) ([]*model.User, []int64, error) {
+	return getUsersOneByOne(ctx, s.GetUserByIDCtx, ids)
+}
+
+// GetUserByUsername 根据用户名获取用户（用户名二级索引 -> 按ID查询）
+func (s *userServiceWithLogicalExpire) GetUserByUsername(username string) (*model.User, error) {
+	return s.GetUserByUsernameCtx(context.Background(), username)
+}
+
+// GetUserByUsernameCtx 根据用户名获取用户（用户名二级索引 -> 按ID查询）
+func (s *userServiceWithLogicalExpire) GetUserByUsernameCtx(ctx context.Context, username string) (*model.User, error) {
+	return s.names.get(ctx, username, s.GetUserByIDCtx)
+}
+
+// rebuildAsync 抢到重建锁的请求启动一个后台协程重建缓存，没抢到的直接返回
+// 请求返回后重建仍在进行，所以后台协程不继承 ctx 的取消
+func (s *userServiceWithLogicalExpire) rebuildAsync(ctx context.Context, id int64) {
+	lock := redis.NewRedisLock(s.rds, fmt.Sprintf("%s%d", RebuildLockKeyPrefix, id))
+	lock.SetExpire(RebuildLockExpireSeconds)
+
+	ok, err := lock.AcquireCtx(ctx)
+	if err != nil {
+		log.Printf("[获取重建锁失败] user_id=%d, error=%v", id, err)
+		return
+	}
+	if !ok {
+		log.Printf("[缓存正在重建] user_id=%d, 本次不重复重建", id)
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer func() {
+			if _, err := lock.ReleaseCtx(ctx); err != nil {
+				log.Printf("[释放重建锁失败] user_id=%d, error=%v", id, err)
+			}
+		}()
+
+		// 双重检查：抢锁期间可能已经被其他进程重建
+		if user, expired, err := s.cache.GetUserCtx(ctx, id); err == nil && user != nil && !expired {
+			return
+		}
+
+		if _, err := s.loadAndCache(ctx, id); err != nil {
+			log.Printf("[异步重建失败] user_id=%d, error=%v (继续返回旧值)", id, err)
+			return
+		}
+		log.Printf("[异步重建成功] user_id=%d", id)
+	}()
+}
+
+// loadAndCache 查询数据库并写入带逻辑过期时间的缓存
+func (s *userServiceWithLogicalExpire) loadAndCache(ctx context.Context, id int64) (*model.User, error) {
+	start := time.Now()
+	user, err := s.repo.FindByIDCtx(ctx, id)
+	s.metrics.Load(time.Since(start))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: user_id=%d", ErrUserNotFound, id)
+		}
+		s.metrics.Error(metrics.OpLoad)
+		return nil, fmt.Errorf("查询用户失败: %w", err)
+	}
+
+	if err := setUserCache(ctx, s.cache, user, s.logicalExpire); errors.Is(err, cache.ErrStaleVersion) {
+		// 查询期间数据被更新，新版本已经写入缓存
+		log.Printf("[跳过旧版本缓存] user_id=%d, version=%d (缓存中已有更新的版本)", id, user.Version)
+	} else if err != nil {
+		s.metrics.Error(metrics.OpSet)
+		log.Printf("[缓存写入失败] user_id=%d, error=%v (不影响返回结果)", id, err)
+	}
+
+	return user, nil
+}
+
+// CreateUser 创建用户
+func (s *userServiceWithLogicalExpire) CreateUser(user *model.User) error {
+	return s.CreateUserCtx(context.Background(), user)
+}
+
+// CreateUserCtx 创建用户
+func (s *userServiceWithLogicalExpire) CreateUserCtx(ctx context.Context, user *model.User) error {
+	if err := s.repo.CreateCtx(ctx, user); err != nil {
+		return fmt.Errorf("创建用户失败: %w", err)
+	}
+
+	s.names.bind(ctx, user.Username, user.ID)
+
+	return nil
+}
+
+// UpdateUser 更新用户
+func (s *userServiceWithLogicalExpire) UpdateUser(user *model.User) error {
+	return s.UpdateUserCtx(context.Background(), user)
+}
+
+// UpdateUserCtx 更新用户
+func (s *userServiceWithLogicalExpire) UpdateUserCtx(ctx context.Context, user *model.User) error {
+	oldUsername := s.names.usernameOf(ctx, user.ID, s.repo.FindByIDCtx)
+
+	// 1. 更新数据库
+	if err := s.repo.UpdateCtx(ctx, user); err != nil {
+		return fmt.Errorf("更新用户失败: %w", err)
+	}
+
+	// 2. 更新缓存（重新计算逻辑过期时间）
+	if err := setUserCache(ctx, s.cache, user, s.logicalExpire); errors.Is(err, cache.ErrStaleVersion) {
+		log.Printf("[跳过旧版本缓存] user_id=%d, version=%d (缓存中已有更新的版本)", user.ID, user.Version)
+	} else if err != nil {
+		log.Printf("[缓存更新失败] user_id=%d, error=%v (不影响业务逻辑)", user.ID, err)
+	}
+
+	s.names.renamed(ctx, oldUsername, user)
+
+	return nil
+}
+
+// DeleteUser 删除用户
+func (s *userServiceWithLogicalExpire) DeleteUser(id int64) error {
+	return s.DeleteUserCtx(context.Background(), id)
+}
+
+// DeleteUserCtx 删除用户
+func (s *userServiceWithLogicalExpire) DeleteUserCtx(ctx context.Context, id int64) error {
+	oldUsername := s.names.usernameOf(ctx, id, s.repo.FindByIDCtx)
+
+	// 1. 删除数据库记录
+	if err := s.repo.DeleteCtx(ctx, id); err != nil {
+		return fmt.Errorf("删除用户失败: %w", err)
+	}
+
+	// 2. 删除缓存
+	if err := deleteUserCache(ctx, s.cache, id); err != nil {
+		log.Printf("[缓存删除失败] user_id=%d, error=%v (不影响业务逻辑)", id, err)
+	}
+
+	s.names.unbind(ctx, oldUsername)
+
+	return nil
+}
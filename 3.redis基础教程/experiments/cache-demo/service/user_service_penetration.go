@@ -3,136 +3,171 @@ package service
 import (
 	"cache-demo/cache"
 	"cache-demo/model"
+	"context"
 	"errors"
 	"fmt"
 	"log"
 
-	"gorm.io/gorm"
+	"github.com/zeromicro/go-zero/core/collection"
 )
 
+// ClientUserService 可以按客户端区分的用户服务（例如按客户端限流）
+type ClientUserService interface {
+	UserService
+	// ForClient 返回绑定到指定客户端（例如IP、用户ID）的服务，与原服务共享缓存和限流器
+	ForClient(clientID string) UserService
+}
+
 // userServiceWithPenetration 支持缓存穿透防护的用户服务实现
+// 1. 数据库中不存在的用户写入空值缓存（过期时间和Key个数上限由缓存配置，见 cache.NewUserCacheWithPenetration）
+// 2. 可选：缓存未命中时先经过回源限流，随机ID刷接口时保护数据库
 type userServiceWithPenetration struct {
-	repo  model.UserRepo
-	cache cache.UserCacheWithPenetration
+	repo    model.UserRepo
+	cache   cache.UserCacheWithPenetration
+	limiter *ProbeLimiter
+	reader  *cache.UserReadThrough
+	readers *collection.Cache // clientID -> *cache.UserReadThrough，所有 ForClient 派生的服务共享
+	names   usernameLookup
 }
 
 // NewUserServiceWithPenetration 创建支持缓存穿透防护的用户服务实例
-func NewUserServiceWithPenetration(repo model.UserRepo, cache cache.UserCacheWithPenetration) UserService {
-	return &userServiceWithPenetration{
-		repo:  repo,
-		cache: cache,
+// limiter 为 nil 时不限流；不通过 ForClient 指定客户端时只使用全局令牌桶
+func NewUserServiceWithPenetration(repo model.UserRepo, cache cache.UserCacheWithPenetration, limiter *ProbeLimiter) ClientUserService {
+	s := &userServiceWithPenetration{
+		repo:    repo,
+		cache:   cache,
+		limiter: limiter,
+		names:   newUsernameLookup(repo, cache),
 	}
+	s.reader = s.newReader("")
+	if limiter != nil {
+		s.readers = newClientCache("penetration_readers")
+	}
+	return s
 }
 
-// GetUserByID 根据ID获取用户（支持空值缓存，防止缓存穿透）
-func (s *userServiceWithPenetration) GetUserByID(id int64) (*model.User, error) {
-	// 1. 先查缓存
-	user, err := s.cache.GetUser(id)
-	if err == nil && user != nil {
-		log.Printf("[缓存命中] user_id=%d, username=%s", id, user.Username)
-		return user, nil
+// ForClient 返回绑定到指定客户端的服务
+// 同一个客户端复用读穿透缓存（最多保留 MaxProbeClients 个客户端）；没有配置限流器时读穿透缓存和客户端无关，直接共享
+func (s *userServiceWithPenetration) ForClient(clientID string) UserService {
+	clientService := *s
+	if s.readers != nil {
+		reader, _ := s.readers.Take(clientID, func() (any, error) {
+			return s.newReader(clientID), nil
+		})
+		clientService.reader = reader.(*cache.UserReadThrough)
 	}
+	return &clientService
+}
 
-	// 2. 检查是否是空值缓存
-	if errors.Is(err, cache.ErrNullCache) {
-		log.Printf("[空值缓存命中] user_id=%d (防止缓存穿透)", id)
-		return nil, fmt.Errorf("用户不存在: user_id=%d", id)
+// newReader 创建读穿透缓存，配置了限流器时回源前按客户端限流
+func (s *userServiceWithPenetration) newReader(clientID string) *cache.UserReadThrough {
+	if s.limiter == nil {
+		return newUserReadThrough(s.repo, s.cache, withNegativeCache())
 	}
+	guard := cache.WithLoadGuard[int64, *model.User](func(id int64) error {
+		return s.limiter.Allow(clientID)
+	})
+	return newUserReadThrough(s.repo, s.cache, withNegativeCache(), guard)
+}
 
-	// 3. 缓存未命中，查数据库
-	log.Printf("[缓存未命中] user_id=%d, 查询数据库", id)
-	user, err = s.repo.FindByID(id)
-	if err != nil {
-		// 检查是否是记录不存在的错误
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			// 用户不存在，设置空值缓存（防止缓存穿透）
-			log.Printf("[用户不存在] user_id=%d, 设置空值缓存 (expire=%d秒)", id, cache.NullCacheExpireSeconds)
-			if err := s.cache.SetNullUser(id); err != nil {
-				log.Printf("[空值缓存写入失败] user_id=%d, error=%v (不影响返回结果)", id, err)
-			} else {
-				log.Printf("[空值缓存写入成功] user_id=%d, expire=%d秒", id, cache.NullCacheExpireSeconds)
-			}
-			return nil, fmt.Errorf("用户不存在: user_id=%d", id)
-		}
-		// 其他数据库错误（如连接失败等）
-		log.Printf("[数据库查询失败] user_id=%d, error=%v", id, err)
-		return nil, fmt.Errorf("查询用户失败: %w", err)
-	}
+// GetUserByID 根据ID获取用户（支持空值缓存，防止缓存穿透）
+// 数据库中不存在的用户会写入空值缓存，过期前的重复查询直接返回，不再访问数据库
+func (s *userServiceWithPenetration) GetUserByID(id int64) (*model.User, error) {
+	return s.GetUserByIDCtx(context.Background(), id)
+}
 
-	// 4. 处理查询结果（正常情况下 user 不为 nil，但为了安全起见还是检查一下）
-	if user == nil {
-		// 用户不存在，设置空值缓存（防止缓存穿透）
-		log.Printf("[用户不存在] user_id=%d, 设置空值缓存 (expire=%d秒)", id, cache.NullCacheExpireSeconds)
-		if err := s.cache.SetNullUser(id); err != nil {
-			log.Printf("[空值缓存写入失败] user_id=%d, error=%v (不影响返回结果)", id, err)
-		} else {
-			log.Printf("[空值缓存写入成功] user_id=%d, expire=%d秒", id, cache.NullCacheExpireSeconds)
-		}
-		return nil, fmt.Errorf("用户不存在: user_id=%d", id)
-	}
+// GetUserByIDCtx 根据ID获取用户（支持空值缓存，防止缓存穿透）
+func (s *userServiceWithPenetration) GetUserByIDCtx(ctx context.Context, id int64) (*model.User, error) {
+	return readUser(ctx, s.reader, id)
+}
 
-	// 5. 用户存在，写入正常缓存
-	log.Printf("[用户存在] user_id=%d, username=%s, 写入缓存", id, user.Username)
-	if err := s.cache.SetUser(user, cache.DefaultExpireSeconds); err != nil {
-		log.Printf("[缓存写入失败] user_id=%d, error=%v (不影响返回结果)", id, err)
-	} else {
-		log.Printf("[缓存写入成功] user_id=%d, username=%s, expire=%d秒", id, user.Username, cache.DefaultExpireSeconds)
-	}
+// GetUsersByIDs 批量获取用户
+func (s *userServiceWithPenetration) GetUsersByIDs(ids []int64) ([]*model.User, []int64, error) {
+	return s.GetUsersByIDsCtx(context.Background(), ids)
+}
+
+// GetUsersByIDsCtx 批量获取用户
+func (s *userServiceWithPenetration) GetUsersByIDsCtx(ctx context.Context, ids []int64) ([]*model.User, []int64, error) {
+	return readUsers(ctx, s.reader, ids)
+}
 
-	return user, nil
+// GetUserByUsername 根据用户名获取用户（用户名二级索引 -> 按ID查询）
+func (s *userServiceWithPenetration) GetUserByUsername(username string) (*model.User, error) {
+	return s.GetUserByUsernameCtx(context.Background(), username)
+}
+
+// GetUserByUsernameCtx 根据用户名获取用户（用户名二级索引 -> 按ID查询）
+func (s *userServiceWithPenetration) GetUserByUsernameCtx(ctx context.Context, username string) (*model.User, error) {
+	return s.names.get(ctx, username, s.GetUserByIDCtx)
 }
 
 // CreateUser 创建用户
 func (s *userServiceWithPenetration) CreateUser(user *model.User) error {
-	log.Printf("[创建用户] username=%s", user.Username)
+	return s.CreateUserCtx(context.Background(), user)
+}
 
-	if err := s.repo.Create(user); err != nil {
+// CreateUserCtx 创建用户
+func (s *userServiceWithPenetration) CreateUserCtx(ctx context.Context, user *model.User) error {
+	if err := s.repo.CreateCtx(ctx, user); err != nil {
 		return fmt.Errorf("创建用户失败: %w", err)
 	}
 
 	// 如果之前有空值缓存，需要删除（因为现在用户已存在）
-	if err := s.cache.DeleteUser(user.ID); err != nil {
+	if err := deleteUserCache(ctx, s.cache, user.ID); err != nil {
 		log.Printf("[删除空值缓存失败] user_id=%d, error=%v (不影响业务逻辑)", user.ID, err)
 	}
 
-	log.Printf("[创建用户成功] user_id=%d, username=%s", user.ID, user.Username)
+	s.names.bind(ctx, user.Username, user.ID)
+
 	return nil
 }
 
 // UpdateUser 更新用户
 func (s *userServiceWithPenetration) UpdateUser(user *model.User) error {
-	log.Printf("[更新用户] user_id=%d", user.ID)
+	return s.UpdateUserCtx(context.Background(), user)
+}
+
+// UpdateUserCtx 更新用户
+func (s *userServiceWithPenetration) UpdateUserCtx(ctx context.Context, user *model.User) error {
+	oldUsername := s.names.usernameOf(ctx, user.ID, s.repo.FindByIDCtx)
 
 	// 1. 更新数据库
-	if err := s.repo.Update(user); err != nil {
+	if err := s.repo.UpdateCtx(ctx, user); err != nil {
 		return fmt.Errorf("更新用户失败: %w", err)
 	}
 
 	// 2. 更新缓存（使用相同的过期时间）
-	if err := s.cache.SetUser(user, cache.DefaultExpireSeconds); err != nil {
+	if err := setUserCache(ctx, s.cache, user, cache.DefaultExpireSeconds); errors.Is(err, cache.ErrStaleVersion) {
+		log.Printf("[跳过旧版本缓存] user_id=%d, version=%d (缓存中已有更新的版本)", user.ID, user.Version)
+	} else if err != nil {
 		log.Printf("[缓存更新失败] user_id=%d, error=%v (不影响业务逻辑)", user.ID, err)
-	} else {
-		log.Printf("[缓存更新成功] user_id=%d", user.ID)
 	}
 
+	s.names.renamed(ctx, oldUsername, user)
+
 	return nil
 }
 
 // DeleteUser 删除用户
 func (s *userServiceWithPenetration) DeleteUser(id int64) error {
-	log.Printf("[删除用户] user_id=%d", id)
+	return s.DeleteUserCtx(context.Background(), id)
+}
+
+// DeleteUserCtx 删除用户
+func (s *userServiceWithPenetration) DeleteUserCtx(ctx context.Context, id int64) error {
+	oldUsername := s.names.usernameOf(ctx, id, s.repo.FindByIDCtx)
 
 	// 1. 删除数据库记录
-	if err := s.repo.Delete(id); err != nil {
+	if err := s.repo.DeleteCtx(ctx, id); err != nil {
 		return fmt.Errorf("删除用户失败: %w", err)
 	}
 
 	// 2. 删除缓存
-	if err := s.cache.DeleteUser(id); err != nil {
+	if err := deleteUserCache(ctx, s.cache, id); err != nil {
 		log.Printf("[缓存删除失败] user_id=%d, error=%v (不影响业务逻辑)", id, err)
-	} else {
-		log.Printf("[缓存删除成功] user_id=%d", id)
 	}
 
+	s.names.unbind(ctx, oldUsername)
+
 	return nil
 }
@@ -3,8 +3,12 @@ package service
 import (
 	"cache-demo/cache"
 	"cache-demo/model"
+	"cache-demo/retry"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 )
 
 // UpdateStrategy 缓存更新策略类型
@@ -15,75 +19,122 @@ const (
 	UpdateCache UpdateStrategy = iota
 	// DeleteCache 删除缓存策略（写多读少、数据一致性要求高）
 	DeleteCache
+	// DelayedDoubleDelete 延迟双删策略（写数据库前后各删一次缓存，第二次延迟执行）
+	DelayedDoubleDelete
+)
+
+const (
+	// DefaultDoubleDeleteDelay 延迟双删中第二次删除的默认延迟
+	// 需要大于"读请求查数据库 + 写缓存"的耗时，才能删掉并发读请求回填的旧数据
+	DefaultDoubleDeleteDelay = 500 * time.Millisecond
+	// DoubleDeleteMaxRetries 第二次删除失败时的最大重试次数
+	DoubleDeleteMaxRetries = 3
+	// DoubleDeleteRetryInterval 第二次删除失败后的重试间隔
+	DoubleDeleteRetryInterval = 100 * time.Millisecond
 )
 
 // userServiceWithStrategy 带策略的用户服务实现
 type userServiceWithStrategy struct {
-	repo     model.UserRepo
-	cache    cache.UserCache
-	strategy UpdateStrategy
+	repo              model.UserRepo
+	cache             cache.UserCache
+	strategy          UpdateStrategy
+	doubleDeleteDelay time.Duration
+	reader            *cache.UserReadThrough
+	names             usernameLookup
 }
 
 // NewUserServiceWithStrategy 创建带策略的用户服务实例
 func NewUserServiceWithStrategy(repo model.UserRepo, cache cache.UserCache, strategy UpdateStrategy) UserService {
 	return &userServiceWithStrategy{
-		repo:     repo,
-		cache:    cache,
-		strategy: strategy,
+		repo:              repo,
+		cache:             cache,
+		strategy:          strategy,
+		doubleDeleteDelay: DefaultDoubleDeleteDelay,
+		reader:            newUserReadThrough(repo, cache),
+		names:             newUsernameLookup(repo, cache),
+	}
+}
+
+// NewUserServiceWithDelayedDoubleDelete 创建使用延迟双删策略的用户服务实例
+// delay 为第二次删除的延迟，<=0 时使用 DefaultDoubleDeleteDelay
+func NewUserServiceWithDelayedDoubleDelete(repo model.UserRepo, cache cache.UserCache, delay time.Duration) UserService {
+	if delay <= 0 {
+		delay = DefaultDoubleDeleteDelay
+	}
+	return &userServiceWithStrategy{
+		repo:              repo,
+		cache:             cache,
+		strategy:          DelayedDoubleDelete,
+		doubleDeleteDelay: delay,
+		reader:            newUserReadThrough(repo, cache),
+		names:             newUsernameLookup(repo, cache),
 	}
 }
 
 // GetUserByID 根据ID获取用户（Cache-Aside 模式）
 func (s *userServiceWithStrategy) GetUserByID(id int64) (*model.User, error) {
-	// 1. 先查缓存
-	user, err := s.cache.GetUser(id)
-	if err == nil && user != nil {
-		log.Printf("[缓存命中] user_id=%d, username=%s", id, user.Username)
-		return user, nil
-	}
+	return s.GetUserByIDCtx(context.Background(), id)
+}
 
-	// 2. 缓存未命中，查数据库
-	log.Printf("[缓存未命中] user_id=%d, 查询数据库", id)
-	user, err = s.repo.FindByID(id)
-	if err != nil {
-		log.Printf("[数据库查询失败] user_id=%d, error=%v", id, err)
-		return nil, fmt.Errorf("查询用户失败: %w", err)
-	}
+// GetUserByIDCtx 根据ID获取用户（Cache-Aside 模式）
+func (s *userServiceWithStrategy) GetUserByIDCtx(ctx context.Context, id int64) (*model.User, error) {
+	return readUser(ctx, s.reader, id)
+}
 
-	// 如果用户不存在，返回nil
-	if user == nil {
-		log.Printf("[用户不存在] user_id=%d", id)
-		return nil, fmt.Errorf("用户不存在: user_id=%d", id)
-	}
+// GetUsersByIDs 批量获取用户
+func (s *userServiceWithStrategy) GetUsersByIDs(ids []int64) ([]*model.User, []int64, error) {
+	return s.GetUsersByIDsCtx(context.Background(), ids)
+}
 
-	// 3. 写入缓存（设置过期时间 5 分钟）
-	if err := s.cache.SetUser(user, cache.DefaultExpireSeconds); err != nil {
-		log.Printf("[缓存写入失败] user_id=%d, error=%v (不影响返回结果)", id, err)
-	} else {
-		log.Printf("[缓存写入成功] user_id=%d, username=%s, expire=%d秒", id, user.Username, cache.DefaultExpireSeconds)
-	}
+// GetUsersByIDsCtx 批量获取用户
+func (s *userServiceWithStrategy) GetUsersByIDsCtx(ctx context.Context, ids []int64) ([]*model.User, []int64, error) {
+	return readUsers(ctx, s.reader, ids)
+}
+
+// GetUserByUsername 根据用户名获取用户（用户名二级索引 -> 按ID查询）
+func (s *userServiceWithStrategy) GetUserByUsername(username string) (*model.User, error) {
+	return s.GetUserByUsernameCtx(context.Background(), username)
+}
 
-	return user, nil
+// GetUserByUsernameCtx 根据用户名获取用户（用户名二级索引 -> 按ID查询）
+func (s *userServiceWithStrategy) GetUserByUsernameCtx(ctx context.Context, username string) (*model.User, error) {
+	return s.names.get(ctx, username, s.GetUserByIDCtx)
 }
 
 // CreateUser 创建用户
 func (s *userServiceWithStrategy) CreateUser(user *model.User) error {
-	log.Printf("[创建用户] username=%s", user.Username)
+	return s.CreateUserCtx(context.Background(), user)
+}
 
-	if err := s.repo.Create(user); err != nil {
+// CreateUserCtx 创建用户
+func (s *userServiceWithStrategy) CreateUserCtx(ctx context.Context, user *model.User) error {
+	if err := s.repo.CreateCtx(ctx, user); err != nil {
 		return fmt.Errorf("创建用户失败: %w", err)
 	}
 
-	log.Printf("[创建用户成功] user_id=%d, username=%s", user.ID, user.Username)
+	s.names.bind(ctx, user.Username, user.ID)
+
 	return nil
 }
 
 // UpdateUser 更新用户（根据策略选择更新缓存或删除缓存）
 func (s *userServiceWithStrategy) UpdateUser(user *model.User) error {
-	log.Printf("[更新用户] user_id=%d, 策略=%v", user.ID, s.strategy)
+	return s.UpdateUserCtx(context.Background(), user)
+}
+
+// UpdateUserCtx 更新用户（根据策略选择更新缓存或删除缓存）
+func (s *userServiceWithStrategy) UpdateUserCtx(ctx context.Context, user *model.User) error {
+	oldUsername := s.names.usernameOf(ctx, user.ID, s.repo.FindByIDCtx)
+
+	// 延迟双删：写数据库之前先删一次缓存
+	if s.strategy == DelayedDoubleDelete {
+		if err := deleteUserCache(ctx, s.cache, user.ID); err != nil {
+			log.Printf("[第一次缓存删除失败] user_id=%d, error=%v (不影响业务逻辑)", user.ID, err)
+		}
+	}
 
 	// 1. 更新数据库
-	if err := s.repo.Update(user); err != nil {
+	if err := s.repo.UpdateCtx(ctx, user); err != nil {
 		return fmt.Errorf("更新用户失败: %w", err)
 	}
 
@@ -91,39 +142,71 @@ func (s *userServiceWithStrategy) UpdateUser(user *model.User) error {
 	switch s.strategy {
 	case UpdateCache:
 		// 策略1：更新缓存（读多写少）
-		if err := s.cache.SetUser(user, cache.DefaultExpireSeconds); err != nil {
+		if err := setUserCache(ctx, s.cache, user, cache.DefaultExpireSeconds); errors.Is(err, cache.ErrStaleVersion) {
+			log.Printf("[跳过旧版本缓存] user_id=%d, version=%d (缓存中已有更新的版本)", user.ID, user.Version)
+		} else if err != nil {
 			log.Printf("[缓存更新失败] user_id=%d, error=%v (不影响业务逻辑)", user.ID, err)
-		} else {
-			log.Printf("[缓存更新成功] user_id=%d (策略: 更新缓存)", user.ID)
 		}
 
 	case DeleteCache:
 		// 策略2：删除缓存（写多读少、数据一致性要求高）
-		if err := s.cache.DeleteUser(user.ID); err != nil {
+		if err := deleteUserCache(ctx, s.cache, user.ID); err != nil {
 			log.Printf("[缓存删除失败] user_id=%d, error=%v (不影响业务逻辑)", user.ID, err)
-		} else {
-			log.Printf("[缓存删除成功] user_id=%d (策略: 删除缓存)", user.ID)
 		}
+
+	case DelayedDoubleDelete:
+		// 策略3：延迟双删（删除写数据库期间被并发读请求回填的旧数据）
+		id, bg := user.ID, context.WithoutCancel(ctx)
+		time.AfterFunc(s.doubleDeleteDelay, func() {
+			s.deleteWithRetry(bg, id)
+		})
+		log.Printf("[已安排第二次缓存删除] user_id=%d, delay=%v", user.ID, s.doubleDeleteDelay)
 	}
 
+	s.names.renamed(ctx, oldUsername, user)
+
 	return nil
 }
 
+// doubleDeleteRetry 延迟双删第二次删除的重试策略：固定间隔，任何错误都重试（重复删除没有副作用）
+var doubleDeleteRetry = newRetryPolicy(
+	retry.WithMaxAttempts(DoubleDeleteMaxRetries+1),
+	retry.WithBackoff(retry.ConstantBackoff(DoubleDeleteRetryInterval)),
+	retry.WithClassifier(func(err error) bool { return !retry.IsPermanent(err) }),
+)
+
+// deleteWithRetry 延迟双删的第二次删除，失败时重试（请求已经返回，ctx 不带取消）
+func (s *userServiceWithStrategy) deleteWithRetry(ctx context.Context, id int64) {
+	err := doubleDeleteRetry.Do(ctx, "double_delete", func(ctx context.Context) error {
+		return s.cache.DeleteUserCtx(ctx, id)
+	})
+	if err != nil {
+		log.Printf("[第二次缓存删除最终失败] user_id=%d, error=%v (等待缓存过期兜底)", id, err)
+		return
+	}
+	log.Printf("[第二次缓存删除成功] user_id=%d", id)
+}
+
 // DeleteUser 删除用户
 func (s *userServiceWithStrategy) DeleteUser(id int64) error {
-	log.Printf("[删除用户] user_id=%d", id)
+	return s.DeleteUserCtx(context.Background(), id)
+}
+
+// DeleteUserCtx 删除用户
+func (s *userServiceWithStrategy) DeleteUserCtx(ctx context.Context, id int64) error {
+	oldUsername := s.names.usernameOf(ctx, id, s.repo.FindByIDCtx)
 
 	// 1. 删除数据库记录
-	if err := s.repo.Delete(id); err != nil {
+	if err := s.repo.DeleteCtx(ctx, id); err != nil {
 		return fmt.Errorf("删除用户失败: %w", err)
 	}
 
 	// 2. 删除缓存
-	if err := s.cache.DeleteUser(id); err != nil {
+	if err := deleteUserCache(ctx, s.cache, id); err != nil {
 		log.Printf("[缓存删除失败] user_id=%d, error=%v (不影响业务逻辑)", id, err)
-	} else {
-		log.Printf("[缓存删除成功] user_id=%d", id)
 	}
 
+	s.names.unbind(ctx, oldUsername)
+
 	return nil
 }
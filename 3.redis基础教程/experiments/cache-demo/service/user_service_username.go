@@ -0,0 +1,158 @@
+package service
+
+import (
+	"cache-demo/cache"
+	"cache-demo/metrics"
+	"cache-demo/model"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// usernameLookup 按用户名查询用户：用户名二级索引（user:username:<name> -> id） + 各服务自己的ID查询路径
+// 索引命中后必须再按ID取回用户并核对用户名，用户改名/删除后残留的旧索引会在这里被发现并删除
+type usernameLookup struct {
+	repo    model.UserRepo
+	index   cache.UsernameIndex // nil 表示缓存实现不支持用户名索引，每次都查询数据库
+	users   cache.UserCache     // 写操作前读取旧用户名，nil 表示缓存实现不是 cache.UserCache，直接查询数据库
+	metrics *metrics.CacheMetrics
+}
+
+// newUsernameLookup 创建按用户名查询的组件，缓存实现支持 cache.UsernameIndex 时启用索引
+func newUsernameLookup(repo model.UserRepo, c any) usernameLookup {
+	index, _ := c.(cache.UsernameIndex)
+	users, _ := c.(cache.UserCache)
+	return usernameLookup{repo: repo, index: index, users: users, metrics: metrics.For(cache.UsernameIndexCacheName)}
+}
+
+// get 根据用户名查询用户，getByID 为所属服务的 GetUserByIDCtx
+func (l usernameLookup) get(ctx context.Context, username string, getByID func(ctx context.Context, id int64) (*model.User, error)) (*model.User, error) {
+	// 1. 先查用户名索引
+	if l.index != nil {
+		id, err := l.index.GetUserIDByUsernameCtx(ctx, username)
+		switch {
+		case err == nil:
+			l.metrics.Hit()
+			user, err := l.verify(ctx, username, id, getByID)
+			if err == nil || !errors.Is(err, ErrUserNotFound) {
+				return user, err
+			}
+			// 索引指向的用户已改名或被删除，删除旧索引后查询数据库
+			log.Printf("[用户名索引失效] username=%s, user_id=%d", username, id)
+			l.unbind(ctx, username)
+		case errors.Is(err, cache.ErrNullCache):
+			l.metrics.NullHit()
+			log.Printf("[用户名空值缓存命中] username=%s", username)
+			return nil, fmt.Errorf("%w: username=%s", ErrUserNotFound, username)
+		case errors.Is(err, cache.ErrCacheMiss):
+			l.metrics.Miss()
+		default:
+			l.metrics.Miss()
+			l.metrics.Error(metrics.OpGet)
+			log.Printf("[用户名索引读取失败] username=%s, error=%v (查询数据库)", username, err)
+		}
+	}
+
+	// 2. 索引未命中，查数据库
+	log.Printf("[用户名索引未命中] username=%s, 查询数据库", username)
+	start := time.Now()
+	found, err := l.repo.FindByUsernameCtx(ctx, username)
+	l.metrics.Load(time.Since(start))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if l.index != nil {
+				if err := l.index.SetNullUsernameCtx(ctx, username); err != nil {
+					l.metrics.Error(metrics.OpSetNull)
+					log.Printf("[用户名空值缓存写入失败] username=%s, error=%v (不影响返回结果)", username, err)
+				}
+			}
+			return nil, fmt.Errorf("%w: username=%s", ErrUserNotFound, username)
+		}
+		l.metrics.Error(metrics.OpLoad)
+		log.Printf("[数据库查询失败] username=%s, error=%v", username, err)
+		return nil, fmt.Errorf("查询用户失败: %w", err)
+	}
+
+	// 3. 再按ID读取（走缓存，并以缓存中的数据为准，Write-Behind 模式下数据库可能落后于缓存）
+	user, err := l.verify(ctx, username, found.ID, getByID)
+	if err != nil {
+		return nil, err
+	}
+	l.bind(ctx, username, user.ID)
+	return user, nil
+}
+
+// verify 按ID取回用户并核对用户名（MySQL默认排序规则不区分大小写，所以用 EqualFold）
+func (l usernameLookup) verify(ctx context.Context, username string, id int64, getByID func(ctx context.Context, id int64) (*model.User, error)) (*model.User, error) {
+	user, err := getByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(user.Username, username) {
+		return nil, fmt.Errorf("%w: username=%s", ErrUserNotFound, username)
+	}
+	return user, nil
+}
+
+// usernameOf 写操作之前查出用户当前的用户名（用于删除旧索引），查询失败返回空字符串
+// 先读缓存，缓存未命中时才调用 find：每次更新、删除都查一次数据库太浪费。
+// 缓存中的用户名可能是旧的（缓存更新失败），漏删的索引在按用户名查询时核对用户名发现并删除
+// find 一般是 repo.FindByIDCtx（不回填缓存）；Write-Behind 模式下数据库可能落后于缓存，传入 GetUserByIDCtx
+func (l usernameLookup) usernameOf(ctx context.Context, id int64, find func(ctx context.Context, id int64) (*model.User, error)) string {
+	if l.index == nil {
+		return ""
+	}
+	if l.users != nil {
+		user, err := l.users.GetUserCtx(ctx, id)
+		if err == nil {
+			return user.Username
+		}
+		if errors.Is(err, cache.ErrNullCache) {
+			// 用户不存在
+			return ""
+		}
+	}
+	user, err := find(ctx, id)
+	if err != nil {
+		return ""
+	}
+	return user.Username
+}
+
+// renamed 用户更新成功后维护索引：用户名变化时删除旧索引，并把新用户名指向该用户（覆盖可能存在的空值缓存）
+// 只有大小写变化时索引Key相同（见 cache.UsernameIndexKeyPrefix），不需要修改
+func (l usernameLookup) renamed(ctx context.Context, oldUsername string, user *model.User) {
+	if oldUsername == "" || strings.EqualFold(oldUsername, user.Username) {
+		return
+	}
+	log.Printf("[用户名变更] user_id=%d, %s -> %s", user.ID, oldUsername, user.Username)
+	l.unbind(ctx, oldUsername)
+	l.bind(ctx, user.Username, user.ID)
+}
+
+// bind 写入用户名索引，失败只记录日志（读取时会核对用户名）
+func (l usernameLookup) bind(ctx context.Context, username string, id int64) {
+	if l.index == nil {
+		return
+	}
+	if err := l.index.SetUsernameIndexCtx(ctx, username, id, cache.DefaultExpireSeconds); err != nil {
+		l.metrics.Error(metrics.OpSet)
+		log.Printf("[用户名索引写入失败] username=%s, user_id=%d, error=%v (不影响业务逻辑)", username, id, err)
+	}
+}
+
+// unbind 删除用户名索引，失败只记录日志（读取时会核对用户名）
+func (l usernameLookup) unbind(ctx context.Context, username string) {
+	if l.index == nil || username == "" {
+		return
+	}
+	if err := l.index.DeleteUsernameIndexCtx(ctx, username); err != nil {
+		l.metrics.Error(metrics.OpDelete)
+		log.Printf("[用户名索引删除失败] username=%s, error=%v (不影响业务逻辑)", username, err)
+	}
+}
@@ -0,0 +1,520 @@
+package service
+
+import (
+	"cache-demo/cache"
+	"cache-demo/metrics"
+	"cache-demo/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	"gorm.io/gorm"
+)
+
+const (
+	// WriteBehindQueueKey 待刷盘写操作的持久化队列（Redis List）
+	WriteBehindQueueKey = "write_behind:user:queue"
+	// WriteBehindDeadLetterKey 刷盘永久失败的写操作（死信队列，Redis List），人工处理后可以重新放回写队列
+	WriteBehindDeadLetterKey = "write_behind:user:dead"
+	// WriteBehindPoisonedKey 有写操作进入死信队列的用户ID（Redis Set），人工处理完死信后从集合中移除
+	WriteBehindPoisonedKey = "write_behind:user:poisoned"
+	// WriteBehindTombstonePrefix 已删除、还没有刷盘的用户（删除墓碑，write_behind:user:deleted:<id>）
+	WriteBehindTombstonePrefix = "write_behind:user:deleted:"
+	// WriteBehindTombstoneExpire 删除墓碑的过期时间，要比刷盘最长可能的延迟长得多（刷盘成功后会主动删除）
+	WriteBehindTombstoneExpire = 24 * time.Hour
+	// WriteBehindFlushInterval 默认刷盘间隔
+	WriteBehindFlushInterval = time.Second
+	// WriteBehindBatchSize 默认每批刷盘的最大写操作数
+	WriteBehindBatchSize = 100
+)
+
+// ErrUserPoisoned 用户有写操作在死信队列中，人工处理之前不再接受更新
+// 死信之后的更新期望的版本号数据库中永远不会出现，入队也只会一个接一个进入死信队列
+var ErrUserPoisoned = errors.New("用户有写操作在死信队列中，暂时不能更新")
+
+const (
+	writeOpUpsert = "upsert"
+	writeOpDelete = "delete"
+)
+
+// writeOp 队列中的一条写操作
+// 更新操作的 User.Version 是更新后的版本号，Expected 是更新前的版本号（刷盘时要求数据库中的版本号等于 Expected）
+type writeOp struct {
+	Op       string      `json:"op"`
+	ID       int64       `json:"id"`
+	Expected int64       `json:"expected,omitempty"`
+	User     *model.User `json:"user,omitempty"`
+}
+
+// enqueueDeleteScript 写入删除墓碑，并把删除操作追加到写队列
+// KEYS[1] 墓碑，KEYS[2] 写队列；ARGV[1] 墓碑的过期时间（秒），ARGV[2] 写操作
+const enqueueDeleteScript = `
+redis.call('SET', KEYS[1], '1', 'EX', ARGV[1])
+return redis.call('RPUSH', KEYS[2], ARGV[2])
+`
+
+// deadLetterScript 把写操作移到死信队列，并把用户加入死信用户集合
+// KEYS[1] 死信队列，KEYS[2] 死信用户集合；ARGV[1] 死信，ARGV[2] 用户ID
+const deadLetterScript = `
+redis.call('RPUSH', KEYS[1], ARGV[1])
+return redis.call('SADD', KEYS[2], ARGV[2])
+`
+
+// deadLetter 死信队列中的一条写操作：原来的写操作 + 失败原因
+type deadLetter struct {
+	writeOp
+	Error    string `json:"error"`
+	FailedAt int64  `json:"failed_at"` // Unix 毫秒
+}
+
+// WriteBehindUserService Write-Behind 模式的用户服务接口
+type WriteBehindUserService interface {
+	UserService
+	// Flush 立即刷一批写操作到数据库
+	Flush() error
+	// Close 停止后台刷盘并把队列中剩余的写操作全部刷到数据库
+	Close() error
+}
+
+// userServiceWriteBehind Write-Behind 模式的用户服务实现
+// 写操作只写Redis（缓存 + 持久化队列）后立即返回，后台按批次合并后写入数据库
+// 注意：同一个队列只能有一个实例负责刷盘
+type userServiceWriteBehind struct {
+	repo      model.UserRepo
+	cache     cache.UserCacheWithPenetration
+	rds       *redis.Redis
+	reader    *cache.UserReadThrough
+	interval  time.Duration
+	batchSize int
+
+	flushLock sync.Mutex
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+	names     usernameLookup
+}
+
+// NewWriteBehindUserService 创建 Write-Behind 模式的用户服务实例，并启动后台刷盘协程
+// 删除操作会在缓存中写入空值标记和删除墓碑，防止刷盘前读请求从数据库读到已删除的用户
+func NewWriteBehindUserService(repo model.UserRepo, cache cache.UserCacheWithPenetration, rds *redis.Redis,
+	flushInterval time.Duration, batchSize int) WriteBehindUserService {
+	if flushInterval <= 0 {
+		flushInterval = WriteBehindFlushInterval
+	}
+	if batchSize <= 0 {
+		batchSize = WriteBehindBatchSize
+	}
+
+	// 读数据库时跳过有删除墓碑的用户（空值标记过期、被淘汰后不会读到还没刷盘删除的用户）
+	visible := &tombstoneUserRepo{UserRepo: repo, rds: rds}
+	s := &userServiceWriteBehind{
+		repo:      repo,
+		cache:     cache,
+		rds:       rds,
+		reader:    newUserReadThrough(visible, cache),
+		interval:  flushInterval,
+		batchSize: batchSize,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		names:     newUsernameLookup(visible, cache),
+	}
+	go s.flushLoop()
+
+	return s
+}
+
+// GetUserByID 根据ID获取用户（缓存是权威数据源，数据库可能还没有最新数据）
+// 已删除但未刷盘的用户在缓存中是空值标记，读穿透缓存会直接返回不存在；空值标记过期后有删除墓碑，也不会从数据库读到旧数据
+func (s *userServiceWriteBehind) GetUserByID(id int64) (*model.User, error) {
+	return s.GetUserByIDCtx(context.Background(), id)
+}
+
+// GetUserByIDCtx 根据ID获取用户（缓存是权威数据源，数据库可能还没有最新数据）
+func (s *userServiceWriteBehind) GetUserByIDCtx(ctx context.Context, id int64) (*model.User, error) {
+	return readUser(ctx, s.reader, id)
+}
+
+// GetUsersByIDs 批量获取用户
+func (s *userServiceWriteBehind) GetUsersByIDs(ids []int64) ([]*model.User, []int64, error) {
+	return s.GetUsersByIDsCtx(context.Background(), ids)
+}
+
+// GetUsersByIDsCtx 批量获取用户
+func (s *userServiceWriteBehind) GetUsersByIDsCtx(ctx context.Context, ids []int64) ([]*model.User, []int64, error) {
+	return readUsers(ctx, s.reader, ids)
+}
+
+// GetUserByUsername 根据用户名获取用户（用户名二级索引 -> 按ID查询）
+func (s *userServiceWriteBehind) GetUserByUsername(username string) (*model.User, error) {
+	return s.GetUserByUsernameCtx(context.Background(), username)
+}
+
+// GetUserByUsernameCtx 根据用户名获取用户（用户名二级索引 -> 按ID查询）
+func (s *userServiceWriteBehind) GetUserByUsernameCtx(ctx context.Context, username string) (*model.User, error) {
+	return s.names.get(ctx, username, s.GetUserByIDCtx)
+}
+
+// CreateUser 创建用户
+// 用户ID由数据库自增生成，所以创建操作同步写数据库，再写缓存
+func (s *userServiceWriteBehind) CreateUser(user *model.User) error {
+	return s.CreateUserCtx(context.Background(), user)
+}
+
+// CreateUserCtx 创建用户
+func (s *userServiceWriteBehind) CreateUserCtx(ctx context.Context, user *model.User) error {
+	if err := s.repo.CreateCtx(ctx, user); err != nil {
+		return fmt.Errorf("创建用户失败: %w", err)
+	}
+
+	if err := setUserCache(ctx, s.cache, user, cache.DefaultExpireSeconds); err != nil {
+		log.Printf("[缓存写入失败] user_id=%d, error=%v (不影响业务逻辑)", user.ID, err)
+	}
+
+	s.names.bind(ctx, user.Username, user.ID)
+
+	return nil
+}
+
+// UpdateUser 更新用户（写缓存和队列后立即返回）
+func (s *userServiceWriteBehind) UpdateUser(user *model.User) error {
+	return s.UpdateUserCtx(context.Background(), user)
+}
+
+// UpdateUserCtx 更新用户（写缓存和队列后立即返回）
+// 缓存是最新数据，乐观锁在缓存上检查：缓存中的版本号等于 user.Version 时写入 user.Version+1 并入队，
+// 否则返回 *model.VersionConflictError。两个请求读到同一个版本时只有一个成功
+// 比较、写缓存、入队在同一个Lua脚本中执行：不会出现缓存已经是新版本、写操作却没有入队（数据库永远停在旧版本，
+// 之后的写操作刷盘时全部版本冲突）的情况
+// 更新成功后 user.Version 加1，和缓存一致
+func (s *userServiceWriteBehind) UpdateUserCtx(ctx context.Context, user *model.User) error {
+	// 读取当前版本（缓存未命中时回填），旧用户名也从这里取
+	current, err := s.GetUserByIDCtx(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if current.Version != user.Version {
+		return &model.VersionConflictError{ID: user.ID, Expected: user.Version, Current: current.Version}
+	}
+	poisoned, err := s.rds.SismemberCtx(ctx, WriteBehindPoisonedKey, user.ID)
+	if err != nil {
+		return fmt.Errorf("读取死信用户失败: %w", err)
+	}
+	if poisoned {
+		return fmt.Errorf("%w: user_id=%d", ErrUserPoisoned, user.ID)
+	}
+
+	next := *user
+	next.Version = user.Version + 1
+	next.UpdatedAt = time.Now()
+	data, err := json.Marshal(writeOp{Op: writeOpUpsert, ID: user.ID, Expected: user.Version, User: &next})
+	if err != nil {
+		return fmt.Errorf("序列化写操作失败: %w", err)
+	}
+	// 比较并写入不重试：第一次可能已经写入成功（缓存和队列都已写入），重试会被自己的写入判为冲突
+	cached, err := s.cache.CompareAndSetUserCtx(ctx, &next, user.Version, cache.DefaultExpireSeconds,
+		cache.QueuedWrite{Queue: WriteBehindQueueKey, Item: string(data), Tombstone: tombstoneKey(user.ID)})
+	switch {
+	case errors.Is(err, cache.ErrVersionMismatch):
+		return &model.VersionConflictError{ID: user.ID, Expected: user.Version, Current: cached}
+	case errors.Is(err, cache.ErrNullCache):
+		// 读取之后被删除
+		return fmt.Errorf("%w: user_id=%d", ErrUserNotFound, user.ID)
+	case err != nil:
+		return fmt.Errorf("写入缓存失败: %w", err)
+	}
+
+	user.Version = next.Version
+	user.UpdatedAt = next.UpdatedAt
+	s.names.renamed(ctx, current.Username, user)
+
+	return nil
+}
+
+// DeleteUser 删除用户（写空值标记、删除墓碑和队列后立即返回）
+func (s *userServiceWriteBehind) DeleteUser(id int64) error {
+	return s.DeleteUserCtx(context.Background(), id)
+}
+
+// DeleteUserCtx 删除用户（写空值标记、删除墓碑和队列后立即返回）
+// 空值标记的过期时间很短，还可能被淘汰，所以另外写一个删除墓碑，和删除操作在同一个Lua脚本中入队，刷盘删除成功后才删除墓碑
+func (s *userServiceWriteBehind) DeleteUserCtx(ctx context.Context, id int64) error {
+	oldUsername := s.names.usernameOf(ctx, id, s.GetUserByIDCtx)
+
+	if err := retryCacheWrite(ctx, metrics.OpSetNull, func(ctx context.Context) error {
+		return s.cache.SetNullUserCtx(ctx, id)
+	}); err != nil {
+		return fmt.Errorf("写入删除标记失败: %w", err)
+	}
+	if err := s.enqueueDelete(ctx, id); err != nil {
+		return err
+	}
+
+	s.names.unbind(ctx, oldUsername)
+
+	return nil
+}
+
+// Flush 从队列头部取一批写操作，按用户ID合并后写入数据库（同一个用户有删除时只执行删除）
+// 全部写入成功后才从队列中删除这一批（至少一次语义，重复刷盘时跳过已经写入的版本，见 apply）
+// 重试也不会成功的写操作（唯一键冲突等）移到死信队列，不会堵住后面的写操作；这个用户之后的更新也直接进入死信队列
+func (s *userServiceWriteBehind) Flush() error {
+	_, err := s.flushBatch()
+	return err
+}
+
+// Close 停止后台刷盘，并把队列中剩余的写操作全部刷到数据库
+func (s *userServiceWriteBehind) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+
+	for {
+		n, err := s.flushBatch()
+		if err != nil {
+			return fmt.Errorf("关闭时刷盘失败: %w", err)
+		}
+		if n == 0 {
+			log.Println("[Write-Behind] 队列已清空，刷盘协程退出")
+			return nil
+		}
+	}
+}
+
+// flushLoop 后台定时刷盘
+func (s *userServiceWriteBehind) flushLoop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				log.Printf("[刷盘失败] error=%v (下次重试)", err)
+			}
+		}
+	}
+}
+
+// flushBatch 刷一批写操作，返回本批处理的写操作数
+func (s *userServiceWriteBehind) flushBatch() (int, error) {
+	s.flushLock.Lock()
+	defer s.flushLock.Unlock()
+
+	items, err := s.rds.Lrange(WriteBehindQueueKey, 0, s.batchSize-1)
+	if err != nil {
+		return 0, fmt.Errorf("读取写队列失败: %w", err)
+	}
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	// 按用户ID合并：同一个用户在一批中的多次更新只保留最后一次，期望的版本号取第一次的
+	// 一批中有删除时只执行删除：删除之后的更新（并发的更新和删除）不能把已删除的用户写回数据库
+	latest := make(map[int64]writeOp, len(items))
+	order := make([]int64, 0, len(items))
+	for _, item := range items {
+		var op writeOp
+		if err := json.Unmarshal([]byte(item), &op); err != nil {
+			log.Printf("[丢弃无法解析的写操作] item=%s, error=%v", item, err)
+			continue
+		}
+		prev, ok := latest[op.ID]
+		if !ok {
+			order = append(order, op.ID)
+		}
+		if ok && prev.Op == writeOpDelete && op.Op != writeOpDelete {
+			log.Printf("[丢弃已删除用户的写操作] op=%s, user_id=%d", op.Op, op.ID)
+			continue
+		}
+		if ok && prev.Op == writeOpUpsert && op.Op == writeOpUpsert {
+			op.Expected = prev.Expected
+		}
+		latest[op.ID] = op
+	}
+
+	dead := 0
+	for _, id := range order {
+		op := latest[id]
+		if op.Op != writeOpDelete {
+			poisoned, err := s.rds.Sismember(WriteBehindPoisonedKey, id)
+			if err != nil {
+				return 0, fmt.Errorf("读取死信用户失败: %w", err)
+			}
+			if poisoned {
+				// 之前的写操作在死信队列中，数据库停在更早的版本，这次更新一定会版本冲突，和之前的死信一起人工处理
+				if err := s.deadLetter(op, ErrUserPoisoned); err != nil {
+					return 0, err
+				}
+				dead++
+				continue
+			}
+		}
+
+		err := s.apply(op)
+		if err == nil {
+			continue
+		}
+		if !isPermanentFlushError(err) {
+			// 临时故障、表结构错误等：这一批留在队列中，下次重试（修复之前刷盘一直停在这里）
+			return 0, err
+		}
+		if err := s.deadLetter(op, err); err != nil {
+			return 0, err
+		}
+		dead++
+	}
+
+	if err := s.rds.Ltrim(WriteBehindQueueKey, int64(len(items)), -1); err != nil {
+		return 0, fmt.Errorf("删除已刷盘的写操作失败: %w", err)
+	}
+
+	log.Printf("[刷盘成功] 写操作=%d, 合并后=%d, 死信=%d", len(items), len(order), dead)
+	return len(items), nil
+}
+
+// deadLetter 把永久失败的写操作移到死信队列，并标记这个用户（之后的更新不再刷盘，也不再接受）
+func (s *userServiceWriteBehind) deadLetter(op writeOp, cause error) error {
+	data, err := json.Marshal(deadLetter{writeOp: op, Error: cause.Error(), FailedAt: time.Now().UnixMilli()})
+	if err != nil {
+		return fmt.Errorf("序列化死信失败: %w", err)
+	}
+	_, err = s.rds.Eval(deadLetterScript, []string{WriteBehindDeadLetterKey, WriteBehindPoisonedKey}, string(data), op.ID)
+	if err != nil {
+		return fmt.Errorf("写入死信队列失败: %w", err)
+	}
+	log.Printf("[刷盘失败，移到死信队列] op=%s, user_id=%d, error=%v", op.Op, op.ID, cause)
+	return nil
+}
+
+// permanentMySQLErrors MySQL 拒绝了数据本身的错误码，这条写操作重试多少次都一样
+var permanentMySQLErrors = map[uint16]bool{
+	1048: true, // 列不能为 NULL
+	1062: true, // 唯一键冲突
+	1264: true, // 数值超出范围
+	1366: true, // 值的格式不正确（字符集等）
+	1406: true, // 数据过长
+}
+
+// isPermanentFlushError 重试也不会成功的刷盘错误：MySQL 拒绝了数据本身（见 permanentMySQLErrors），
+// 数据库中的版本号和写操作期望的不同
+// 其他错误都按临时故障处理，留在队列中下次重试：连接断开、超时、死锁、熔断器打开，以及表结构错误（1054 列不存在、
+// 1146 表不存在等，修复表结构后这些写操作都能成功，不能移到死信队列）和不认识的错误
+func isPermanentFlushError(err error) bool {
+	if errors.Is(err, model.ErrVersionConflict) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && permanentMySQLErrors[mysqlErr.Number]
+}
+
+// apply 把一条写操作写入数据库
+// 更新要求数据库中的版本号等于 op.Expected，写入后数据库的版本号和缓存相同（op.User.Version）：
+// 1. 数据库中的版本号不小于 op.User.Version：这次更新已经写入过（刷盘成功后没来得及从队列中删除），跳过
+// 2. 其他版本号不同的情况（之前的更新移到了死信队列、数据库被直接修改）：返回版本冲突，移到死信队列
+// 3. 用户已经被删除：跳过
+func (s *userServiceWriteBehind) apply(op writeOp) error {
+	switch op.Op {
+	case writeOpUpsert:
+		err := s.repo.UpdateVersion(op.User, op.Expected)
+		var conflict *model.VersionConflictError
+		if errors.As(err, &conflict) && conflict.Current >= op.User.Version {
+			log.Printf("[刷盘跳过] user_id=%d, version=%d (已经写入过)", op.ID, op.User.Version)
+			return nil
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("[刷盘跳过] user_id=%d (用户已被删除)", op.ID)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("刷盘更新用户失败: user_id=%d, %w", op.ID, err)
+		}
+	case writeOpDelete:
+		if err := s.repo.Delete(op.ID); err != nil {
+			return fmt.Errorf("刷盘删除用户失败: user_id=%d, %w", op.ID, err)
+		}
+		// 数据库中已经删除，不再需要墓碑（删除失败时等它过期）；用户已经不存在，也不用再拦截它的更新
+		if _, err := s.rds.Del(tombstoneKey(op.ID)); err != nil {
+			log.Printf("[删除墓碑失败] user_id=%d, error=%v", op.ID, err)
+		}
+		if _, err := s.rds.Srem(WriteBehindPoisonedKey, op.ID); err != nil {
+			log.Printf("[移除死信用户失败] user_id=%d, error=%v", op.ID, err)
+		}
+	default:
+		log.Printf("[未知的写操作] op=%s, user_id=%d", op.Op, op.ID)
+	}
+	return nil
+}
+
+// enqueueDelete 写入删除墓碑并把删除操作追加到持久化队列（同一个Lua脚本）
+// 空值标记已经写入，调用方超时或取消后也要入队，否则删除永远不会写到数据库
+func (s *userServiceWriteBehind) enqueueDelete(ctx context.Context, id int64) error {
+	data, err := json.Marshal(writeOp{Op: writeOpDelete, ID: id})
+	if err != nil {
+		return fmt.Errorf("序列化写操作失败: %w", err)
+	}
+	_, err = s.rds.EvalCtx(context.WithoutCancel(ctx), enqueueDeleteScript,
+		[]string{tombstoneKey(id), WriteBehindQueueKey}, int(WriteBehindTombstoneExpire/time.Second), string(data))
+	if err != nil {
+		return fmt.Errorf("写入写队列失败: %w", err)
+	}
+	return nil
+}
+
+// tombstoneKey 用户的删除墓碑Key
+func tombstoneKey(id int64) string {
+	return fmt.Sprintf("%s%d", WriteBehindTombstonePrefix, id)
+}
+
+// tombstoneUserRepo 有删除墓碑的用户按不存在处理（已经删除、还没有刷盘到数据库）
+type tombstoneUserRepo struct {
+	model.UserRepo
+	rds *redis.Redis
+}
+
+// FindByIDCtx 根据ID查询用户，有删除墓碑时返回 gorm.ErrRecordNotFound
+func (r *tombstoneUserRepo) FindByIDCtx(ctx context.Context, id int64) (*model.User, error) {
+	deleted, err := r.rds.ExistsCtx(ctx, tombstoneKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("查询删除墓碑失败: %w", err)
+	}
+	if deleted {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return r.UserRepo.FindByIDCtx(ctx, id)
+}
+
+// FindByIDsCtx 根据ID批量查询用户，有删除墓碑的用户不出现在结果中
+func (r *tombstoneUserRepo) FindByIDsCtx(ctx context.Context, ids []int64) ([]*model.User, error) {
+	if len(ids) == 0 {
+		return r.UserRepo.FindByIDsCtx(ctx, ids)
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = tombstoneKey(id)
+	}
+	marks, err := r.rds.MgetCtx(ctx, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("查询删除墓碑失败: %w", err)
+	}
+	alive := make([]int64, 0, len(ids))
+	for i, id := range ids {
+		if marks[i] == "" {
+			alive = append(alive, id)
+		}
+	}
+	if len(alive) == 0 {
+		return nil, nil
+	}
+	return r.UserRepo.FindByIDsCtx(ctx, alive)
+}
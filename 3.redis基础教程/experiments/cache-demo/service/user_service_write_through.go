@@ -0,0 +1,141 @@
+package service
+
+import (
+	"cache-demo/cache"
+	"cache-demo/metrics"
+	"cache-demo/model"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// userServiceWriteThrough Write-Through 模式的用户服务实现
+// 写操作在同一个调用链路中同步写数据库和缓存，缓存是读请求的权威数据源
+type userServiceWriteThrough struct {
+	repo   model.UserRepo
+	cache  cache.UserCache
+	reader *cache.UserReadThrough
+	names  usernameLookup
+}
+
+// NewWriteThroughUserService 创建 Write-Through 模式的用户服务实例
+func NewWriteThroughUserService(repo model.UserRepo, cache cache.UserCache) UserService {
+	return &userServiceWriteThrough{
+		repo:   repo,
+		cache:  cache,
+		reader: newUserReadThrough(repo, cache),
+		names:  newUsernameLookup(repo, cache),
+	}
+}
+
+// GetUserByID 根据ID获取用户
+// 写操作会同步写缓存，所以缓存未命中只发生在数据过期或首次访问时
+func (s *userServiceWriteThrough) GetUserByID(id int64) (*model.User, error) {
+	return s.GetUserByIDCtx(context.Background(), id)
+}
+
+// GetUserByIDCtx 根据ID获取用户
+func (s *userServiceWriteThrough) GetUserByIDCtx(ctx context.Context, id int64) (*model.User, error) {
+	return readUser(ctx, s.reader, id)
+}
+
+// GetUsersByIDs 批量获取用户
+func (s *userServiceWriteThrough) GetUsersByIDs(ids []int64) ([]*model.User, []int64, error) {
+	return s.GetUsersByIDsCtx(context.Background(), ids)
+}
+
+// GetUsersByIDsCtx 批量获取用户
+func (s *userServiceWriteThrough) GetUsersByIDsCtx(ctx context.Context, ids []int64) ([]*model.User, []int64, error) {
+	return readUsers(ctx, s.reader, ids)
+}
+
+// GetUserByUsername 根据用户名获取用户（用户名二级索引 -> 按ID查询）
+func (s *userServiceWriteThrough) GetUserByUsername(username string) (*model.User, error) {
+	return s.GetUserByUsernameCtx(context.Background(), username)
+}
+
+// GetUserByUsernameCtx 根据用户名获取用户（用户名二级索引 -> 按ID查询）
+func (s *userServiceWriteThrough) GetUserByUsernameCtx(ctx context.Context, username string) (*model.User, error) {
+	return s.names.get(ctx, username, s.GetUserByIDCtx)
+}
+
+// CreateUser 创建用户（同步写数据库和缓存）
+func (s *userServiceWriteThrough) CreateUser(user *model.User) error {
+	return s.CreateUserCtx(context.Background(), user)
+}
+
+// CreateUserCtx 创建用户（同步写数据库和缓存）
+func (s *userServiceWriteThrough) CreateUserCtx(ctx context.Context, user *model.User) error {
+	if err := s.repo.CreateCtx(ctx, user); err != nil {
+		return fmt.Errorf("创建用户失败: %w", err)
+	}
+
+	s.writeCache(ctx, user)
+	s.names.bind(ctx, user.Username, user.ID)
+
+	return nil
+}
+
+// UpdateUser 更新用户（同步写数据库和缓存）
+func (s *userServiceWriteThrough) UpdateUser(user *model.User) error {
+	return s.UpdateUserCtx(context.Background(), user)
+}
+
+// UpdateUserCtx 更新用户（同步写数据库和缓存）
+func (s *userServiceWriteThrough) UpdateUserCtx(ctx context.Context, user *model.User) error {
+	oldUsername := s.names.usernameOf(ctx, user.ID, s.repo.FindByIDCtx)
+
+	if err := s.repo.UpdateCtx(ctx, user); err != nil {
+		return fmt.Errorf("更新用户失败: %w", err)
+	}
+
+	s.writeCache(ctx, user)
+	s.names.renamed(ctx, oldUsername, user)
+
+	return nil
+}
+
+// DeleteUser 删除用户（同步删除数据库和缓存）
+func (s *userServiceWriteThrough) DeleteUser(id int64) error {
+	return s.DeleteUserCtx(context.Background(), id)
+}
+
+// DeleteUserCtx 删除用户（同步删除数据库和缓存）
+func (s *userServiceWriteThrough) DeleteUserCtx(ctx context.Context, id int64) error {
+	oldUsername := s.names.usernameOf(ctx, id, s.repo.FindByIDCtx)
+
+	if err := s.repo.DeleteCtx(ctx, id); err != nil {
+		return fmt.Errorf("删除用户失败: %w", err)
+	}
+
+	if err := deleteUserCache(ctx, s.cache, id); err != nil {
+		return fmt.Errorf("删除缓存失败: %w", err)
+	}
+
+	s.names.unbind(ctx, oldUsername)
+
+	return nil
+}
+
+// writeCache 同步写缓存
+// 数据库已经提交，写缓存失败不能再让调用方当作写入失败（重试创建会重复创建，重试更新会版本冲突）：
+// 删除旧缓存让下次读取回源数据库，记录日志和指标后照常返回
+// 缓存中已经有更新的版本时（更晚的更新先写入了缓存）不覆盖，也不删除
+func (s *userServiceWriteThrough) writeCache(ctx context.Context, user *model.User) {
+	err := setUserCache(ctx, s.cache, user, cache.DefaultExpireSeconds)
+	if err == nil {
+		return
+	}
+	if errors.Is(err, cache.ErrStaleVersion) {
+		log.Printf("[跳过旧版本缓存] user_id=%d, version=%d (缓存中已有更新的版本)", user.ID, user.Version)
+		return
+	}
+
+	userCacheMetrics().Error(metrics.OpSet)
+	log.Printf("[缓存写入失败] user_id=%d, error=%v, 删除旧缓存 (不影响业务逻辑)", user.ID, err)
+	if delErr := deleteUserCache(ctx, s.cache, user.ID); delErr != nil {
+		userCacheMetrics().Error(metrics.OpDelete)
+		log.Printf("[旧缓存删除失败] user_id=%d, error=%v (缓存过期前可能读到旧数据)", user.ID, delErr)
+	}
+}
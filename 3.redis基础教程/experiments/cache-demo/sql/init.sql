@@ -9,6 +9,7 @@ CREATE TABLE IF NOT EXISTS `users` (
     `username` VARCHAR(50) NOT NULL COMMENT '用户名',
     `email` VARCHAR(100) NOT NULL COMMENT '邮箱',
     `age` INT(11) DEFAULT 0 COMMENT '年龄',
+    `version` BIGINT(20) NOT NULL DEFAULT 0 COMMENT '乐观锁版本号，每次更新加1',
     `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     `updated_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     PRIMARY KEY (`id`),
@@ -16,6 +17,22 @@ CREATE TABLE IF NOT EXISTS `users` (
     KEY `email` (`email`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='用户表';
 
+-- 已有的用户表增加版本号：执行下面的语句（只执行一次），或者直接运行 go run main.go（启动时 AutoMigrate 会自动添加）
+-- ALTER TABLE `users` ADD COLUMN `version` BIGINT(20) NOT NULL DEFAULT 0 COMMENT '乐观锁版本号，每次更新加1' AFTER `age`;
+
+-- 创建库存流水表（inventory 包每次成功修改Redis中的可用库存都记录一条，用于对账）
+CREATE TABLE IF NOT EXISTS `inventory_ledger` (
+    `id` BIGINT(20) NOT NULL AUTO_INCREMENT,
+    `order_id` VARCHAR(64) NOT NULL COMMENT '订单ID（设置库存时为 set:商品ID:时间戳）',
+    `product_id` VARCHAR(64) NOT NULL COMMENT '商品ID',
+    `action` VARCHAR(16) NOT NULL COMMENT '动作: set/reserve/confirm/cancel/expire',
+    `delta` BIGINT(20) NOT NULL COMMENT '可用库存的变化量',
+    `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uk_order_action` (`order_id`, `action`),
+    KEY `product_id` (`product_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='库存流水表';
+
 -- 插入测试数据
 INSERT INTO `users` (`username`, `email`, `age`) VALUES
 ('alice', 'alice@example.com', 25),
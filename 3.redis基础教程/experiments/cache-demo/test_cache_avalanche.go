@@ -2,13 +2,13 @@ package main
 
 import (
 	"cache-demo/cache"
+	"cache-demo/metrics"
 	"cache-demo/model"
 	"cache-demo/service"
 	"fmt"
 	"log"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/zeromicro/go-zero/core/conf"
@@ -37,41 +37,6 @@ type Config struct {
 	} `json:"redis" yaml:"redis"`
 }
 
-// DBQueryStats 数据库查询统计
-type DBQueryStats struct {
-	TotalQueries int64
-	QueryTimes   []time.Time
-	mu           sync.Mutex
-}
-
-func (s *DBQueryStats) AddQuery() {
-	atomic.AddInt64(&s.TotalQueries, 1)
-	s.mu.Lock()
-	s.QueryTimes = append(s.QueryTimes, time.Now())
-	s.mu.Unlock()
-}
-
-func (s *DBQueryStats) GetQPSInWindow(windowSeconds int) float64 {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if len(s.QueryTimes) == 0 {
-		return 0
-	}
-
-	now := time.Now()
-	windowStart := now.Add(-time.Duration(windowSeconds) * time.Second)
-
-	count := 0
-	for _, t := range s.QueryTimes {
-		if t.After(windowStart) {
-			count++
-		}
-	}
-
-	return float64(count) / float64(windowSeconds)
-}
-
 func main() {
 	// 加载配置
 	var c Config
@@ -124,9 +89,6 @@ func testAvalancheProblem(repo model.UserRepo, rds *redis.Redis, title string) {
 	userCache := cache.NewUserCacheWithAvalanche(rds)
 	userService := service.NewUserServiceWithAvalanche(repo, userCache, service.FixedExpire, cache.AvalancheBaseExpireSeconds)
 
-	// 统计数据库查询
-	stats := &DBQueryStats{}
-
 	// 步骤1：批量加载用户到缓存（固定过期时间60秒）
 	fmt.Println("[步骤1] 批量加载10个用户到缓存（固定过期时间60秒）")
 	userIDs := make([]int64, 0, 10)
@@ -152,6 +114,8 @@ func testAvalancheProblem(repo model.UserRepo, rds *redis.Redis, title string) {
 
 	// 步骤3：模拟大量并发请求（缓存雪崩）
 	fmt.Println("\n[步骤3] 模拟100个并发请求查询用户（缓存雪崩）")
+	userMetrics := metrics.For(cache.UserCacheName)
+	before := userMetrics.Snapshot()
 	startTime := time.Now()
 
 	var wg sync.WaitGroup
@@ -166,23 +130,22 @@ func testAvalancheProblem(repo model.UserRepo, rds *redis.Redis, title string) {
 			queryStart := time.Now()
 			_, err := userService.GetUserByID(userID)
 			queryDuration := time.Since(queryStart)
-			if err == nil {
-				stats.AddQuery()
-				if requestID < 5 {
-					fmt.Printf("  [请求%d] 查询用户ID %d (耗时: %v)\n", requestID+1, userID, queryDuration)
-				}
+			if err == nil && requestID < 5 {
+				fmt.Printf("  [请求%d] 查询用户ID %d (耗时: %v)\n", requestID+1, userID, queryDuration)
 			}
 		}(i)
 	}
 
 	wg.Wait()
 	totalDuration := time.Since(startTime)
+	stats := userMetrics.Snapshot().Sub(before)
 
 	fmt.Printf("\n[统计结果]\n")
 	fmt.Printf("  并发请求数: %d\n", concurrentRequests)
-	fmt.Printf("  数据库查询次数: %d\n", stats.TotalQueries)
+	fmt.Printf("  缓存命中: %d, 未命中: %d\n", stats.Hits, stats.Misses)
+	fmt.Printf("  数据库查询次数: %d\n", stats.Loads)
 	fmt.Printf("  总耗时: %v\n", totalDuration)
-	fmt.Printf("  数据库QPS峰值: %.2f\n", stats.GetQPSInWindow(1))
+	fmt.Printf("  数据库QPS峰值: %d\n", userMetrics.PeakLoadQPS(startTime))
 	fmt.Println("  → 问题：大量请求同时访问数据库，造成数据库压力暴增！")
 
 	fmt.Println("\n✓ 场景1测试完成：演示了缓存雪崩问题")
@@ -202,9 +165,6 @@ func testRandomExpireSolution(repo model.UserRepo, rds *redis.Redis, title strin
 	userCache := cache.NewUserCacheWithAvalanche(rds)
 	userService := service.NewUserServiceWithAvalanche(repo, userCache, service.RandomExpire, cache.AvalancheBaseExpireSeconds)
 
-	// 统计数据库查询
-	stats := &DBQueryStats{}
-
 	// 步骤1：批量加载用户到缓存（随机过期时间60-66秒）
 	fmt.Println("[步骤1] 批量加载10个用户到缓存（随机过期时间60-66秒）")
 	userIDs := make([]int64, 0, 10)
@@ -231,6 +191,8 @@ func testRandomExpireSolution(repo model.UserRepo, rds *redis.Redis, title strin
 
 	// 步骤3：模拟大量并发请求（应该分散访问数据库）
 	fmt.Println("\n[步骤3] 模拟100个并发请求查询用户（随机过期时间）")
+	userMetrics := metrics.For(cache.UserCacheName)
+	before := userMetrics.Snapshot()
 	startTime := time.Now()
 
 	var wg sync.WaitGroup
@@ -245,23 +207,22 @@ func testRandomExpireSolution(repo model.UserRepo, rds *redis.Redis, title strin
 			queryStart := time.Now()
 			_, err := userService.GetUserByID(userID)
 			queryDuration := time.Since(queryStart)
-			if err == nil {
-				stats.AddQuery()
-				if requestID < 5 {
-					fmt.Printf("  [请求%d] 查询用户ID %d (耗时: %v)\n", requestID+1, userID, queryDuration)
-				}
+			if err == nil && requestID < 5 {
+				fmt.Printf("  [请求%d] 查询用户ID %d (耗时: %v)\n", requestID+1, userID, queryDuration)
 			}
 		}(i)
 	}
 
 	wg.Wait()
 	totalDuration := time.Since(startTime)
+	stats := userMetrics.Snapshot().Sub(before)
 
 	fmt.Printf("\n[统计结果]\n")
 	fmt.Printf("  并发请求数: %d\n", concurrentRequests)
-	fmt.Printf("  数据库查询次数: %d\n", stats.TotalQueries)
+	fmt.Printf("  缓存命中: %d, 未命中: %d\n", stats.Hits, stats.Misses)
+	fmt.Printf("  数据库查询次数: %d\n", stats.Loads)
 	fmt.Printf("  总耗时: %v\n", totalDuration)
-	fmt.Printf("  数据库QPS峰值: %.2f\n", stats.GetQPSInWindow(1))
+	fmt.Printf("  数据库QPS峰值: %d\n", userMetrics.PeakLoadQPS(startTime))
 	fmt.Println("  → 优势：数据库访问分散，不会同时访问，压力平滑！")
 
 	fmt.Println("\n✓ 场景2测试完成：随机过期时间成功解决了缓存雪崩问题")
@@ -284,6 +245,8 @@ func testAvalancheEffectiveness(repo model.UserRepo, rds *redis.Redis, title str
 		}
 	}
 
+	userMetrics := metrics.For(cache.UserCacheName)
+
 	// 测试1：固定过期时间（缓存雪崩）
 	fmt.Println("[测试1] 固定过期时间（缓存雪崩）")
 	userCache1 := cache.NewUserCacheWithAvalanche(rds)
@@ -302,7 +265,7 @@ func testAvalancheEffectiveness(repo model.UserRepo, rds *redis.Redis, title str
 	time.Sleep(time.Duration(cache.AvalancheBaseExpireSeconds) * time.Second)
 
 	// 并发查询
-	stats1 := &DBQueryStats{}
+	before1 := userMetrics.Snapshot()
 	var wg1 sync.WaitGroup
 	start1 := time.Now()
 
@@ -311,17 +274,16 @@ func testAvalancheEffectiveness(repo model.UserRepo, rds *redis.Redis, title str
 		go func(i int) {
 			defer wg1.Done()
 			userID := userIDs[i%len(userIDs)]
-			_, err := userService1.GetUserByID(userID)
-			if err == nil {
-				stats1.AddQuery()
-			}
+			userService1.GetUserByID(userID)
 		}(i)
 	}
 	wg1.Wait()
 	duration1 := time.Since(start1)
+	stats1 := userMetrics.Snapshot().Sub(before1)
+	peak1 := userMetrics.PeakLoadQPS(start1)
 
-	fmt.Printf("  查询50次，数据库访问 %d 次，耗时 %v\n", stats1.TotalQueries, duration1)
-	fmt.Printf("  数据库QPS峰值: %.2f\n", stats1.GetQPSInWindow(1))
+	fmt.Printf("  查询50次，数据库访问 %d 次，耗时 %v\n", stats1.Loads, duration1)
+	fmt.Printf("  数据库QPS峰值: %d\n", peak1)
 	fmt.Println("  → 问题：大量请求同时访问数据库")
 
 	time.Sleep(2 * time.Second)
@@ -344,7 +306,7 @@ func testAvalancheEffectiveness(repo model.UserRepo, rds *redis.Redis, title str
 	time.Sleep(time.Duration(cache.AvalancheBaseExpireSeconds) * time.Second)
 
 	// 并发查询
-	stats2 := &DBQueryStats{}
+	before2 := userMetrics.Snapshot()
 	var wg2 sync.WaitGroup
 	start2 := time.Now()
 
@@ -353,25 +315,24 @@ func testAvalancheEffectiveness(repo model.UserRepo, rds *redis.Redis, title str
 		go func(i int) {
 			defer wg2.Done()
 			userID := userIDs[i%len(userIDs)]
-			_, err := userService2.GetUserByID(userID)
-			if err == nil {
-				stats2.AddQuery()
-			}
+			userService2.GetUserByID(userID)
 		}(i)
 	}
 	wg2.Wait()
 	duration2 := time.Since(start2)
+	stats2 := userMetrics.Snapshot().Sub(before2)
+	peak2 := userMetrics.PeakLoadQPS(start2)
 
-	fmt.Printf("  查询50次，数据库访问 %d 次，耗时 %v\n", stats2.TotalQueries, duration2)
-	fmt.Printf("  数据库QPS峰值: %.2f\n", stats2.GetQPSInWindow(1))
+	fmt.Printf("  查询50次，数据库访问 %d 次，耗时 %v\n", stats2.Loads, duration2)
+	fmt.Printf("  数据库QPS峰值: %d\n", peak2)
 	fmt.Println("  → 优势：数据库访问分散，压力平滑")
 
 	// 效果对比
 	fmt.Println("\n[效果对比]")
-	fmt.Printf("  固定过期时间: 数据库访问 %d 次，QPS峰值 %.2f\n", stats1.TotalQueries, stats1.GetQPSInWindow(1))
-	fmt.Printf("  随机过期时间: 数据库访问 %d 次，QPS峰值 %.2f\n", stats2.TotalQueries, stats2.GetQPSInWindow(1))
-	if stats1.GetQPSInWindow(1) > 0 {
-		reduction := (stats1.GetQPSInWindow(1) - stats2.GetQPSInWindow(1)) / stats1.GetQPSInWindow(1) * 100
+	fmt.Printf("  固定过期时间: 数据库访问 %d 次，QPS峰值 %d\n", stats1.Loads, peak1)
+	fmt.Printf("  随机过期时间: 数据库访问 %d 次，QPS峰值 %d\n", stats2.Loads, peak2)
+	if peak1 > 0 {
+		reduction := float64(peak1-peak2) / float64(peak1) * 100
 		fmt.Printf("  QPS峰值减少: %.1f%%\n", reduction)
 	}
 
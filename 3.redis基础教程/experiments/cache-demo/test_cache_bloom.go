@@ -2,13 +2,16 @@ package main
 
 import (
 	"cache-demo/cache"
+	"cache-demo/metrics"
 	"cache-demo/model"
 	"cache-demo/service"
 	"fmt"
 	"log"
+	"math"
 	"strings"
 	"time"
 
+	"github.com/zeromicro/go-zero/core/bloom"
 	"github.com/zeromicro/go-zero/core/conf"
 	"github.com/zeromicro/go-zero/core/stores/redis"
 	"gorm.io/driver/mysql"
@@ -72,38 +75,56 @@ func main() {
 
 	// 场景3：布隆过滤器效果对比
 	testBloomFilterEffectiveness(userRepo, rds, "场景3：布隆过滤器效果对比")
+
+	// 场景4：删除用户后的过滤器（普通布隆过滤器 vs 计数布隆过滤器）
+	testBloomFilterDeletion(userRepo, rds, "场景4：删除用户后的过滤器")
+
+	// 场景5：误判率测量
+	testFalsePositiveRate(rds, "场景5：误判率测量")
+
+	// 场景6：可扩展布隆过滤器（数据量增长后误判率不变）
+	testScalableBloomFilter(userRepo, rds, "场景6：可扩展布隆过滤器")
 }
 
-// testBloomFilterInit 场景1：初始化布隆过滤器（加载现有用户）
+// testBloomFilterInit 场景1：初始化布隆过滤器（按数据量计算参数，全量加载现有用户）
 func testBloomFilterInit(repo model.UserRepo, rds *redis.Redis, title string) {
 	fmt.Println("\n" + strings.Repeat("-", 80))
 	fmt.Println(title)
 	fmt.Println(strings.Repeat("-", 80))
-	fmt.Println("说明：将数据库中现有的用户ID加载到布隆过滤器")
+	fmt.Println("说明：按用户数和目标误判率计算位数和哈希函数个数，分页加载所有用户ID后原子替换线上过滤器")
 	fmt.Println("目的：确保已存在的用户能够通过布隆过滤器检查")
 	fmt.Println()
 
-	// 创建支持布隆过滤器的缓存服务
-	userCache := cache.NewUserCacheWithBloom(rds)
+	manager := cache.NewUserBloomManager(rds, repo)
 
-	// 将现有用户添加到布隆过滤器
-	fmt.Println("[初始化] 加载现有用户到布隆过滤器")
-	fmt.Println("  注意：在实际应用中，系统启动时会全量加载所有用户ID到布隆过滤器")
-	count := 0
-	for i := int64(1); i <= 10; i++ {
-		user, err := repo.FindByID(i)
-		if err == nil && user != nil {
-			if err := userCache.AddToBloomFilter(user.ID); err == nil {
-				count++
-				fmt.Printf("  ✓ 添加用户ID %d 到布隆过滤器\n", user.ID)
-			}
-		}
+	// 参数计算：m = -n·ln(p)/(ln2)², k = m/n·ln2
+	fmt.Println("[参数计算] 不同数据量下误判率1%需要的参数")
+	for _, n := range []uint64{10000, 1000000, 100000000} {
+		params := cache.OptimalBloomParams(n, cache.DefaultBloomFPRate)
+		fmt.Printf("  n=%-10d m=%-11d (%.1fMB) k=%d\n", n, params.Bits, float64(params.Bits)/8/1024/1024, params.Hashes)
+	}
+
+	// 检查是否需要重建
+	reason, needed, err := manager.NeedsRebuild()
+	if err != nil {
+		log.Fatalf("检查布隆过滤器失败: %v", err)
 	}
-	if count > 0 {
-		fmt.Printf("\n✓ 场景1测试完成：成功加载 %d 个用户到布隆过滤器\n", count)
+	if needed {
+		fmt.Printf("\n[检查] 需要重建: %s\n", reason)
 	} else {
-		fmt.Println("\n✓ 场景1测试完成：演示布隆过滤器初始化流程")
+		fmt.Println("\n[检查] 不需要重建（演示仍然强制重建一次）")
 	}
+
+	// 重建：从MySQL分页加载所有用户ID
+	fmt.Println("\n[重建] 加载所有用户ID到临时key，再用 RENAME 原子替换")
+	fmt.Println("  注意：在实际应用中，通过 go run main.go bloom rebuild 或定时任务重建")
+	stats, err := manager.Rebuild()
+	if err != nil {
+		log.Fatalf("重建布隆过滤器失败: %v", err)
+	}
+	fmt.Printf("  %s\n", stats)
+
+	fmt.Printf("\n✓ 场景1测试完成：成功加载 %d 个用户到布隆过滤器\n", stats.Items)
 }
 
 // testBloomFilterProtection 场景2：使用布隆过滤器防止缓存穿透
@@ -175,20 +196,21 @@ func testBloomFilterEffectiveness(repo model.UserRepo, rds *redis.Redis, title s
 
 	nonExistentID := int64(88888)
 
+	// 数据库访问次数、拦截次数读取自用户缓存的指标
+	userMetrics := metrics.For(cache.UserCacheName)
+
 	// 测试1：不使用布隆过滤器（缓存穿透）
 	fmt.Println("[测试1] 不使用布隆过滤器（缓存穿透）")
 	userCache1 := cache.NewUserCache(rds)
 	userService1 := service.NewUserService(repo, userCache1)
 
-	var dbQueries1 int
+	before1 := userMetrics.Snapshot()
 	for i := 1; i <= 5; i++ {
-		_, err := userService1.GetUserByID(nonExistentID)
-		if err != nil {
-			dbQueries1++
-		}
+		userService1.GetUserByID(nonExistentID)
 		time.Sleep(100 * time.Millisecond)
 	}
-	fmt.Printf("  查询5次，访问数据库 %d 次\n", dbQueries1)
+	stats1 := userMetrics.Snapshot().Sub(before1)
+	fmt.Printf("  查询5次，访问数据库 %d 次\n", stats1.Loads)
 	fmt.Println("  → 问题：每次查询都访问数据库")
 
 	time.Sleep(500 * time.Millisecond)
@@ -198,34 +220,194 @@ func testBloomFilterEffectiveness(repo model.UserRepo, rds *redis.Redis, title s
 	userCache2 := cache.NewUserCacheWithBloom(rds)
 	userService2 := service.NewUserServiceWithBloom(repo, userCache2)
 
-	var dbQueries2 int
-	var bloomIntercepts int
+	before2 := userMetrics.Snapshot()
 	for i := 1; i <= 5; i++ {
-		_, err := userService2.GetUserByID(nonExistentID)
-		if err != nil {
-			// 检查是否被布隆过滤器拦截（不查询数据库）
-			if i == 1 {
-				// 第一次可能查询数据库（如果布隆过滤器未初始化）
-				dbQueries2++
-			} else {
-				// 后续查询都被布隆过滤器拦截
-				bloomIntercepts++
-			}
-		}
+		userService2.GetUserByID(nonExistentID)
 		time.Sleep(100 * time.Millisecond)
 	}
-	fmt.Printf("  查询5次，访问数据库 %d 次，布隆过滤器拦截 %d 次\n", dbQueries2, bloomIntercepts)
+	stats2 := userMetrics.Snapshot().Sub(before2)
+	fmt.Printf("  查询5次，访问数据库 %d 次，布隆过滤器拦截 %d 次\n", stats2.Loads, stats2.BloomRejects)
 	fmt.Println("  → 优势：布隆过滤器拦截了大部分无效查询")
 
 	// 效果对比
 	fmt.Println("\n[效果对比]")
-	fmt.Printf("  不使用布隆过滤器: 5次查询，%d次数据库访问\n", dbQueries1)
-	fmt.Printf("  使用布隆过滤器:   5次查询，%d次数据库访问，%d次拦截\n", dbQueries2, bloomIntercepts)
-	if dbQueries1 > 0 {
-		fmt.Printf("  数据库压力减少: %.1f%%\n", float64(dbQueries1-dbQueries2)/float64(dbQueries1)*100)
+	fmt.Printf("  不使用布隆过滤器: 5次查询，%d次数据库访问\n", stats1.Loads)
+	fmt.Printf("  使用布隆过滤器:   5次查询，%d次数据库访问，%d次拦截\n", stats2.Loads, stats2.BloomRejects)
+	if stats1.Loads > 0 {
+		fmt.Printf("  数据库压力减少: %.1f%%\n", float64(stats1.Loads-stats2.Loads)/float64(stats1.Loads)*100)
 	}
 
 	fmt.Println("\n✓ 场景3测试完成：布隆过滤器显著减少了数据库压力")
+}
+
+// testBloomFilterDeletion 场景4：删除用户后，普通布隆过滤器无法删除，计数布隆过滤器可以
+func testBloomFilterDeletion(repo model.UserRepo, rds *redis.Redis, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：创建用户 -> 删除用户 -> 再次查询该用户")
+	fmt.Println("问题：普通布隆过滤器不支持删除，已删除的用户会一直通过过滤器")
+	fmt.Println()
+
+	userMetrics := metrics.For(cache.UserCacheName)
+	caches := []struct {
+		name  string
+		cache cache.UserCacheWithBloom
+	}{
+		{"普通布隆过滤器", cache.NewUserCacheWithBloom(rds)},
+		{"计数布隆过滤器", cache.NewUserCacheWithCountingBloom(rds)},
+	}
+
+	results := make([]metrics.Snapshot, len(caches))
+	for i, c := range caches {
+		userService := service.NewUserServiceWithBloom(repo, c.cache)
+
+		user := &model.User{
+			Username: fmt.Sprintf("bloom_delete_%d", time.Now().UnixNano()),
+			Email:    "bloom_delete@example.com",
+			Age:      20,
+		}
+		if err := userService.CreateUser(user); err != nil {
+			log.Fatalf("创建用户失败: %v", err)
+		}
+		if err := userService.DeleteUser(user.ID); err != nil {
+			log.Fatalf("删除用户失败: %v", err)
+		}
+
+		before := userMetrics.Snapshot()
+		for j := 0; j < 3; j++ {
+			userService.GetUserByID(user.ID)
+		}
+		results[i] = userMetrics.Snapshot().Sub(before)
+		fmt.Printf("[%s] 删除用户ID %d 后查询3次：过滤器拦截 %d 次，访问数据库 %d 次\n",
+			c.name, user.ID, results[i].BloomRejects, results[i].Loads)
+	}
+
+	fmt.Println("  → 计数布隆过滤器在删除用户时同步删除，后续查询直接被拦截")
+
+	fmt.Println("\n✓ 场景4测试完成：计数布隆过滤器支持删除")
+}
+
+// testFalsePositiveRate 场景5：测量误判率，并与理论值 (1 - e^(-kn/m))^k 对比
+func testFalsePositiveRate(rds *redis.Redis, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：插入n个元素后，查询n个从未插入的元素，统计被判断为存在的比例")
+	fmt.Println()
+
+	const (
+		bits       = 100000 // 位数/计数器个数（m），比线上小，便于观察误判
+		members    = 10000  // 插入的元素个数（n）
+		probes     = 10000  // 查询的不存在元素个数
+		bloomMaps  = 14     // go-zero bloom 固定使用14个哈希函数
+		bloomKey   = "test_bloom_fp"
+		countedKey = "test_counting_bloom_fp"
+	)
+
+	rds.Del(bloomKey, countedKey)
+	defer rds.Del(bloomKey, countedKey)
+
+	filters := []struct {
+		name   string
+		filter cache.Filter
+		hashes int
+	}{
+		{"普通布隆过滤器", bloom.New(rds, bloomKey, bits), bloomMaps},
+		{"计数布隆过滤器", cache.NewCountingBloomFilter(rds, countedKey, bits, cache.CountingBloomHashes), cache.CountingBloomHashes},
+	}
+
+	fmt.Printf("  m=%d, n=%d, 查询 %d 个不存在的元素\n\n", bits, members, probes)
+	for _, f := range filters {
+		for i := 0; i < members; i++ {
+			if err := f.filter.Add([]byte(fmt.Sprintf("member:%d", i))); err != nil {
+				log.Fatalf("添加元素失败: %v", err)
+			}
+		}
+
+		falsePositives := countExisting(f.filter, "probe", 0, probes)
+		k := float64(f.hashes)
+		expected := math.Pow(1-math.Exp(-k*members/bits), k)
+		fmt.Printf("  [%s] k=%d, 实测误判率 %.3f%% (%d/%d)，理论误判率 %.3f%%\n",
+			f.name, f.hashes, float64(falsePositives)/probes*100, falsePositives, probes, expected*100)
+	}
+
+	// 删除一半元素后，已删除的元素还能不能通过过滤器
+	counting := filters[1].filter.(cache.DeletableFilter)
+	for i := 0; i < members/2; i++ {
+		if err := counting.Remove([]byte(fmt.Sprintf("member:%d", i))); err != nil {
+			log.Fatalf("删除元素失败: %v", err)
+		}
+	}
+	deletedPassed := countExisting(counting, "member", 0, members/2)
+	remainingMissed := members/2 - countExisting(counting, "member", members/2, members)
+	fmt.Printf("\n  [计数布隆过滤器] 删除 %d 个元素后：已删除元素仍通过 %d 个，未删除元素被误拦截 %d 个\n",
+		members/2, deletedPassed, remainingMissed)
+	fmt.Println("  → 普通布隆过滤器无法删除，已删除的元素100%通过；计数布隆过滤器删除后只剩正常的误判")
+
+	fmt.Println("\n✓ 场景5测试完成")
+}
+
+// testScalableBloomFilter 场景6：元素个数超过初始容量后，可扩展布隆过滤器追加新层，误判率保持在目标值以内
+func testScalableBloomFilter(repo model.UserRepo, rds *redis.Redis, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：初始容量1000、目标误判率1%，逐步插入到20倍容量，对比固定大小的布隆过滤器")
+	fmt.Println()
+
+	const (
+		capacity = 1000
+		probes   = 10000
+		key      = "test_scalable_bloom"
+	)
+
+	filter := cache.NewScalableBloomFilter(rds, key, capacity, cache.DefaultBloomFPRate)
+	filter.Clear()
+	defer filter.Clear()
+	if err := filter.Init(); err != nil {
+		log.Fatalf("初始化可扩展布隆过滤器失败: %v", err)
+	}
+	fixed := cache.OptimalBloomParams(capacity, cache.DefaultBloomFPRate)
+
+	added := 0
+	for _, n := range []int{1000, 5000, 20000} {
+		for ; added < n; added++ {
+			if err := filter.Add([]byte(fmt.Sprintf("member:%d", added))); err != nil {
+				log.Fatalf("添加元素失败: %v", err)
+			}
+		}
+
+		stats, err := filter.Stats()
+		if err != nil {
+			log.Fatalf("查询可扩展布隆过滤器失败: %v", err)
+		}
+		falsePositives := countExisting(filter, "probe", 0, probes)
+		fmt.Printf("  [n=%d] %s，实测误判率 %.3f%%；固定大小（m=%d, k=%d）理论误判率 %.3f%%\n",
+			n, stats, float64(falsePositives)/probes*100, fixed.Bits, fixed.Hashes, fixed.FalsePositiveRate(uint64(n))*100)
+		for i, layer := range stats.Layers {
+			fmt.Printf("      第%d层: bits=%d, k=%d, 容量=%d, 元素=%d, 目标误判率=%.4f%%, 填充率=%.1f%%\n",
+				i, layer.Bits, layer.Hashes, layer.Capacity, layer.Items, layer.TargetFPRate*100, layer.FillRatio*100)
+		}
+	}
+	fmt.Println("  → 固定大小的过滤器超过容量后误判率快速上升；可扩展布隆过滤器追加新层，误判率保持在1%以内")
+
+	// 用户服务使用可扩展布隆过滤器
+	fmt.Println("\n[用户服务] 加载所有用户ID到可扩展布隆过滤器后查询")
+	loaded, err := cache.LoadUserScalableBloom(rds, repo)
+	if err != nil {
+		log.Fatalf("加载可扩展布隆过滤器失败: %v", err)
+	}
+	userMetrics := metrics.For(cache.UserCacheName)
+	userService := service.NewUserServiceWithBloom(repo, cache.NewUserCacheWithScalableBloom(rds))
+	before := userMetrics.Snapshot()
+	_, existErr := userService.GetUserByID(1)
+	_, missErr := userService.GetUserByID(77777)
+	stats := userMetrics.Snapshot().Sub(before)
+	fmt.Printf("  加载 %d 个用户ID；查询用户1: error=%v，查询用户77777: error=%v，过滤器拦截 %d 次\n",
+		loaded, existErr, missErr, stats.BloomRejects)
+
+	fmt.Println("\n✓ 场景6测试完成")
 	fmt.Println("\n" + strings.Repeat("=", 80))
 	fmt.Println("所有测试场景完成！")
 	fmt.Println(strings.Repeat("=", 80))
@@ -234,6 +416,23 @@ func testBloomFilterEffectiveness(repo model.UserRepo, rds *redis.Redis, title s
 	fmt.Println("2. 优势：如果判断不存在，直接返回，不查询数据库")
 	fmt.Println("3. 效果：显著减少数据库压力，提升系统性能")
 	fmt.Println("4. 注意：存在误判率，但不存在误判（False Negative = 0）")
+	fmt.Println("5. 计数布隆过滤器：每个位置是计数器，支持删除，代价是更多的内存")
+	fmt.Println("6. 可扩展布隆过滤器：写满后追加新层，数据量增长后不需要重建，代价是查询要检查每一层")
+}
+
+// countExisting 统计 [from, to) 范围内的元素有多少个被判断为可能存在
+func countExisting(filter cache.Filter, prefix string, from, to int) int {
+	count := 0
+	for i := from; i < to; i++ {
+		exists, err := filter.Exists([]byte(fmt.Sprintf("%s:%d", prefix, i)))
+		if err != nil {
+			log.Fatalf("查询过滤器失败: %v", err)
+		}
+		if exists {
+			count++
+		}
+	}
+	return count
 }
 
 // initDB 初始化数据库连接（复用main.go的函数）
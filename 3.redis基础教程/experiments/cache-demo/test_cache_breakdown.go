@@ -0,0 +1,381 @@
+package main
+
+import (
+	"cache-demo/cache"
+	"cache-demo/metrics"
+	"cache-demo/model"
+	"cache-demo/service"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/conf"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Config 配置结构（复用main.go的配置）
+type Config struct {
+	MySQL struct {
+		Host         string `json:"host" yaml:"host"`
+		Port         int    `json:"port" yaml:"port"`
+		User         string `json:"user" yaml:"user"`
+		Password     string `json:"password" yaml:"password"`
+		Database     string `json:"database" yaml:"database"`
+		MaxOpenConns int    `json:"max_open_conns" yaml:"max_open_conns"`
+		MaxIdleConns int    `json:"max_idle_conns" yaml:"max_idle_conns"`
+	} `json:"mysql" yaml:"mysql"`
+	Redis struct {
+		Host        string `json:"host" yaml:"host"`
+		Password    string `json:"password" yaml:"password"`
+		Type        string `json:"type" yaml:"type"`
+		PingTimeout string `json:"ping_timeout" yaml:"ping_timeout"`
+	} `json:"redis" yaml:"redis"`
+}
+
+const (
+	// hotUserID 热点用户ID
+	hotUserID = int64(1)
+	// breakdownConcurrency 热点Key过期瞬间的并发请求数
+	breakdownConcurrency = 200
+	// slowQueryDelay 模拟慢查询，放大缓存重建窗口
+	slowQueryDelay = 100 * time.Millisecond
+)
+
+// slowRepo 模拟慢查询的仓储包装（数据库查询次数由缓存指标统计）
+type slowRepo struct {
+	model.UserRepo
+}
+
+// FindByIDCtx 每次查询数据库前增加固定延迟（服务层都通过带 ctx 的方法查询）
+func (r *slowRepo) FindByIDCtx(ctx context.Context, id int64) (*model.User, error) {
+	time.Sleep(slowQueryDelay)
+	return r.UserRepo.FindByIDCtx(ctx, id)
+}
+
+// breakdownResult 单个场景的统计结果（读取自用户缓存的指标）
+type breakdownResult struct {
+	metrics.Snapshot
+	PeakLoadQPS int64
+}
+
+func main() {
+	// 加载配置
+	var c Config
+	conf.MustLoad("config.yaml", &c)
+
+	// 初始化数据库连接
+	db, err := initDB(c)
+	if err != nil {
+		log.Fatalf("初始化数据库失败: %v", err)
+	}
+
+	// 初始化Redis连接
+	rds, err := initRedis(c)
+	if err != nil {
+		log.Fatalf("初始化Redis失败: %v", err)
+	}
+
+	// 确保测试数据存在
+	if err := ensureTestData(db); err != nil {
+		log.Fatalf("初始化测试数据失败: %v", err)
+	}
+
+	// 初始化服务层
+	userRepo := model.NewUserRepo(db)
+
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("缓存击穿问题测试")
+	fmt.Println(strings.Repeat("=", 80))
+
+	// 场景1：演示缓存击穿问题（热点Key过期，无保护）
+	stats1 := testBreakdownProblem(userRepo, rds, "场景1：缓存击穿问题演示")
+
+	// 场景2：进程内 singleflight 合并并发请求
+	stats2 := testSingleFlightSolution(userRepo, rds, "场景2：singleflight 进程内合并")
+
+	// 场景3：多进程 + Redis 互斥锁
+	stats3 := testMutexSolution(userRepo, rds, "场景3：singleflight + Redis 互斥锁（模拟多进程）")
+
+	// 场景4：逻辑过期 + 异步重建
+	stats4 := testLogicalExpireSolution(userRepo, rds, "场景4：逻辑过期 + 异步重建")
+
+	// 效果对比
+	printBreakdownSummary(stats1, stats2, stats3, stats4)
+}
+
+// warmUpAndExpire 预热热点Key，然后删除缓存模拟热点Key过期的瞬间
+func warmUpAndExpire(repo model.UserRepo, userCache cache.UserCache) {
+	user, err := repo.FindByID(hotUserID)
+	if err != nil {
+		log.Fatalf("查询热点用户失败: %v", err)
+	}
+	userCache.SetUser(user, cache.DefaultExpireSeconds)
+	fmt.Printf("[步骤1] 预热热点用户ID %d 到缓存\n", hotUserID)
+
+	userCache.DeleteUser(hotUserID)
+	fmt.Println("[步骤2] 热点Key过期（删除缓存模拟过期瞬间）")
+}
+
+// runHotKeyRequests 对热点Key发起并发请求，services 模拟多个进程（每个进程一个服务实例）
+func runHotKeyRequests(services []service.UserService) breakdownResult {
+	fmt.Printf("[步骤3] %d 个实例共发起 %d 个并发请求查询热点用户\n", len(services), breakdownConcurrency)
+	userMetrics := metrics.For(cache.UserCacheName)
+	before := userMetrics.Snapshot()
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	var failed int64
+	for i := 0; i < breakdownConcurrency; i++ {
+		wg.Add(1)
+		go func(requestID int) {
+			defer wg.Done()
+			svc := services[requestID%len(services)]
+			if _, err := svc.GetUserByID(hotUserID); err != nil {
+				atomic.AddInt64(&failed, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	duration := time.Since(start)
+	result := breakdownResult{
+		Snapshot:    userMetrics.Snapshot().Sub(before),
+		PeakLoadQPS: userMetrics.PeakLoadQPS(start),
+	}
+
+	fmt.Printf("\n[统计结果]\n")
+	fmt.Printf("  并发请求数: %d (失败: %d)\n", breakdownConcurrency, failed)
+	fmt.Printf("  缓存命中: %d, 未命中: %d\n", result.Hits, result.Misses)
+	fmt.Printf("  数据库查询次数: %d (平均耗时 %v)\n", result.Loads, result.AvgLoadTime())
+	fmt.Printf("  总耗时: %v\n", duration)
+	fmt.Printf("  数据库QPS峰值: %d\n", result.PeakLoadQPS)
+	return result
+}
+
+// testBreakdownProblem 场景1：演示缓存击穿问题（热点Key过期，无保护）
+func testBreakdownProblem(repo model.UserRepo, rds *redis.Redis, title string) breakdownResult {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：热点Key过期的瞬间，大量并发请求同时未命中缓存")
+	fmt.Println("问题：每个请求都去查询数据库重建缓存")
+	fmt.Println()
+
+	userCache := cache.NewUserCache(rds)
+	userService := service.NewUserService(&slowRepo{UserRepo: repo}, userCache)
+
+	warmUpAndExpire(repo, userCache)
+	result := runHotKeyRequests([]service.UserService{userService})
+	fmt.Println("  → 问题：热点Key过期后，并发请求全部打到数据库！")
+
+	fmt.Println("\n✓ 场景1测试完成：演示了缓存击穿问题")
+	return result
+}
+
+// testSingleFlightSolution 场景2：进程内 singleflight 合并并发请求
+func testSingleFlightSolution(repo model.UserRepo, rds *redis.Redis, title string) breakdownResult {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：同一进程内，同一个Key的并发未命中合并为一次数据库查询")
+	fmt.Println()
+
+	userCache := cache.NewUserCache(rds)
+	userService := service.NewUserServiceWithBreakdownProtection(&slowRepo{UserRepo: repo}, userCache, nil)
+
+	warmUpAndExpire(repo, userCache)
+	result := runHotKeyRequests([]service.UserService{userService})
+	fmt.Println("  → 优势：单进程内只查询1次数据库，其余请求共享结果")
+
+	fmt.Println("\n✓ 场景2测试完成：singleflight 解决了单进程内的缓存击穿")
+	return result
+}
+
+// testMutexSolution 场景3：多进程 + Redis 互斥锁
+func testMutexSolution(repo model.UserRepo, rds *redis.Redis, title string) breakdownResult {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：多个进程各自有 singleflight，只能合并本进程的请求")
+	fmt.Println("方案：再加一层 Redis 互斥锁，所有进程中只有一个去重建缓存")
+	fmt.Println()
+
+	const instances = 5
+	sharedRepo := &slowRepo{UserRepo: repo}
+	userCache := cache.NewUserCache(rds)
+
+	services := make([]service.UserService, 0, instances)
+	for i := 0; i < instances; i++ {
+		services = append(services, service.NewUserServiceWithBreakdownProtection(sharedRepo, userCache, rds))
+	}
+
+	warmUpAndExpire(repo, userCache)
+	result := runHotKeyRequests(services)
+	fmt.Printf("  → 优势：%d 个实例合计只查询1次数据库，其他实例等待缓存重建\n", instances)
+
+	fmt.Println("\n✓ 场景3测试完成：Redis 互斥锁解决了多进程的缓存击穿")
+	return result
+}
+
+// testLogicalExpireSolution 场景4：逻辑过期 + 异步重建
+func testLogicalExpireSolution(repo model.UserRepo, rds *redis.Redis, title string) breakdownResult {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：缓存不设置物理过期，逻辑过期后直接返回旧值")
+	fmt.Println("方案：只有抢到重建锁的请求在后台异步查询数据库，其他请求不等待")
+	fmt.Println()
+
+	const logicalExpire = 1
+	userCache := cache.NewUserCacheWithLogicalExpire(rds)
+	userService := service.NewUserServiceWithLogicalExpire(&slowRepo{UserRepo: repo}, userCache, rds, logicalExpire)
+
+	user, err := repo.FindByID(hotUserID)
+	if err != nil {
+		log.Fatalf("查询热点用户失败: %v", err)
+	}
+	userCache.SetUser(user, logicalExpire)
+	fmt.Printf("[步骤1] 预热热点用户ID %d 到缓存（逻辑过期时间: %d秒）\n", hotUserID, logicalExpire)
+
+	time.Sleep(2 * time.Second)
+	fmt.Println("[步骤2] 热点Key已逻辑过期（数据仍在Redis中）")
+
+	userMetrics := metrics.For(cache.UserCacheName)
+	before := userMetrics.Snapshot()
+	result := runHotKeyRequests([]service.UserService{userService})
+
+	// 等待后台重建完成，把后台重建的数据库查询也算进来
+	time.Sleep(2 * slowQueryDelay)
+	result.Snapshot = userMetrics.Snapshot().Sub(before)
+	fmt.Printf("  后台重建后数据库查询次数: %d\n", result.Loads)
+	fmt.Println("  → 优势：所有请求立即返回旧值，不需要等待数据库查询")
+
+	fmt.Println("\n✓ 场景4测试完成：逻辑过期让热点Key永远不会同步查询数据库")
+	return result
+}
+
+// printBreakdownSummary 打印效果对比
+func printBreakdownSummary(stats1, stats2, stats3, stats4 breakdownResult) {
+	fmt.Println("\n[效果对比]")
+	fmt.Printf("  无保护:                   数据库访问 %d 次，QPS峰值 %d\n", stats1.Loads, stats1.PeakLoadQPS)
+	fmt.Printf("  singleflight:             数据库访问 %d 次，QPS峰值 %d\n", stats2.Loads, stats2.PeakLoadQPS)
+	fmt.Printf("  singleflight + 互斥锁:    数据库访问 %d 次，QPS峰值 %d\n", stats3.Loads, stats3.PeakLoadQPS)
+	fmt.Printf("  逻辑过期 + 异步重建:      数据库访问 %d 次，QPS峰值 %d\n", stats4.Loads, stats4.PeakLoadQPS)
+
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("所有测试场景完成！")
+	fmt.Println(strings.Repeat("=", 80))
+	fmt.Println("\n总结：")
+	fmt.Println("1. 缓存击穿：热点Key过期的瞬间，大量并发请求同时查询数据库")
+	fmt.Println("2. singleflight：进程内合并同一个Key的并发请求")
+	fmt.Println("3. Redis 互斥锁：跨进程只允许一个请求重建缓存，其他请求等待后读缓存")
+	fmt.Println("4. 逻辑过期：过期后先返回旧值，后台只有一个请求异步重建缓存")
+	fmt.Println("5. 效果：无论并发多高，数据库QPS保持平稳")
+}
+
+// initDB 初始化数据库连接（复用main.go的函数）
+func initDB(c Config) (*gorm.DB, error) {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+		c.MySQL.User,
+		c.MySQL.Password,
+		c.MySQL.Host,
+		c.MySQL.Port,
+		c.MySQL.Database,
+	)
+
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("连接数据库失败: %w", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("获取数据库实例失败: %w", err)
+	}
+
+	if c.MySQL.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(c.MySQL.MaxOpenConns)
+	}
+	if c.MySQL.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(c.MySQL.MaxIdleConns)
+	}
+
+	return db, nil
+}
+
+// initRedis 初始化Redis连接（复用main.go的函数）
+func initRedis(c Config) (*redis.Redis, error) {
+	pingTimeout, err := time.ParseDuration(c.Redis.PingTimeout)
+	if err != nil {
+		pingTimeout = time.Second
+	}
+
+	conf := redis.RedisConf{
+		Host:        c.Redis.Host,
+		Type:        c.Redis.Type,
+		Pass:        c.Redis.Password,
+		PingTimeout: pingTimeout,
+	}
+
+	// 如果密码为空，不设置Pass字段
+	if c.Redis.Password == "" {
+		conf.Pass = ""
+	}
+
+	rds := redis.MustNewRedis(conf)
+	return rds, nil
+}
+
+// ensureTestData 确保测试数据存在（复用main.go的函数）
+func ensureTestData(db *gorm.DB) error {
+	var count int64
+	db.Model(&model.User{}).Count(&count)
+
+	if count == 0 {
+		log.Println("检测到数据库中没有测试数据，正在初始化...")
+		return initTestData(db)
+	}
+
+	// 确保至少有10个用户用于测试
+	if count < 10 {
+		log.Printf("数据库只有 %d 个用户，需要至少10个，正在补充...", count)
+		return initTestData(db)
+	}
+
+	log.Printf("数据库已有 %d 条测试数据", count)
+	return nil
+}
+
+// initTestData 初始化测试数据（复用main.go的函数）
+func initTestData(db *gorm.DB) error {
+	users := []*model.User{
+		{Username: "alice", Email: "alice@example.com", Age: 20},
+		{Username: "bob", Email: "bob@example.com", Age: 25},
+		{Username: "charlie", Email: "charlie@example.com", Age: 30},
+		{Username: "david", Email: "david@example.com", Age: 28},
+		{Username: "eve", Email: "eve@example.com", Age: 22},
+		{Username: "frank", Email: "frank@example.com", Age: 35},
+		{Username: "grace", Email: "grace@example.com", Age: 27},
+		{Username: "henry", Email: "henry@example.com", Age: 32},
+		{Username: "ivy", Email: "ivy@example.com", Age: 24},
+		{Username: "jack", Email: "jack@example.com", Age: 29},
+	}
+
+	for _, user := range users {
+		if err := db.Create(user).Error; err != nil {
+			// 如果用户已存在，跳过
+			continue
+		}
+		log.Printf("创建测试用户: ID=%d, Username=%s", user.ID, user.Username)
+	}
+
+	return nil
+}
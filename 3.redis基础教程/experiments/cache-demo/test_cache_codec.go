@@ -0,0 +1,224 @@
+package main
+
+import (
+	"cache-demo/cache"
+	"cache-demo/model"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/conf"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+// Config 配置结构（复用main.go的配置）
+type Config struct {
+	MySQL struct {
+		Host         string `json:"host" yaml:"host"`
+		Port         int    `json:"port" yaml:"port"`
+		User         string `json:"user" yaml:"user"`
+		Password     string `json:"password" yaml:"password"`
+		Database     string `json:"database" yaml:"database"`
+		MaxOpenConns int    `json:"max_open_conns" yaml:"max_open_conns"`
+		MaxIdleConns int    `json:"max_idle_conns" yaml:"max_idle_conns"`
+	} `json:"mysql" yaml:"mysql"`
+	Redis struct {
+		Host        string `json:"host" yaml:"host"`
+		Password    string `json:"password" yaml:"password"`
+		Type        string `json:"type" yaml:"type"`
+		PingTimeout string `json:"ping_timeout" yaml:"ping_timeout"`
+	} `json:"redis" yaml:"redis"`
+}
+
+// codecNames 参与对比的编码
+var codecNames = []string{
+	"json",
+	"msgpack",
+	"protobuf",
+	"json+gzip",
+	"json+snappy",
+	"msgpack+snappy",
+	"protobuf+snappy",
+}
+
+// codecDemoUserID 迁移演示使用的用户ID（数据库中不存在，只写缓存）
+const codecDemoUserID = int64(990001)
+
+func main() {
+	// 加载配置
+	var c Config
+	conf.MustLoad("config.yaml", &c)
+
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("缓存序列化编码测试")
+	fmt.Println(strings.Repeat("=", 80))
+
+	// 场景1：各编码的体积与编解码耗时（不需要Redis）
+	testCodecBenchmark("场景1：payload 体积与编解码耗时对比")
+
+	// 初始化Redis连接
+	rds, err := initRedis(c)
+	if err != nil {
+		log.Fatalf("初始化Redis失败: %v", err)
+	}
+
+	// 场景2：灰度切换编码（旧数据读到时自动迁移）
+	testCodecMigration(rds, "场景2：灰度切换编码与旧数据迁移")
+}
+
+// sampleUser 测试用的用户数据
+func sampleUser() *model.User {
+	now := time.Now()
+	return &model.User{
+		ID:        codecDemoUserID,
+		Username:  "codec_demo_user",
+		Email:     "codec_demo_user@example.com",
+		Age:       28,
+		CreatedAt: now.Add(-24 * time.Hour),
+		UpdatedAt: now,
+	}
+}
+
+// testCodecBenchmark 场景1：用 testing.Benchmark 对比各编码
+func testCodecBenchmark(title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：对同一个 model.User 分别编码/解码，比较 payload 大小和耗时")
+	fmt.Println()
+
+	user := sampleUser()
+
+	fmt.Printf("  %-18s %10s %14s %14s %12s\n", "编码", "大小(字节)", "编码(ns/op)", "解码(ns/op)", "解码allocs")
+	for _, name := range codecNames {
+		codec, err := cache.ParseUserCodec(name)
+		if err != nil {
+			log.Fatalf("创建编解码器失败: %v", err)
+		}
+
+		data, err := codec.Marshal(user)
+		if err != nil {
+			log.Fatalf("[%s] 编码失败: %v", name, err)
+		}
+		decoded, err := codec.Unmarshal(data)
+		if err != nil {
+			log.Fatalf("[%s] 解码失败: %v", name, err)
+		}
+		if decoded.ID != user.ID || decoded.Username != user.Username || !decoded.UpdatedAt.Equal(user.UpdatedAt) {
+			log.Fatalf("[%s] 解码结果与原数据不一致: %+v", name, decoded)
+		}
+
+		encode := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				codec.Marshal(user)
+			}
+		})
+		decode := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				codec.Unmarshal(data)
+			}
+		})
+
+		fmt.Printf("  %-18s %10d %14d %14d %12d\n", name, len(data), encode.NsPerOp(), decode.NsPerOp(), decode.AllocsPerOp())
+	}
+
+	fmt.Println("\n  → MessagePack/protobuf 不保存字段名，体积明显小于JSON，编解码也更快")
+	fmt.Println("  → 单个用户只有一百多字节，压缩收益很小甚至变大（gzip有固定头部），压缩适合大对象或列表")
+
+	fmt.Println("\n✓ 场景1测试完成")
+}
+
+// testCodecMigration 场景2：灰度切换编码
+func testCodecMigration(rds *redis.Redis, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：值的第一个字节是版本字节（>= 0x80），没有版本字节的是旧的JSON数据")
+	fmt.Println("方案：新编码的实例读到旧数据时，用新编码原地重写并保留剩余过期时间")
+	fmt.Println()
+
+	user := sampleUser()
+	key := fmt.Sprintf("%s%d", cache.UserCacheKeyPrefix, user.ID)
+
+	jsonCodec, _ := cache.ParseUserCodec("json")
+	newCodec, _ := cache.ParseUserCodec("msgpack+snappy")
+
+	legacyCache := cache.NewUserCache(rds) // 旧版本：encoding/json，不认识版本字节
+	compatCache := cache.NewUserCache(rds, cache.WithUserCodec(jsonCodec))
+	newCache := cache.NewUserCache(rds, cache.WithUserCodec(newCodec))
+	defer legacyCache.DeleteUser(user.ID)
+
+	// 步骤1：旧版本实例写入JSON
+	if err := legacyCache.SetUser(user, cache.DefaultExpireSeconds); err != nil {
+		log.Fatalf("写入缓存失败: %v", err)
+	}
+	fmt.Println("[步骤1] 旧版本实例写入用户缓存（JSON）")
+	printRawValue(rds, key)
+
+	// 步骤2：编码配置为 json 的新版本实例，写入的仍是旧格式，旧实例可以继续读取
+	if err := compatCache.SetUser(user, cache.DefaultExpireSeconds); err != nil {
+		log.Fatalf("写入缓存失败: %v", err)
+	}
+	_, err := legacyCache.GetUser(user.ID)
+	fmt.Printf("\n[步骤2] 新版本实例（编码: %s）写入后，旧版本实例读取: error=%v\n", jsonCodec.Name(), err)
+
+	// 步骤3：切换为新编码，读到旧数据时自动迁移
+	fmt.Printf("\n[步骤3] 新版本实例切换编码为 %s 后读取\n", newCodec.Name())
+	got, err := newCache.GetUser(user.ID)
+	if err != nil {
+		log.Fatalf("读取缓存失败: %v", err)
+	}
+	fmt.Printf("  读取成功: ID=%d, Username=%s\n", got.ID, got.Username)
+	printRawValue(rds, key)
+
+	// 步骤4：切换完成后，编码为 json 的实例仍能读取新编码（回滚安全），旧版本实例不能
+	_, compatErr := compatCache.GetUser(user.ID)
+	_, legacyErr := legacyCache.GetUser(user.ID)
+	fmt.Printf("\n[步骤4] 迁移后读取: 新版本(json)实例 error=%v, 旧版本实例 error=%v\n", compatErr, legacyErr)
+	fmt.Println("  → 注意：必须先让所有实例升级到能识别版本字节的代码，再切换编码")
+
+	fmt.Println("\n✓ 场景2测试完成：旧数据在读取时被迁移到新编码，剩余过期时间保持不变")
+}
+
+// printRawValue 打印Redis中的原始值：版本字节、大小和剩余过期时间
+func printRawValue(rds *redis.Redis, key string) {
+	val, err := rds.Get(key)
+	if err != nil || val == "" {
+		fmt.Printf("  Redis原始值: 读取失败 (error=%v)\n", err)
+		return
+	}
+	ttl, _ := rds.Ttl(key)
+
+	header := "无（旧JSON）"
+	if val[0] >= 0x80 {
+		header = fmt.Sprintf("0x%02x", val[0])
+	}
+	fmt.Printf("  Redis原始值: 版本字节=%s, 大小=%d字节, TTL=%d秒\n", header, len(val), ttl)
+}
+
+// initRedis 初始化Redis连接（复用main.go的函数）
+func initRedis(c Config) (*redis.Redis, error) {
+	pingTimeout, err := time.ParseDuration(c.Redis.PingTimeout)
+	if err != nil {
+		pingTimeout = time.Second
+	}
+
+	conf := redis.RedisConf{
+		Host:        c.Redis.Host,
+		Type:        c.Redis.Type,
+		Pass:        c.Redis.Password,
+		PingTimeout: pingTimeout,
+	}
+
+	// 如果密码为空，不设置Pass字段
+	if c.Redis.Password == "" {
+		conf.Pass = ""
+	}
+
+	rds := redis.MustNewRedis(conf)
+	return rds, nil
+}
@@ -2,11 +2,16 @@ package main
 
 import (
 	"cache-demo/cache"
+	"cache-demo/metrics"
 	"cache-demo/model"
 	"cache-demo/service"
 	"fmt"
+	"io"
 	"log"
+	"math/rand"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/zeromicro/go-zero/core/conf"
@@ -72,6 +77,12 @@ func main() {
 
 	// 场景3：空值缓存的效果对比
 	testNullCacheEffectiveness(userRepo, rds, "场景3：空值缓存效果对比")
+
+	// 场景4：随机ID洪水攻击（空值Key上限 + 回源限流）
+	testRandomIDFlood(userRepo, rds, "场景4：随机ID洪水攻击")
+
+	// 场景5：请求参数校验（查询缓存之前拒绝不合法的ID和黑名单客户端）
+	testRequestGuard(userRepo, rds, "场景5：请求参数校验")
 }
 
 // testPenetrationProblem 场景1：演示缓存穿透问题（未使用空值缓存）
@@ -136,7 +147,7 @@ func testNullCacheSolution(repo model.UserRepo, rds *redis.Redis, title string)
 
 	// 使用支持空值缓存的缓存服务
 	userCache := cache.NewUserCacheWithPenetration(rds)
-	userService := service.NewUserServiceWithPenetration(repo, userCache)
+	userService := service.NewUserServiceWithPenetration(repo, userCache, nil)
 
 	nonExistentID := int64(88888)
 
@@ -188,20 +199,21 @@ func testNullCacheEffectiveness(repo model.UserRepo, rds *redis.Redis, title str
 
 	nonExistentID := int64(77777)
 
+	// 数据库访问次数读取自用户缓存的指标（回源加载次数）
+	userMetrics := metrics.For(cache.UserCacheName)
+
 	// 测试1：不使用空值缓存（缓存穿透）
 	fmt.Println("[测试1] 不使用空值缓存（缓存穿透）")
 	userCache1 := cache.NewUserCache(rds)
 	userService1 := service.NewUserService(repo, userCache1)
 
-	var dbQueries1 int
+	before1 := userMetrics.Snapshot()
 	for i := 1; i <= 5; i++ {
-		_, err := userService1.GetUserByID(nonExistentID)
-		if err != nil {
-			dbQueries1++
-		}
+		userService1.GetUserByID(nonExistentID)
 		time.Sleep(100 * time.Millisecond)
 	}
-	fmt.Printf("  查询5次，访问数据库 %d 次\n", dbQueries1)
+	stats1 := userMetrics.Snapshot().Sub(before1)
+	fmt.Printf("  查询5次，访问数据库 %d 次\n", stats1.Loads)
 	fmt.Println("  → 问题：每次查询都访问数据库")
 
 	time.Sleep(500 * time.Millisecond)
@@ -209,30 +221,238 @@ func testNullCacheEffectiveness(repo model.UserRepo, rds *redis.Redis, title str
 	// 测试2：使用空值缓存（解决缓存穿透）
 	fmt.Println("\n[测试2] 使用空值缓存（解决缓存穿透）")
 	userCache2 := cache.NewUserCacheWithPenetration(rds)
-	userService2 := service.NewUserServiceWithPenetration(repo, userCache2)
+	userService2 := service.NewUserServiceWithPenetration(repo, userCache2, nil)
 
-	var dbQueries2 int
+	before2 := userMetrics.Snapshot()
 	for i := 1; i <= 5; i++ {
-		_, err := userService2.GetUserByID(nonExistentID)
-		if err != nil {
-			// 检查是否是空值缓存命中
-			if i == 1 {
-				dbQueries2++ // 第一次查询数据库
-			}
-			// 后续查询都是空值缓存命中，不查询数据库
-		}
+		userService2.GetUserByID(nonExistentID)
 		time.Sleep(100 * time.Millisecond)
 	}
-	fmt.Printf("  查询5次，访问数据库 %d 次\n", dbQueries2)
+	stats2 := userMetrics.Snapshot().Sub(before2)
+	fmt.Printf("  查询5次，访问数据库 %d 次，空值缓存命中 %d 次\n", stats2.Loads, stats2.NullHits)
 	fmt.Println("  → 优势：只有第1次查询数据库，后续都命中空值缓存")
 
 	// 效果对比
 	fmt.Println("\n[效果对比]")
-	fmt.Printf("  不使用空值缓存: 5次查询，%d次数据库访问\n", dbQueries1)
-	fmt.Printf("  使用空值缓存:   5次查询，%d次数据库访问\n", dbQueries2)
-	fmt.Printf("  数据库压力减少: %.1f%%\n", float64(dbQueries1-dbQueries2)/float64(dbQueries1)*100)
+	fmt.Printf("  不使用空值缓存: 5次查询，%d次数据库访问\n", stats1.Loads)
+	fmt.Printf("  使用空值缓存:   5次查询，%d次数据库访问\n", stats2.Loads)
+	if stats1.Loads > 0 {
+		fmt.Printf("  数据库压力减少: %.1f%%\n", float64(stats1.Loads-stats2.Loads)/float64(stats1.Loads)*100)
+	}
 
 	fmt.Println("\n✓ 场景3测试完成：空值缓存显著减少了数据库压力")
+}
+
+// floodResult 一轮洪水攻击的统计
+type floodResult struct {
+	metrics.Snapshot
+	NullKeys    int // 攻击结束后Redis中的空值Key个数
+	NormalOK    int // 正常客户端成功的请求数
+	NormalTotal int // 正常客户端的请求数
+}
+
+// testRandomIDFlood 场景4：多个攻击者用随机ID刷接口，同时有一个正常客户端在查询存在的用户
+func testRandomIDFlood(repo model.UserRepo, rds *redis.Redis, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：攻击者每次请求都换一个随机ID，空值缓存永远不会命中")
+	fmt.Println("问题：每个请求都访问数据库，并且每个ID都留下一个空值Key，Redis中堆满垃圾数据")
+	fmt.Println("方案：空值缓存随机过期时间 + 空值Key个数上限 + 按客户端/全局的回源令牌桶限流")
+	fmt.Println()
+
+	const (
+		attackers          = 4
+		requestsPerAttack  = 200
+		normalRequests     = 50
+		maxNullKeys        = 200
+		globalRate         = 50
+		clientRate         = 20
+		attackIDLowerBound = 100000000
+	)
+
+	type setup struct {
+		name    string
+		cache   cache.UserCacheWithPenetration
+		limiter *service.ProbeLimiter
+	}
+	setups := []setup{
+		{
+			name: "仅空值缓存（固定过期时间、不限Key个数、不限流）",
+			cache: cache.NewUserCacheWithPenetration(rds,
+				cache.WithRandomNullExpire[int64, *model.User](0),
+				cache.WithNullKeyLimit[int64, *model.User]("", 0)),
+		},
+		{
+			name: fmt.Sprintf("随机过期时间 + 空值Key上限%d + 限流（全局%d/秒，每个客户端%d/秒）", maxNullKeys, globalRate, clientRate),
+			cache: cache.NewUserCacheWithPenetration(rds,
+				cache.WithNullKeyLimit[int64, *model.User](cache.UserNullKeyIndex, maxNullKeys)),
+			limiter: service.NewProbeLimiter(rds, globalRate, globalRate, clientRate, clientRate),
+		},
+	}
+
+	userMetrics := metrics.For(cache.UserCacheName)
+	results := make([]floodResult, len(setups))
+	for i, st := range setups {
+		clearNullKeys(rds)
+		userService := service.NewUserServiceWithPenetration(repo, st.cache, st.limiter)
+
+		// 正常客户端先查询一次，把存在的用户加载到缓存
+		normal := userService.ForClient("normal")
+		for id := int64(1); id <= 3; id++ {
+			normal.GetUserByID(id)
+		}
+
+		fmt.Printf("[%s] %d 个攻击者各发送 %d 个随机ID请求...\n", st.name, attackers, requestsPerAttack)
+		// 洪水期间每个请求都会打印日志，暂时关闭
+		log.SetOutput(io.Discard)
+		before := userMetrics.Snapshot()
+		var (
+			wg     sync.WaitGroup
+			result floodResult
+		)
+		for a := 0; a < attackers; a++ {
+			wg.Add(1)
+			go func(a int) {
+				defer wg.Done()
+				attacker := userService.ForClient(fmt.Sprintf("attacker-%d", a))
+				for j := 0; j < requestsPerAttack; j++ {
+					attacker.GetUserByID(attackIDLowerBound + rand.Int63n(attackIDLowerBound))
+				}
+			}(a)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < normalRequests; j++ {
+				if _, err := normal.GetUserByID(int64(j%3 + 1)); err == nil {
+					result.NormalOK++
+				}
+				result.NormalTotal++
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+		wg.Wait()
+		log.SetOutput(os.Stderr)
+
+		result.Snapshot = userMetrics.Snapshot().Sub(before)
+		result.NullKeys = countNullKeys(rds)
+		results[i] = result
+		fmt.Printf("  访问数据库 %d 次，回源被限流 %d 次，Redis中空值Key %d 个，正常客户端成功 %d/%d\n",
+			result.Loads, result.LoadRejects, result.NullKeys, result.NormalOK, result.NormalTotal)
+	}
+	clearNullKeys(rds)
+
+	fmt.Println("\n[效果对比]")
+	fmt.Printf("  %-10s %12s %12s %12s %14s\n", "方案", "数据库访问", "回源限流", "空值Key", "正常请求成功")
+	for i, r := range results {
+		fmt.Printf("  %-10s %12d %12d %12d %11d/%d\n", fmt.Sprintf("方案%d", i+1), r.Loads, r.LoadRejects, r.NullKeys, r.NormalOK, r.NormalTotal)
+	}
+	fmt.Println("  → 限流只作用于缓存未命中的请求，正常客户端查询的用户已在缓存中，不受攻击者影响")
+	fmt.Println("  → 空值Key超过上限时淘汰最早过期的，Redis中的垃圾Key个数有上限")
+
+	fmt.Println("\n✓ 场景4测试完成：随机ID攻击下数据库访问次数和空值Key个数都被限制住了")
+}
+
+// testRequestGuard 场景5：请求校验中间件，在查询Redis和MySQL之前拒绝不合法的ID和黑名单客户端
+func testRequestGuard(repo model.UserRepo, rds *redis.Redis, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：空值缓存和回源限流都要先访问Redis，随机ID攻击的每个请求仍然会落到Redis上")
+	fmt.Println("方案：在服务外面包一层请求校验，非正数ID、超过当前最大ID的ID、黑名单客户端直接拒绝")
+	fmt.Println()
+
+	const (
+		attackers          = 4
+		requestsPerAttack  = 200
+		attackIDLowerBound = 100000000
+		blockedIP          = "203.0.113.7"
+	)
+
+	guard, err := service.NewRequestGuard(repo, rds, service.GuardConfig{
+		IDFormat:     service.IDFormatAutoIncrement,
+		CheckMaxID:   true,
+		MaxIDSlack:   100,
+		Blacklist:    []string{"10.0.0.0/8"},
+		BlacklistKey: service.GuardBlacklistKey,
+	})
+	if err != nil {
+		log.Printf("创建请求校验器失败: %v", err)
+		return
+	}
+	clearNullKeys(rds)
+	userCache := cache.NewUserCacheWithPenetration(rds)
+	userService := service.NewGuardedUserService(
+		service.NewUserServiceWithPenetration(repo, userCache, service.NewDefaultProbeLimiter(rds)), guard)
+	userMetrics := metrics.For(cache.UserCacheName)
+
+	// 1. 不合法的ID
+	fmt.Println("[1] 查询不合法的ID")
+	for _, id := range []int64{-1, 0, attackIDLowerBound} {
+		before := userMetrics.Snapshot()
+		_, err := userService.GetUserByID(id)
+		delta := userMetrics.Snapshot().Sub(before)
+		fmt.Printf("  user_id=%d: %v（查询缓存 %d 次）\n", id, err, delta.Hits+delta.NullHits+delta.Misses)
+	}
+	if user, err := userService.GetUserByID(1); err == nil {
+		fmt.Printf("  user_id=1: 正常返回 %s\n", user.Username)
+	}
+
+	// 2. 批量查询：不合法的ID直接算作不存在
+	fmt.Println("\n[2] 批量查询 [1, 2, -5, 100000000]")
+	users, missing, err := userService.GetUsersByIDs([]int64{1, 2, -5, attackIDLowerBound})
+	if err != nil {
+		log.Printf("批量查询失败: %v", err)
+	} else {
+		fmt.Printf("  返回 %d 个用户，不存在的ID: %v\n", len(users), missing)
+	}
+
+	// 3. 随机ID攻击：所有请求都在校验阶段被拒绝
+	fmt.Printf("\n[3] %d 个攻击者各发送 %d 个随机ID请求...\n", attackers, requestsPerAttack)
+	log.SetOutput(io.Discard)
+	before := userMetrics.Snapshot()
+	statsBefore := guard.Stats()
+	var wg sync.WaitGroup
+	for a := 0; a < attackers; a++ {
+		wg.Add(1)
+		go func(a int) {
+			defer wg.Done()
+			attacker := userService.ForClient(fmt.Sprintf("attacker-%d", a))
+			for j := 0; j < requestsPerAttack; j++ {
+				attacker.GetUserByID(attackIDLowerBound + rand.Int63n(attackIDLowerBound))
+			}
+		}(a)
+	}
+	wg.Wait()
+	log.SetOutput(os.Stderr)
+	delta := userMetrics.Snapshot().Sub(before)
+	fmt.Printf("  校验拒绝 %d 次，查询缓存 %d 次，访问数据库 %d 次，Redis中空值Key %d 个\n",
+		guard.Stats().OutOfRange-statsBefore.OutOfRange, delta.Hits+delta.NullHits+delta.Misses, delta.Loads, countNullKeys(rds))
+
+	// 4. 黑名单
+	fmt.Println("\n[4] 客户端黑名单")
+	if _, err := userService.ForClient("10.1.2.3").GetUserByID(1); err != nil {
+		fmt.Printf("  10.1.2.3（静态黑名单 10.0.0.0/8）: %v\n", err)
+	}
+	if err := guard.Block(blockedIP); err != nil {
+		log.Printf("加入黑名单失败: %v", err)
+	}
+	if _, err := userService.ForClient(blockedIP).GetUserByID(1); err != nil {
+		fmt.Printf("  %s（动态黑名单）: %v\n", blockedIP, err)
+	}
+	if err := guard.Unblock(blockedIP); err != nil {
+		log.Printf("移出黑名单失败: %v", err)
+	}
+	if user, err := userService.ForClient(blockedIP).GetUserByID(1); err == nil {
+		fmt.Printf("  %s（移出黑名单后）: 正常返回 %s\n", blockedIP, user.Username)
+	}
+
+	fmt.Printf("\n[统计] %s\n", guard.Stats())
+	fmt.Println("  → 不合法的请求不查询Redis、不访问数据库、不产生空值Key，也不消耗回源限流的令牌")
+	fmt.Println("  → 只能拒绝\"一定不存在\"的ID，最大ID范围内的随机ID仍然需要空值缓存和限流兜底")
+
+	fmt.Println("\n✓ 场景5测试完成：不合法的ID和黑名单客户端在查询缓存之前就被拒绝")
 	fmt.Println("\n" + strings.Repeat("=", 80))
 	fmt.Println("所有测试场景完成！")
 	fmt.Println(strings.Repeat("=", 80))
@@ -240,6 +460,51 @@ func testNullCacheEffectiveness(repo model.UserRepo, rds *redis.Redis, title str
 	fmt.Println("1. 缓存穿透：查询不存在的数据，每次都访问数据库")
 	fmt.Println("2. 空值缓存：将空结果缓存起来，避免重复查询数据库")
 	fmt.Println("3. 效果：显著减少数据库压力，提升系统性能")
+	fmt.Println("4. 随机ID攻击：空值缓存不再有效，需要限制空值Key个数，并对回源请求限流")
+	fmt.Println("5. 请求校验：ID范围、ID格式、黑名单在查询缓存之前拦截，成本最低")
+}
+
+// countNullKeys 统计Redis中值为空值标记的用户缓存Key个数
+func countNullKeys(rds *redis.Redis) int {
+	keys, err := rds.Keys(cache.UserCacheKeyPrefix + "*")
+	if err != nil {
+		log.Printf("获取缓存Key失败: %v", err)
+		return 0
+	}
+
+	count := 0
+	for start := 0; start < len(keys); start += 500 {
+		end := min(start+500, len(keys))
+		vals, err := rds.Mget(keys[start:end]...)
+		if err != nil {
+			log.Printf("批量读取缓存失败: %v", err)
+			return count
+		}
+		for _, val := range vals {
+			if val == cache.NullCacheValue {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// clearNullKeys 删除所有用户空值缓存Key和空值Key索引
+func clearNullKeys(rds *redis.Redis) {
+	keys, _ := rds.Keys(cache.UserCacheKeyPrefix + "*")
+	for start := 0; start < len(keys); start += 500 {
+		end := min(start+500, len(keys))
+		vals, err := rds.Mget(keys[start:end]...)
+		if err != nil {
+			continue
+		}
+		for j, val := range vals {
+			if val == cache.NullCacheValue {
+				rds.Del(keys[start+j])
+			}
+		}
+	}
+	rds.Del(cache.UserNullKeyIndex)
 }
 
 // initDB 初始化数据库连接（复用main.go的函数）
@@ -4,6 +4,7 @@ import (
 	"cache-demo/cache"
 	"cache-demo/model"
 	"cache-demo/service"
+	"context"
 	"fmt"
 	"log"
 	"strings"
@@ -73,6 +74,9 @@ func main() {
 
 	// 场景3：数据一致性要求高 - 删除缓存策略
 	testScenario3(userRepo, userCache, "场景3：数据一致性要求高 - 删除缓存策略")
+
+	// 场景4：并发读写 - 延迟双删策略
+	testScenario4(userRepo, userCache, "场景4：并发读写 - 延迟双删策略")
 }
 
 // testScenario1 场景1：读多写少 - 更新缓存策略
@@ -270,11 +274,105 @@ func testScenario3(repo model.UserRepo, userCache cache.UserCache, title string)
 	fmt.Println("  → 注意：这次查询缓存未命中，从数据库重新加载，保证数据一致性")
 
 	fmt.Println("\n✓ 场景3测试完成：删除缓存策略适合数据一致性要求高的场景")
+}
+
+// slowReadRepo 模拟慢读请求：查到数据库旧值后，要过一段时间才写回缓存
+type slowReadRepo struct {
+	model.UserRepo
+	delay time.Duration
+}
+
+// FindByIDCtx 查询数据库后延迟返回（服务层都通过带 ctx 的方法查询）
+func (r *slowReadRepo) FindByIDCtx(ctx context.Context, id int64) (*model.User, error) {
+	user, err := r.UserRepo.FindByIDCtx(ctx, id)
+	time.Sleep(r.delay)
+	return user, err
+}
+
+// testScenario4 场景4：并发读写 - 延迟双删策略
+// 特点：写请求删除缓存的同时，并发读请求读到数据库旧值并回填缓存
+// 策略：延迟双删（第二次删除在读请求回填之后执行，删掉旧数据）
+func testScenario4(repo model.UserRepo, userCache cache.UserCache, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：读请求查到旧值后，写请求更新数据库并删除缓存，读请求再把旧值写回缓存")
+	fmt.Println("问题：只删一次缓存时，旧数据会一直留在缓存中直到过期")
+	fmt.Println()
+
+	const readDelay = 200 * time.Millisecond
+	userID := int64(3)
+
+	// 读请求使用慢仓储，放大"查到旧值 -> 写回缓存"的窗口
+	reader := service.NewUserServiceWithStrategy(&slowReadRepo{UserRepo: repo, delay: readDelay}, userCache, service.DeleteCache)
+
+	// 测试1：只删一次缓存
+	fmt.Println("[测试1] 删除缓存策略（只删一次）")
+	writer1 := service.NewUserServiceWithStrategy(repo, userCache, service.DeleteCache)
+	stale1 := runReadWriteRace(repo, userCache, reader, writer1, userID, "delete_once@example.com", 0)
+
+	time.Sleep(500 * time.Millisecond)
+
+	// 测试2：延迟双删
+	fmt.Println("\n[测试2] 延迟双删策略（第二次删除延迟500ms）")
+	writer2 := service.NewUserServiceWithDelayedDoubleDelete(repo, userCache, service.DefaultDoubleDeleteDelay)
+	stale2 := runReadWriteRace(repo, userCache, reader, writer2, userID, "double_delete@example.com", service.DefaultDoubleDeleteDelay)
+
+	// 效果对比
+	fmt.Println("\n[效果对比]")
+	fmt.Printf("  删除缓存（一次）: 缓存中是否残留旧数据: %v\n", stale1)
+	fmt.Printf("  延迟双删:         缓存中是否残留旧数据: %v\n", stale2)
+
+	fmt.Println("\n✓ 场景4测试完成：延迟双删关闭了并发读回填旧数据的窗口")
 	fmt.Println("\n" + strings.Repeat("=", 80))
 	fmt.Println("所有测试场景完成！")
 	fmt.Println(strings.Repeat("=", 80))
 }
 
+// runReadWriteRace 构造一次"读请求回填旧值"的并发竞争，返回最终缓存中是否残留旧数据
+func runReadWriteRace(repo model.UserRepo, userCache cache.UserCache, reader, writer service.UserService,
+	userID int64, newEmail string, secondDeleteDelay time.Duration) bool {
+	user, err := repo.FindByID(userID)
+	if err != nil {
+		log.Printf("查询失败: %v", err)
+		return false
+	}
+	oldEmail := user.Email
+	userCache.DeleteUser(userID)
+
+	// 步骤1：读请求缓存未命中，查到数据库旧值（还没写回缓存）
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reader.GetUserByID(userID)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	fmt.Printf("  [步骤1] 读请求查到旧值: Email=%s\n", oldEmail)
+
+	// 步骤2：写请求更新数据库并删除缓存
+	user.Email = newEmail
+	if err := writer.UpdateUser(user); err != nil {
+		log.Printf("更新失败: %v", err)
+		return false
+	}
+	fmt.Printf("  [步骤2] 写请求更新数据库: Email=%s\n", newEmail)
+
+	// 步骤3：读请求把旧值写回缓存
+	<-done
+	fmt.Println("  [步骤3] 读请求把旧值写回缓存")
+
+	// 等待延迟双删的第二次删除执行
+	time.Sleep(secondDeleteDelay + 200*time.Millisecond)
+
+	cached, err := userCache.GetUser(userID)
+	if err == nil && cached != nil && cached.Email != newEmail {
+		fmt.Printf("  [结果] ✗ 缓存中残留旧数据: Email=%s\n", cached.Email)
+		return true
+	}
+	fmt.Println("  [结果] ✓ 缓存中没有旧数据，下次查询从数据库加载最新值")
+	return false
+}
+
 // initDB 初始化数据库连接（复用main.go的函数）
 func initDB(c Config) (*gorm.DB, error) {
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
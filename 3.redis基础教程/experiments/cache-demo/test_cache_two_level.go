@@ -0,0 +1,291 @@
+package main
+
+import (
+	"cache-demo/cache"
+	"cache-demo/model"
+	"cache-demo/service"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	red "github.com/go-redis/redis/v8"
+	"github.com/zeromicro/go-zero/core/conf"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Config 配置结构（复用main.go的配置）
+type Config struct {
+	MySQL struct {
+		Host         string `json:"host" yaml:"host"`
+		Port         int    `json:"port" yaml:"port"`
+		User         string `json:"user" yaml:"user"`
+		Password     string `json:"password" yaml:"password"`
+		Database     string `json:"database" yaml:"database"`
+		MaxOpenConns int    `json:"max_open_conns" yaml:"max_open_conns"`
+		MaxIdleConns int    `json:"max_idle_conns" yaml:"max_idle_conns"`
+	} `json:"mysql" yaml:"mysql"`
+	Redis struct {
+		Host        string `json:"host" yaml:"host"`
+		Password    string `json:"password" yaml:"password"`
+		Type        string `json:"type" yaml:"type"`
+		PingTimeout string `json:"ping_timeout" yaml:"ping_timeout"`
+	} `json:"redis" yaml:"redis"`
+}
+
+func main() {
+	// 加载配置
+	var c Config
+	conf.MustLoad("config.yaml", &c)
+
+	// 初始化数据库连接
+	db, err := initDB(c)
+	if err != nil {
+		log.Fatalf("初始化数据库失败: %v", err)
+	}
+
+	// 初始化Redis连接
+	rds, err := initRedis(c)
+	if err != nil {
+		log.Fatalf("初始化Redis失败: %v", err)
+	}
+
+	// 初始化用于 pub/sub 的Redis客户端
+	client := red.NewClient(&red.Options{
+		Addr:     c.Redis.Host,
+		Password: c.Redis.Password,
+		DB:       0,
+	})
+	defer client.Close()
+
+	// 确保测试数据存在
+	if err := ensureTestData(db); err != nil {
+		log.Fatalf("初始化测试数据失败: %v", err)
+	}
+
+	// 初始化服务层
+	userRepo := model.NewUserRepo(db)
+
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("二级缓存（进程内LRU + Redis）测试")
+	fmt.Println(strings.Repeat("=", 80))
+
+	// 场景1：L1 与 L2 的读取延迟对比
+	testL1Latency(userRepo, rds, client, "场景1：L1 与 L2 读取延迟对比")
+
+	// 场景2：跨实例失效通知
+	testCrossInstanceInvalidation(userRepo, rds, client, "场景2：跨实例失效通知")
+}
+
+// testL1Latency 场景1：L1 与 L2 的读取延迟对比
+func testL1Latency(repo model.UserRepo, rds *redis.Redis, client *red.Client, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：热点用户命中L1时不需要访问Redis，省掉一次网络往返")
+	fmt.Println()
+
+	const rounds = 1000
+	userID := int64(1)
+
+	l2 := cache.NewUserCache(rds)
+	twoLevel, err := cache.NewTwoLevelUserCache(l2, client, cache.L1DefaultSize, cache.L1DefaultExpire)
+	if err != nil {
+		log.Fatalf("创建二级缓存失败: %v", err)
+	}
+	defer twoLevel.Close()
+
+	user, err := repo.FindByID(userID)
+	if err != nil {
+		log.Fatalf("查询用户失败: %v", err)
+	}
+	twoLevel.SetUser(user, cache.DefaultExpireSeconds)
+
+	// 测试1：只用Redis
+	start1 := time.Now()
+	for i := 0; i < rounds; i++ {
+		l2.GetUser(userID)
+	}
+	avg1 := time.Since(start1) / rounds
+	fmt.Printf("[测试1] 只使用Redis: 读取 %d 次，平均耗时 %v\n", rounds, avg1)
+
+	// 测试2：L1 + Redis
+	start2 := time.Now()
+	for i := 0; i < rounds; i++ {
+		twoLevel.GetUser(userID)
+	}
+	avg2 := time.Since(start2) / rounds
+	fmt.Printf("[测试2] L1 + Redis:  读取 %d 次，平均耗时 %v\n", rounds, avg2)
+
+	if avg2 > 0 {
+		fmt.Printf("  → 优势：L1命中时延迟降低约 %.0f 倍\n", float64(avg1)/float64(avg2))
+	}
+
+	fmt.Println("\n✓ 场景1测试完成：热点数据命中L1，不再有Redis网络往返")
+}
+
+// testCrossInstanceInvalidation 场景2：跨实例失效通知
+func testCrossInstanceInvalidation(repo model.UserRepo, rds *redis.Redis, client *red.Client, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：实例A更新用户后，通过 pub/sub 通知实例B删除自己的L1副本")
+	fmt.Println()
+
+	userID := int64(2)
+	l2 := cache.NewUserCache(rds)
+
+	// 两个实例共享同一个L2（Redis），各自有独立的L1
+	cacheA, err := cache.NewTwoLevelUserCache(l2, client, cache.L1DefaultSize, cache.L1DefaultExpire)
+	if err != nil {
+		log.Fatalf("创建实例A的二级缓存失败: %v", err)
+	}
+	defer cacheA.Close()
+	cacheB, err := cache.NewTwoLevelUserCache(l2, client, cache.L1DefaultSize, cache.L1DefaultExpire)
+	if err != nil {
+		log.Fatalf("创建实例B的二级缓存失败: %v", err)
+	}
+	defer cacheB.Close()
+
+	serviceA := service.NewUserService(repo, cacheA)
+	serviceB := service.NewUserService(repo, cacheB)
+
+	// 步骤1：两个实例都读取一次，L1中都有副本
+	fmt.Println("[步骤1] 实例A和实例B各查询一次用户（填充各自的L1）")
+	userA, err := serviceA.GetUserByID(userID)
+	if err != nil {
+		log.Printf("查询失败: %v", err)
+		return
+	}
+	userB, err := serviceB.GetUserByID(userID)
+	if err != nil {
+		log.Printf("查询失败: %v", err)
+		return
+	}
+	fmt.Printf("  实例A: Age=%d, 实例B: Age=%d\n", userA.Age, userB.Age)
+
+	// 步骤2：实例A更新用户
+	fmt.Println("\n[步骤2] 实例A更新用户年龄")
+	userA.Age++
+	if err := serviceA.UpdateUser(userA); err != nil {
+		log.Printf("更新失败: %v", err)
+		return
+	}
+	fmt.Printf("✓ 实例A更新成功: Age=%d\n", userA.Age)
+
+	// 等待失效通知送达
+	time.Sleep(100 * time.Millisecond)
+
+	// 步骤3：实例B再次查询
+	fmt.Println("\n[步骤3] 实例B再次查询用户")
+	userB, err = serviceB.GetUserByID(userID)
+	if err != nil {
+		log.Printf("查询失败: %v", err)
+		return
+	}
+	fmt.Printf("✓ 实例B查询结果: Age=%d\n", userB.Age)
+	if userB.Age == userA.Age {
+		fmt.Println("  → 实例B的L1已被失效通知删除，从Redis读取到最新数据")
+	} else {
+		fmt.Println("  → 实例B读取到旧数据，请检查 pub/sub 是否正常")
+	}
+
+	fmt.Println("\n✓ 场景2测试完成：pub/sub 保证了多实例L1的一致性")
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("所有测试场景完成！")
+	fmt.Println(strings.Repeat("=", 80))
+	fmt.Println("\n总结：")
+	fmt.Println("1. L1：进程内LRU缓存，命中时没有网络往返，适合少量热点数据")
+	fmt.Println("2. L2：Redis，多实例共享")
+	fmt.Println("3. 一致性：写操作通过 pub/sub 广播，其他实例删除L1副本；L1过期时间兜底")
+}
+
+// initDB 初始化数据库连接（复用main.go的函数）
+func initDB(c Config) (*gorm.DB, error) {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+		c.MySQL.User,
+		c.MySQL.Password,
+		c.MySQL.Host,
+		c.MySQL.Port,
+		c.MySQL.Database,
+	)
+
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("连接数据库失败: %w", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("获取数据库实例失败: %w", err)
+	}
+
+	if c.MySQL.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(c.MySQL.MaxOpenConns)
+	}
+	if c.MySQL.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(c.MySQL.MaxIdleConns)
+	}
+
+	return db, nil
+}
+
+// initRedis 初始化Redis连接（复用main.go的函数）
+func initRedis(c Config) (*redis.Redis, error) {
+	pingTimeout, err := time.ParseDuration(c.Redis.PingTimeout)
+	if err != nil {
+		pingTimeout = time.Second
+	}
+
+	conf := redis.RedisConf{
+		Host:        c.Redis.Host,
+		Type:        c.Redis.Type,
+		Pass:        c.Redis.Password,
+		PingTimeout: pingTimeout,
+	}
+
+	// 如果密码为空，不设置Pass字段
+	if c.Redis.Password == "" {
+		conf.Pass = ""
+	}
+
+	rds := redis.MustNewRedis(conf)
+	return rds, nil
+}
+
+// ensureTestData 确保测试数据存在（复用main.go的函数）
+func ensureTestData(db *gorm.DB) error {
+	var count int64
+	db.Model(&model.User{}).Count(&count)
+
+	if count == 0 {
+		log.Println("检测到数据库中没有测试数据，正在初始化...")
+		return initTestData(db)
+	}
+
+	log.Printf("数据库已有 %d 条测试数据", count)
+	return nil
+}
+
+// initTestData 初始化测试数据（复用main.go的函数）
+func initTestData(db *gorm.DB) error {
+	users := []*model.User{
+		{Username: "alice", Email: "alice@example.com", Age: 20},
+		{Username: "bob", Email: "bob@example.com", Age: 25},
+		{Username: "charlie", Email: "charlie@example.com", Age: 30},
+	}
+
+	for _, user := range users {
+		if err := db.Create(user).Error; err != nil {
+			return fmt.Errorf("创建用户失败: %w", err)
+		}
+		log.Printf("创建测试用户: ID=%d, Username=%s", user.ID, user.Username)
+	}
+
+	return nil
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"cache-demo/breaker"
+	"cache-demo/cache"
+	"cache-demo/metrics"
+	"cache-demo/model"
+	"cache-demo/service"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/conf"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Config 配置结构（复用main.go的配置）
+type Config struct {
+	MySQL struct {
+		Host         string `json:"host" yaml:"host"`
+		Port         int    `json:"port" yaml:"port"`
+		User         string `json:"user" yaml:"user"`
+		Password     string `json:"password" yaml:"password"`
+		Database     string `json:"database" yaml:"database"`
+		MaxOpenConns int    `json:"max_open_conns" yaml:"max_open_conns"`
+		MaxIdleConns int    `json:"max_idle_conns" yaml:"max_idle_conns"`
+	} `json:"mysql" yaml:"mysql"`
+	Redis struct {
+		Host        string `json:"host" yaml:"host"`
+		Password    string `json:"password" yaml:"password"`
+		Type        string `json:"type" yaml:"type"`
+		PingTimeout string `json:"ping_timeout" yaml:"ping_timeout"`
+	} `json:"redis" yaml:"redis"`
+}
+
+// 场景2、3的时间线：运行 runDuration，killAt 时数据库宕机，reviveAt 时恢复
+const (
+	dbTimeout      = 300 * time.Millisecond // 数据库宕机后每次查询等待的时间（模拟驱动的连接超时）
+	clients        = 5
+	runDuration    = 3500 * time.Millisecond
+	killAt         = 1000 * time.Millisecond
+	reviveAt       = 2500 * time.Millisecond
+	timelineBucket = 500 * time.Millisecond
+	requestPause   = 20 * time.Millisecond
+	noStaleUserID  = 3 // 宕机时删除该用户的旧数据副本，演示没有旧数据时的快速失败
+)
+
+// timeoutError 模拟网络超时（实现 net.Error）
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// standInRepo 数据库替身：包装真实的用户仓储，kill 之后每次查询等待 dbTimeout 再返回超时错误
+type standInRepo struct {
+	model.UserRepo
+	down      atomic.Bool
+	downCalls atomic.Int64 // 宕机期间收到的查询次数
+}
+
+// FindByIDCtx 根据ID查询用户，宕机时等待超时（服务层都通过带 ctx 的方法查询）
+func (r *standInRepo) FindByIDCtx(ctx context.Context, id int64) (*model.User, error) {
+	if r.down.Load() {
+		r.downCalls.Add(1)
+		time.Sleep(dbTimeout)
+		return nil, fmt.Errorf("dial tcp 127.0.0.1:3306: %w", timeoutError{})
+	}
+	user, err := r.UserRepo.FindByIDCtx(ctx, id)
+	// 查询过程中数据库宕机，连接断开
+	if r.down.Load() {
+		return nil, fmt.Errorf("read tcp 127.0.0.1:3306: %w", timeoutError{})
+	}
+	return user, err
+}
+
+func main() {
+	// 加载配置
+	var c Config
+	conf.MustLoad("config.yaml", &c)
+
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("熔断器测试")
+	fmt.Println(strings.Repeat("=", 80))
+
+	// 场景1 不需要数据库和Redis
+	testStateMachine("场景1：熔断器状态变化")
+
+	// 初始化数据库和Redis连接
+	db, err := initDB(c)
+	if err != nil {
+		log.Fatalf("初始化数据库失败: %v", err)
+	}
+	rds, err := initRedis(c)
+	if err != nil {
+		log.Fatalf("初始化Redis失败: %v", err)
+	}
+	repo := model.NewUserRepo(db)
+
+	testWithoutBreaker(repo, rds, "场景2：数据库宕机（没有熔断器）")
+	testWithBreaker(repo, rds, "场景3：数据库宕机（熔断器 + 旧数据降级）")
+}
+
+// testStateMachine 场景1：关闭 -> 打开 -> 半开 -> 打开 -> 半开 -> 关闭
+func testStateMachine(title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("配置：窗口1s，至少10个请求，错误率50%打开，打开300ms后半开，半开放行2个探测请求")
+	fmt.Println()
+
+	brk := service.NewBreaker("demo",
+		breaker.WithWindow(time.Second, 10),
+		breaker.WithMinRequests(10),
+		breaker.WithErrorRate(0.5),
+		breaker.WithOpenTimeout(300*time.Millisecond),
+		breaker.WithHalfOpenRequests(2),
+	)
+	failure := errors.New("模拟失败")
+	call := func(n int, err error) {
+		for i := 0; i < n; i++ {
+			_ = brk.Do(func() error { return err })
+		}
+	}
+
+	call(6, nil)
+	call(3, failure)
+	fmt.Printf("  6次成功 + 3次失败（请求数不足10）: %v\n", brk.Counts())
+	call(3, failure)
+	fmt.Printf("  再失败3次（错误率50%%）:          %v\n", brk.Counts())
+
+	start := time.Now()
+	err := brk.Do(func() error { return nil })
+	fmt.Printf("  打开状态下的请求: 耗时 %v, error=%v, errors.Is(err, breaker.ErrOpen)=%v\n",
+		time.Since(start), err, errors.Is(err, breaker.ErrOpen))
+
+	time.Sleep(300 * time.Millisecond)
+	fmt.Printf("  300ms 后:                         状态=%s\n", brk.State())
+	call(1, failure)
+	fmt.Printf("  探测请求失败:                     状态=%s\n", brk.State())
+
+	time.Sleep(300 * time.Millisecond)
+	call(2, nil)
+	fmt.Printf("  再等300ms，2个探测请求成功:       %v\n", brk.Counts())
+
+	fmt.Println("\n  → 请求数达到下限且错误率达到阈值才打开，少量失败不会误判")
+	fmt.Println("  → 打开后直接返回 breaker.ErrOpen，不执行请求；半开时任意一个探测失败就重新打开")
+
+	fmt.Println("\n✓ 场景1测试完成")
+}
+
+// testWithoutBreaker 场景2：数据库宕机后，每个缓存未命中的请求都要等待驱动超时
+func testWithoutBreaker(repo model.UserRepo, rds *redis.Redis, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Printf("说明：%d个客户端持续查询用户1-3（每次先删除缓存，模拟缓存过期），%v 时数据库宕机，%v 时恢复\n",
+		clients, killAt, reviveAt)
+	fmt.Printf("      宕机期间每次查询要等待 %v 才返回超时错误\n", dbTimeout)
+	fmt.Println()
+
+	db := &standInRepo{UserRepo: repo}
+	userCache := cache.NewUserCache(rds, cache.WithUserStaleCopy(cache.DefaultStaleExtraSeconds))
+	runTimeline(service.NewUserService(db, userCache), db, rds, nil)
+
+	fmt.Println("\n  → 宕机期间每个请求都等满超时时间才失败，请求堆积，数据库恢复前一直在做无用功")
+
+	fmt.Println("\n✓ 场景2测试完成")
+}
+
+// testWithBreaker 场景3：数据库熔断后直接失败，有旧数据副本的用户降级返回旧数据
+func testWithBreaker(repo model.UserRepo, rds *redis.Redis, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("方案：用户仓储包装熔断器（窗口1s，至少5个请求，错误率50%，打开500ms后半开）")
+	fmt.Println("      缓存写入时同时写旧数据副本 stale:user:<id>，熔断时降级返回副本")
+	fmt.Printf("      数据库宕机时删除用户%d的旧数据副本，演示没有副本时的快速失败\n", noStaleUserID)
+	fmt.Println()
+
+	db := &standInRepo{UserRepo: repo}
+	brk := service.NewBreaker("mysql",
+		breaker.WithWindow(time.Second, 10),
+		breaker.WithMinRequests(5),
+		breaker.WithErrorRate(0.5),
+		breaker.WithOpenTimeout(500*time.Millisecond),
+		breaker.WithHalfOpenRequests(2),
+	)
+	userCache := cache.NewUserCache(rds, cache.WithUserStaleCopy(cache.DefaultStaleExtraSeconds))
+	runTimeline(service.NewUserService(model.NewBreakerUserRepo(db, brk), userCache), db, rds, brk)
+
+	fmt.Printf("\n  熔断器: %v\n", brk.Counts())
+	fmt.Println("\n  → 熔断器打开后请求不再访问数据库，耗时从几百毫秒降到1毫秒以内")
+	fmt.Println("  → 有旧数据副本的用户降级返回旧数据，没有副本的用户快速失败")
+	fmt.Println("  → 数据库恢复后，半开状态的探测请求成功，熔断器自动关闭")
+
+	fmt.Println("\n✓ 场景3测试完成")
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("所有测试场景完成！")
+	fmt.Println(strings.Repeat("=", 80))
+	fmt.Println("\n总结：")
+	fmt.Println("1. 熔断器按滑动窗口的错误率打开，打开后直接失败，不再等待驱动超时")
+	fmt.Println("2. 半开状态放行少量探测请求，依赖恢复后自动关闭")
+	fmt.Println("3. 数据库熔断时返回旧数据副本：数据可能不是最新的，但比报错好")
+	fmt.Println("4. 记录不存在、参数错误等业务错误不计入失败")
+}
+
+// sample 一次请求的结果
+type sample struct {
+	at      time.Duration // 请求开始时间（相对于开始运行）
+	elapsed time.Duration
+	err     error
+	state   breaker.State
+}
+
+// runTimeline 多个客户端持续查询用户，运行过程中让数据库宕机再恢复，按时间段输出请求结果
+func runTimeline(userService service.UserService, db *standInRepo, rds *redis.Redis, brk *breaker.Breaker) {
+	userMetrics := metrics.For(cache.UserCacheName)
+	before := userMetrics.Snapshot()
+	start := time.Now()
+
+	go func() {
+		time.Sleep(killAt)
+		db.down.Store(true)
+		if _, err := rds.Del(cache.StaleKeyPrefix + userKey(noStaleUserID)); err != nil {
+			log.Printf("删除旧数据副本失败: %v", err)
+		}
+		log.Printf("========== 数据库宕机 ==========")
+		time.Sleep(reviveAt - killAt)
+		db.down.Store(false)
+		log.Printf("========== 数据库恢复 ==========")
+	}()
+
+	var mu sync.Mutex
+	var samples []sample
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(client int) {
+			defer wg.Done()
+			for n := client; time.Since(start) < runDuration; n++ {
+				id := int64(n%3 + 1)
+				// 删除正常缓存（模拟缓存过期），每个请求都要回源
+				if _, err := rds.Del(userKey(id)); err != nil {
+					log.Printf("删除缓存失败: %v", err)
+				}
+
+				s := sample{at: time.Since(start)}
+				begin := time.Now()
+				_, s.err = userService.GetUserByID(id)
+				s.elapsed = time.Since(begin)
+				if brk != nil {
+					s.state = brk.State()
+				}
+
+				mu.Lock()
+				samples = append(samples, s)
+				mu.Unlock()
+				time.Sleep(requestPause)
+			}
+		}(i)
+	}
+	wg.Wait()
+	delta := userMetrics.Snapshot().Sub(before)
+
+	fmt.Println()
+	fmt.Printf("  %-12s %-8s %-6s %-6s %-10s %-10s %s\n", "时间段", "数据库", "请求", "失败", "平均耗时", "最大耗时", "熔断器")
+	for from := time.Duration(0); from < runDuration; from += timelineBucket {
+		to := from + timelineBucket
+		var count, failed int
+		var total, slowest time.Duration
+		state := "-"
+		for _, s := range samples {
+			if s.at < from || s.at >= to {
+				continue
+			}
+			count++
+			total += s.elapsed
+			slowest = max(slowest, s.elapsed)
+			if s.err != nil {
+				failed++
+			}
+			if brk != nil {
+				state = s.state.String()
+			}
+		}
+		dbState := "正常"
+		if from >= killAt && from < reviveAt {
+			dbState = "宕机"
+		}
+		var avg time.Duration
+		if count > 0 {
+			avg = total / time.Duration(count)
+		}
+		fmt.Printf("  %4.1fs-%4.1fs  %-8s %-6d %-6d %-10v %-10v %s\n",
+			from.Seconds(), to.Seconds(), dbState, count, failed,
+			avg.Round(time.Microsecond*100), slowest.Round(time.Microsecond*100), state)
+	}
+	fmt.Printf("\n  宕机期间访问数据库 %d 次，整个过程降级返回旧数据 %d 次\n", db.downCalls.Load(), delta.StaleHits)
+}
+
+// userKey 用户缓存Key
+func userKey(id int64) string {
+	return fmt.Sprintf("%s%d", cache.UserCacheKeyPrefix, id)
+}
+
+// initDB 初始化数据库连接（复用main.go的函数）
+func initDB(c Config) (*gorm.DB, error) {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+		c.MySQL.User,
+		c.MySQL.Password,
+		c.MySQL.Host,
+		c.MySQL.Port,
+		c.MySQL.Database,
+	)
+
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("连接数据库失败: %w", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("获取数据库实例失败: %w", err)
+	}
+
+	if c.MySQL.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(c.MySQL.MaxOpenConns)
+	}
+	if c.MySQL.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(c.MySQL.MaxIdleConns)
+	}
+
+	return db, nil
+}
+
+// initRedis 初始化Redis连接（复用main.go的函数）
+func initRedis(c Config) (*redis.Redis, error) {
+	pingTimeout, err := time.ParseDuration(c.Redis.PingTimeout)
+	if err != nil {
+		pingTimeout = time.Second
+	}
+
+	rds := redis.MustNewRedis(redis.RedisConf{
+		Host:        c.Redis.Host,
+		Type:        c.Redis.Type,
+		Pass:        c.Redis.Password,
+		PingTimeout: pingTimeout,
+	})
+	return rds, nil
+}
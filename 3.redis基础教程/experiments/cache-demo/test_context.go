@@ -0,0 +1,275 @@
+package main
+
+import (
+	"cache-demo/cache"
+	"cache-demo/model"
+	"cache-demo/service"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/conf"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Config 配置结构（复用main.go的配置）
+type Config struct {
+	MySQL struct {
+		Host         string `json:"host" yaml:"host"`
+		Port         int    `json:"port" yaml:"port"`
+		User         string `json:"user" yaml:"user"`
+		Password     string `json:"password" yaml:"password"`
+		Database     string `json:"database" yaml:"database"`
+		MaxOpenConns int    `json:"max_open_conns" yaml:"max_open_conns"`
+		MaxIdleConns int    `json:"max_idle_conns" yaml:"max_idle_conns"`
+	} `json:"mysql" yaml:"mysql"`
+	Redis struct {
+		Host        string `json:"host" yaml:"host"`
+		Password    string `json:"password" yaml:"password"`
+		Type        string `json:"type" yaml:"type"`
+		PingTimeout string `json:"ping_timeout" yaml:"ping_timeout"`
+	} `json:"redis" yaml:"redis"`
+}
+
+const (
+	// contextUserID 测试使用的用户ID
+	contextUserID = int64(1)
+	// slowSQLDelay 慢SQL的执行时间
+	slowSQLDelay = time.Second
+	// callerTimeout 调用方愿意等待的时间
+	callerTimeout = 200 * time.Millisecond
+)
+
+// slowSQLRepo 慢SQL仓储：按ID查询前先在MySQL中执行 SELECT SLEEP，ctx 和真实查询一样传给MySQL驱动
+type slowSQLRepo struct {
+	model.UserRepo
+	db      *gorm.DB
+	queries atomic.Int64
+}
+
+// FindByIDCtx 先执行慢SQL，再按ID查询用户（服务层都通过带 ctx 的方法查询）
+func (r *slowSQLRepo) FindByIDCtx(ctx context.Context, id int64) (*model.User, error) {
+	r.queries.Add(1)
+	if err := r.db.WithContext(ctx).Exec("SELECT SLEEP(?)", slowSQLDelay.Seconds()).Error; err != nil {
+		return nil, err
+	}
+	return r.UserRepo.FindByIDCtx(ctx, id)
+}
+
+func main() {
+	// 加载配置
+	var c Config
+	conf.MustLoad("config.yaml", &c)
+
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("Context 超时传递测试")
+	fmt.Println(strings.Repeat("=", 80))
+
+	// 初始化数据库和Redis连接
+	db, err := initDB(c)
+	if err != nil {
+		log.Fatalf("初始化数据库失败: %v", err)
+	}
+	rds, err := initRedis(c)
+	if err != nil {
+		log.Fatalf("初始化Redis失败: %v", err)
+	}
+
+	repo := model.NewUserRepo(db)
+	userCache := cache.NewUserCache(rds)
+
+	testCanceled(repo, userCache, "场景1：已取消的 context")
+	testDeadline(repo, db, userCache, "场景2：慢SQL + 调用方超时")
+	testSharedLoad(repo, db, userCache, "场景3：合并加载时一个请求超时")
+
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("所有测试场景完成！")
+	fmt.Println(strings.Repeat("=", 80))
+	fmt.Println("\n总结：")
+	fmt.Println("1. 仓储、缓存、服务都有带 ctx 的方法（XxxCtx），不带 ctx 的方法使用 context.Background()")
+	fmt.Println("2. ctx 一直传到 gorm 和 Redis 客户端：调用方超时后，正在执行的SQL和Redis命令被中断")
+	fmt.Println("3. 超时、取消不重试，不计入熔断器失败，不降级返回旧数据")
+	fmt.Println("4. 数据库修改之后的缓存写入、合并加载、异步重建不继承 ctx 的取消，避免留下旧数据或连累其他请求")
+}
+
+// testCanceled 场景1：已取消的 context，各层都不再访问MySQL和Redis
+func testCanceled(repo model.UserRepo, userCache cache.UserCache, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：调用方已经放弃（例如HTTP客户端断开），后面的查询都是白做")
+	fmt.Println()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	userService := service.NewUserService(repo, userCache)
+	steps := []struct {
+		name string
+		call func() error
+	}{
+		{"repo.FindByIDCtx", func() error {
+			_, err := repo.FindByIDCtx(ctx, contextUserID)
+			return err
+		}},
+		{"userCache.GetUserCtx", func() error {
+			_, err := userCache.GetUserCtx(ctx, contextUserID)
+			return err
+		}},
+		{"userService.GetUserByIDCtx", func() error {
+			_, err := userService.GetUserByIDCtx(ctx, contextUserID)
+			return err
+		}},
+	}
+	for _, step := range steps {
+		start := time.Now()
+		err := step.call()
+		fmt.Printf("  %-28s 耗时=%-8v context.Canceled=%v, error=%v\n",
+			step.name, time.Since(start).Round(time.Microsecond), errors.Is(err, context.Canceled), err)
+	}
+
+	fmt.Println("\n  → 三层都立即返回 context.Canceled")
+	fmt.Println("\n✓ 场景1测试完成")
+}
+
+// testDeadline 场景2：慢SQL，调用方只等待 callerTimeout
+func testDeadline(repo model.UserRepo, db *gorm.DB, userCache cache.UserCache, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Printf("说明：缓存未命中后的SQL需要执行 %v，调用方只愿意等 %v\n", slowSQLDelay, callerTimeout)
+	fmt.Println("服务经过日志和重试中间件：service.Chain(NewUserService(...), Logging(), Retry())")
+	fmt.Println()
+
+	slow := &slowSQLRepo{UserRepo: repo, db: db}
+	userService := service.Chain(service.NewUserService(slow, userCache), service.Logging(), service.Retry())
+
+	// 1. 不带 ctx：调用方超时了，请求仍然在等慢SQL
+	userCache.DeleteUser(contextUserID)
+	start := time.Now()
+	_, err := userService.GetUserByID(contextUserID)
+	fmt.Printf("  [GetUserByID]    耗时=%v, error=%v\n", time.Since(start).Round(time.Millisecond), err)
+
+	// 2. 带 ctx：到期后SQL被中断，请求立即返回
+	userCache.DeleteUser(contextUserID)
+	ctx, cancel := context.WithTimeout(context.Background(), callerTimeout)
+	defer cancel()
+	start = time.Now()
+	_, err = userService.GetUserByIDCtx(ctx, contextUserID)
+	fmt.Printf("  [GetUserByIDCtx] 耗时=%v, context.DeadlineExceeded=%v, error=%v\n",
+		time.Since(start).Round(time.Millisecond), errors.Is(err, context.DeadlineExceeded), err)
+
+	_, cacheErr := userCache.GetUser(contextUserID)
+	fmt.Printf("  数据库查询次数=%d, 超时后缓存未回填: %v\n", slow.queries.Load(), errors.Is(cacheErr, cache.ErrCacheMiss))
+
+	fmt.Printf("\n  → 不带 ctx 的调用等满 %v；带 ctx 的调用约 %v 返回\n", slowSQLDelay, callerTimeout)
+	fmt.Println("  → 超时不重试（数据库只查询2次），也不回填缓存")
+	fmt.Println("\n✓ 场景2测试完成")
+}
+
+// testSharedLoad 场景3：缓存击穿防护合并了并发加载，其中一个请求超时不影响其他请求
+func testSharedLoad(repo model.UserRepo, db *gorm.DB, userCache cache.UserCache, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Printf("说明：5个请求同时查询同一个用户，singleflight 合并为一次加载；请求1只等 %v，其他请求等3s\n", callerTimeout)
+	fmt.Println("问题：如果合并后的加载使用请求1的 ctx，请求1超时时其他4个请求会一起失败")
+	fmt.Println()
+
+	slow := &slowSQLRepo{UserRepo: repo, db: db}
+	userService := service.NewUserServiceWithBreakdownProtection(slow, userCache, nil)
+	userCache.DeleteUser(contextUserID)
+
+	var wg sync.WaitGroup
+	results := make([]string, 5)
+	for i := range results {
+		timeout := 3 * time.Second
+		if i == 0 {
+			timeout = callerTimeout
+		}
+		wg.Add(1)
+		go func(i int, timeout time.Duration) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			start := time.Now()
+			user, err := userService.GetUserByIDCtx(ctx, contextUserID)
+			elapsed := time.Since(start).Round(time.Millisecond)
+			if err != nil {
+				results[i] = fmt.Sprintf("请求%d (超时%v): 耗时=%v, error=%v", i+1, timeout, elapsed, err)
+				return
+			}
+			results[i] = fmt.Sprintf("请求%d (超时%v): 耗时=%v, username=%s", i+1, timeout, elapsed, user.Username)
+		}(i, timeout)
+		// 保证请求1最先发起加载
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		fmt.Printf("  %s\n", result)
+	}
+	_, cacheErr := userCache.GetUser(contextUserID)
+	fmt.Printf("  数据库查询次数=%d, 缓存已回填: %v\n", slow.queries.Load(), cacheErr == nil)
+
+	fmt.Printf("\n  → 请求1约 %v 返回超时错误，其他请求约 %v 后拿到结果\n", callerTimeout, slowSQLDelay)
+	fmt.Println("  → 合并后的加载不继承 ctx 的取消，只查询一次数据库并回填缓存")
+	fmt.Println("\n✓ 场景3测试完成")
+}
+
+// initDB 初始化数据库连接（复用main.go的函数）
+func initDB(c Config) (*gorm.DB, error) {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+		c.MySQL.User,
+		c.MySQL.Password,
+		c.MySQL.Host,
+		c.MySQL.Port,
+		c.MySQL.Database,
+	)
+
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("连接数据库失败: %w", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("获取数据库实例失败: %w", err)
+	}
+
+	if c.MySQL.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(c.MySQL.MaxOpenConns)
+	}
+	if c.MySQL.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(c.MySQL.MaxIdleConns)
+	}
+
+	return db, nil
+}
+
+// initRedis 初始化Redis连接（复用main.go的函数）
+func initRedis(c Config) (*redis.Redis, error) {
+	pingTimeout, err := time.ParseDuration(c.Redis.PingTimeout)
+	if err != nil {
+		pingTimeout = time.Second
+	}
+
+	rds := redis.MustNewRedis(redis.RedisConf{
+		Host:        c.Redis.Host,
+		Type:        c.Redis.Type,
+		Pass:        c.Redis.Password,
+		PingTimeout: pingTimeout,
+	})
+	return rds, nil
+}
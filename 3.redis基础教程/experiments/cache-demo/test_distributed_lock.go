@@ -1,7 +1,9 @@
 package main
 
 import (
+	"cache-demo/lock"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -20,49 +22,84 @@ type LockConfig struct {
 		Type        string `json:"type" yaml:"type"`
 		PingTimeout string `json:"ping_timeout" yaml:"ping_timeout"`
 	} `json:"redis" yaml:"redis"`
+	Redlock struct {
+		Enabled     bool     `json:"enabled,optional" yaml:"enabled"`
+		Nodes       []string `json:"nodes,optional" yaml:"nodes"`               // 相互独立的Redis节点
+		Password    string   `json:"password,optional" yaml:"password"`         // 所有节点使用同一个密码
+		NodeTimeout string   `json:"node_timeout,optional" yaml:"node_timeout"` // 访问单个节点的超时时间，默认50ms
+	} `json:"redlock,optional" yaml:"redlock"`
 }
 
-// ✅ 正确：使用分布式锁（Redis）
-func Lock(ctx context.Context, kv *redis.Redis, key string, expire int) (lock *redis.RedisLock, ok bool, err error) {
-	lock = redis.NewRedisLock(kv, key)
-	lock.SetExpire(expire)
-	ok, err = lock.AcquireCtx(ctx)
+const (
+	// lockTTL 锁过期时间：比订单处理时间短，靠看门狗续期
+	lockTTL = 2 * time.Second
+	// processTime 模拟订单处理时间
+	processTime = 3 * time.Second
+	// waitTimeout 最多等待锁的时间
+	waitTimeout = 30 * time.Second
+)
+
+// readStock 读取库存：内部再次获取同一把锁（可重入），单独调用时也受锁保护
+// 读取之前先在库存上登记 token，之后 token 更小的持有者（锁过期前的旧持有者）写入库存会被拒绝
+func readStock(ctx context.Context, l lock.Locker, kv *redis.Redis, stockKey, processName string) (int, error) {
+	if err := l.Lock(ctx); err != nil {
+		return 0, err
+	}
+	defer l.Unlock(ctx)
+	fmt.Printf("%s: 🔁 readStock 重入获取锁成功（token 不变: %d）\n", processName, l.Token())
+
+	if err := lock.Fence(ctx, kv, stockKey, l.Token()); err != nil {
+		return 0, err
+	}
+
+	currentStock, err := kv.GetCtx(ctx, stockKey)
 	if err != nil {
-		err = fmt.Errorf("lock AcquireCtx err: %w", err)
-		return
+		return 0, err
 	}
-	return
+	return strconv.Atoi(currentStock)
 }
 
-func ReleaseLock(lock *redis.RedisLock) {
-	if lock != nil {
-		_, err := lock.Release()
-		if err != nil {
-			fmt.Printf("释放锁失败: %v\n", err)
-		}
+// newStockLock 创建库存锁：配置了 redlock.enabled 时在多个独立节点上加锁（Redlock），否则只在 rds 上加锁
+func newStockLock(c LockConfig, rds *redis.Redis, key string, opts ...lock.Option) (lock.Locker, string) {
+	if !c.Redlock.Enabled || len(c.Redlock.Nodes) == 0 {
+		return lock.New(rds, key, opts...), "单节点"
 	}
+
+	nodes := make([]*redis.Redis, len(c.Redlock.Nodes))
+	for i, host := range c.Redlock.Nodes {
+		nodes[i] = redis.New(host, redis.WithPass(c.Redlock.Password))
+	}
+	if timeout, err := time.ParseDuration(c.Redlock.NodeTimeout); err == nil {
+		opts = append(opts, lock.WithNodeTimeout(timeout))
+	}
+	return lock.NewRedlock(nodes, key, opts...), fmt.Sprintf("Redlock %d 个节点", len(nodes))
 }
 
 func main() {
 	// 获取进程ID
 	processID := os.Getpid()
 
-	// 获取命令行参数（进程标识）
+	// 获取命令行参数（进程标识、是否模拟暂停）
 	processName := fmt.Sprintf("进程-%d", processID)
 	if len(os.Args) > 1 {
 		processName = os.Args[1]
 	}
+	pause := len(os.Args) > 2 && os.Args[2] == "pause"
 
 	fmt.Println(strings.Repeat("=", 80))
 	fmt.Printf("【实验2】多进程分布式锁 - 正确解决方案\n")
 	fmt.Printf("%s 启动 (PID: %d)\n", processName, processID)
 	fmt.Println(strings.Repeat("=", 80))
 	fmt.Println("✅ 分布式锁可以跨进程/跨服务器控制，所有进程共享同一个锁！")
+	fmt.Println("✅ 看门狗自动续期 + fencing token + 可重入 + 阻塞等待（cache-demo/lock）")
+	if pause {
+		fmt.Println("⚠️  pause 模式：关闭看门狗，处理订单时暂停超过锁过期时间（模拟长时间GC）")
+	}
 	fmt.Println(strings.Repeat("=", 80))
 
 	// 加载配置
 	var c LockConfig
-	err := conf.Load("../config.yaml", &c)
+	err := conf.Load("config.yaml", &c)
 	if err != nil {
 		fmt.Printf("加载配置失败: %v\n", err)
 		return
@@ -112,48 +149,39 @@ func main() {
 	fmt.Printf("%s: 🛒 尝试购买 %d 件商品...\n", processName, purchaseQuantity)
 
 	// ✅ 使用分布式锁（Redis）- 所有进程共享同一个锁
-	fmt.Printf("%s: 🔒 尝试获取分布式锁 '%s'...\n", processName, lockKey)
-	ctx := context.Background()
-	lock, ok, err := Lock(ctx, redisClient, lockKey, 10)
-	if err != nil {
-		fmt.Printf("%s: ❌ 获取锁时出错: %v\n", processName, err)
-		return
-	}
-
-	if !ok {
-		fmt.Printf("%s: ⏳ 获取锁失败（锁已被其他进程持有），等待中...\n", processName)
-
-		// 重试获取锁（最多等待30秒）
-		maxRetries := 30
-		for i := 0; i < maxRetries; i++ {
-			time.Sleep(1 * time.Second)
-			lock, ok, err = Lock(ctx, redisClient, lockKey, 10)
-			if err != nil {
-				fmt.Printf("%s: ❌ 获取锁时出错: %v\n", processName, err)
-				return
-			}
-			if ok {
-				fmt.Printf("%s: ✅ 获取分布式锁成功（等待了 %d 秒）\n", processName, i+1)
-				break
-			}
-			if i == maxRetries-1 {
-				fmt.Printf("%s: ❌ 等待超时，无法获取锁\n", processName)
-				return
-			}
+	l, kind := newStockLock(c, redisClient, lockKey, lock.WithTTL(lockTTL), lock.WithWatchdog(!pause))
+	fmt.Printf("%s: 🔒 尝试获取分布式锁 '%s'（%s，最多等待 %v）...\n", processName, lockKey, kind, waitTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := l.Lock(ctx); err != nil {
+		if errors.Is(err, lock.ErrNotAcquired) {
+			fmt.Printf("%s: ❌ 等待超时，无法获取锁: %v\n", processName, err)
+		} else {
+			fmt.Printf("%s: ❌ 获取锁时出错: %v\n", processName, err)
 		}
-	} else {
-		fmt.Printf("%s: ✅ 获取分布式锁成功\n", processName)
+		return
 	}
+	// 写入时使用获取锁时的 token：锁丢失后 l.Token() 会变成0，真实场景中暂停的进程也只记得旧 token
+	token := l.Token()
+	fmt.Printf("%s: ✅ 获取分布式锁成功（等待了 %v，fencing token=%d）\n", processName, time.Since(start).Round(time.Millisecond), token)
 
 	// 确保释放锁
 	defer func() {
-		ReleaseLock(lock)
+		if err := l.Unlock(context.Background()); err != nil {
+			fmt.Printf("%s: ⚠️  释放锁: %v\n", processName, err)
+			return
+		}
 		fmt.Printf("%s: 🔓 释放分布式锁\n", processName)
 	}()
 
 	// 重新读取库存（双重检查）
-	currentStock, _ = redisClient.Get(stockKey)
-	stockValue, _ = strconv.Atoi(currentStock)
+	stockValue, err = readStock(ctx, l, redisClient, stockKey, processName)
+	if err != nil {
+		fmt.Printf("%s: ❌ 读取库存失败: %v\n", processName, err)
+		return
+	}
 	fmt.Printf("%s: 📖 重新读取库存: %d\n", processName, stockValue)
 
 	if stockValue < purchaseQuantity {
@@ -161,14 +189,36 @@ func main() {
 		return
 	}
 
-	// 模拟业务处理时间
-	fmt.Printf("%s: ⏳ 处理订单中（模拟耗时操作）...\n", processName)
-	time.Sleep(1 * time.Second)
+	// 模拟业务处理时间：超过锁过期时间
+	if pause {
+		fmt.Printf("%s: ⏸️  进程暂停 %v（锁过期时间 %v，没有看门狗续期）...\n", processName, processTime, lockTTL)
+		time.Sleep(processTime)
+		select {
+		case <-l.Lost():
+			fmt.Printf("%s: ⚠️  恢复运行，锁已经过期（进程暂停期间感知不到）\n", processName)
+		default:
+		}
+	} else {
+		fmt.Printf("%s: ⏳ 处理订单中（耗时 %v，超过锁过期时间 %v）...\n", processName, processTime, lockTTL)
+		for i := 1; i <= int(processTime/time.Second); i++ {
+			time.Sleep(time.Second)
+			ttl, _ := redisClient.Ttl(lockKey)
+			fmt.Printf("%s:   第 %ds，锁剩余过期时间: %ds（看门狗每 %v 续期一次）\n", processName, i, ttl, (lockTTL / 3).Round(time.Millisecond))
+		}
+	}
 
-	// 扣减库存
+	// 扣减库存：写入时检查 fencing token
 	newStock := stockValue - purchaseQuantity
-	redisClient.Set(stockKey, strconv.Itoa(newStock))
-	fmt.Printf("%s: ✅ 扣减库存: %d - %d = %d\n", processName, stockValue, purchaseQuantity, newStock)
+	err = lock.SetWithFence(ctx, redisClient, stockKey, strconv.Itoa(newStock), token)
+	if errors.Is(err, lock.ErrFenced) {
+		fmt.Printf("%s: 🛡️  扣减库存被拒绝：锁已经被 token 更大的进程获取过，库存已被修改（%v）\n", processName, err)
+		return
+	}
+	if err != nil {
+		fmt.Printf("%s: ❌ 扣减库存失败: %v\n", processName, err)
+		return
+	}
+	fmt.Printf("%s: ✅ 扣减库存: %d - %d = %d (token=%d)\n", processName, stockValue, purchaseQuantity, newStock, token)
 
 	// 最终库存
 	finalStock, _ := redisClient.Get(stockKey)
@@ -179,9 +229,9 @@ func main() {
 	fmt.Printf("%s 完成\n", processName)
 	fmt.Println(strings.Repeat("=", 80))
 	fmt.Println("\n✅ 优势分析：")
-	fmt.Println("   1. 所有进程共享同一个Redis锁")
-	fmt.Println("   2. 同一时刻只有一个进程能获取锁")
-	fmt.Println("   3. 其他进程必须等待锁释放")
-	fmt.Println("   4. 有效防止超卖问题！")
+	fmt.Println("   1. 所有进程共享同一个Redis锁，同一时刻只有一个进程能获取锁")
+	fmt.Println("   2. 其他进程按退避策略阻塞等待，不需要自己写重试循环")
+	fmt.Println("   3. 看门狗自动续期：订单处理时间超过锁过期时间，锁也不会被抢走")
+	fmt.Println("   4. fencing token：暂停的进程恢复后，过期的写入被拒绝，有效防止超卖！")
 	fmt.Println(strings.Repeat("=", 80))
 }
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"cache-demo/inventory"
+	"cache-demo/lock"
+	"cache-demo/model"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/conf"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Config 配置结构（复用main.go的配置）
+type Config struct {
+	MySQL struct {
+		Host         string `json:"host" yaml:"host"`
+		Port         int    `json:"port" yaml:"port"`
+		User         string `json:"user" yaml:"user"`
+		Password     string `json:"password" yaml:"password"`
+		Database     string `json:"database" yaml:"database"`
+		MaxOpenConns int    `json:"max_open_conns" yaml:"max_open_conns"`
+		MaxIdleConns int    `json:"max_idle_conns" yaml:"max_idle_conns"`
+	} `json:"mysql" yaml:"mysql"`
+	Redis struct {
+		Host        string `json:"host" yaml:"host"`
+		Password    string `json:"password" yaml:"password"`
+		Type        string `json:"type" yaml:"type"`
+		PingTimeout string `json:"ping_timeout" yaml:"ping_timeout"`
+	} `json:"redis" yaml:"redis"`
+}
+
+const (
+	// flashSaleStock 秒杀商品的库存
+	flashSaleStock = 100
+	// flashSaleBuyers 同时抢购的用户数（每人买1件），比库存多
+	flashSaleBuyers = 300
+	// naiveProcessTime GET 和 SET 之间的处理时间，放大并发窗口
+	naiveProcessTime = time.Millisecond
+	// demoReserveTTL 超时实验的预留超时时间
+	demoReserveTTL = 2 * time.Second
+)
+
+// saleResult 一种扣减方式的抢购结果
+type saleResult struct {
+	name    string
+	sold    int64
+	stock   int64
+	elapsed time.Duration
+}
+
+// flakyLedger 写流水失败的仓储：failNext 为 true 时下一次写入失败（模拟写流水时MySQL不可用）
+type flakyLedger struct {
+	model.InventoryLedgerRepo
+	failNext atomic.Bool
+}
+
+// Record 写入流水，failNext 为 true 时返回错误
+func (l *flakyLedger) Record(ctx context.Context, entry *model.InventoryLedger) (bool, error) {
+	if l.failNext.CompareAndSwap(true, false) {
+		return false, errors.New("模拟MySQL不可用")
+	}
+	return l.InventoryLedgerRepo.Record(ctx, entry)
+}
+
+func main() {
+	// 加载配置
+	var c Config
+	conf.MustLoad("config.yaml", &c)
+
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("库存服务测试（Lua 原子扣减 + 预留/确认/取消 + 流水对账）")
+	fmt.Println(strings.Repeat("=", 80))
+
+	// 初始化数据库和Redis连接
+	db, err := initDB(c)
+	if err != nil {
+		log.Fatalf("初始化数据库失败: %v", err)
+	}
+	if err := db.AutoMigrate(&model.InventoryLedger{}); err != nil {
+		log.Fatalf("创建库存流水表失败: %v", err)
+	}
+
+	rds, err := initRedis(c)
+	if err != nil {
+		log.Fatalf("初始化Redis失败: %v", err)
+	}
+
+	ledger := &flakyLedger{InventoryLedgerRepo: model.NewInventoryLedgerRepo(db)}
+	inv := inventory.New(rds, inventory.WithLedger(ledger))
+	defer inv.Close()
+
+	// 每次运行使用不同的商品和订单ID，上次运行留下的预留记录、流水不影响本次结果
+	runID := time.Now().Format("150405")
+	ctx := context.Background()
+
+	testOversell(ctx, rds, inv, runID)
+	testIdempotency(ctx, inv, runID)
+	testReserveTTL(ctx, rds, ledger, runID)
+	testReconcile(ctx, rds, inv, ledger, runID)
+
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("测试完成")
+	fmt.Println(strings.Repeat("=", 80))
+}
+
+// testOversell 实验1：秒杀扣减，对比 无锁 GET/SET、分布式锁 GET/SET、Lua 原子预留
+func testOversell(ctx context.Context, rds *redis.Redis, inv inventory.Inventory, runID string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Printf("实验1：秒杀扣减（库存 %d，%d 个用户同时各买1件）\n", flashSaleStock, flashSaleBuyers)
+	fmt.Println(strings.Repeat("-", 80))
+
+	results := []saleResult{
+		naiveSale(ctx, rds, "product:naive:"+runID),
+		lockedSale(ctx, rds, "product:locked:"+runID),
+		luaSale(ctx, inv, "product:lua:"+runID, runID),
+	}
+
+	fmt.Printf("\n%-22s %8s %10s %12s %s\n", "方式", "卖出", "剩余库存", "耗时", "结果")
+	for _, r := range results {
+		verdict := "✅ 正确"
+		if r.sold+r.stock != flashSaleStock || r.sold > flashSaleStock {
+			verdict = fmt.Sprintf("❌ 超卖 %d 件", r.sold+r.stock-flashSaleStock)
+		}
+		fmt.Printf("%-22s %8d %10d %12v %s\n", r.name, r.sold, r.stock, r.elapsed.Round(time.Millisecond), verdict)
+	}
+	fmt.Println("\n说明：")
+	fmt.Println("  - 无锁：多个请求读到同一个库存后各自写回，后写的覆盖先写的，卖出数 + 剩余库存 > 初始库存")
+	fmt.Println("  - 分布式锁：结果正确，但所有请求在锁上排队，获取失败时退避重试")
+	fmt.Println("  - Lua：检查和扣减在一个脚本中由Redis原子执行，不需要锁，也不需要重试")
+}
+
+// naiveSale 无锁：GET → 判断 → SET（test_local_lock.go 中多进程使用本地锁时的效果）
+func naiveSale(ctx context.Context, rds *redis.Redis, productID string) saleResult {
+	stockKey := inventory.StockKey(productID)
+	_ = rds.SetCtx(ctx, stockKey, strconv.Itoa(flashSaleStock))
+
+	var sold atomic.Int64
+	elapsed := runBuyers(func(i int) {
+		val, err := rds.GetCtx(ctx, stockKey)
+		if err != nil {
+			return
+		}
+		stock, _ := strconv.Atoi(val)
+		if stock <= 0 {
+			return
+		}
+		time.Sleep(naiveProcessTime)
+		if err := rds.SetCtx(ctx, stockKey, strconv.Itoa(stock-1)); err == nil {
+			sold.Add(1)
+		}
+	})
+
+	val, _ := rds.GetCtx(ctx, stockKey)
+	stock, _ := strconv.ParseInt(val, 10, 64)
+	return saleResult{name: "无锁 GET/SET", sold: sold.Load(), stock: stock, elapsed: elapsed}
+}
+
+// lockedSale 分布式锁：加锁 → GET → 判断 → SET → 释放锁（test_distributed_lock.go 的做法）
+func lockedSale(ctx context.Context, rds *redis.Redis, productID string) saleResult {
+	stockKey := inventory.StockKey(productID)
+	_ = rds.SetCtx(ctx, stockKey, strconv.Itoa(flashSaleStock))
+
+	var sold atomic.Int64
+	elapsed := runBuyers(func(i int) {
+		l := lock.New(rds, "lock:"+stockKey)
+		lockCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		if err := l.Lock(lockCtx); err != nil {
+			return
+		}
+		defer l.Unlock(ctx)
+
+		val, err := rds.GetCtx(ctx, stockKey)
+		if err != nil {
+			return
+		}
+		stock, _ := strconv.Atoi(val)
+		if stock <= 0 {
+			return
+		}
+		time.Sleep(naiveProcessTime)
+		if err := rds.SetCtx(ctx, stockKey, strconv.Itoa(stock-1)); err == nil {
+			sold.Add(1)
+		}
+	})
+
+	val, _ := rds.GetCtx(ctx, stockKey)
+	stock, _ := strconv.ParseInt(val, 10, 64)
+	return saleResult{name: "分布式锁 GET/SET", sold: sold.Load(), stock: stock, elapsed: elapsed}
+}
+
+// luaSale Lua 原子预留，预留成功后确认
+func luaSale(ctx context.Context, inv inventory.Inventory, productID, runID string) saleResult {
+	if err := inv.SetStock(ctx, productID, flashSaleStock); err != nil {
+		log.Fatalf("设置库存失败: %v", err)
+	}
+
+	var sold, rejected atomic.Int64
+	elapsed := runBuyers(func(i int) {
+		orderID := fmt.Sprintf("order:flash:%s:%d", runID, i)
+		_, err := inv.Reserve(ctx, orderID, productID, 1)
+		if errors.Is(err, inventory.ErrInsufficientStock) {
+			rejected.Add(1)
+			return
+		}
+		if err != nil {
+			log.Printf("预留失败: %v", err)
+			return
+		}
+		if _, err := inv.Confirm(ctx, orderID); err == nil {
+			sold.Add(1)
+		}
+	})
+
+	stock, _ := inv.Stock(ctx, productID)
+	fmt.Printf("Lua: 库存不足直接拒绝 %d 个请求\n", rejected.Load())
+	return saleResult{name: "Lua 原子预留", sold: sold.Load(), stock: stock, elapsed: elapsed}
+}
+
+// runBuyers 同时启动 flashSaleBuyers 个协程执行 buy，返回全部完成的耗时
+func runBuyers(buy func(i int)) time.Duration {
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < flashSaleBuyers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			buy(i)
+		}(i)
+	}
+
+	begin := time.Now()
+	close(start)
+	wg.Wait()
+	return time.Since(begin)
+}
+
+// testIdempotency 实验2：同一个订单ID的重复请求（网关超时重试、用户重复点击）
+func testIdempotency(ctx context.Context, inv inventory.Inventory, runID string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println("实验2：幂等（同一个订单ID重复预留、确认、取消）")
+	fmt.Println(strings.Repeat("-", 80))
+
+	productID := "product:idempotent:" + runID
+	orderID := "order:idempotent:" + runID
+	_ = inv.SetStock(ctx, productID, 10)
+
+	for i := 1; i <= 3; i++ {
+		r, err := inv.Reserve(ctx, orderID, productID, 3)
+		if err != nil {
+			fmt.Printf("第%d次预留失败: %v\n", i, err)
+			continue
+		}
+		stock, _ := inv.Stock(ctx, productID)
+		fmt.Printf("第%d次预留: 状态=%s, 重复请求=%v, 可用库存=%d\n", i, r.Status, r.Replayed, stock)
+	}
+
+	_, err := inv.Reserve(ctx, orderID, productID, 5)
+	fmt.Printf("同一个订单ID预留不同数量: %v（期望 ErrIdempotencyConflict: %v）\n", err, errors.Is(err, inventory.ErrIdempotencyConflict))
+
+	for i := 1; i <= 2; i++ {
+		r, err := inv.Confirm(ctx, orderID)
+		if err != nil {
+			fmt.Printf("第%d次确认失败: %v\n", i, err)
+			continue
+		}
+		fmt.Printf("第%d次确认: 状态=%s, 重复请求=%v\n", i, r.Status, r.Replayed)
+	}
+
+	_, err = inv.Cancel(ctx, orderID)
+	fmt.Printf("取消已确认的订单: %v（期望 ErrInvalidState: %v）\n", err, errors.Is(err, inventory.ErrInvalidState))
+
+	cancelID := "order:idempotent:cancel:" + runID
+	_, _ = inv.Reserve(ctx, cancelID, productID, 2)
+	for i := 1; i <= 2; i++ {
+		r, err := inv.Cancel(ctx, cancelID)
+		if err != nil {
+			fmt.Printf("第%d次取消失败: %v\n", i, err)
+			continue
+		}
+		stock, _ := inv.Stock(ctx, productID)
+		fmt.Printf("第%d次取消: 状态=%s, 重复请求=%v, 可用库存=%d\n", i, r.Status, r.Replayed, stock)
+	}
+
+	stock, _ := inv.Stock(ctx, productID)
+	fmt.Printf("\n最终可用库存: %d（期望 10 - 3 = 7）\n", stock)
+}
+
+// testReserveTTL 实验3：预留超时没有确认，后台扫描自动归还库存
+func testReserveTTL(ctx context.Context, rds *redis.Redis, ledger model.InventoryLedgerRepo, runID string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Printf("实验3：预留超时自动归还（预留超时 %v，每 500ms 扫描一次）\n", demoReserveTTL)
+	fmt.Println(strings.Repeat("-", 80))
+
+	inv := inventory.New(rds,
+		inventory.WithLedger(ledger),
+		inventory.WithReserveTTL(demoReserveTTL),
+		inventory.WithSweepInterval(500*time.Millisecond),
+	)
+	defer inv.Close()
+
+	productID := "product:ttl:" + runID
+	_ = inv.SetStock(ctx, productID, 10)
+
+	unpaid := "order:ttl:unpaid:" + runID
+	paid := "order:ttl:paid:" + runID
+	_, _ = inv.Reserve(ctx, unpaid, productID, 4)
+	_, _ = inv.Reserve(ctx, paid, productID, 2)
+	stock, _ := inv.Stock(ctx, productID)
+	fmt.Printf("预留两个订单（4件、2件）后可用库存: %d\n", stock)
+
+	if _, err := inv.Confirm(ctx, paid); err != nil {
+		fmt.Printf("确认失败: %v\n", err)
+	}
+	fmt.Println("2件的订单已支付确认，4件的订单不支付...")
+
+	time.Sleep(demoReserveTTL + time.Second)
+
+	r, _ := inv.Get(ctx, unpaid)
+	stock, _ = inv.Stock(ctx, productID)
+	fmt.Printf("%v 后: 未支付订单状态=%s, 可用库存=%d（期望 8）\n", demoReserveTTL+time.Second, r.Status, stock)
+
+	_, err := inv.Confirm(ctx, unpaid)
+	fmt.Printf("超时后再支付确认: %v（期望 ErrReservationExpired: %v）\n", err, errors.Is(err, inventory.ErrReservationExpired))
+}
+
+// testReconcile 实验4：对账（写流水失败、绕过库存服务直接修改库存）
+func testReconcile(ctx context.Context, rds *redis.Redis, inv inventory.Inventory, ledger *flakyLedger, runID string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println("实验4：对账（流水 vs Redis）")
+	fmt.Println(strings.Repeat("-", 80))
+
+	productID := "product:reconcile:" + runID
+	_ = inv.SetStock(ctx, productID, 50)
+
+	for i := 0; i < 3; i++ {
+		orderID := fmt.Sprintf("order:reconcile:%s:%d", runID, i)
+		_, _ = inv.Reserve(ctx, orderID, productID, 5)
+	}
+
+	// 第1个订单确认，写确认流水时MySQL不可用：流水中只有预留
+	first := fmt.Sprintf("order:reconcile:%s:0", runID)
+	ledger.failNext.Store(true)
+	_, _ = inv.Confirm(ctx, first)
+	// 第2个订单取消，写取消流水时MySQL不可用：流水比Redis少归还5件
+	second := fmt.Sprintf("order:reconcile:%s:1", runID)
+	ledger.failNext.Store(true)
+	_, _ = inv.Cancel(ctx, second)
+	// 第3个订单保持预留
+
+	printReport(ctx, inv, productID, "写流水失败后对账（补记缺少的结束流水）")
+
+	fmt.Println("\n绕过库存服务直接修改库存: INCRBY", inventory.StockKey(productID), 5)
+	_, _ = rds.IncrbyCtx(ctx, inventory.StockKey(productID), 5)
+	printReport(ctx, inv, productID, "直接修改库存后对账")
+}
+
+// printReport 执行对账并打印结果
+func printReport(ctx context.Context, inv inventory.Inventory, productID, title string) {
+	report, err := inv.Reconcile(ctx, productID)
+	if err != nil {
+		fmt.Printf("对账失败: %v\n", err)
+		return
+	}
+	fmt.Printf("\n%s:\n", title)
+	fmt.Printf("  Redis可用库存: %d, 流水可用库存: %d, 差额: %d\n", report.RedisStock, report.LedgerStock, report.Diff())
+	fmt.Printf("  补记流水的订单: %v\n", report.Backfilled)
+	if len(report.Unresolved) > 0 {
+		fmt.Printf("  需要人工核对的订单: %v\n", report.Unresolved)
+	}
+	if report.Diff() == 0 {
+		fmt.Println("  ✅ 流水和Redis一致")
+	} else {
+		fmt.Println("  ❌ 流水和Redis不一致：检查日志中的 [库存流水写入失败]，以及是否有程序绕过库存服务修改库存")
+	}
+}
+
+// initDB 初始化数据库连接（复用main.go的函数）
+func initDB(c Config) (*gorm.DB, error) {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+		c.MySQL.User,
+		c.MySQL.Password,
+		c.MySQL.Host,
+		c.MySQL.Port,
+		c.MySQL.Database,
+	)
+
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("连接数据库失败: %w", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("获取数据库实例失败: %w", err)
+	}
+
+	if c.MySQL.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(c.MySQL.MaxOpenConns)
+	}
+	if c.MySQL.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(c.MySQL.MaxIdleConns)
+	}
+
+	return db, nil
+}
+
+// initRedis 初始化Redis连接（复用main.go的函数）
+func initRedis(c Config) (*redis.Redis, error) {
+	pingTimeout, err := time.ParseDuration(c.Redis.PingTimeout)
+	if err != nil {
+		pingTimeout = time.Second
+	}
+
+	rds := redis.MustNewRedis(redis.RedisConf{
+		Host:        c.Redis.Host,
+		Type:        c.Redis.Type,
+		Pass:        c.Redis.Password,
+		PingTimeout: pingTimeout,
+	})
+	return rds, nil
+}
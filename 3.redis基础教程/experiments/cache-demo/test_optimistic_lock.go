@@ -0,0 +1,375 @@
+package main
+
+import (
+	"cache-demo/cache"
+	"cache-demo/model"
+	"cache-demo/service"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/conf"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Config 配置结构（复用main.go的配置）
+type Config struct {
+	MySQL struct {
+		Host         string `json:"host" yaml:"host"`
+		Port         int    `json:"port" yaml:"port"`
+		User         string `json:"user" yaml:"user"`
+		Password     string `json:"password" yaml:"password"`
+		Database     string `json:"database" yaml:"database"`
+		MaxOpenConns int    `json:"max_open_conns" yaml:"max_open_conns"`
+		MaxIdleConns int    `json:"max_idle_conns" yaml:"max_idle_conns"`
+	} `json:"mysql" yaml:"mysql"`
+	Redis struct {
+		Host        string `json:"host" yaml:"host"`
+		Password    string `json:"password" yaml:"password"`
+		Type        string `json:"type" yaml:"type"`
+		PingTimeout string `json:"ping_timeout" yaml:"ping_timeout"`
+	} `json:"redis" yaml:"redis"`
+}
+
+const (
+	// optimisticUserID 测试使用的用户ID
+	optimisticUserID = int64(1)
+	// concurrentWriters 同时更新同一个用户的请求数
+	concurrentWriters = 10
+)
+
+func main() {
+	// 加载配置
+	var c Config
+	conf.MustLoad("config.yaml", &c)
+
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("乐观锁测试（版本号 + 缓存只保留最新版本）")
+	fmt.Println(strings.Repeat("=", 80))
+
+	// 初始化数据库和Redis连接
+	db, err := initDB(c)
+	if err != nil {
+		log.Fatalf("初始化数据库失败: %v", err)
+	}
+	// 已有的用户表自动增加 version 列
+	if err := db.AutoMigrate(&model.User{}); err != nil {
+		log.Fatalf("迁移用户表失败: %v", err)
+	}
+
+	rds, err := initRedis(c)
+	if err != nil {
+		log.Fatalf("初始化Redis失败: %v", err)
+	}
+
+	repo := model.NewUserRepo(db)
+	userCache := cache.NewUserCache(rds)
+	userService := service.NewUserService(repo, userCache)
+
+	original, err := repo.FindByID(optimisticUserID)
+	if err != nil {
+		log.Fatalf("查询用户失败: %v", err)
+	}
+
+	testConcurrentUpdate(repo, userCache, userService, "场景1：并发更新同一个版本")
+	testRetryOnConflict(repo, userService, "场景2：冲突后重新读取再更新")
+	testStaleCacheWrite(repo, userCache, userService, "场景3：迟到的旧版本不覆盖缓存")
+	testWriteBehindConcurrentUpdate(repo, rds, "场景4：Write-Behind 并发更新同一个版本")
+
+	// 恢复年龄，重复运行结果相同
+	latest, err := repo.FindByID(optimisticUserID)
+	if err == nil {
+		latest.Age = original.Age
+		if err := userService.UpdateUser(latest); err != nil {
+			log.Printf("恢复用户失败: %v", err)
+		}
+	}
+
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("所有测试场景完成！")
+	fmt.Println(strings.Repeat("=", 80))
+	fmt.Println("\n总结：")
+	fmt.Println("1. UPDATE ... WHERE id = ? AND version = ?：同一个版本的并发更新只有一个成功，其他返回 model.ErrVersionConflict")
+	fmt.Println("2. 冲突的请求没有修改数据库，也不写缓存；需要更新时重新读取最新版本再修改")
+	fmt.Println("3. 缓存写入比较版本号（user:<id>:version）：迟到的旧版本不会覆盖新版本")
+	fmt.Println("4. Write-Behind 在缓存上比较并写入（版本号相同才写入 N+1），刷盘时数据库的版本号和缓存一致")
+}
+
+// testConcurrentUpdate 场景1：多个请求读到同一个版本后同时更新
+func testConcurrentUpdate(repo model.UserRepo, userCache cache.UserCache, userService service.UserService, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+
+	base, err := repo.FindByID(optimisticUserID)
+	if err != nil {
+		log.Printf("查询用户失败: %v", err)
+		return
+	}
+	fmt.Printf("%d 个请求都读到 version=%d，各自把年龄改成不同的值后同时更新\n\n", concurrentWriters, base.Version)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var winners []int
+	conflicts := 0
+	for i := 0; i < concurrentWriters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			user := *base
+			user.Age = 100 + i
+			err := userService.UpdateUser(&user)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				winners = append(winners, user.Age)
+			case errors.Is(err, model.ErrVersionConflict):
+				conflicts++
+			default:
+				log.Printf("更新失败: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	dbUser, _ := repo.FindByID(optimisticUserID)
+	cached, _ := userCache.GetUser(optimisticUserID)
+	fmt.Printf("\n成功 %d 个（年龄=%v），版本冲突 %d 个\n", len(winners), winners, conflicts)
+	if dbUser != nil && cached != nil {
+		fmt.Printf("数据库: age=%d, version=%d\n", dbUser.Age, dbUser.Version)
+		fmt.Printf("缓存:   age=%d, version=%d\n", cached.Age, cached.Version)
+		if dbUser.Age == cached.Age && dbUser.Version == cached.Version {
+			fmt.Println("✅ 缓存和数据库一致，都是成功的那个请求写入的值")
+		} else {
+			fmt.Println("❌ 缓存和数据库不一致")
+		}
+	}
+}
+
+// testRetryOnConflict 场景2：每个请求把年龄加1，冲突时重新读取再更新，所有修改都不会丢失
+func testRetryOnConflict(repo model.UserRepo, userService service.UserService, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+
+	before, err := repo.FindByID(optimisticUserID)
+	if err != nil {
+		log.Printf("查询用户失败: %v", err)
+		return
+	}
+	fmt.Printf("%d 个请求同时把年龄加1（读取 → 加1 → 更新，冲突时重来），更新前 age=%d\n\n", concurrentWriters, before.Age)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	attempts := 0
+	for i := 0; i < concurrentWriters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				attempts++
+				mu.Unlock()
+
+				// 从数据库读取：缓存可能还没有被成功的请求更新
+				user, err := repo.FindByIDCtx(context.Background(), optimisticUserID)
+				if err != nil {
+					log.Printf("查询用户失败: %v", err)
+					return
+				}
+				user.Age++
+				err = userService.UpdateUser(user)
+				if errors.Is(err, model.ErrVersionConflict) {
+					continue
+				}
+				if err != nil {
+					log.Printf("更新失败: %v", err)
+				}
+				return
+			}
+		}()
+	}
+	wg.Wait()
+
+	after, _ := repo.FindByID(optimisticUserID)
+	if after == nil {
+		return
+	}
+	fmt.Printf("\n更新后 age=%d（期望 %d），共尝试 %d 次，version %d → %d\n",
+		after.Age, before.Age+concurrentWriters, attempts, before.Version, after.Version)
+	if after.Age == before.Age+concurrentWriters {
+		fmt.Println("✅ 没有丢失更新")
+	} else {
+		fmt.Println("❌ 有更新丢失")
+	}
+}
+
+// testStaleCacheWrite 场景3：更新数据库成功后，写缓存前暂停（GC、网络慢），期间又有更新写入了缓存
+func testStaleCacheWrite(repo model.UserRepo, userCache cache.UserCache, userService service.UserService, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+
+	user, err := repo.FindByID(optimisticUserID)
+	if err != nil {
+		log.Printf("查询用户失败: %v", err)
+		return
+	}
+
+	// 请求A更新数据库成功，还没写缓存
+	user.Age = 200
+	if err := repo.Update(user); err != nil {
+		log.Printf("更新失败: %v", err)
+		return
+	}
+	stale := *user
+	fmt.Printf("[请求A] 更新数据库: age=%d, version=%d，写缓存前暂停\n", stale.Age, stale.Version)
+
+	// 请求B读取最新版本，更新数据库和缓存
+	newer := *user
+	newer.Age = 201
+	if err := userService.UpdateUser(&newer); err != nil {
+		log.Printf("更新失败: %v", err)
+		return
+	}
+	fmt.Printf("[请求B] 更新数据库和缓存: age=%d, version=%d\n", newer.Age, newer.Version)
+
+	// 请求A恢复，把旧版本写入缓存
+	time.Sleep(100 * time.Millisecond)
+	err = userCache.SetUser(&stale, cache.DefaultExpireSeconds)
+	fmt.Printf("[请求A] 恢复后写缓存: %v（期望 ErrStaleVersion: %v）\n", err, errors.Is(err, cache.ErrStaleVersion))
+
+	cached, _ := userCache.GetUser(optimisticUserID)
+	if cached != nil {
+		fmt.Printf("\n缓存: age=%d, version=%d\n", cached.Age, cached.Version)
+		if cached.Version == newer.Version {
+			fmt.Println("✅ 缓存中仍然是请求B写入的新版本")
+		} else {
+			fmt.Println("❌ 缓存被旧版本覆盖")
+		}
+	}
+}
+
+// testWriteBehindConcurrentUpdate 场景4：Write-Behind 模式（先写缓存，后台刷盘）下多个请求读到同一个版本后同时更新
+func testWriteBehindConcurrentUpdate(repo model.UserRepo, rds *redis.Redis, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+
+	// 刷盘间隔设置得很长，测试中手动调用 Flush
+	writeBehind := service.NewWriteBehindUserService(repo, cache.NewUserCacheWithPenetration(rds), rds, time.Hour, 0)
+	defer func() {
+		if err := writeBehind.Close(); err != nil {
+			log.Printf("关闭失败: %v", err)
+		}
+	}()
+
+	base, err := writeBehind.GetUserByID(optimisticUserID)
+	if err != nil {
+		log.Printf("查询用户失败: %v", err)
+		return
+	}
+	fmt.Printf("%d 个请求都读到 version=%d，各自把年龄改成不同的值后同时更新（只写缓存和队列）\n\n", concurrentWriters, base.Version)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var winners []int
+	conflicts := 0
+	for i := 0; i < concurrentWriters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			user := *base
+			user.Age = 300 + i
+			err := writeBehind.UpdateUser(&user)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				winners = append(winners, user.Age)
+			case errors.Is(err, model.ErrVersionConflict):
+				conflicts++
+			default:
+				log.Printf("更新失败: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := writeBehind.Flush(); err != nil {
+		log.Printf("刷盘失败: %v", err)
+		return
+	}
+
+	dbUser, _ := repo.FindByID(optimisticUserID)
+	cached, _ := writeBehind.GetUserByID(optimisticUserID)
+	fmt.Printf("\n成功 %d 个（年龄=%v），版本冲突 %d 个\n", len(winners), winners, conflicts)
+	if dbUser == nil || cached == nil {
+		return
+	}
+	fmt.Printf("缓存:   age=%d, version=%d\n", cached.Age, cached.Version)
+	fmt.Printf("刷盘后数据库: age=%d, version=%d\n", dbUser.Age, dbUser.Version)
+	if len(winners) == 1 && cached.Age == winners[0] && dbUser.Age == cached.Age && dbUser.Version == cached.Version {
+		fmt.Println("✅ 只有一个请求成功，缓存和数据库都是它写入的值")
+	} else {
+		fmt.Println("❌ 多个请求都写入了缓存（后写的覆盖先写的），或者刷盘后数据库和缓存不一致")
+	}
+}
+
+// initDB 初始化数据库连接（复用main.go的函数）
+func initDB(c Config) (*gorm.DB, error) {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+		c.MySQL.User,
+		c.MySQL.Password,
+		c.MySQL.Host,
+		c.MySQL.Port,
+		c.MySQL.Database,
+	)
+
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("连接数据库失败: %w", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("获取数据库实例失败: %w", err)
+	}
+
+	if c.MySQL.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(c.MySQL.MaxOpenConns)
+	}
+	if c.MySQL.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(c.MySQL.MaxIdleConns)
+	}
+
+	return db, nil
+}
+
+// initRedis 初始化Redis连接（复用main.go的函数）
+func initRedis(c Config) (*redis.Redis, error) {
+	pingTimeout, err := time.ParseDuration(c.Redis.PingTimeout)
+	if err != nil {
+		pingTimeout = time.Second
+	}
+
+	rds := redis.MustNewRedis(redis.RedisConf{
+		Host:        c.Redis.Host,
+		Type:        c.Redis.Type,
+		Pass:        c.Redis.Password,
+		PingTimeout: pingTimeout,
+	})
+	return rds, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"cache-demo/lock"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+// redlockNodes Redlock 使用的节点个数（最多容忍 2 个节点故障）
+const redlockNodes = 5
+
+// standIn 内存中的Redis节点替身（miniredis），可以模拟宕机和主从切换
+type standIn struct {
+	name string
+	mr   *miniredis.Miniredis
+	rds  *redis.Redis
+}
+
+// failover 模拟主从切换：锁还没同步到从节点时主节点宕机，从节点升级为主节点，锁数据丢失
+func (n *standIn) failover() {
+	n.mr.FlushAll()
+}
+
+// crash 模拟节点宕机
+func (n *standIn) crash() {
+	n.mr.Close()
+}
+
+// startStandIns 启动 count 个相互独立的节点替身
+func startStandIns(count int) []*standIn {
+	nodes := make([]*standIn, count)
+	for i := range nodes {
+		mr, err := miniredis.Run()
+		if err != nil {
+			log.Fatalf("启动节点替身失败: %v", err)
+		}
+		nodes[i] = &standIn{
+			name: fmt.Sprintf("node%d", i+1),
+			mr:   mr,
+			rds:  redis.New(mr.Addr()),
+		}
+	}
+	return nodes
+}
+
+// stopStandIns 关闭节点替身
+func stopStandIns(nodes []*standIn) {
+	for _, n := range nodes {
+		n.mr.Close()
+	}
+}
+
+// clients 所有节点的客户端
+func clients(nodes []*standIn) []*redis.Redis {
+	rdss := make([]*redis.Redis, len(nodes))
+	for i, n := range nodes {
+		rdss[i] = n.rds
+	}
+	return rdss
+}
+
+func main() {
+	// 宕机的节点会触发 go-zero Redis 客户端自带的熔断器告警日志，这里只看锁的结果
+	logx.Disable()
+
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("Redlock 多节点分布式锁测试")
+	fmt.Println(strings.Repeat("=", 80))
+	fmt.Printf("说明：使用 %d 个内存中的Redis节点替身（miniredis），不需要启动 redis-server\n", redlockNodes)
+
+	testSingleNodeFailover("场景1：单节点锁 + 主从切换")
+	testRedlockFailover("场景2：Redlock + 节点主从切换")
+	testRedlockCrash("场景3：Redlock + 节点宕机")
+	testRedlockRenewAndRelease("场景4：节点宕机时的续期与释放")
+
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("所有测试场景完成！")
+	fmt.Println(strings.Repeat("=", 80))
+	fmt.Println("\n总结：")
+	fmt.Println("1. 单节点锁在主从切换时可能同时被两个客户端持有（锁还没同步到从节点）")
+	fmt.Println("2. Redlock 在超过半数的独立节点上加锁成功才算获取成功，5个节点最多容忍2个节点丢失锁或宕机")
+	fmt.Println("3. 获取锁的有效时间 = ttl - 获取耗时 - 时钟漂移补偿，有效时间不足时按获取失败处理")
+	fmt.Println("4. 释放、续期同样需要超过半数节点确认；获取失败时释放所有节点")
+}
+
+// testSingleNodeFailover 场景1：单节点锁，主节点宕机后从节点没有锁数据
+func testSingleNodeFailover(title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：Redis主从复制是异步的，锁写入主节点后还没同步到从节点时主节点宕机，从节点升级为主节点")
+	fmt.Println()
+
+	nodes := startStandIns(1)
+	defer stopStandIns(nodes)
+	ctx := context.Background()
+	key := "lock:redlock:single"
+
+	clientA := lock.New(nodes[0].rds, key)
+	okA, _ := clientA.TryLock(ctx)
+	fmt.Printf("  客户端A获取锁: %v (token=%d)\n", okA, clientA.Token())
+
+	nodes[0].failover()
+	fmt.Println("  [主从切换] 新的主节点上没有锁")
+
+	clientB := lock.New(nodes[0].rds, key)
+	okB, _ := clientB.TryLock(ctx)
+	fmt.Printf("  客户端B获取锁: %v (token=%d)\n", okB, clientB.Token())
+
+	if okA && okB {
+		fmt.Println("\n  → ❌ 两个客户端同时持有同一把锁；token 也回退了（计数器同样没有同步）")
+	}
+	fmt.Println("\n✓ 场景1测试完成")
+}
+
+// testRedlockFailover 场景2：Redlock，少数节点主从切换后锁仍然有效
+func testRedlockFailover(title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Printf("说明：%d 个独立节点，至少 %d 个节点加锁成功才算获取成功\n", redlockNodes, redlockNodes/2+1)
+	fmt.Println()
+
+	nodes := startStandIns(redlockNodes)
+	defer stopStandIns(nodes)
+	ctx := context.Background()
+	key := "lock:redlock:failover"
+
+	clientA := lock.NewRedlock(clients(nodes), key)
+	okA, _ := clientA.TryLock(ctx)
+	fmt.Printf("  客户端A获取锁: %v (token=%d)\n", okA, clientA.Token())
+
+	clientB := lock.NewRedlock(clients(nodes), key)
+	for i := 0; i < redlockNodes; i++ {
+		nodes[i].failover()
+		okB, err := clientB.TryLock(ctx)
+		fmt.Printf("  [%s 主从切换] %d 个节点丢失锁 → 客户端B获取锁: %v, error=%v\n", nodes[i].name, i+1, okB, err)
+		if okB {
+			break
+		}
+	}
+
+	fmt.Printf("\n  → 丢失锁的节点不超过 %d 个时，客户端B获取失败；超过半数节点丢失锁时 Redlock 也无法保证互斥\n", redlockNodes-redlockNodes/2-1)
+	fmt.Println("  → 获取失败时客户端B会释放所有节点，不会在少数节点上留下自己的锁")
+	fmt.Println("\n✓ 场景2测试完成")
+}
+
+// testRedlockCrash 场景3：Redlock，节点宕机
+func testRedlockCrash(title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：每个节点的超时时间（默认50ms）远小于锁的过期时间，宕机的节点不会拖慢获取")
+	fmt.Println()
+
+	nodes := startStandIns(redlockNodes)
+	defer stopStandIns(nodes)
+	ctx := context.Background()
+
+	for i := 0; i <= redlockNodes/2+1; i++ {
+		if i > 0 {
+			nodes[redlockNodes-i].crash()
+		}
+		key := fmt.Sprintf("lock:redlock:crash:%d", i)
+		client := lock.NewRedlock(clients(nodes), key, lock.WithTTL(10*time.Second))
+
+		start := time.Now()
+		ok, err := client.TryLock(ctx)
+		fmt.Printf("  宕机 %d 个节点 → 获取锁: %v, 耗时=%v, error=%v\n", i, ok, time.Since(start).Round(time.Microsecond), err)
+		if ok {
+			client.Unlock(ctx)
+		}
+	}
+
+	fmt.Printf("\n  → 宕机不超过 %d 个节点时正常获取；超过时返回\"可用节点不足\"错误（不是锁被占用）\n", redlockNodes-redlockNodes/2-1)
+	fmt.Println("\n✓ 场景3测试完成")
+}
+
+// testRedlockRenewAndRelease 场景4：持有锁期间节点宕机，续期和释放需要超过半数节点确认
+func testRedlockRenewAndRelease(title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：锁过期时间 300ms，看门狗每 100ms 续期一次")
+	fmt.Println()
+
+	nodes := startStandIns(redlockNodes)
+	defer stopStandIns(nodes)
+	ctx := context.Background()
+	ttl := 300 * time.Millisecond
+
+	// 1. 宕机2个节点：续期仍然成功，释放也成功
+	clientA := lock.NewRedlock(clients(nodes), "lock:redlock:renew:1", lock.WithTTL(ttl))
+	okA, _ := clientA.TryLock(ctx)
+	fmt.Printf("  客户端A获取锁: %v\n", okA)
+	nodes[3].crash()
+	nodes[4].crash()
+	time.Sleep(3 * ttl)
+	select {
+	case <-clientA.Lost():
+		fmt.Println("  宕机2个节点，等待3个过期时间后: 锁已丢失")
+	default:
+		fmt.Println("  宕机2个节点，等待3个过期时间后: 仍然持有锁（剩余3个节点续期成功）")
+	}
+	fmt.Printf("  客户端A释放锁: error=%v\n", clientA.Unlock(ctx))
+
+	// 2. 再宕机1个节点：续期无法得到半数确认，锁按估算过期后认为已丢失
+	clientB := lock.NewRedlock(clients(nodes), "lock:redlock:renew:2", lock.WithTTL(ttl))
+	okB, _ := clientB.TryLock(ctx)
+	fmt.Printf("\n  客户端B获取锁: %v\n", okB)
+	lost := clientB.Lost()
+	nodes[2].crash()
+	start := time.Now()
+	select {
+	case <-lost:
+		fmt.Printf("  再宕机1个节点: %v 后发现锁已丢失（续期只有2个节点确认）\n", time.Since(start).Round(10*time.Millisecond))
+	case <-time.After(3 * ttl):
+		fmt.Println("  再宕机1个节点: 仍然持有锁")
+	}
+	fmt.Printf("  客户端B释放锁: error=%v\n", clientB.Unlock(ctx))
+
+	fmt.Println("\n  → 续期失败时不会立即放弃：按本地时间估算的有效时间（ttl - 时钟漂移）用完后才认为锁已丢失")
+	fmt.Println("\n✓ 场景4测试完成")
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"cache-demo/cache"
+	"cache-demo/model"
+	"cache-demo/retry"
+	"cache-demo/service"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	red "github.com/go-redis/redis/v8"
+	"github.com/go-sql-driver/mysql"
+	"github.com/zeromicro/go-zero/core/conf"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	mysqldriver "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Config 配置结构（复用main.go的配置）
+type Config struct {
+	MySQL struct {
+		Host         string `json:"host" yaml:"host"`
+		Port         int    `json:"port" yaml:"port"`
+		User         string `json:"user" yaml:"user"`
+		Password     string `json:"password" yaml:"password"`
+		Database     string `json:"database" yaml:"database"`
+		MaxOpenConns int    `json:"max_open_conns" yaml:"max_open_conns"`
+		MaxIdleConns int    `json:"max_idle_conns" yaml:"max_idle_conns"`
+	} `json:"mysql" yaml:"mysql"`
+	Redis struct {
+		Host        string `json:"host" yaml:"host"`
+		Password    string `json:"password" yaml:"password"`
+		Type        string `json:"type" yaml:"type"`
+		PingTimeout string `json:"ping_timeout" yaml:"ping_timeout"`
+	} `json:"redis" yaml:"redis"`
+}
+
+// timeoutError 模拟网络超时（实现 net.Error）
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func main() {
+	// 加载配置
+	var c Config
+	conf.MustLoad("config.yaml", &c)
+
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("重试机制测试")
+	fmt.Println(strings.Repeat("=", 80))
+
+	// 场景1-4 不需要数据库和Redis
+	testBackoffJitter("场景1：退避与抖动")
+	testClassifier("场景2：错误分类")
+	testRetryBudget("场景3：重试预算")
+	testRetryLimits("场景4：最长总耗时与 context 取消")
+
+	// 初始化数据库和Redis连接
+	db, err := initDB(c)
+	if err != nil {
+		log.Fatalf("初始化数据库失败: %v", err)
+	}
+	rds, err := initRedis(c)
+	if err != nil {
+		log.Fatalf("初始化Redis失败: %v", err)
+	}
+
+	// 场景5：服务层的缓存写入重试
+	testCacheWriteRetry(model.NewUserRepo(db), rds, "场景5：服务层缓存写入重试")
+}
+
+// testBackoffJitter 场景1：三种抖动方式的等待时间，以及10个客户端同时失败后第一次重试的时间分布
+func testBackoffJitter(title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：固定间隔重试时，同时失败的客户端会在同一时刻一起重试，再次把依赖打垮（惊群）")
+	fmt.Println("方案：指数退避 + 随机抖动，base=100ms，上限=2s")
+	fmt.Println()
+
+	const (
+		base     = 100 * time.Millisecond
+		maxDelay = 2 * time.Second
+		attempts = 6
+		clients  = 10
+	)
+
+	for _, jitter := range []retry.Jitter{retry.NoJitter, retry.FullJitter, retry.DecorrelatedJitter} {
+		backoff := retry.ExponentialBackoff(base, maxDelay, jitter)
+
+		// 单个客户端连续失败时每次的等待时间
+		var delays []string
+		var prev time.Duration
+		for attempt := 1; attempt <= attempts; attempt++ {
+			prev = backoff(attempt, prev)
+			delays = append(delays, prev.Round(time.Millisecond).String())
+		}
+
+		// 10个客户端第一次失败后的等待时间
+		var first []string
+		for i := 0; i < clients; i++ {
+			first = append(first, backoff(1, 0).Round(time.Millisecond).String())
+		}
+
+		fmt.Printf("  [%s]\n", jitter)
+		fmt.Printf("    连续失败的等待时间: %s\n", strings.Join(delays, ", "))
+		fmt.Printf("    %d个客户端的第一次等待: %s\n", clients, strings.Join(first, ", "))
+	}
+	fmt.Println("\n  → none: 所有客户端在同一时刻重试")
+	fmt.Println("  → full: 等待时间在 [0, base·2^(n-1)] 中均匀分布，重试最分散")
+	fmt.Println("  → decorrelated: 在 [base, 3·上一次等待] 中随机，不依赖重试次数，适合长时间重试")
+	fmt.Println("\n✓ 场景1测试完成")
+}
+
+// testClassifier 场景2：默认的错误分类
+func testClassifier(title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：只有临时故障值得重试，记录不存在、唯一键冲突等错误重试多少次结果都一样")
+	fmt.Println()
+
+	cases := []struct {
+		name string
+		err  error
+	}{
+		{"MySQL 死锁 (1213)", &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}},
+		{"MySQL 锁等待超时 (1205)", &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}},
+		{"MySQL 唯一键冲突 (1062)", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}},
+		{"MySQL 连接断开", fmt.Errorf("查询用户失败: %w", driver.ErrBadConn)},
+		{"Redis 读超时", fmt.Errorf("写入缓存失败: %w", timeoutError{})},
+		{"Redis 主从切换中 (READONLY)", red.Error(readonlyError{})},
+		{"gorm.ErrRecordNotFound", gorm.ErrRecordNotFound},
+		{"redis.Nil", red.Nil},
+		{"context.Canceled", context.Canceled},
+		{"Permanent 标记的超时", retry.Permanent(timeoutError{})},
+		{"不认识的错误", errors.New("something went wrong")},
+	}
+	for _, tc := range cases {
+		mark := "✗ 不重试"
+		if retry.IsRetryable(tc.err) {
+			mark = "✓ 重试"
+		}
+		fmt.Printf("  %-28s %s\n", tc.name, mark)
+	}
+	fmt.Println("\n  → 不认识的错误默认不重试：宁可少重试一次，也不要对非幂等或参数错误的请求反复执行")
+	fmt.Println("\n✓ 场景2测试完成")
+}
+
+// readonlyError 模拟 Redis 返回的 READONLY 错误（实现 go-redis 的 redis.Error）
+type readonlyError struct{}
+
+func (readonlyError) Error() string { return "READONLY You can't write against a read only replica." }
+func (readonlyError) RedisError()   {}
+
+// testRetryBudget 场景3：依赖持续故障时，有预算和没有预算的实际调用次数
+func testRetryBudget(title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：依赖完全故障时，每个请求重试3次，依赖承受的请求量变成原来的3倍，更难恢复")
+	fmt.Println("方案：重试预算（gRPC retryThrottling）：失败消耗1个令牌，成功补充0.1个，令牌不超过一半时不再重试")
+	fmt.Println()
+
+	const requests = 200
+	for _, withBudget := range []bool{false, true} {
+		var calls atomic.Int64
+		opts := []retry.Option{
+			retry.WithMaxAttempts(3),
+			retry.WithBackoff(retry.ConstantBackoff(0)),
+		}
+		name := "无预算"
+		var budget *retry.Budget
+		if withBudget {
+			budget = retry.NewBudget(retry.DefaultBudgetTokens, retry.DefaultBudgetRatio)
+			opts = append(opts, retry.WithBudget(budget))
+			name = fmt.Sprintf("预算%d令牌", retry.DefaultBudgetTokens)
+		}
+		policy := retry.New(opts...)
+
+		for i := 0; i < requests; i++ {
+			policy.Do(context.Background(), "budget_demo", func(context.Context) error {
+				calls.Add(1)
+				return timeoutError{}
+			})
+		}
+		fmt.Printf("  [%s] %d 个请求，依赖实际收到 %d 次调用（放大 %.2f 倍）",
+			name, requests, calls.Load(), float64(calls.Load())/requests)
+		if budget != nil {
+			fmt.Printf("，剩余令牌 %.1f", budget.Tokens())
+		}
+		fmt.Println()
+	}
+	fmt.Println("\n  → 有预算时只有前几十个请求会重试，之后退化为只调用一次；依赖恢复后成功请求逐渐补充令牌")
+	fmt.Println("\n✓ 场景3测试完成")
+}
+
+// testRetryLimits 场景4：最长总耗时和 context 取消
+func testRetryLimits(title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：调用方有自己的超时，重试不能超过调用方愿意等待的时间")
+	fmt.Println()
+
+	var attempts int
+	policy := retry.New(
+		retry.WithMaxAttempts(10),
+		retry.WithBackoff(retry.ExponentialBackoff(50*time.Millisecond, time.Second, retry.NoJitter)),
+		retry.WithMaxElapsed(300*time.Millisecond),
+		retry.WithOnGiveUp(func(op string, n int, err error, reason string) {
+			fmt.Printf("  [%s] 放弃重试: 已执行%d次, 原因=%s\n", op, n, reason)
+		}),
+	)
+	start := time.Now()
+	err := policy.Do(context.Background(), "max_elapsed", func(context.Context) error {
+		attempts++
+		return timeoutError{}
+	})
+	fmt.Printf("  最长总耗时300ms: 执行%d次, 耗时%v, error=%v\n\n", attempts, time.Since(start).Round(time.Millisecond), err)
+
+	attempts = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	err = policy.Do(ctx, "ctx_timeout", func(context.Context) error {
+		attempts++
+		return timeoutError{}
+	})
+	fmt.Printf("  context 120ms 超时: 执行%d次, 耗时%v, error=%v\n", attempts, time.Since(start).Round(time.Millisecond), err)
+	fmt.Printf("  errors.Is(err, context.DeadlineExceeded) = %v\n", errors.Is(err, context.DeadlineExceeded))
+
+	fmt.Println("\n✓ 场景4测试完成")
+}
+
+// flakyUserCache 前 failures 次写入返回指定错误的用户缓存
+type flakyUserCache struct {
+	cache.UserCache
+	failures atomic.Int64
+	err      error
+	writes   atomic.Int64
+}
+
+// SetUserCtx 写入用户缓存，还有失败次数时返回错误（服务层都通过带 ctx 的方法写缓存）
+func (c *flakyUserCache) SetUserCtx(ctx context.Context, user *model.User, expireSeconds int) error {
+	c.writes.Add(1)
+	if c.failures.Add(-1) >= 0 {
+		return c.err
+	}
+	return c.UserCache.SetUserCtx(ctx, user, expireSeconds)
+}
+
+// testCacheWriteRetry 场景5：UpdateUser 更新缓存时遇到临时故障和不可重试的错误
+func testCacheWriteRetry(repo model.UserRepo, rds *redis.Redis, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println("说明：更新数据库后写缓存失败，缓存里会留下旧数据直到过期")
+	fmt.Println("方案：服务层的缓存写入（更新、删除、写空值）使用 retry 包，临时故障快速重试")
+	fmt.Println()
+
+	user, err := repo.FindByID(1)
+	if err != nil {
+		log.Printf("查询用户失败: %v", err)
+		return
+	}
+
+	cases := []struct {
+		name     string
+		err      error
+		failures int64
+	}{
+		{"前2次写入超时", timeoutError{}, 2},
+		{"一直超时", timeoutError{}, 100},
+		{"WRONGTYPE（不可重试）", errors.New("WRONGTYPE Operation against a key holding the wrong kind of value"), 1},
+	}
+	for _, tc := range cases {
+		flaky := &flakyUserCache{UserCache: cache.NewUserCache(rds), err: tc.err}
+		flaky.failures.Store(tc.failures)
+		userService := service.NewUserService(repo, flaky)
+
+		fmt.Printf("[%s]\n", tc.name)
+		start := time.Now()
+		if err := userService.UpdateUser(user); err != nil {
+			log.Printf("更新失败: %v", err)
+		}
+		cached, _ := flaky.GetUser(user.ID)
+		fmt.Printf("  写入缓存 %d 次，耗时 %v，缓存中有最新数据: %v\n\n",
+			flaky.writes.Load(), time.Since(start).Round(time.Millisecond), cached != nil)
+		flaky.DeleteUser(user.ID)
+	}
+
+	fmt.Println("  → 临时故障重试后写入成功；一直失败时最多执行3次、总耗时不超过500ms，不会拖慢更新请求")
+	fmt.Println("  → 不可重试的错误只执行一次")
+
+	fmt.Println("\n✓ 场景5测试完成")
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("所有测试场景完成！")
+	fmt.Println(strings.Repeat("=", 80))
+	fmt.Println("\n总结：")
+	fmt.Println("1. 指数退避 + 抖动：分散重试时间，避免惊群")
+	fmt.Println("2. 错误分类：只重试临时故障，业务错误直接返回")
+	fmt.Println("3. 重试预算：依赖持续故障时停止重试，避免放大流量")
+	fmt.Println("4. 最长总耗时和 context：重试不超过调用方愿意等待的时间")
+}
+
+// initDB 初始化数据库连接（复用main.go的函数）
+func initDB(c Config) (*gorm.DB, error) {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+		c.MySQL.User,
+		c.MySQL.Password,
+		c.MySQL.Host,
+		c.MySQL.Port,
+		c.MySQL.Database,
+	)
+
+	db, err := gorm.Open(mysqldriver.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("连接数据库失败: %w", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("获取数据库实例失败: %w", err)
+	}
+
+	if c.MySQL.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(c.MySQL.MaxOpenConns)
+	}
+	if c.MySQL.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(c.MySQL.MaxIdleConns)
+	}
+
+	return db, nil
+}
+
+// initRedis 初始化Redis连接（复用main.go的函数）
+func initRedis(c Config) (*redis.Redis, error) {
+	pingTimeout, err := time.ParseDuration(c.Redis.PingTimeout)
+	if err != nil {
+		pingTimeout = time.Second
+	}
+
+	rds := redis.MustNewRedis(redis.RedisConf{
+		Host:        c.Redis.Host,
+		Type:        c.Redis.Type,
+		Pass:        c.Redis.Password,
+		PingTimeout: pingTimeout,
+	})
+	return rds, nil
+}
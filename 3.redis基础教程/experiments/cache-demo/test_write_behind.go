@@ -0,0 +1,247 @@
+package main
+
+import (
+	"cache-demo/cache"
+	"cache-demo/model"
+	"cache-demo/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/conf"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Config 配置结构（复用main.go的配置）
+type Config struct {
+	MySQL struct {
+		Host         string `json:"host" yaml:"host"`
+		Port         int    `json:"port" yaml:"port"`
+		User         string `json:"user" yaml:"user"`
+		Password     string `json:"password" yaml:"password"`
+		Database     string `json:"database" yaml:"database"`
+		MaxOpenConns int    `json:"max_open_conns" yaml:"max_open_conns"`
+		MaxIdleConns int    `json:"max_idle_conns" yaml:"max_idle_conns"`
+	} `json:"mysql" yaml:"mysql"`
+	Redis struct {
+		Host        string `json:"host" yaml:"host"`
+		Password    string `json:"password" yaml:"password"`
+		Type        string `json:"type" yaml:"type"`
+		PingTimeout string `json:"ping_timeout" yaml:"ping_timeout"`
+	} `json:"redis" yaml:"redis"`
+}
+
+func main() {
+	// 加载配置
+	var c Config
+	conf.MustLoad("config.yaml", &c)
+
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("Write-Behind 刷盘测试（合并写操作 + 死信队列）")
+	fmt.Println(strings.Repeat("=", 80))
+
+	// 初始化数据库和Redis连接
+	db, err := initDB(c)
+	if err != nil {
+		log.Fatalf("初始化数据库失败: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}); err != nil {
+		log.Fatalf("迁移用户表失败: %v", err)
+	}
+
+	rds, err := initRedis(c)
+	if err != nil {
+		log.Fatalf("初始化Redis失败: %v", err)
+	}
+
+	repo := model.NewUserRepo(db)
+	// 刷盘间隔设置得很长，测试中手动调用 Flush 控制每一批的内容
+	writeBehind := service.NewWriteBehindUserService(repo, cache.NewUserCacheWithPenetration(rds), rds, time.Hour, 0)
+
+	testDeleteThenUpsert(repo, writeBehind, rds, "场景1：同一批中删除后又更新")
+	testDeadLetter(repo, writeBehind, rds, "场景2：永久失败的写操作移到死信队列")
+
+	if err := writeBehind.Close(); err != nil {
+		log.Printf("关闭失败: %v", err)
+	}
+
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("所有测试场景完成！")
+	fmt.Println(strings.Repeat("=", 80))
+	fmt.Println("\n总结：")
+	fmt.Println("1. 同一个用户在一批中有删除时只执行删除，删除之后的更新不会把用户写回数据库")
+	fmt.Println("2. 唯一键冲突等重试也不会成功的写操作移到死信队列（write_behind:user:dead），后面的写操作照常刷盘")
+	fmt.Println("3. 连接断开、超时等临时故障时这一批留在队列中，下次重试")
+}
+
+// testDeleteThenUpsert 场景1：删除和更新并发，两个写操作进入同一批（删除在前）
+func testDeleteThenUpsert(repo model.UserRepo, writeBehind service.WriteBehindUserService, rds *redis.Redis, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+
+	user := &model.User{
+		Username: fmt.Sprintf("wb_delete_%d", time.Now().UnixNano()),
+		Email:    "wb_delete@example.com",
+		Age:      20,
+	}
+	if err := writeBehind.CreateUser(user); err != nil {
+		log.Printf("创建用户失败: %v", err)
+		return
+	}
+	fmt.Printf("创建用户: id=%d, username=%s\n", user.ID, user.Username)
+
+	// 模拟：删除请求先入队，并发的更新请求（删除前读到了用户）随后入队
+	if err := writeBehind.DeleteUser(user.ID); err != nil {
+		log.Printf("删除用户失败: %v", err)
+		return
+	}
+	updated := *user
+	updated.Age = 21
+	if err := pushWriteOp(rds, map[string]any{"op": "upsert", "id": user.ID, "user": &updated}); err != nil {
+		log.Printf("写入队列失败: %v", err)
+		return
+	}
+	fmt.Println("队列: delete → upsert（同一个用户）")
+
+	if err := writeBehind.Flush(); err != nil {
+		log.Printf("刷盘失败: %v", err)
+		return
+	}
+
+	_, err := repo.FindByID(user.ID)
+	fmt.Printf("\n刷盘后查询数据库: %v\n", err)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		fmt.Println("✅ 用户仍然是删除状态，删除之后的更新被丢弃")
+	} else {
+		fmt.Println("❌ 已删除的用户被写回了数据库")
+		_ = repo.Delete(user.ID)
+	}
+}
+
+// testDeadLetter 场景2：一个写操作违反唯一键（用户名重复），后面的写操作照常刷盘
+func testDeadLetter(repo model.UserRepo, writeBehind service.WriteBehindUserService, rds *redis.Redis, title string) {
+	fmt.Println("\n" + strings.Repeat("-", 80))
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", 80))
+
+	suffix := time.Now().UnixNano()
+	first := &model.User{Username: fmt.Sprintf("wb_dead_a_%d", suffix), Email: "wb_dead_a@example.com", Age: 30}
+	second := &model.User{Username: fmt.Sprintf("wb_dead_b_%d", suffix), Email: "wb_dead_b@example.com", Age: 40}
+	for _, u := range []*model.User{first, second} {
+		if err := writeBehind.CreateUser(u); err != nil {
+			log.Printf("创建用户失败: %v", err)
+			return
+		}
+	}
+	// 删除测试用户（关闭时刷盘）
+	defer func() {
+		_ = writeBehind.DeleteUser(first.ID)
+		_ = writeBehind.DeleteUser(second.ID)
+	}()
+
+	deadBefore, _ := rds.Llen(service.WriteBehindDeadLetterKey)
+
+	// 第一个更新把用户名改成和另一个用户相同，刷盘时违反唯一键；第二个更新正常
+	conflicting := *second
+	conflicting.Username = first.Username
+	if err := writeBehind.UpdateUser(&conflicting); err != nil {
+		log.Printf("更新失败: %v", err)
+		return
+	}
+	normal := *first
+	normal.Age = 31
+	if err := writeBehind.UpdateUser(&normal); err != nil {
+		log.Printf("更新失败: %v", err)
+		return
+	}
+	fmt.Printf("队列: 用户%d 改名为 %s（重复），用户%d 年龄改为 %d\n", second.ID, first.Username, first.ID, normal.Age)
+
+	if err := writeBehind.Flush(); err != nil {
+		log.Printf("刷盘失败: %v", err)
+		return
+	}
+
+	queued, _ := rds.Llen(service.WriteBehindQueueKey)
+	deadAfter, _ := rds.Llen(service.WriteBehindDeadLetterKey)
+	dbFirst, _ := repo.FindByID(first.ID)
+	fmt.Printf("\n刷盘后: 写队列剩余=%d, 新增死信=%d\n", queued, deadAfter-deadBefore)
+	if deadAfter > deadBefore {
+		items, _ := rds.Lrange(service.WriteBehindDeadLetterKey, -1, -1)
+		for _, item := range items {
+			var letter map[string]any
+			if json.Unmarshal([]byte(item), &letter) == nil {
+				fmt.Printf("死信: op=%v, id=%v, error=%v\n", letter["op"], letter["id"], letter["error"])
+			}
+		}
+	}
+	if dbFirst != nil && dbFirst.Age == normal.Age && deadAfter-deadBefore == 1 {
+		fmt.Println("✅ 用户名重复的更新移到死信队列，后面的更新已经写入数据库")
+	} else {
+		fmt.Println("❌ 写队列被永久失败的写操作堵住")
+	}
+}
+
+// pushWriteOp 直接向写队列追加一条写操作（格式和 Write-Behind 服务入队的相同）
+func pushWriteOp(rds *redis.Redis, op map[string]any) error {
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	_, err = rds.Rpush(service.WriteBehindQueueKey, string(data))
+	return err
+}
+
+// initDB 初始化数据库连接（复用main.go的函数）
+func initDB(c Config) (*gorm.DB, error) {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+		c.MySQL.User,
+		c.MySQL.Password,
+		c.MySQL.Host,
+		c.MySQL.Port,
+		c.MySQL.Database,
+	)
+
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("连接数据库失败: %w", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("获取数据库实例失败: %w", err)
+	}
+
+	if c.MySQL.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(c.MySQL.MaxOpenConns)
+	}
+	if c.MySQL.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(c.MySQL.MaxIdleConns)
+	}
+
+	return db, nil
+}
+
+// initRedis 初始化Redis连接（复用main.go的函数）
+func initRedis(c Config) (*redis.Redis, error) {
+	pingTimeout, err := time.ParseDuration(c.Redis.PingTimeout)
+	if err != nil {
+		pingTimeout = time.Second
+	}
+
+	rds := redis.MustNewRedis(redis.RedisConf{
+		Host:        c.Redis.Host,
+		Type:        c.Redis.Type,
+		Pass:        c.Redis.Password,
+		PingTimeout: pingTimeout,
+	})
+	return rds, nil
+}